@@ -0,0 +1,273 @@
+// mockoidc — маленький OpenID Connect провайдер для локальной проверки входа через SSO.
+//
+// Запуск:
+//
+//	go run ./cmd/mockoidc -addr :9090 -client-id saas-calc -client-secret dev-secret
+//
+// В /api/admin/settings указать issuer http://localhost:9090, client id и secret.
+// На странице входа провайдера вводится любой email — он и придёт в id_token.
+// Для скриптов можно сразу передать ?login_hint=user@example.com — форма будет пропущена.
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"flag"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"saas-calc-backend/internal/oidc"
+)
+
+const keyID = "mock-1"
+
+type authCode struct {
+	ClientID    string
+	RedirectURI string
+	Challenge   string
+	Nonce       string
+	Email       string
+	Verified    bool
+	Name        string
+	Expires     time.Time
+}
+
+type server struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	key          *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]*authCode
+}
+
+var loginPage = template.Must(template.New("login").Parse(`<!doctype html>
+<html lang="ru">
+<head><meta charset="utf-8"><title>Mock OIDC</title></head>
+<body style="font-family: system-ui, sans-serif; max-width: 360px; margin: 60px auto;">
+  <h2>Mock OIDC — вход</h2>
+  <form method="post" action="/authorize">
+    {{range $k, $v := .}}<input type="hidden" name="{{$k}}" value="{{index $v 0}}">
+    {{end}}
+    <p><label>Email<br><input type="email" name="email" required style="width:100%"></label></p>
+    <p><label>Имя<br><input type="text" name="name" style="width:100%"></label></p>
+    <p><label><input type="checkbox" name="email_verified" value="true" checked> email подтверждён</label></p>
+    <button type="submit">Войти</button>
+  </form>
+</body>
+</html>`))
+
+func main() {
+	addr := flag.String("addr", ":9090", "listen address")
+	issuer := flag.String("issuer", "http://localhost:9090", "issuer URL (как его видит браузер и бэкенд)")
+	clientID := flag.String("client-id", "saas-calc", "expected client_id")
+	clientSecret := flag.String("client-secret", "", "expected client secret (пусто — не проверять)")
+	flag.Parse()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatalf("generate key: %v", err)
+	}
+
+	s := &server{
+		issuer:       strings.TrimRight(*issuer, "/"),
+		clientID:     *clientID,
+		clientSecret: *clientSecret,
+		key:          key,
+		codes:        make(map[string]*authCode),
+	}
+
+	log.Printf("mock OIDC issuer %s listening on %s (client_id=%s)", s.issuer, *addr, s.clientID)
+	log.Fatal(http.ListenAndServe(*addr, s.routes()))
+}
+
+func (s *server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", s.handleDiscovery)
+	mux.HandleFunc("/authorize", s.handleAuthorize)
+	mux.HandleFunc("/token", s.handleToken)
+	mux.HandleFunc("/jwks", s.handleJWKS)
+	return mux
+}
+
+func (s *server) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                s.issuer,
+		"authorization_endpoint":                s.issuer + "/authorize",
+		"token_endpoint":                        s.issuer + "/token",
+		"jwks_uri":                              s.issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"scopes_supported":                      []string{"openid", "email", "profile"},
+	})
+}
+
+func (s *server) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, oidc.JWKS{Keys: []oidc.JWK{oidc.NewRSAJWK(keyID, &s.key.PublicKey)}})
+}
+
+// GET — форма входа, POST — выдача кода и редирект обратно в приложение.
+func (s *server) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	q := r.Form
+
+	switch {
+	case q.Get("response_type") != "code":
+		http.Error(w, "unsupported response_type", http.StatusBadRequest)
+		return
+	case q.Get("client_id") != s.clientID:
+		http.Error(w, "unknown client_id", http.StatusBadRequest)
+		return
+	case q.Get("redirect_uri") == "":
+		http.Error(w, "redirect_uri is required", http.StatusBadRequest)
+		return
+	case q.Get("code_challenge") == "" || q.Get("code_challenge_method") != "S256":
+		http.Error(w, "PKCE S256 is required", http.StatusBadRequest)
+		return
+	}
+
+	email := q.Get("email")
+	if r.Method == http.MethodGet {
+		email = q.Get("login_hint")
+	}
+	if email == "" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		params := url.Values{}
+		for _, k := range []string{"response_type", "client_id", "redirect_uri", "state", "nonce", "code_challenge", "code_challenge_method"} {
+			params.Set(k, q.Get(k))
+		}
+		_ = loginPage.Execute(w, params)
+		return
+	}
+
+	code, err := oidc.RandomString(24)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// без галочки отдадим email_verified=false — приложение должно отказать во входе
+	verified := r.Method == http.MethodGet || q.Get("email_verified") == "true"
+
+	s.mu.Lock()
+	s.codes[code] = &authCode{
+		ClientID:    q.Get("client_id"),
+		RedirectURI: q.Get("redirect_uri"),
+		Challenge:   q.Get("code_challenge"),
+		Nonce:       q.Get("nonce"),
+		Email:       email,
+		Verified:    verified,
+		Name:        q.Get("name"),
+		Expires:     time.Now().Add(time.Minute),
+	}
+	s.mu.Unlock()
+
+	back, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil {
+		http.Error(w, "bad redirect_uri", http.StatusBadRequest)
+		return
+	}
+	bq := back.Query()
+	bq.Set("code", code)
+	bq.Set("state", q.Get("state"))
+	back.RawQuery = bq.Encode()
+
+	http.Redirect(w, r, back.String(), http.StatusFound)
+}
+
+func (s *server) handleToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		tokenError(w, "invalid_request", err.Error())
+		return
+	}
+
+	clientID, secret, hasBasic := r.BasicAuth()
+	if hasBasic {
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID = r.PostForm.Get("client_id")
+		secret = r.PostForm.Get("client_secret")
+	}
+	if clientID != s.clientID || (s.clientSecret != "" && secret != s.clientSecret) {
+		tokenError(w, "invalid_client", "bad client credentials")
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		tokenError(w, "unsupported_grant_type", "")
+		return
+	}
+
+	code := r.PostForm.Get("code")
+	s.mu.Lock()
+	ac := s.codes[code]
+	delete(s.codes, code) // код одноразовый
+	s.mu.Unlock()
+
+	switch {
+	case ac == nil || time.Now().After(ac.Expires):
+		tokenError(w, "invalid_grant", "unknown or expired code")
+		return
+	case ac.ClientID != clientID || ac.RedirectURI != r.PostForm.Get("redirect_uri"):
+		tokenError(w, "invalid_grant", "client_id or redirect_uri mismatch")
+		return
+	case oidc.CodeChallenge(r.PostForm.Get("code_verifier")) != ac.Challenge:
+		tokenError(w, "invalid_grant", "PKCE verification failed")
+		return
+	}
+
+	now := time.Now()
+	idToken, err := oidc.SignRS256(s.key, keyID, map[string]interface{}{
+		"iss":            s.issuer,
+		"sub":            "mock|" + strings.ToLower(ac.Email),
+		"aud":            clientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"nonce":          ac.Nonce,
+		"email":          ac.Email,
+		"email_verified": ac.Verified,
+		"name":           ac.Name,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	accessToken, _ := oidc.RandomString(24)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+func tokenError(w http.ResponseWriter, code, desc string) {
+	writeJSON(w, http.StatusBadRequest, map[string]string{
+		"error":             code,
+		"error_description": desc,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
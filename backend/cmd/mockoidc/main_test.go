@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"saas-calc-backend/internal/oidc"
+)
+
+// Полный цикл клиента internal/oidc против mock-провайдера: discovery, вход, обмен кода, проверка id_token.
+func TestRoundTrip(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &server{
+		clientID:     "saas-calc",
+		clientSecret: "dev-secret",
+		key:          key,
+		codes:        make(map[string]*authCode),
+	}
+	ts := httptest.NewServer(s.routes())
+	defer ts.Close()
+	s.issuer = ts.URL
+
+	ctx := context.Background()
+	p, err := oidc.Discover(ctx, ts.URL+"/")
+	if err != nil {
+		t.Fatalf("Discover: %v", err)
+	}
+
+	cfg := oidc.Config{
+		Issuer:       ts.URL,
+		ClientID:     "saas-calc",
+		ClientSecret: "dev-secret",
+		RedirectURL:  "http://app.test/api/auth/sso/callback",
+	}
+
+	// authorize: login_hint пропускает форму, провайдер сразу отвечает редиректом с кодом
+	authorize := func(state, nonce, verifier string) string {
+		t.Helper()
+		noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}}
+		resp, err := noRedirect.Get(p.AuthCodeURL(cfg, state, nonce, verifier) + "&login_hint=User@Example.com")
+		if err != nil {
+			t.Fatalf("authorize: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusFound {
+			t.Fatalf("authorize: status %d", resp.StatusCode)
+		}
+		back, err := url.Parse(resp.Header.Get("Location"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(back.String(), cfg.RedirectURL+"?") {
+			t.Fatalf("redirected to %s", back)
+		}
+		if got := back.Query().Get("state"); got != state {
+			t.Fatalf("state = %q, want %q", got, state)
+		}
+		return back.Query().Get("code")
+	}
+
+	code := authorize("state-1", "nonce-1", "verifier-1")
+	claims, err := p.Exchange(ctx, cfg, code, "verifier-1", "nonce-1")
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	if claims.Email != "User@Example.com" || claims.EmailVerified == nil || !*claims.EmailVerified {
+		t.Errorf("claims = %+v", claims)
+	}
+
+	// код одноразовый
+	if _, err := p.Exchange(ctx, cfg, code, "verifier-1", "nonce-1"); err == nil {
+		t.Error("code exchanged twice")
+	}
+
+	// чужой verifier — PKCE не сходится
+	code = authorize("state-2", "nonce-2", "verifier-2")
+	if _, err := p.Exchange(ctx, cfg, code, "other-verifier", "nonce-2"); err == nil {
+		t.Error("exchange with a wrong code_verifier succeeded")
+	}
+
+	// id_token выписан на другой nonce
+	code = authorize("state-3", "nonce-3", "verifier-3")
+	if _, err := p.Exchange(ctx, cfg, code, "verifier-3", "nonce-other"); err == nil || !strings.Contains(err.Error(), "nonce") {
+		t.Errorf("exchange with a wrong nonce: %v", err)
+	}
+
+	// неверный секрет клиента
+	code = authorize("state-4", "nonce-4", "verifier-4")
+	bad := cfg
+	bad.ClientSecret = "wrong"
+	if _, err := p.Exchange(ctx, bad, code, "verifier-4", "nonce-4"); err == nil || !strings.Contains(err.Error(), "invalid_client") {
+		t.Errorf("exchange with a wrong client secret: %v", err)
+	}
+}
+
+func TestDiscoverIssuerMismatch(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &server{issuer: "https://elsewhere.test", clientID: "saas-calc", key: key, codes: make(map[string]*authCode)}
+	ts := httptest.NewServer(s.routes())
+	defer ts.Close()
+
+	if _, err := oidc.Discover(context.Background(), ts.URL); err == nil || !strings.Contains(err.Error(), "issuer mismatch") {
+		t.Errorf("Discover: %v", err)
+	}
+}
@@ -5,9 +5,16 @@ import (
     "database/sql"
     "log"
     "net/http"
+    "os"
+    "strconv"
+    "time"
+
+    "golang.org/x/crypto/bcrypt"
 
     "saas-calc-backend/internal/domain"
     "saas-calc-backend/internal/handlers"
+    "saas-calc-backend/internal/mail"
+    "saas-calc-backend/internal/ratelimit"
 )
 
 type App struct {
@@ -44,10 +51,19 @@ func New(db *sql.DB) *App {
     }
 
     // 5. Засеять демо-пользователей (admin / user1 / user2), если users пустая
-    if err := seedDemoUsersIfEmpty(ctx, db, plans); err != nil {
+    freshDB, err := seedDemoUsersIfEmpty(ctx, db, plans)
+    if err != nil {
         log.Fatalf("seedDemoUsersIfEmpty: %v", err)
     }
 
+    // 5.1. Демо-пароли (user1pass / user2pass) — только для разработки (SEED_DEMO_PASSWORDS=1)
+    // и только в только что созданной базе; админу пароль не ставим никогда
+    if freshDB && os.Getenv("SEED_DEMO_PASSWORDS") == "1" {
+        if err := seedDemoPasswords(ctx, db, plans); err != nil {
+            log.Fatalf("seedDemoPasswords: %v", err)
+        }
+    }
+
     // 6. Загрузить всех пользователей из БД
     users, err := loadUsers(ctx, db)
     if err != nil {
@@ -63,50 +79,84 @@ func New(db *sql.DB) *App {
         log.Fatalf("initCalculators: %v", err)
     }
 
+    // 8.1. Встроенные шаблоны калькуляторов
+    if err := seedTemplates(ctx, db); err != nil {
+        log.Fatalf("seedTemplates: %v", err)
+    }
+
+    // 9. Ключ подписи cookie сессий
+    sessionSecret, err := loadSessionSecret(ctx, db)
+    if err != nil {
+        log.Fatalf("loadSessionSecret: %v", err)
+    }
+
     env := &handlers.Env{
-        DB:             db,
-        LayeredConfig:  domain.NewDefaultLayeredConfig(),
-        DistanceConfig: domain.NewDefaultDistanceConfig(),
-        UploadDir:      "../frontend/uploads",
+        DB:        db,
+        UploadDir: "../frontend/uploads",
 
         Plans:       plans,
         Users:       users,       // пользователи из БД
         Calculators: calculators, // калькуляторы из БД
-        NextCalcID:  len(calculators) + 1,
 
         OSRMBaseURL:      "https://router.project-osrm.org",
         NominatimBaseURL: "https://nominatim.openstreetmap.org",
         TelegramBotToken: "",
+
+        SessionSecret: sessionSecret,
+
+        Mailer:        mail.FromEnv(),
+        PublicBaseURL: os.Getenv("PUBLIC_BASE_URL"),
+
+        // с одного IP: после 10 неудачных входов — пауза 30 секунд, дальше удваивается до 30 минут
+        LoginBackoff: ratelimit.NewBackoff(10, 30*time.Second, 30*time.Minute),
+
+        // письма по запросу: не больше 3 подряд на адресата, дальше одно в 10 минут
+        MailLimit: ratelimit.NewLimiter(0.1, 3),
+    }
+
+    // за nginx/балансировщиком IP клиента приходит в X-Forwarded-For
+    ratelimit.TrustProxyHeaders = os.Getenv("TRUST_PROXY") == "1"
+
+    // у опубликованных калькуляторов без снимка конфига (демо и старые) публикуем стартовый
+    if err := env.EnsurePublishedConfigs(ctx); err != nil {
+        log.Fatalf("EnsurePublishedConfigs: %v", err)
     }
 
     registerRoutes(mux, env)
 
+    // корзина калькуляторов: срок хранения из TRASH_RETENTION_DAYS, очистка раз в час
+    env.TrashRetention = trashRetentionFromEnv()
+    env.StartTrashPurger(time.Hour)
+
     return &App{
         mux: mux,
         Env: env,
     }
 }
 
-func (a *App) Router() *http.ServeMux {
-    return a.mux
+// Router — корневой обработчик: поверх mux определяем сессию запроса
+// (она же ставит заголовки X-Real-User-Id / X-Effective-User-Id и ведёт аудит).
+func (a *App) Router() http.Handler {
+    return a.Env.WithSession(a.mux)
 }
 
 //
 // ---- Демо-пользователи (admin / user1 / user2) --------------------------
 //
 
-func seedDemoUsersIfEmpty(ctx context.Context, db *sql.DB, plans []domain.Plan) error {
+// seedDemoUsersIfEmpty создаёт демо-пользователей без паролей; true — база была пустой.
+func seedDemoUsersIfEmpty(ctx context.Context, db *sql.DB, plans []domain.Plan) (bool, error) {
     if db == nil {
-        return nil
+        return false, nil
     }
 
     var cnt int
     if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM users`).Scan(&cnt); err != nil {
-        return err
+        return false, err
     }
     if cnt > 0 {
         // уже есть пользователи – ничего не делаем
-        return nil
+        return false, nil
     }
 
     // найдём ID тарифов по их кодам
@@ -140,7 +190,34 @@ VALUES
   ('user2', 'user2@example.com', 'Клиент 2', 'user', 'x', $3, TRUE)
 ON CONFLICT (id) DO NOTHING;
 `, maxID, proID, basicID)
-    return err
+    return err == nil, err
+}
+
+// seedDemoPasswords ставит демо-пользователям пароли из domain.MockUsers,
+// если в password_hash лежит заглушка (пусто или 'x' из старого сида).
+// Администраторов не трогает: известный пароль админа не должен попасть ни в одну базу.
+func seedDemoPasswords(ctx context.Context, db *sql.DB, plans []domain.Plan) error {
+    if db == nil {
+        return nil
+    }
+
+    for _, u := range domain.MockUsers(plans) {
+        if u.Password == "" || u.Role == domain.RoleAdmin {
+            continue
+        }
+        hash, err := bcrypt.GenerateFromPassword([]byte(u.Password), bcrypt.DefaultCost)
+        if err != nil {
+            return err
+        }
+        if _, err := db.ExecContext(ctx, `
+UPDATE users
+SET password_hash = $1
+WHERE id = $2 AND password_hash IN ('', 'x') AND role <> 'admin';
+`, string(hash), u.ID); err != nil {
+            return err
+        }
+    }
+    return nil
 }
 
 //
@@ -210,7 +287,7 @@ func initCalculators(ctx context.Context, db *sql.DB, demoCalcs []*domain.Calcul
         return nil, err
     }
 
-    // если калькуляторов нет — засеваем демо
+    // если калькуляторов нет — засеваем демо (id берём из той же последовательности, что и для новых)
     if count == 0 && len(demoCalcs) > 0 {
         for _, c := range demoCalcs {
             if c == nil {
@@ -219,9 +296,8 @@ func initCalculators(ctx context.Context, db *sql.DB, demoCalcs []*domain.Calcul
             _, err := db.ExecContext(
                 ctx,
                 `INSERT INTO calculators
-                  (id, name, type, owner_id, status, created_at, public_token, public_path, calc_count)
-                 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
-                c.ID,
+                  (id, name, type, owner_id, status, created_at, public_token, public_path, calc_count, preview_token)
+                 VALUES ('calc_' || nextval('calculator_id_seq'), $1, $2, $3, $4, $5, $6, $7, $8, $9)`,
                 c.Name,
                 string(c.Type),
                 c.OwnerID,
@@ -230,6 +306,7 @@ func initCalculators(ctx context.Context, db *sql.DB, demoCalcs []*domain.Calcul
                 c.PublicToken,
                 c.PublicPath,
                 c.CalcCount,
+                domain.GeneratePublicToken(),
             )
             if err != nil {
                 return nil, err
@@ -237,10 +314,16 @@ func initCalculators(ctx context.Context, db *sql.DB, demoCalcs []*domain.Calcul
         }
     }
 
+    // личные организации пользователей + привязка калькуляторов к ним
+    if err := backfillOrganizations(ctx, db); err != nil {
+        return nil, err
+    }
+
     // грузим всё из БД
     rows, err := db.QueryContext(ctx, `
-SELECT id, name, type, owner_id, status, created_at, public_token, public_path, calc_count
+SELECT id, name, type, owner_id, COALESCE(org_id, ''), status, created_at, public_token, public_path, calc_count
 FROM calculators
+WHERE deleted_at IS NULL
 ORDER BY created_at DESC;
 `)
     if err != nil {
@@ -257,6 +340,7 @@ ORDER BY created_at DESC;
             &c.Name,
             &t,
             &c.OwnerID,
+            &c.OrgID,
             &c.Status,
             &c.CreatedAt,
             &c.PublicToken,
@@ -274,3 +358,12 @@ ORDER BY created_at DESC;
 
     return out, nil
 }
+
+// trashRetentionFromEnv — срок хранения калькуляторов в корзине (0 — по умолчанию, см. domain.DefaultTrashRetention).
+func trashRetentionFromEnv() time.Duration {
+    days, err := strconv.Atoi(os.Getenv("TRASH_RETENTION_DAYS"))
+    if err != nil || days <= 0 {
+        return 0
+    }
+    return time.Duration(days) * 24 * time.Hour
+}
@@ -2,7 +2,9 @@ package app
 
 import (
 	"context"
+	"crypto/rand"
 	"database/sql"
+	"encoding/hex"
 	"fmt"
 	"log"
 	"os"
@@ -121,9 +123,514 @@ CREATE TABLE IF NOT EXISTS calculators (
 		return err
 	}
 
+	// id калькуляторов выдаёт последовательность: calc_1, calc_2, ...
+	// При её создании продолжаем с наибольшего номера среди существующих калькуляторов.
+	var hasCalculatorIDSeq bool
+	if err := db.QueryRow(`SELECT to_regclass('calculator_id_seq') IS NOT NULL`).Scan(&hasCalculatorIDSeq); err != nil {
+		return err
+	}
+	if !hasCalculatorIDSeq {
+		if _, err := db.Exec(`
+CREATE SEQUENCE IF NOT EXISTS calculator_id_seq;
+SELECT setval('calculator_id_seq', COALESCE(MAX(substring(id FROM '^calc_([0-9]+)$')::BIGINT), 0) + 1, false)
+FROM calculators;
+`); err != nil {
+			return err
+		}
+	}
+
+	// подтверждение email; у уже существующих пользователей считаем его подтверждённым
+	if _, err := db.Exec(`
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS email_confirmed BOOLEAN NOT NULL DEFAULT TRUE;
+`); err != nil {
+		return err
+	}
+
+	// email уникален без учёта регистра. До индекса повторы не запрещались:
+	// адрес остаётся у самого старого аккаунта, остальным ставим заглушку duplicate-<id>@invalid
+	// (войти по ней нельзя, админ может поправить email вручную)
+	var hasEmailIndex bool
+	if err := db.QueryRow(`SELECT to_regclass('idx_users_email_lower') IS NOT NULL`).Scan(&hasEmailIndex); err != nil {
+		return err
+	}
+	if !hasEmailIndex {
+		res, err := db.Exec(`
+UPDATE users u
+SET email = 'duplicate-' || u.id || '@invalid', email_confirmed = FALSE
+WHERE EXISTS (
+    SELECT 1 FROM users o
+    WHERE lower(o.email) = lower(u.email)
+      AND (o.created_at, o.id) < (u.created_at, u.id)
+);
+`)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			log.Printf("ensureSchema: %d users with duplicate emails renamed to duplicate-<id>@invalid", n)
+		}
+		if _, err := db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email_lower ON users (lower(email));`); err != nil {
+			return err
+		}
+	}
+
+	// --- user_tokens (одноразовые токены: подтверждение email и т.п.) ---
+	if _, err := db.Exec(`
+CREATE TABLE IF NOT EXISTS user_tokens (
+    token_hash TEXT PRIMARY KEY,
+    user_id    TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    kind       TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at    TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_user_tokens_user ON user_tokens(user_id);
+`); err != nil {
+		return err
+	}
+
+	// --- organizations (рабочие пространства с общим тарифом) ---
+	if _, err := db.Exec(`
+CREATE TABLE IF NOT EXISTS organizations (
+    id           TEXT PRIMARY KEY,
+    name         TEXT NOT NULL,
+    plan_id      TEXT NOT NULL,
+    plan_active  BOOLEAN NOT NULL DEFAULT TRUE,
+    personal_for TEXT UNIQUE REFERENCES users(id) ON DELETE CASCADE,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS org_members (
+    org_id     TEXT NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    user_id    TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role       TEXT NOT NULL,          -- owner / editor / viewer
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (org_id, user_id)
+);
+CREATE INDEX IF NOT EXISTS idx_org_members_user ON org_members(user_id);
+
+ALTER TABLE calculators
+    ADD COLUMN IF NOT EXISTS org_id TEXT REFERENCES organizations(id) ON DELETE CASCADE;
+CREATE INDEX IF NOT EXISTS idx_calculators_org ON calculators(org_id);
+`); err != nil {
+		return err
+	}
+
+	// кто создал организацию (число организаций на пользователя ограничено);
+	// у уже существующих считаем создателем первого owner
+	if _, err := db.Exec(`
+ALTER TABLE organizations
+    ADD COLUMN IF NOT EXISTS created_by TEXT REFERENCES users(id) ON DELETE SET NULL;
+UPDATE organizations o
+SET created_by = (
+    SELECT m.user_id FROM org_members m
+    WHERE m.org_id = o.id AND m.role = 'owner'
+    ORDER BY m.created_at
+    LIMIT 1
+)
+WHERE o.created_by IS NULL AND o.personal_for IS NULL;
+`); err != nil {
+		return err
+	}
+
+	// --- org_invites (приглашения в организацию: участником становятся, только приняв его) ---
+	if _, err := db.Exec(`
+CREATE TABLE IF NOT EXISTS org_invites (
+    org_id     TEXT NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    email      TEXT NOT NULL,          -- в нижнем регистре
+    role       TEXT NOT NULL,          -- owner / editor / viewer
+    invited_by TEXT REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (org_id, email)
+);
+CREATE INDEX IF NOT EXISTS idx_org_invites_email ON org_invites(email);
+`); err != nil {
+		return err
+	}
+
+	// --- leads (заявки с публичных калькуляторов) ---
+	if _, err := db.Exec(`
+CREATE TABLE IF NOT EXISTS leads (
+    id         BIGSERIAL PRIMARY KEY,
+    owner_id   TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    calc_id    TEXT REFERENCES calculators(id) ON DELETE SET NULL,
+    payload    JSONB,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS idx_leads_owner ON leads(owner_id);
+`); err != nil {
+		return err
+	}
+
+	// --- api_keys (ключи для server-to-server доступа) ---
+	if _, err := db.Exec(`
+CREATE TABLE IF NOT EXISTS api_keys (
+    id           TEXT PRIMARY KEY,
+    user_id      TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name         TEXT NOT NULL,
+    prefix       TEXT NOT NULL,
+    key_hash     TEXT NOT NULL UNIQUE,
+    scopes       TEXT[] NOT NULL DEFAULT '{}',
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_used_at TIMESTAMPTZ,
+    revoked_at   TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_api_keys_user ON api_keys(user_id);
+`); err != nil {
+		return err
+	}
+
+	// --- sessions ---
+	if _, err := db.Exec(`
+CREATE TABLE IF NOT EXISTS sessions (
+    id         TEXT PRIMARY KEY,
+    user_id    TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_sessions_user ON sessions(user_id);
+`); err != nil {
+		return err
+	}
+
+	// секрет для подписи cookie сессий
+	if _, err := db.Exec(`
+ALTER TABLE settings
+    ADD COLUMN IF NOT EXISTS session_secret TEXT;
+`); err != nil {
+		return err
+	}
+
+	// вход администратора "под пользователем": чья это на самом деле сессия
+	// и к какой сессии админа вернуться
+	if _, err := db.Exec(`
+ALTER TABLE sessions
+    ADD COLUMN IF NOT EXISTS impersonator_id TEXT REFERENCES users(id) ON DELETE CASCADE,
+    ADD COLUMN IF NOT EXISTS parent_session_id TEXT REFERENCES sessions(id) ON DELETE CASCADE;
+`); err != nil {
+		return err
+	}
+
+	// защита от перебора паролей: счётчик неудачных входов и блокировка
+	if _, err := db.Exec(`
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS failed_logins INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS locked_until TIMESTAMPTZ;
+`); err != nil {
+		return err
+	}
+
+	// счётчик неудачных входов по адресу — ведётся и для email, которых нет в users,
+	// иначе блокировка выдавала бы, что учётная запись существует; users.locked_until — копия для админки
+	if _, err := db.Exec(`
+CREATE TABLE IF NOT EXISTS login_failures (
+    email        TEXT PRIMARY KEY,
+    failures     INTEGER NOT NULL DEFAULT 0,
+    last_failure TIMESTAMPTZ NOT NULL DEFAULT now(),
+    locked_until TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS login_failures_last_failure_idx ON login_failures (last_failure);
+`); err != nil {
+		return err
+	}
+
+	// двухфакторная аутентификация (TOTP): секрет, включена ли, последний принятый шаг
+	if _, err := db.Exec(`
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS totp_secret TEXT,
+    ADD COLUMN IF NOT EXISTS totp_enabled BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN IF NOT EXISTS totp_last_counter BIGINT NOT NULL DEFAULT 0;
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    user_id   TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash TEXT NOT NULL,
+    used_at   TIMESTAMPTZ,
+    PRIMARY KEY (user_id, code_hash)
+);
+ALTER TABLE settings
+    ADD COLUMN IF NOT EXISTS require_admin_2fa BOOLEAN NOT NULL DEFAULT FALSE;
+`); err != nil {
+		return err
+	}
+
+	// вход через корпоративного провайдера (OpenID Connect)
+	if _, err := db.Exec(`
+ALTER TABLE settings
+    ADD COLUMN IF NOT EXISTS oidc_issuer TEXT,
+    ADD COLUMN IF NOT EXISTS oidc_client_id TEXT,
+    ADD COLUMN IF NOT EXISTS oidc_client_secret TEXT;
+`); err != nil {
+		return err
+	}
+
+	// токен предпросмотра черновиков (?preview=...); старым калькуляторам выдаём случайный
+	if _, err := db.Exec(`
+ALTER TABLE calculators
+    ADD COLUMN IF NOT EXISTS preview_token TEXT NOT NULL DEFAULT '';
+UPDATE calculators SET preview_token = md5(random()::text || id) WHERE preview_token = '';
+`); err != nil {
+		return err
+	}
+
+	// человекочитаемый адрес /c/{slug}; уникален без учёта регистра
+	if _, err := db.Exec(`
+ALTER TABLE calculators
+    ADD COLUMN IF NOT EXISTS slug TEXT;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_calculators_slug ON calculators (lower(slug)) WHERE slug IS NOT NULL;
+`); err != nil {
+		return err
+	}
+
+	// корзина: удалённый калькулятор помечается deleted_at и через срок хранения удаляется насовсем
+	if _, err := db.Exec(`
+ALTER TABLE calculators
+    ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+CREATE INDEX IF NOT EXISTS idx_calculators_deleted ON calculators(deleted_at) WHERE deleted_at IS NOT NULL;
+`); err != nil {
+		return err
+	}
+
+	// --- calculator_folders (папки калькуляторов внутри организации) ---
+	if _, err := db.Exec(`
+CREATE TABLE IF NOT EXISTS calculator_folders (
+    id         TEXT PRIMARY KEY,
+    org_id     TEXT NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    name       TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_calculator_folders_name ON calculator_folders (org_id, lower(name));
+ALTER TABLE calculators
+    ADD COLUMN IF NOT EXISTS folder_id TEXT REFERENCES calculator_folders(id) ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS idx_calculators_folder ON calculators(folder_id);
+`); err != nil {
+		return err
+	}
+
+	// --- calculator_tags (метки калькуляторов для поиска и фильтров) ---
+	if _, err := db.Exec(`
+CREATE TABLE IF NOT EXISTS calculator_tags (
+    calculator_id TEXT NOT NULL REFERENCES calculators(id) ON DELETE CASCADE,
+    tag           TEXT NOT NULL,
+    PRIMARY KEY (calculator_id, tag)
+);
+CREATE INDEX IF NOT EXISTS idx_calculator_tags_tag ON calculator_tags(tag);
+`); err != nil {
+		return err
+	}
+
+	// --- calculator_redirects (история старых публичных адресов: перевыпуск токена, смена slug) ---
+	if _, err := db.Exec(`
+CREATE TABLE IF NOT EXISTS calculator_redirects (
+    path          TEXT PRIMARY KEY,
+    calculator_id TEXT NOT NULL REFERENCES calculators(id) ON DELETE CASCADE,
+    reason        TEXT NOT NULL,
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at    TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_calculator_redirects_calc ON calculator_redirects (calculator_id, created_at DESC);
+`); err != nil {
+		return err
+	}
+
+	// --- calculator_transfers (передача калькулятора другому пользователю) ---
+	if _, err := db.Exec(`
+CREATE TABLE IF NOT EXISTS calculator_transfers (
+    id            TEXT PRIMARY KEY,
+    calculator_id TEXT NOT NULL REFERENCES calculators(id) ON DELETE CASCADE,
+    from_org_id   TEXT NOT NULL,
+    from_user_id  TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    to_user_id    TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    status        TEXT NOT NULL,          -- pending / accepted / declined / cancelled
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at    TIMESTAMPTZ NOT NULL,
+    resolved_at   TIMESTAMPTZ
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_calculator_transfers_pending ON calculator_transfers (calculator_id) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_calculator_transfers_to ON calculator_transfers (to_user_id, status);
+`); err != nil {
+		return err
+	}
+
+	// получатель передачи — email: предложить можно и адресу без учётной записи,
+	// чтобы по ответу нельзя было понять, зарегистрирован ли он; to_user_id заполняется при принятии
+	if _, err := db.Exec(`
+ALTER TABLE calculator_transfers
+    ADD COLUMN IF NOT EXISTS to_email TEXT;
+UPDATE calculator_transfers t
+SET to_email = lower(u.email)
+FROM users u
+WHERE u.id = t.to_user_id AND t.to_email IS NULL;
+ALTER TABLE calculator_transfers
+    ALTER COLUMN to_user_id DROP NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_calculator_transfers_to_email ON calculator_transfers (to_email, status);
+`); err != nil {
+		return err
+	}
+
+	// --- calculator_configs (конфиг каждого калькулятора: слои, тарифы доставки и т.п.) ---
+	if _, err := db.Exec(`
+CREATE TABLE IF NOT EXISTS calculator_configs (
+    calculator_id TEXT PRIMARY KEY REFERENCES calculators(id) ON DELETE CASCADE,
+    config        JSONB NOT NULL,
+    updated_at    TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+`); err != nil {
+		return err
+	}
+
+	// --- calculator_config_versions (каждое сохранение конфига — неизменяемая версия) ---
+	if _, err := db.Exec(`
+ALTER TABLE calculator_configs
+    ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS calculator_config_versions (
+    calculator_id TEXT NOT NULL REFERENCES calculators(id) ON DELETE CASCADE,
+    version       INTEGER NOT NULL,
+    config        JSONB NOT NULL,
+    author_id     TEXT REFERENCES users(id) ON DELETE SET NULL,
+    restored_from INTEGER,
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (calculator_id, version)
+);
+
+-- конфиги, сохранённые до появления истории, становятся версией 1
+UPDATE calculator_configs SET version = 1 WHERE version = 0;
+INSERT INTO calculator_config_versions (calculator_id, version, config, created_at)
+SELECT c.calculator_id, c.version, c.config, c.updated_at
+FROM calculator_configs c
+ON CONFLICT (calculator_id, version) DO NOTHING;
+`); err != nil {
+		return err
+	}
+
+	// опубликованный снимок конфига — номер версии из истории; конструктор правит черновик.
+	// При появлении колонки считаем опубликованным то, что уже было на сайте.
+	var hasPublishedVersion bool
+	if err := db.QueryRow(`
+SELECT EXISTS (
+    SELECT 1 FROM information_schema.columns
+    WHERE table_name = 'calculator_configs' AND column_name = 'published_version'
+)
+`).Scan(&hasPublishedVersion); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`
+ALTER TABLE calculator_configs
+    ADD COLUMN IF NOT EXISTS published_version INTEGER,
+    ADD COLUMN IF NOT EXISTS published_at TIMESTAMPTZ;
+`); err != nil {
+		return err
+	}
+	if !hasPublishedVersion {
+		if _, err := db.Exec(`
+UPDATE calculator_configs SET published_version = version, published_at = updated_at
+WHERE published_version IS NULL;
+`); err != nil {
+			return err
+		}
+	}
+
+	// --- calculator_templates (каталог шаблонов калькуляторов) ---
+	if _, err := db.Exec(`
+CREATE TABLE IF NOT EXISTS calculator_templates (
+    id          TEXT PRIMARY KEY,
+    name        TEXT NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    type        TEXT NOT NULL,
+    config      JSONB NOT NULL,
+    builtin     BOOLEAN NOT NULL DEFAULT FALSE,
+    sort_order  INTEGER NOT NULL DEFAULT 0,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+`); err != nil {
+		return err
+	}
+
+	// --- audit_log ---
+	if _, err := db.Exec(`
+CREATE TABLE IF NOT EXISTS audit_log (
+    id                BIGSERIAL PRIMARY KEY,
+    created_at        TIMESTAMPTZ NOT NULL DEFAULT now(),
+    actor_id          TEXT NOT NULL,
+    effective_user_id TEXT NOT NULL,
+    action            TEXT NOT NULL,
+    method            TEXT NOT NULL DEFAULT '',
+    path              TEXT NOT NULL DEFAULT '',
+    status            INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS idx_audit_log_created ON audit_log(created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_audit_log_actor ON audit_log(actor_id);
+`); err != nil {
+		return err
+	}
+
 	return nil
 }
 
+// backfillOrganizations создаёт личные организации пользователям, у которых их ещё нет
+// (тариф переносится из users.plan_id), и привязывает к ним калькуляторы без org_id.
+func backfillOrganizations(ctx context.Context, db *sql.DB) error {
+	if db == nil {
+		return nil
+	}
+
+	_, err := db.ExecContext(ctx, `
+INSERT INTO organizations (id, name, plan_id, plan_active, personal_for)
+SELECT 'org_' || u.id, u.name, u.plan_id, u.plan_active, u.id
+FROM users u
+WHERE NOT EXISTS (SELECT 1 FROM organizations o WHERE o.personal_for = u.id)
+ON CONFLICT (id) DO NOTHING;
+
+INSERT INTO org_members (org_id, user_id, role)
+SELECT o.id, o.personal_for, 'owner'
+FROM organizations o
+WHERE o.personal_for IS NOT NULL
+ON CONFLICT (org_id, user_id) DO NOTHING;
+
+UPDATE calculators c
+SET org_id = o.id
+FROM organizations o
+WHERE c.org_id IS NULL AND o.personal_for = c.owner_id;
+`)
+	return err
+}
+
+// loadSessionSecret возвращает ключ подписи cookie сессий.
+// Приоритет: переменная окружения SESSION_SECRET, затем settings.session_secret.
+// Если ключа нет нигде — генерируем и сохраняем, чтобы сессии переживали рестарт.
+func loadSessionSecret(ctx context.Context, db *sql.DB) ([]byte, error) {
+	if s := os.Getenv("SESSION_SECRET"); s != "" {
+		return []byte(s), nil
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	generated := hex.EncodeToString(b)
+
+	if db == nil {
+		return []byte(generated), nil
+	}
+
+	// не перетираем уже сохранённый ключ
+	if _, err := db.ExecContext(ctx, `
+UPDATE settings
+SET session_secret = $1
+WHERE id = 1 AND COALESCE(session_secret, '') = '';
+`, generated); err != nil {
+		return nil, err
+	}
+
+	var secret string
+	if err := db.QueryRowContext(ctx, `SELECT session_secret FROM settings WHERE id = 1`).Scan(&secret); err != nil {
+		return nil, err
+	}
+	return []byte(secret), nil
+}
+
 // seedPlans заполняет таблицу plans тарифами, если она пустая.
 func seedPlans(ctx context.Context, db *sql.DB, plans []domain.Plan) error {
 	if db == nil {
@@ -167,6 +674,33 @@ ON CONFLICT (id) DO NOTHING;
 	return nil
 }
 
+// seedTemplates добавляет встроенные шаблоны калькуляторов, которых ещё нет в каталоге.
+// Уже существующие не трогаем — администратор мог их отредактировать.
+func seedTemplates(ctx context.Context, db *sql.DB) error {
+	if db == nil {
+		return nil
+	}
+
+	for _, t := range domain.BuiltinTemplates() {
+		_, err := db.ExecContext(ctx, `
+INSERT INTO calculator_templates (id, name, description, type, config, builtin, sort_order)
+VALUES ($1, $2, $3, $4, $5, TRUE, $6)
+ON CONFLICT (id) DO NOTHING;
+`,
+			t.ID,
+			t.Name,
+			t.Description,
+			string(t.Type),
+			[]byte(t.Config),
+			t.SortOrder,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // loadPlans загружает все тарифы из БД в []domain.Plan.
 func loadPlans(ctx context.Context, db *sql.DB) ([]domain.Plan, error) {
 	if db == nil {
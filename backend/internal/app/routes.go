@@ -2,8 +2,11 @@ package app
 
 import (
     "net/http"
+    "os"
+    "strconv"
 
     "saas-calc-backend/internal/handlers"
+    "saas-calc-backend/internal/ratelimit"
 )
 
 func withCORS(next http.Handler) http.Handler {
@@ -21,31 +24,96 @@ func withCORS(next http.Handler) http.Handler {
     })
 }
 
+// calcRateLimiter — ограничение публичных расчётов по IP, чтобы посетители
+// не выбирали нашу квоту OSRM/Nominatim. Настраивается через
+// CALC_RATE_PER_MIN (по умолчанию 30) и CALC_RATE_BURST (по умолчанию 10).
+func calcRateLimiter() *ratelimit.Limiter {
+    perMin := 30.0
+    if v, err := strconv.ParseFloat(os.Getenv("CALC_RATE_PER_MIN"), 64); err == nil && v > 0 {
+        perMin = v
+    }
+    burst := 10
+    if v, err := strconv.Atoi(os.Getenv("CALC_RATE_BURST")); err == nil && v > 0 {
+        burst = v
+    }
+    return ratelimit.NewLimiter(perMin, burst)
+}
+
 func registerRoutes(mux *http.ServeMux, env *handlers.Env) {
+    calcLimit := calcRateLimiter()
+
     // --- API ---
+    // вход / выход
+    mux.Handle("/api/auth/login", withCORS(http.HandlerFunc(env.HandleAuthLogin)))
+    mux.Handle("/api/auth/logout", withCORS(http.HandlerFunc(env.HandleAuthLogout)))
+    // вход через корпоративного провайдера (OpenID Connect)
+    mux.Handle("/api/auth/oidc", withCORS(http.HandlerFunc(env.HandleAuthOIDC)))
+    mux.Handle("/api/auth/oidc/login", http.HandlerFunc(env.HandleAuthOIDCLogin))
+    mux.Handle("/api/auth/oidc/callback", http.HandlerFunc(env.HandleAuthOIDCCallback))
+    // второй шаг входа при включённой 2FA
+    mux.Handle("/api/auth/login/2fa", withCORS(http.HandlerFunc(env.HandleAuthLogin2FA)))
+    // регистрация и подтверждение email
+    mux.Handle("/api/auth/signup", withCORS(http.HandlerFunc(env.HandleAuthSignup)))
+    mux.Handle("/api/auth/confirm", withCORS(http.HandlerFunc(env.HandleAuthConfirm)))
+    mux.Handle("/api/auth/confirm/resend", withCORS(http.HandlerFunc(env.HandleAuthConfirmResend)))
+    // сброс пароля по ссылке из письма
+    mux.Handle("/api/auth/password/forgot", withCORS(http.HandlerFunc(env.HandleAuthPasswordForgot)))
+    mux.Handle("/api/auth/password/reset", withCORS(http.HandlerFunc(env.HandleAuthPasswordReset)))
+    // приглашение от администратора: установка пароля
+    mux.Handle("/api/auth/invite/accept", withCORS(http.HandlerFunc(env.HandleAuthInviteAccept)))
+    // выход из режима "под пользователем"
+    mux.Handle("/api/auth/impersonation/stop", withCORS(http.HandlerFunc(env.HandleAuthImpersonationStop)))
+
     mux.Handle("/api/layers/config", withCORS(http.HandlerFunc(env.HandleLayeredConfig)))
     mux.Handle("/api/calculators", withCORS(http.HandlerFunc(env.HandleCalculators)))
+    mux.Handle("/api/calculators/", withCORS(http.HandlerFunc(env.HandleCalculatorDetail)))
+    // шаблоны калькуляторов
+    mux.Handle("/api/templates", withCORS(http.HandlerFunc(env.HandleTemplates)))
     mux.Handle("/api/me", withCORS(http.HandlerFunc(env.HandleMe)))
     mux.Handle("/api/me/plan", withCORS(http.HandlerFunc(env.HandleMePlan)))
 
     // 👉 тарифы
     mux.Handle("/api/plans", withCORS(http.HandlerFunc(env.HandlePlans)))
-    mux.HandleFunc("/api/mortgage/calc", env.HandleMortgageCalc)
+    mux.Handle("/api/mortgage/calc", calcLimit.Middleware(http.HandlerFunc(env.HandleMortgageCalc)))
 
     // админские пользователи
     mux.Handle("/api/admin/users", withCORS(http.HandlerFunc(env.HandleAdminUsers)))
     mux.Handle("/api/admin/users/", withCORS(http.HandlerFunc(env.HandleAdminUserDetail)))
     // настройки для администратора (ключи и т.п.)
     mux.Handle("/api/admin/settings", withCORS(http.HandlerFunc(env.HandleAdminSettings)))
+    // каталог шаблонов калькуляторов
+    mux.Handle("/api/admin/templates", withCORS(http.HandlerFunc(env.HandleAdminTemplates)))
+    mux.Handle("/api/admin/templates/", withCORS(http.HandlerFunc(env.HandleAdminTemplates)))
+    // журнал аудита
+    mux.Handle("/api/admin/audit", withCORS(http.HandlerFunc(env.HandleAdminAudit)))
     // конфиг калькулятора расстояний
     mux.Handle("/api/distance/config", withCORS(http.HandlerFunc(env.HandleDistanceConfig)))
     // расчёт расстояния
-    mux.Handle("/api/distance/calc", withCORS(http.HandlerFunc(env.HandleDistanceCalc)))
+    mux.Handle("/api/distance/calc", withCORS(calcLimit.Middleware(http.HandlerFunc(env.HandleDistanceCalc))))
+    // калькулятор выезда специалиста: конфиг и расчёт
+    mux.Handle("/api/onsite/config", withCORS(http.HandlerFunc(env.HandleOnSiteConfig)))
+    mux.Handle("/api/onsite/calc", withCORS(calcLimit.Middleware(http.HandlerFunc(env.HandleOnSiteCalc))))
+    mux.Handle("/api/formula/config", withCORS(http.HandlerFunc(env.HandleFormulaConfig)))
+    mux.Handle("/api/formula/calc", withCORS(calcLimit.Middleware(http.HandlerFunc(env.HandleFormulaCalc))))
+    mux.Handle("/api/form/config", withCORS(http.HandlerFunc(env.HandleFormConfig)))
+    mux.Handle("/api/form/calc", withCORS(calcLimit.Middleware(http.HandlerFunc(env.HandleFormCalc))))
     // загрузка файлов (картинки для слоёв)
     mux.Handle("/api/upload", withCORS(http.HandlerFunc(env.HandleUpload)))
     mux.Handle("/api/me/telegram", withCORS(http.HandlerFunc(env.HandleMeTelegram)))
+    // двухфакторная аутентификация
+    mux.Handle("/api/me/2fa", withCORS(http.HandlerFunc(env.HandleMe2FA)))
+    mux.Handle("/api/me/2fa/", withCORS(http.HandlerFunc(env.HandleMe2FA)))
+    // API-ключи пользователя
+    mux.Handle("/api/me/api-keys", withCORS(http.HandlerFunc(env.HandleMeAPIKeys)))
+    mux.Handle("/api/me/api-keys/", withCORS(http.HandlerFunc(env.HandleMeAPIKeys)))
+    // организации и участники
+    mux.Handle("/api/orgs", withCORS(http.HandlerFunc(env.HandleOrgs)))
+    mux.Handle("/api/orgs/", withCORS(http.HandlerFunc(env.HandleOrgs)))
+    // заявки
+    mux.Handle("/api/leads", withCORS(http.HandlerFunc(env.HandleLeads)))
     // публичные калькуляторы
     mux.Handle("/p/", http.HandlerFunc(env.HandlePublicCalculatorPage))
+    mux.Handle("/c/", http.HandlerFunc(env.HandlePublicSlugPage))
 
     // --- Статика и страницы ---
 
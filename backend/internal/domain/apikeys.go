@@ -0,0 +1,48 @@
+package domain
+
+import "time"
+
+// Скоупы API-ключей: что можно делать ключом при вызове с сервера.
+const (
+	ScopeCalculatorsRead = "calculators:read" // GET /api/calculators
+	ScopeCalcRun         = "calc:run"         // /api/distance/calc, /api/mortgage/calc, /api/onsite/calc, /api/formula/calc, /api/form/calc
+	ScopeLeadsRead       = "leads:read"       // GET /api/leads
+)
+
+// AllScopes — все известные скоупы (для валидации и фронта)
+func AllScopes() []string {
+	return []string{ScopeCalculatorsRead, ScopeCalcRun, ScopeLeadsRead}
+}
+
+// ValidScope проверяет, что скоуп известен
+func ValidScope(s string) bool {
+	for _, known := range AllScopes() {
+		if s == known {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKey — персональный ключ пользователя для server-to-server вызовов.
+// Сам ключ хранится только в виде хеша, Prefix — чтобы узнать ключ в списке.
+type APIKey struct {
+	ID         string     `json:"id"`
+	UserID     string     `json:"userId"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+}
+
+// HasScope — есть ли у ключа нужный скоуп
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
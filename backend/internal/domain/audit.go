@@ -0,0 +1,23 @@
+package domain
+
+import "time"
+
+// Действия в журнале аудита
+const (
+	AuditImpersonationStart = "impersonation_start"
+	AuditImpersonationStop  = "impersonation_stop"
+	AuditRequest            = "request" // изменяющий запрос, сделанный "под пользователем"
+)
+
+// AuditEntry — запись журнала аудита.
+// ActorID — кто действовал на самом деле, EffectiveUserID — от чьего имени.
+type AuditEntry struct {
+	ID              int64     `json:"id"`
+	CreatedAt       time.Time `json:"createdAt"`
+	ActorID         string    `json:"actorId"`
+	EffectiveUserID string    `json:"effectiveUserId"`
+	Action          string    `json:"action"`
+	Method          string    `json:"method,omitempty"`
+	Path            string    `json:"path,omitempty"`
+	Status          int       `json:"status,omitempty"`
+}
@@ -0,0 +1,34 @@
+package domain
+
+import "time"
+
+// Переносимый архив калькулятора (zip) для переноса между аккаунтами и стендами:
+//
+//	manifest.json   — CalculatorBundleManifest
+//	calculator.json — CalculatorBundleMeta
+//	config.json     — черновик конфига (нет, если конфиг не сохраняли)
+//	uploads/...     — картинки, на которые ссылается конфиг
+const (
+	CalculatorBundleFormat  = "saas-calc-bundle"
+	CalculatorBundleVersion = 1
+)
+
+// CalculatorBundleManifest — оглавление архива.
+type CalculatorBundleManifest struct {
+	Format     string    `json:"format"`
+	Version    int       `json:"version"`
+	ExportedAt time.Time `json:"exportedAt"`
+	HasConfig  bool      `json:"hasConfig"`
+
+	// Files — ссылка /uploads/... из конфига -> путь файла внутри архива.
+	Files map[string]string `json:"files"`
+}
+
+// CalculatorBundleMeta — данные калькулятора, которые переносятся вместе с конфигом.
+// Токены, статистика и владелец не переносятся: при импорте калькулятор создаётся заново черновиком.
+type CalculatorBundleMeta struct {
+	SourceID string         `json:"sourceId"`
+	Name     string         `json:"name"`
+	Type     CalculatorType `json:"type"`
+	Status   string         `json:"status"`
+}
@@ -13,25 +13,90 @@ const (
 	CalculatorTypeDistance CalculatorType = "distance"
 	CalculatorTypeOnSite   CalculatorType = "on_site"
 	CalculatorTypeMortgage CalculatorType = "mortgage"
+	CalculatorTypeFormula  CalculatorType = "formula"
+	CalculatorTypeForm     CalculatorType = "form"
 )
 
+// ValidCalculatorType — известный ли тип калькулятора.
+func ValidCalculatorType(t CalculatorType) bool {
+	switch t {
+	case CalculatorTypeLayered,
+		CalculatorTypeDistance,
+		CalculatorTypeOnSite,
+		CalculatorTypeMortgage,
+		CalculatorTypeFormula,
+		CalculatorTypeForm:
+		return true
+	}
+	return false
+}
+
+// Статусы калькулятора. Публичная страница открыта только у published,
+// черновик и архив видны лишь по ссылке предпросмотра.
+const (
+	CalculatorStatusDraft     = "draft"
+	CalculatorStatusPublished = "published"
+	CalculatorStatusArchived  = "archived"
+)
+
+// calculatorTransitions — допустимые переходы: draft ⇄ published ⇄ archived.
+var calculatorTransitions = map[string][]string{
+	CalculatorStatusDraft:     {CalculatorStatusPublished},
+	CalculatorStatusPublished: {CalculatorStatusDraft, CalculatorStatusArchived},
+	CalculatorStatusArchived:  {CalculatorStatusPublished},
+}
+
+// ValidCalculatorStatus — известный ли статус.
+func ValidCalculatorStatus(s string) bool {
+	_, ok := calculatorTransitions[s]
+	return ok
+}
+
+// CanTransitionCalculator — можно ли перевести калькулятор из статуса from в to.
+func CanTransitionCalculator(from, to string) bool {
+	for _, s := range calculatorTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
 // Calculator — описание калькулятора в системе
 type Calculator struct {
 	ID          string         `json:"id"`
 	Name        string         `json:"name"`
 	Type        CalculatorType `json:"type"`
 	OwnerID     string         `json:"ownerId"`
+	OrgID       string         `json:"orgId"` // организация, которой принадлежит калькулятор
 	Status      string         `json:"status"` // draft / published / archived и т.п.
 	CreatedAt   time.Time      `json:"createdAt"`
 
 	// Публичная часть: токен и путь, по которому доступен калькулятор
 	PublicToken string `json:"publicToken"`
 	PublicPath  string `json:"publicPath"`
+	Slug        string `json:"slug,omitempty"` // если задан, PublicPath — /c/{slug}
+
+	// Токен предпросмотра: ?preview=... открывает страницу черновика или архива
+	PreviewToken string `json:"previewToken,omitempty"`
 
 	// Сколько раз этот калькулятор реально считали (учитываем в тарифе)
 	CalcCount int `json:"calcCount"`
+
+	// В черновике конфига есть правки, которых ещё нет на публичной странице
+	UnpublishedChanges bool `json:"unpublishedChanges"`
+
+	// Когда калькулятор отправили в корзину (nil — не удалён)
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+
+	// Папка (пусто — без папки) и метки для поиска и фильтров в списке
+	FolderID string   `json:"folderId,omitempty"`
+	Tags     []string `json:"tags"`
 }
 
+// DefaultTrashRetention — сколько удалённый калькулятор можно восстановить из корзины.
+const DefaultTrashRetention = 30 * 24 * time.Hour
+
 // GeneratePublicToken — простой генератор токена для публичного доступа к калькулятору
 func GeneratePublicToken() string {
 	const size = 16
@@ -72,7 +137,7 @@ func MockCalculators(users []*User) []*Calculator {
 			Name:        "Прицеп – послойный калькулятор (демо)",
 			Type:        CalculatorTypeLayered,
 			OwnerID:     user1ID,
-			Status:      "published",
+			Status:      CalculatorStatusPublished,
 			CreatedAt:   now.Add(-48 * time.Hour),
 			PublicToken: token1,
 			PublicPath:  "/p/" + user1ID + "/" + token1,
@@ -83,7 +148,7 @@ func MockCalculators(users []*User) []*Calculator {
 			Name:        "Расчёт доставки по городу (демо)",
 			Type:        CalculatorTypeDistance,
 			OwnerID:     user1ID,
-			Status:      "published",
+			Status:      CalculatorStatusPublished,
 			CreatedAt:   now.Add(-24 * time.Hour),
 			PublicToken: token2,
 			PublicPath:  "/p/" + user1ID + "/" + token2,
@@ -94,7 +159,7 @@ func MockCalculators(users []*User) []*Calculator {
 			Name:        "Выезд замерщика (демо)",
 			Type:        CalculatorTypeOnSite,
 			OwnerID:     user2ID,
-			Status:      "draft",
+			Status:      CalculatorStatusDraft,
 			CreatedAt:   now.Add(-12 * time.Hour),
 			PublicToken: token3,
 			PublicPath:  "/p/" + user2ID + "/" + token3,
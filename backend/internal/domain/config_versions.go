@@ -0,0 +1,160 @@
+package domain
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// ConfigVersion — сохранённая версия конфига калькулятора (неизменяемая).
+type ConfigVersion struct {
+	CalculatorID string          `json:"calculatorId"`
+	Version      int             `json:"version"`
+	AuthorID     string          `json:"authorId,omitempty"`
+	AuthorEmail  string          `json:"authorEmail,omitempty"`
+	RestoredFrom int             `json:"restoredFrom,omitempty"` // если версия — откат к более старой
+	CreatedAt    time.Time       `json:"createdAt"`
+	Current      bool            `json:"current"`   // текущий черновик
+	Published    bool            `json:"published"` // эту версию видят посетители
+	Config       json.RawMessage `json:"config,omitempty"`
+}
+
+// Виды изменений в ConfigChange.
+const (
+	ConfigChangeAdded   = "added"
+	ConfigChangeRemoved = "removed"
+	ConfigChangeChanged = "changed"
+)
+
+// ConfigChange — одно отличие между двумя версиями конфига.
+// Path — путь до поля: "basePrice", "vehicleCoefs.large", "options[frame_tent].price", "options[2]".
+type ConfigChange struct {
+	Path string      `json:"path"`
+	Op   string      `json:"op"`
+	From interface{} `json:"from,omitempty"`
+	To   interface{} `json:"to,omitempty"`
+}
+
+// DiffConfigs сравнивает два конфига (JSON) и возвращает список отличий.
+// Массивы объектов с уникальным полем id (опции послойного калькулятора) сравниваются по id,
+// чтобы перестановка опций не выглядела как изменение всех элементов.
+func DiffConfigs(from, to []byte) ([]ConfigChange, error) {
+	var a, b interface{}
+	if err := json.Unmarshal(from, &a); err != nil {
+		return nil, fmt.Errorf("from: %v", err)
+	}
+	if err := json.Unmarshal(to, &b); err != nil {
+		return nil, fmt.Errorf("to: %v", err)
+	}
+
+	changes := make([]ConfigChange, 0)
+	diffValues("", a, b, &changes)
+	return changes, nil
+}
+
+func diffValues(path string, a, b interface{}, out *[]ConfigChange) {
+	switch av := a.(type) {
+	case map[string]interface{}:
+		if bv, ok := b.(map[string]interface{}); ok {
+			diffMaps(path, av, bv, out)
+			return
+		}
+	case []interface{}:
+		if bv, ok := b.([]interface{}); ok {
+			diffArrays(path, av, bv, out)
+			return
+		}
+	}
+
+	if !reflect.DeepEqual(a, b) {
+		*out = append(*out, ConfigChange{Path: path, Op: ConfigChangeChanged, From: a, To: b})
+	}
+}
+
+func diffMaps(path string, a, b map[string]interface{}, out *[]ConfigChange) {
+	keys := make([]string, 0, len(a)+len(b))
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		p := k
+		if path != "" {
+			p = path + "." + k
+		}
+		av, inA := a[k]
+		bv, inB := b[k]
+		switch {
+		case !inA:
+			*out = append(*out, ConfigChange{Path: p, Op: ConfigChangeAdded, To: bv})
+		case !inB:
+			*out = append(*out, ConfigChange{Path: p, Op: ConfigChangeRemoved, From: av})
+		default:
+			diffValues(p, av, bv, out)
+		}
+	}
+}
+
+func diffArrays(path string, a, b []interface{}, out *[]ConfigChange) {
+	aByID, aIDs := indexByID(a)
+	bByID, bIDs := indexByID(b)
+	if aByID == nil || bByID == nil {
+		// обычный массив — сравниваем по позициям
+		for i := 0; i < len(a) || i < len(b); i++ {
+			p := fmt.Sprintf("%s[%d]", path, i)
+			switch {
+			case i >= len(a):
+				*out = append(*out, ConfigChange{Path: p, Op: ConfigChangeAdded, To: b[i]})
+			case i >= len(b):
+				*out = append(*out, ConfigChange{Path: p, Op: ConfigChangeRemoved, From: a[i]})
+			default:
+				diffValues(p, a[i], b[i], out)
+			}
+		}
+		return
+	}
+
+	for _, id := range aIDs {
+		p := path + "[" + id + "]"
+		if bv, ok := bByID[id]; ok {
+			diffValues(p, aByID[id], bv, out)
+		} else {
+			*out = append(*out, ConfigChange{Path: p, Op: ConfigChangeRemoved, From: aByID[id]})
+		}
+	}
+	for _, id := range bIDs {
+		if _, ok := aByID[id]; !ok {
+			*out = append(*out, ConfigChange{Path: path + "[" + id + "]", Op: ConfigChangeAdded, To: bByID[id]})
+		}
+	}
+}
+
+// indexByID — элементы массива по полю id (nil, если это не массив объектов с уникальными id).
+func indexByID(items []interface{}) (map[string]interface{}, []string) {
+	byID := make(map[string]interface{}, len(items))
+	ids := make([]string, 0, len(items))
+	for _, item := range items {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, nil
+		}
+		id, ok := m["id"].(string)
+		if !ok || id == "" {
+			return nil, nil
+		}
+		if _, dup := byID[id]; dup {
+			return nil, nil
+		}
+		byID[id] = m
+		ids = append(ids, id)
+	}
+	return byID, ids
+}
@@ -0,0 +1,52 @@
+package domain
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// CalculatorFolder — папка калькуляторов в организации.
+type CalculatorFolder struct {
+	ID          string    `json:"id"`
+	OrgID       string    `json:"orgId"`
+	Name        string    `json:"name"`
+	CreatedAt   time.Time `json:"createdAt"`
+	Calculators int       `json:"calculators"` // сколько калькуляторов в папке (без корзины)
+}
+
+// TagCount — метка и сколько калькуляторов ею помечено.
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+// Ограничения на метки калькулятора.
+const (
+	MaxCalculatorTags = 20
+	MaxTagLength      = 32
+)
+
+// NormalizeTags приводит метки к нижнему регистру, убирает пустые и повторы и сортирует.
+func NormalizeTags(tags []string) ([]string, error) {
+	seen := map[string]bool{}
+	out := make([]string, 0, len(tags))
+	for _, t := range tags {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t == "" || seen[t] {
+			continue
+		}
+		if utf8.RuneCountInString(t) > MaxTagLength {
+			return nil, errors.New("tag is too long: " + t)
+		}
+		seen[t] = true
+		out = append(out, t)
+	}
+	if len(out) > MaxCalculatorTags {
+		return nil, errors.New("too many tags")
+	}
+	sort.Strings(out)
+	return out, nil
+}
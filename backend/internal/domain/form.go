@@ -0,0 +1,236 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Виды полей калькулятора-формы.
+const (
+	FormFieldNumber      = "number"      // число с min/max/step
+	FormFieldSlider      = "slider"      // ползунок, обязательно с min и max
+	FormFieldRadio       = "radio"       // один вариант из списка
+	FormFieldMultiselect = "multiselect" // несколько вариантов
+	FormFieldText        = "text"        // свободный текст (комментарий, надпись для гравировки)
+)
+
+// Условия видимости поля.
+const (
+	FormCondFilled      = "filled"       // поле заполнено: число не 0, текст не пуст, выбран хоть один вариант (так же проверяется Required)
+	FormCondEmpty       = "empty"        //
+	FormCondEq          = "eq"           // число == value
+	FormCondNe          = "ne"           //
+	FormCondGt          = "gt"           //
+	FormCondGte         = "gte"          //
+	FormCondLt          = "lt"           //
+	FormCondLte         = "lte"          //
+	FormCondSelected    = "selected"     // выбран вариант option
+	FormCondNotSelected = "not_selected" //
+)
+
+// FormOption — вариант radio / multiselect со своей ценой и множителем.
+type FormOption struct {
+	ID         string  `json:"id"`
+	Label      string  `json:"label"`
+	Price      float64 `json:"price"`      // прибавка к сумме, ₽
+	Multiplier float64 `json:"multiplier"` // множитель всей суммы (0 или 1 — нет)
+}
+
+// FormCondition — условие видимости: поле показывается, только если выполнены все его условия.
+// Ссылаться можно только на поля выше по списку; скрытое поле считается пустым.
+type FormCondition struct {
+	Field  string  `json:"field"`            // id поля
+	Op     string  `json:"op"`               // см. FormCond*
+	Value  float64 `json:"value,omitempty"`  // для сравнений чисел
+	Option string  `json:"option,omitempty"` // для selected / not_selected
+}
+
+// FormField — поле формы. Цена поля складывается из фиксированной части (если поле заполнено),
+// цены за единицу (для чисел и ползунков) и цен выбранных вариантов; множители применяются к сумме.
+type FormField struct {
+	ID          string          `json:"id"`
+	Label       string          `json:"label"`
+	Kind        string          `json:"kind"`
+	Required    bool            `json:"required"`              // см. FormCondFilled: у чисел 0 считается пустым
+	Min         *float64        `json:"min,omitempty"`         // number / slider
+	Max         *float64        `json:"max,omitempty"`         // number / slider
+	Step        float64         `json:"step,omitempty"`        // number / slider, 0 — любое
+	Default     float64         `json:"default"`               // number / slider
+	Unit        string          `json:"unit,omitempty"`        // number / slider: м², шт.
+	Placeholder string          `json:"placeholder,omitempty"` // text
+	Options     []FormOption    `json:"options,omitempty"`     // radio / multiselect
+	Price       float64         `json:"price"`                 // фиксированная прибавка, если поле заполнено, ₽
+	UnitPrice   float64         `json:"unitPrice"`             // number / slider: цена за единицу, ₽
+	Multiplier  float64         `json:"multiplier"`            // множитель суммы, если поле заполнено (0 или 1 — нет)
+	VisibleIf   []FormCondition `json:"visibleIf,omitempty"`
+}
+
+// FormConfig — конфигурация калькулятора-формы: упорядоченный список полей и базовая цена.
+// Сумма считается только на сервере (/api/form/calc), посетитель не может её подменить.
+type FormConfig struct {
+	Description string      `json:"description"`
+	BasePrice   float64     `json:"basePrice"` // прибавляется всегда, ₽
+	BaseLabel   string      `json:"baseLabel"` // подпись базовой цены в расчёте
+	MinTotal    float64     `json:"minTotal"`  // минимальная сумма заказа, ₽ (0 — нет)
+	Fields      []FormField `json:"fields"`
+}
+
+// Ограничения на размер формы.
+const (
+	FormMaxFields     = 100
+	FormMaxOptions    = 50
+	FormMaxTextLen    = 500
+	FormMaxConditions = 10
+)
+
+// NewDefaultFormConfig — дефолтные значения для демо: уборка квартиры.
+func NewDefaultFormConfig() *FormConfig {
+	areaMin, areaMax := 10.0, 500.0
+	bathMin, bathMax := 1.0, 5.0
+	balconyMin, balconyMax := 1.0, 50.0
+	return &FormConfig{
+		Description: "Стоимость уборки квартиры",
+		BasePrice:   1000,
+		BaseLabel:   "Выезд бригады",
+		MinTotal:    3000,
+		Fields: []FormField{
+			{ID: "type", Label: "Вид уборки", Kind: FormFieldRadio, Required: true, Options: []FormOption{
+				{ID: "regular", Label: "Поддерживающая"},
+				{ID: "general", Label: "Генеральная", Multiplier: 1.5},
+				{ID: "renovation", Label: "После ремонта", Multiplier: 2},
+			}},
+			{ID: "area", Label: "Площадь", Kind: FormFieldNumber, Required: true, Min: &areaMin, Max: &areaMax, Step: 1, Default: 50, Unit: "м²", UnitPrice: 60},
+			{ID: "bathrooms", Label: "Санузлы", Kind: FormFieldSlider, Min: &bathMin, Max: &bathMax, Step: 1, Default: 1, Unit: "шт.", UnitPrice: 500},
+			{ID: "extras", Label: "Дополнительно", Kind: FormFieldMultiselect, Options: []FormOption{
+				{ID: "windows", Label: "Мойка окон", Price: 1500},
+				{ID: "fridge", Label: "Холодильник внутри", Price: 800},
+				{ID: "balcony", Label: "Балкон", Price: 500},
+			}},
+			{ID: "balcony_area", Label: "Площадь балкона", Kind: FormFieldNumber, Min: &balconyMin, Max: &balconyMax, Step: 1, Default: 3, Unit: "м²", UnitPrice: 100,
+				VisibleIf: []FormCondition{{Field: "extras", Op: FormCondSelected, Option: "balcony"}}},
+			{ID: "comment", Label: "Комментарий", Kind: FormFieldText, Placeholder: "Этаж, наличие лифта, пожелания"},
+		},
+	}
+}
+
+// FindField — поле по id (nil, если нет).
+func (c *FormConfig) FindField(id string) *FormField {
+	for i := range c.Fields {
+		if c.Fields[i].ID == id {
+			return &c.Fields[i]
+		}
+	}
+	return nil
+}
+
+// FindOption — вариант по id (nil, если нет).
+func (f *FormField) FindOption(id string) *FormOption {
+	for i := range f.Options {
+		if f.Options[i].ID == id {
+			return &f.Options[i]
+		}
+	}
+	return nil
+}
+
+// Numeric — числовое ли поле (number или slider).
+func (f *FormField) Numeric() bool {
+	return f.Kind == FormFieldNumber || f.Kind == FormFieldSlider
+}
+
+// Validate проверяет конфиг перед сохранением: id полей и вариантов непустые и не повторяются,
+// у списков есть варианты, у ползунков — границы, цены не отрицательные,
+// условия видимости ссылаются на поля выше по списку и подходят к их виду.
+func (c *FormConfig) Validate() error {
+	if c.BasePrice < 0 || c.MinTotal < 0 {
+		return errors.New("basePrice and minTotal must be >= 0")
+	}
+	if len(c.Fields) == 0 {
+		return errors.New("at least one field is required")
+	}
+	if len(c.Fields) > FormMaxFields {
+		return fmt.Errorf("at most %d fields allowed", FormMaxFields)
+	}
+
+	seen := map[string]*FormField{}
+	for i := range c.Fields {
+		f := &c.Fields[i]
+		if strings.TrimSpace(f.ID) == "" || seen[f.ID] != nil {
+			return errors.New("field ids must be unique and non-empty")
+		}
+		if f.Price < 0 || f.UnitPrice < 0 || f.Multiplier < 0 {
+			return fmt.Errorf("field %s: prices and multiplier must be >= 0", f.ID)
+		}
+
+		switch f.Kind {
+		case FormFieldNumber, FormFieldSlider:
+			if f.Kind == FormFieldSlider && (f.Min == nil || f.Max == nil) {
+				return fmt.Errorf("field %s: slider needs min and max", f.ID)
+			}
+			if f.Min != nil && f.Max != nil && *f.Min > *f.Max {
+				return fmt.Errorf("field %s: min must not exceed max", f.ID)
+			}
+			if (f.Min != nil && f.Default < *f.Min) || (f.Max != nil && f.Default > *f.Max) {
+				return fmt.Errorf("field %s: default is out of min/max range", f.ID)
+			}
+			if f.Step < 0 {
+				return fmt.Errorf("field %s: step must be >= 0", f.ID)
+			}
+			if !OnStep(f.Default, f.Min, f.Step) {
+				return fmt.Errorf("field %s: default must be min plus a multiple of step", f.ID)
+			}
+		case FormFieldRadio, FormFieldMultiselect:
+			if len(f.Options) == 0 || len(f.Options) > FormMaxOptions {
+				return fmt.Errorf("field %s: needs from 1 to %d options", f.ID, FormMaxOptions)
+			}
+			opts := map[string]bool{}
+			for _, o := range f.Options {
+				if strings.TrimSpace(o.ID) == "" || opts[o.ID] {
+					return fmt.Errorf("field %s: option ids must be unique and non-empty", f.ID)
+				}
+				opts[o.ID] = true
+				if o.Price < 0 || o.Multiplier < 0 {
+					return fmt.Errorf("field %s: option prices and multipliers must be >= 0", f.ID)
+				}
+			}
+		case FormFieldText:
+		default:
+			return fmt.Errorf("field %s: kind must be number, slider, radio, multiselect or text", f.ID)
+		}
+
+		if len(f.VisibleIf) > FormMaxConditions {
+			return fmt.Errorf("field %s: at most %d visibility conditions allowed", f.ID, FormMaxConditions)
+		}
+		for _, cond := range f.VisibleIf {
+			if err := cond.validate(seen[cond.Field]); err != nil {
+				return fmt.Errorf("field %s: %v", f.ID, err)
+			}
+		}
+
+		seen[f.ID] = f
+	}
+	return nil
+}
+
+// validate проверяет условие относительно поля, на которое оно ссылается (nil — такого поля выше нет).
+func (cond *FormCondition) validate(target *FormField) error {
+	if target == nil {
+		return fmt.Errorf("condition refers to %q, which is not a field above", cond.Field)
+	}
+	switch cond.Op {
+	case FormCondFilled, FormCondEmpty:
+		return nil
+	case FormCondEq, FormCondNe, FormCondGt, FormCondGte, FormCondLt, FormCondLte:
+		if !target.Numeric() {
+			return fmt.Errorf("condition %s needs a number or slider field", cond.Op)
+		}
+		return nil
+	case FormCondSelected, FormCondNotSelected:
+		if target.FindOption(cond.Option) == nil {
+			return fmt.Errorf("condition refers to unknown option %q of %s", cond.Option, cond.Field)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown condition %q", cond.Op)
+}
@@ -0,0 +1,105 @@
+package domain
+
+import "math"
+
+// Виды полей формульного калькулятора.
+const (
+	FormulaInputNumber   = "number"   // число с min/max/step
+	FormulaInputSelect   = "select"   // выбор из списка, в формулу идёт value выбранного варианта
+	FormulaInputCheckbox = "checkbox" // галочка: отмечена — value, иначе 0
+)
+
+// FormulaOption — вариант выпадающего списка.
+type FormulaOption struct {
+	Label string  `json:"label"`
+	Value float64 `json:"value"`
+}
+
+// FormulaInput — переменная, которую посетитель вводит в форме.
+// Name — имя в формулах (латиница, цифры, _), Label — подпись в форме.
+type FormulaInput struct {
+	Name    string          `json:"name"`
+	Label   string          `json:"label"`
+	Kind    string          `json:"kind"`              // number / select / checkbox
+	Default float64         `json:"default"`           // number: начальное значение; select: value выбранного варианта; checkbox: != 0 — отмечена
+	Min     *float64        `json:"min,omitempty"`     // number
+	Max     *float64        `json:"max,omitempty"`     // number
+	Step    float64         `json:"step,omitempty"`    // number, 0 — любое
+	Unit    string          `json:"unit,omitempty"`    // number: подпись единиц (м², шт.)
+	Options []FormulaOption `json:"options,omitempty"` // select
+	Value   float64         `json:"value,omitempty"`   // checkbox: значение отмеченной галочки
+}
+
+// FormulaOutput — результат расчёта. Формула может ссылаться на поля и на результаты выше по списку.
+type FormulaOutput struct {
+	Name     string `json:"name"`
+	Label    string `json:"label"`
+	Expr     string `json:"expr"`
+	Unit     string `json:"unit,omitempty"`   // ₽, дней и т.п.
+	Decimals int    `json:"decimals"`         // знаков после запятой при показе
+	Hidden   bool   `json:"hidden,omitempty"` // промежуточный результат: считается, но посетителю не показывается
+}
+
+// FormulaConfig — конфигурация формульного калькулятора: поля формы и формулы результатов.
+// Формулы считаются только на сервере, посетителю уходят поля и подписи результатов.
+type FormulaConfig struct {
+	Description string          `json:"description"`
+	Inputs      []FormulaInput  `json:"inputs"`
+	Outputs     []FormulaOutput `json:"outputs"`
+}
+
+// NewDefaultFormulaConfig — дефолтные значения для демо: площадь × цена за м² плюс надбавка за срочность.
+func NewDefaultFormulaConfig() *FormulaConfig {
+	areaMin, areaMax := 1.0, 1000.0
+	return &FormulaConfig{
+		Description: "Стоимость работ по площади помещения",
+		Inputs: []FormulaInput{
+			{Name: "area", Label: "Площадь", Kind: FormulaInputNumber, Default: 20, Min: &areaMin, Max: &areaMax, Step: 0.1, Unit: "м²"},
+			{Name: "price", Label: "Тип работ", Kind: FormulaInputSelect, Default: 500, Options: []FormulaOption{
+				{Label: "Покраска", Value: 500},
+				{Label: "Поклейка обоев", Value: 700},
+				{Label: "Штукатурка", Value: 900},
+			}},
+			{Name: "urgent", Label: "Срочно (+15%)", Kind: FormulaInputCheckbox, Value: 1},
+		},
+		Outputs: []FormulaOutput{
+			{Name: "base", Label: "Работы", Expr: "area * price", Unit: "₽"},
+			{Name: "surcharge", Label: "Надбавка за срочность", Expr: "if(urgent, base * 0.15, 0)", Unit: "₽"},
+			{Name: "total", Label: "Итого", Expr: "max(round(base + surcharge), 5000)", Unit: "₽"},
+		},
+	}
+}
+
+// FindInput — поле по имени (nil, если нет).
+func (c *FormulaConfig) FindInput(name string) *FormulaInput {
+	for i := range c.Inputs {
+		if c.Inputs[i].Name == name {
+			return &c.Inputs[i]
+		}
+	}
+	return nil
+}
+
+// HasOption — есть ли у выпадающего списка вариант с таким значением.
+func (in *FormulaInput) HasOption(v float64) bool {
+	for _, o := range in.Options {
+		if o.Value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// OnStep — лежит ли v на сетке min + k*step (без min — от нуля). step 0 — подходит любое значение.
+// Небольшой допуск нужен из-за дробных шагов вроде 0.1.
+func OnStep(v float64, min *float64, step float64) bool {
+	if step <= 0 {
+		return true
+	}
+	base := 0.0
+	if min != nil {
+		base = *min
+	}
+	k := (v - base) / step
+	return math.Abs(k-math.Round(k)) <= 1e-6
+}
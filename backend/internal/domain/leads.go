@@ -0,0 +1,15 @@
+package domain
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Lead — заявка, оставленная посетителем публичного калькулятора
+type Lead struct {
+	ID        int64           `json:"id"`
+	OwnerID   string          `json:"ownerId"`
+	CalcID    string          `json:"calcId,omitempty"`
+	Payload   json.RawMessage `json:"payload,omitempty"`
+	CreatedAt time.Time       `json:"createdAt"`
+}
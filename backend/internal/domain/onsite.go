@@ -0,0 +1,118 @@
+package domain
+
+import (
+	"errors"
+	"strings"
+)
+
+// OnSiteService — вид выезда (замер, консультация, монтаж) и его стоимость.
+type OnSiteService struct {
+	ID       string  `json:"id"`
+	Name     string  `json:"name"`
+	VisitFee float64 `json:"visitFee"` // стоимость выезда, ₽
+}
+
+// OnSiteUrgency — срочность выезда и надбавка за неё.
+type OnSiteUrgency struct {
+	ID               string  `json:"id"`
+	Name             string  `json:"name"`
+	SurchargePercent float64 `json:"surchargePercent"` // % к стоимости выезда и дороги
+	SurchargeFixed   float64 `json:"surchargeFixed"`   // фиксированная надбавка, ₽
+}
+
+// OnSiteConfig — конфигурация калькулятора выезда специалиста (замерщика).
+// Дорога в пределах бесплатного радиуса от базы не оплачивается, дальше — за каждый км.
+type OnSiteConfig struct {
+	BaseAddress  string          `json:"baseAddress"`  // откуда выезжает специалист
+	BaseLat      float64         `json:"baseLat"`      // координаты базы, заполняются при сохранении конфига
+	BaseLon      float64         `json:"baseLon"`      //
+	FreeRadiusKm float64         `json:"freeRadiusKm"` // бесплатный радиус по дорогам, км
+	PricePerKm   float64         `json:"pricePerKm"`   // цена за км за пределами радиуса, ₽
+	RoundTrip    bool            `json:"roundTrip"`    // платные км считаются туда и обратно
+	Services     []OnSiteService `json:"services"`
+	Urgencies    []OnSiteUrgency `json:"urgencies"`
+}
+
+// NewDefaultOnSiteConfig — дефолтные значения для демо
+func NewDefaultOnSiteConfig() *OnSiteConfig {
+	return &OnSiteConfig{
+		BaseAddress:  "Москва, Тверская улица, 1",
+		BaseLat:      55.757718,
+		BaseLon:      37.612916,
+		FreeRadiusKm: 10,
+		PricePerKm:   30,
+		RoundTrip:    true,
+		Services: []OnSiteService{
+			{ID: "measure", Name: "Замер", VisitFee: 1000},
+			{ID: "consult", Name: "Консультация на объекте", VisitFee: 1500},
+			{ID: "install", Name: "Монтаж", VisitFee: 3000},
+		},
+		Urgencies: []OnSiteUrgency{
+			{ID: "normal", Name: "В течение 3 дней"},
+			{ID: "tomorrow", Name: "Завтра", SurchargePercent: 20},
+			{ID: "today", Name: "Сегодня", SurchargePercent: 50, SurchargeFixed: 500},
+		},
+	}
+}
+
+// HasBaseCoords — известны ли координаты базы (иначе адрес базы геокодируется при расчёте).
+func (c *OnSiteConfig) HasBaseCoords() bool {
+	return c.BaseLat != 0 || c.BaseLon != 0
+}
+
+// FindService — услуга по id; пустой id — первая в списке (nil, если не нашли).
+func (c *OnSiteConfig) FindService(id string) *OnSiteService {
+	for i := range c.Services {
+		if id == "" || c.Services[i].ID == id {
+			return &c.Services[i]
+		}
+	}
+	return nil
+}
+
+// FindUrgency — срочность по id; пустой id — первая в списке (nil, если не нашли или список пуст).
+func (c *OnSiteConfig) FindUrgency(id string) *OnSiteUrgency {
+	for i := range c.Urgencies {
+		if id == "" || c.Urgencies[i].ID == id {
+			return &c.Urgencies[i]
+		}
+	}
+	return nil
+}
+
+// Validate проверяет конфиг перед сохранением: нужна база, хотя бы одна услуга,
+// id услуг и срочностей непустые и не повторяются, цены не отрицательные.
+func (c *OnSiteConfig) Validate() error {
+	if strings.TrimSpace(c.BaseAddress) == "" {
+		return errors.New("baseAddress is required")
+	}
+	if c.FreeRadiusKm < 0 || c.PricePerKm < 0 {
+		return errors.New("freeRadiusKm and pricePerKm must be >= 0")
+	}
+	if len(c.Services) == 0 {
+		return errors.New("at least one service is required")
+	}
+
+	seen := map[string]bool{}
+	for _, s := range c.Services {
+		if s.ID == "" || seen[s.ID] {
+			return errors.New("service ids must be unique and non-empty")
+		}
+		seen[s.ID] = true
+		if s.VisitFee < 0 {
+			return errors.New("visitFee must be >= 0")
+		}
+	}
+
+	seen = map[string]bool{}
+	for _, u := range c.Urgencies {
+		if u.ID == "" || seen[u.ID] {
+			return errors.New("urgency ids must be unique and non-empty")
+		}
+		seen[u.ID] = true
+		if u.SurchargePercent < 0 || u.SurchargeFixed < 0 {
+			return errors.New("surcharges must be >= 0")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package domain
+
+import "time"
+
+// OrgRole — роль участника в организации
+type OrgRole string
+
+const (
+	OrgRoleOwner  OrgRole = "owner"  // всё, включая участников, тариф и удаление
+	OrgRoleEditor OrgRole = "editor" // создание и редактирование калькуляторов
+	OrgRoleViewer OrgRole = "viewer" // только просмотр
+)
+
+// Rank — "вес" роли для сравнения (чем больше, тем больше прав)
+func (r OrgRole) Rank() int {
+	switch r {
+	case OrgRoleOwner:
+		return 3
+	case OrgRoleEditor:
+		return 2
+	case OrgRoleViewer:
+		return 1
+	}
+	return 0
+}
+
+// AtLeast — роль не ниже указанной
+func (r OrgRole) AtLeast(min OrgRole) bool {
+	return r.Rank() >= min.Rank() && r.Rank() > 0
+}
+
+// ValidOrgRole проверяет, что роль известна
+func ValidOrgRole(r OrgRole) bool {
+	return r.Rank() > 0
+}
+
+// Organization — рабочее пространство: калькуляторы и тариф общие для всех участников.
+// У каждого пользователя есть личная организация (PersonalFor = его id).
+type Organization struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	PlanID      string    `json:"planId"`
+	PlanActive  bool      `json:"planActive"`
+	PersonalFor string    `json:"personalFor,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+
+	// роль текущего пользователя (заполняется в ответах API)
+	Role OrgRole `json:"role,omitempty"`
+}
+
+// OrgMember — участник организации
+type OrgMember struct {
+	OrgID     string    `json:"orgId"`
+	UserID    string    `json:"userId"`
+	Email     string    `json:"email"`
+	Name      string    `json:"name"`
+	Role      OrgRole   `json:"role"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// PersonalOrgID — id личной организации пользователя
+func PersonalOrgID(userID string) string {
+	return "org_" + userID
+}
+
+// OrgInviteTTL — сколько действует приглашение в организацию.
+const OrgInviteTTL = 7 * 24 * time.Hour
+
+// OrgInvite — приглашение в организацию по email: участником пользователь становится, только приняв его.
+type OrgInvite struct {
+	OrgID     string    `json:"orgId"`
+	OrgName   string    `json:"orgName"`
+	Email     string    `json:"email"`
+	Role      OrgRole   `json:"role"`
+	InvitedBy string    `json:"invitedBy,omitempty"` // email пригласившего
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
@@ -53,3 +53,14 @@ func FindPlan(plans []Plan, id string) *Plan {
 	}
 	return nil
 }
+
+// CheapestPlan возвращает самый дешёвый тариф — на нём стартуют новые пользователи
+func CheapestPlan(plans []Plan) *Plan {
+	var best *Plan
+	for i := range plans {
+		if best == nil || plans[i].Price < best.Price {
+			best = &plans[i]
+		}
+	}
+	return best
+}
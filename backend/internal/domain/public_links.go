@@ -0,0 +1,62 @@
+package domain
+
+import (
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Публичные адреса калькулятора:
+//
+//	/p/{ownerId}/{token} — по публичному токену (есть всегда, токен можно перевыпустить)
+//	/c/{slug}            — по человекочитаемому адресу, если владелец его задал
+const (
+	PublicTokenPathPrefix = "/p/"
+	PublicSlugPathPrefix  = "/c/"
+)
+
+// Причины появления старой ссылки в истории переадресаций.
+const (
+	RedirectReasonTokenRotated = "token_rotated"
+	RedirectReasonSlugChanged  = "slug_changed"
+	RedirectReasonOwnerChanged = "owner_changed"
+)
+
+// MaxTokenGraceHours — сколько максимум старая ссылка может вести на калькулятор после перевыпуска токена.
+const MaxTokenGraceHours = 24 * 30
+
+var slugPattern = regexp.MustCompile(`^[a-z0-9](?:[a-z0-9-]*[a-z0-9])?$`)
+
+// NormalizeSlug приводит адрес к нижнему регистру и убирает пробелы по краям.
+func NormalizeSlug(s string) string {
+	return strings.ToLower(strings.TrimSpace(s))
+}
+
+// ValidSlug — 3..64 символа: латиница, цифры и дефисы, не в начале и не в конце.
+func ValidSlug(s string) bool {
+	return len(s) >= 3 && len(s) <= 64 && slugPattern.MatchString(s) && !strings.Contains(s, "--")
+}
+
+// TokenPublicPath — адрес калькулятора по публичному токену.
+func TokenPublicPath(ownerID, token string) string {
+	return PublicTokenPathPrefix + ownerID + "/" + token
+}
+
+// SlugPublicPath — адрес калькулятора по slug.
+func SlugPublicPath(slug string) string {
+	return PublicSlugPathPrefix + slug
+}
+
+// CalculatorRedirect — старый публичный адрес калькулятора, с которого посетителей переадресуют на текущий.
+type CalculatorRedirect struct {
+	Path         string     `json:"path"`
+	CalculatorID string     `json:"calculatorId"`
+	Reason       string     `json:"reason"` // token_rotated / slug_changed / owner_changed
+	CreatedAt    time.Time  `json:"createdAt"`
+	ExpiresAt    *time.Time `json:"expiresAt,omitempty"` // nil — бессрочно
+}
+
+// Active — ведёт ли ссылка на калькулятор в момент now.
+func (r *CalculatorRedirect) Active(now time.Time) bool {
+	return r.ExpiresAt == nil || now.Before(*r.ExpiresAt)
+}
@@ -0,0 +1,77 @@
+package domain
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// CalculatorTemplate — шаблон калькулятора: тип и готовый конфиг (с примерами картинок).
+// Шаблонами управляет администратор, пользователи создают из них калькуляторы.
+type CalculatorTemplate struct {
+	ID          string          `json:"id"`
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Type        CalculatorType  `json:"type"`
+	Config      json.RawMessage `json:"config"`
+	Builtin     bool            `json:"builtin"` // встроенные засеваются при старте и не удаляются
+	SortOrder   int             `json:"sortOrder"`
+	CreatedAt   time.Time       `json:"createdAt"`
+	UpdatedAt   time.Time       `json:"updatedAt"`
+}
+
+// BuiltinTemplates — встроенные шаблоны из стартовых конфигов.
+func BuiltinTemplates() []CalculatorTemplate {
+	layered, _ := json.Marshal(NewDefaultLayeredConfig())
+	distance, _ := json.Marshal(NewDefaultDistanceConfig())
+	onSite, _ := json.Marshal(NewDefaultOnSiteConfig())
+	formula, _ := json.Marshal(NewDefaultFormulaConfig())
+	form, _ := json.Marshal(NewDefaultFormConfig())
+
+	return []CalculatorTemplate{
+		{
+			ID:          "tpl_layered_trailer",
+			Name:        "Прицеп с опциями",
+			Description: "Послойный калькулятор: базовый прицеп, тент и запасное колесо с картинками спереди и сзади.",
+			Type:        CalculatorTypeLayered,
+			Config:      layered,
+			Builtin:     true,
+			SortOrder:   10,
+		},
+		{
+			ID:          "tpl_distance_city",
+			Name:        "Доставка по городу",
+			Description: "Расчёт доставки: подача, цена за км и коэффициенты для малого, среднего и большого транспорта.",
+			Type:        CalculatorTypeDistance,
+			Config:      distance,
+			Builtin:     true,
+			SortOrder:   20,
+		},
+		{
+			ID:          "tpl_onsite_measure",
+			Name:        "Выезд замерщика",
+			Description: "Выезд специалиста: бесплатный радиус от офиса, цена за км дальше, замер, консультация и монтаж, надбавки за срочность.",
+			Type:        CalculatorTypeOnSite,
+			Config:      onSite,
+			Builtin:     true,
+			SortOrder:   30,
+		},
+		{
+			ID:          "tpl_formula_area",
+			Name:        "Работы по площади",
+			Description: "Формульный калькулятор: площадь × цена за м² по типу работ, надбавка 15% за срочность и минимальный заказ.",
+			Type:        CalculatorTypeFormula,
+			Config:      formula,
+			Builtin:     true,
+			SortOrder:   40,
+		},
+		{
+			ID:          "tpl_form_cleaning",
+			Name:        "Уборка квартиры",
+			Description: "Калькулятор-форма: вид уборки с множителем, площадь, санузлы, доп. услуги, площадь балкона только если выбран балкон.",
+			Type:        CalculatorTypeForm,
+			Config:      form,
+			Builtin:     true,
+			SortOrder:   50,
+		},
+	}
+}
@@ -0,0 +1,36 @@
+package domain
+
+import "time"
+
+// Статусы передачи калькулятора другому пользователю.
+const (
+	TransferStatusPending   = "pending"   // ждёт ответа получателя
+	TransferStatusAccepted  = "accepted"  // калькулятор передан
+	TransferStatusDeclined  = "declined"  // получатель отказался
+	TransferStatusCancelled = "cancelled" // отозвана владельцем или калькулятор сменил организацию
+)
+
+// TransferTTL — сколько получатель может принять передачу.
+const TransferTTL = 14 * 24 * time.Hour
+
+// CalculatorTransfer — предложение передать калькулятор другому пользователю.
+// Владелец предлагает по email, получатель (пользователь с этим email) принимает в одну из своих организаций.
+type CalculatorTransfer struct {
+	ID             string     `json:"id"`
+	CalculatorID   string     `json:"calculatorId"`
+	CalculatorName string     `json:"calculatorName"`
+	FromOrgID      string     `json:"fromOrgId"`
+	FromUserID     string     `json:"fromUserId"`
+	FromEmail      string     `json:"fromEmail"`
+	ToUserID       string     `json:"toUserId,omitempty"` // заполняется, когда получатель принял передачу
+	ToEmail        string     `json:"toEmail"`
+	Status         string     `json:"status"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	ExpiresAt      time.Time  `json:"expiresAt"`
+	ResolvedAt     *time.Time `json:"resolvedAt,omitempty"`
+}
+
+// Pending — ждёт ли передача ответа в момент now.
+func (t *CalculatorTransfer) Pending(now time.Time) bool {
+	return t.Status == TransferStatusPending && now.Before(t.ExpiresAt)
+}
@@ -1,6 +1,10 @@
 package domain
 
-import "time"
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
 
 type Role string
 
@@ -11,15 +15,27 @@ const (
 
 // User описывает клиента/админа SaaS
 type User struct {
-	ID             string    `json:"id"`
-	Email          string    `json:"email"`
-	Name           string    `json:"name"`
-	Role           Role      `json:"role"`
-	PlanID         string    `json:"planId"`
-	PlanActive     bool      `json:"planActive"` // активен ли тариф (подписка)
-	CreatedAt      time.Time `json:"createdAt"`
-	TelegramChatID string    `json:"telegramChatId"`
-	Password       string    `json:"-"` // для смены пароля (в демо, без хэшей)
+	ID             string     `json:"id"`
+	Email          string     `json:"email"`
+	Name           string     `json:"name"`
+	Role           Role       `json:"role"`
+	PlanID         string     `json:"planId"`
+	PlanActive     bool       `json:"planActive"` // активен ли тариф (подписка)
+	CreatedAt      time.Time  `json:"createdAt"`
+	TelegramChatID string     `json:"telegramChatId"`
+	EmailConfirmed bool       `json:"emailConfirmed"`        // подтверждён ли email (без этого нельзя публиковать)
+	LockedUntil    *time.Time `json:"lockedUntil,omitempty"` // вход заблокирован после неудачных попыток
+	TwoFactor      bool       `json:"twoFactorEnabled"`      // включена ли TOTP-аутентификация
+	Password       string     `json:"-"`                     // для смены пароля (в демо, без хэшей)
+}
+
+// NewUserID генерирует id для нового пользователя вида "usr_<16 hex>".
+func NewUserID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "usr_" + time.Now().Format("20060102150405.000000")
+	}
+	return "usr_" + hex.EncodeToString(b)
 }
 
 // MockUsers — моковые пользователи под демо
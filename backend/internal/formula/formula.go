@@ -0,0 +1,556 @@
+// Package formula — безопасный вычислитель выражений для калькуляторов типа formula.
+// Выражения работают только с числами и переданными переменными: арифметика, сравнения,
+// логика и функции if, min, max, round, floor, ceil, abs. Циклов и обращений наружу нет,
+// длина и вложенность выражения ограничены, поэтому владелец калькулятора не может
+// ни повесить сервер, ни добраться до чего-то кроме значений полей формы.
+package formula
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Ограничения на выражение.
+const (
+	MaxLength = 2000 // символов
+	MaxDepth  = 50   // вложенность скобок и вызовов
+)
+
+// Error — ошибка разбора или вычисления; Pos — позиция в выражении (с 0).
+type Error struct {
+	Pos int
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("at position %d: %s", e.Pos+1, e.Msg)
+}
+
+func errorf(pos int, format string, args ...interface{}) *Error {
+	return &Error{Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// Expr — разобранное выражение, его можно вычислять много раз.
+type Expr struct {
+	src  string
+	root node
+	vars []string
+}
+
+// Parse разбирает выражение. Неизвестные функции и неверное число аргументов — ошибка сразу,
+// имена переменных проверяет вызывающий (см. Vars).
+func Parse(src string) (*Expr, error) {
+	if strings.TrimSpace(src) == "" {
+		return nil, errorf(0, "expression is empty")
+	}
+	if len(src) > MaxLength {
+		return nil, errorf(MaxLength, "expression is longer than %d characters", MaxLength)
+	}
+
+	toks, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks, seen: map[string]bool{}}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, errorf(t.pos, "unexpected %q", t.text)
+	}
+	return &Expr{src: src, root: root, vars: p.vars}, nil
+}
+
+// String — исходный текст выражения.
+func (e *Expr) String() string { return e.src }
+
+// Vars — имена переменных в выражении в порядке первого появления.
+func (e *Expr) Vars() []string {
+	out := make([]string, len(e.vars))
+	copy(out, e.vars)
+	return out
+}
+
+// Eval вычисляет выражение. Логические значения — 1 и 0, в условиях истинно всё, кроме 0.
+func (e *Expr) Eval(vars map[string]float64) (float64, error) {
+	v, err := e.root.eval(vars)
+	if err != nil {
+		return 0, err
+	}
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, errorf(0, "result is not a finite number")
+	}
+	return v, nil
+}
+
+// IsFunction — занято ли имя встроенной функцией или константой (переменную так назвать нельзя).
+func IsFunction(name string) bool {
+	_, fn := functions[name]
+	return fn || name == "true" || name == "false"
+}
+
+// --- лексер ---
+
+type tokKind int
+
+const (
+	tokEOF tokKind = iota
+	tokNum
+	tokIdent
+	tokOp
+)
+
+type token struct {
+	kind tokKind
+	text string
+	num  float64
+	pos  int
+}
+
+// операторы; сначала двухсимвольные, чтобы "<=" не разобрался как "<" и "="
+var operators = []string{"<=", ">=", "==", "!=", "&&", "||", "+", "-", "*", "/", "%", "^", "<", ">", "!", "(", ")", ","}
+
+func lex(src string) ([]token, error) {
+	var toks []token
+	i := 0
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+
+		case isDigit(c) || (c == '.' && i+1 < len(src) && isDigit(src[i+1])):
+			start := i
+			for i < len(src) && (isDigit(src[i]) || src[i] == '.') {
+				i++
+			}
+			n, err := strconv.ParseFloat(src[start:i], 64)
+			if err != nil {
+				return nil, errorf(start, "bad number %q", src[start:i])
+			}
+			toks = append(toks, token{kind: tokNum, text: src[start:i], num: n, pos: start})
+
+		case isIdentStart(c):
+			start := i
+			for i < len(src) && (isIdentStart(src[i]) || isDigit(src[i])) {
+				i++
+			}
+			toks = append(toks, token{kind: tokIdent, text: src[start:i], pos: start})
+
+		default:
+			op := ""
+			for _, o := range operators {
+				if strings.HasPrefix(src[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				if c == '=' {
+					return nil, errorf(i, "use == to compare values")
+				}
+				return nil, errorf(i, "unexpected character %q", string(src[i]))
+			}
+			toks = append(toks, token{kind: tokOp, text: op, pos: i})
+			i += len(op)
+		}
+	}
+	return append(toks, token{kind: tokEOF, text: "end of expression", pos: len(src)}), nil
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// --- парсер ---
+//
+// or      := and { "||" and }
+// and     := cmp { "&&" cmp }
+// cmp     := add { ("==" | "!=" | "<" | "<=" | ">" | ">=") add }
+// add     := mul { ("+" | "-") mul }
+// mul     := unary { ("*" | "/" | "%") unary }
+// unary   := ("-" | "+" | "!") unary | pow
+// pow     := primary [ "^" unary ]
+// primary := number | name | name "(" [ or { "," or } ] ")" | "(" or ")"
+
+type parser struct {
+	toks  []token
+	i     int
+	depth int
+	vars  []string
+	seen  map[string]bool
+}
+
+func (p *parser) peek() token { return p.toks[p.i] }
+
+func (p *parser) next() token {
+	t := p.toks[p.i]
+	if t.kind != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+// acceptOp съедает оператор, если он один из ops.
+func (p *parser) acceptOp(ops ...string) (token, bool) {
+	t := p.peek()
+	if t.kind != tokOp {
+		return t, false
+	}
+	for _, op := range ops {
+		if t.text == op {
+			p.i++
+			return t, true
+		}
+	}
+	return t, false
+}
+
+func (p *parser) enter(pos int) error {
+	p.depth++
+	if p.depth > MaxDepth {
+		return errorf(pos, "expression is nested deeper than %d levels", MaxDepth)
+	}
+	return nil
+}
+
+func (p *parser) leave() { p.depth-- }
+
+// binary разбирает левоассоциативную цепочку операторов ops над операндами sub.
+func (p *parser) binary(sub func() (node, error), ops ...string) (node, error) {
+	left, err := sub()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t, ok := p.acceptOp(ops...)
+		if !ok {
+			return left, nil
+		}
+		right, err := sub()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op: t.text, l: left, r: right, pos: t.pos}
+	}
+}
+
+func (p *parser) parseOr() (node, error)  { return p.binary(p.parseAnd, "||") }
+func (p *parser) parseAnd() (node, error) { return p.binary(p.parseCmp, "&&") }
+func (p *parser) parseCmp() (node, error) {
+	return p.binary(p.parseAdd, "==", "!=", "<=", ">=", "<", ">")
+}
+func (p *parser) parseAdd() (node, error) { return p.binary(p.parseMul, "+", "-") }
+func (p *parser) parseMul() (node, error) { return p.binary(p.parseUnary, "*", "/", "%") }
+
+func (p *parser) parseUnary() (node, error) {
+	if t, ok := p.acceptOp("-", "+", "!"); ok {
+		if err := p.enter(t.pos); err != nil {
+			return nil, err
+		}
+		defer p.leave()
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryNode{op: t.text, x: x}, nil
+	}
+	return p.parsePow()
+}
+
+func (p *parser) parsePow() (node, error) {
+	base, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	t, ok := p.acceptOp("^")
+	if !ok {
+		return base, nil
+	}
+	// правоассоциативно: 2^3^2 = 2^(3^2)
+	exp, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	return &binaryNode{op: "^", l: base, r: exp, pos: t.pos}, nil
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokNum:
+		return numNode(t.num), nil
+
+	case tokIdent:
+		switch t.text {
+		case "true":
+			return numNode(1), nil
+		case "false":
+			return numNode(0), nil
+		}
+		if _, ok := p.acceptOp("("); ok {
+			return p.parseCall(t)
+		}
+		if _, ok := functions[t.text]; ok {
+			return nil, errorf(t.pos, "%s is a function, call it as %s(...)", t.text, t.text)
+		}
+		if !p.seen[t.text] {
+			p.seen[t.text] = true
+			p.vars = append(p.vars, t.text)
+		}
+		return &varNode{name: t.text, pos: t.pos}, nil
+
+	case tokOp:
+		if t.text == "(" {
+			if err := p.enter(t.pos); err != nil {
+				return nil, err
+			}
+			defer p.leave()
+			x, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := p.acceptOp(")"); !ok {
+				return nil, errorf(p.peek().pos, "missing )")
+			}
+			return x, nil
+		}
+	}
+	if t.kind == tokEOF {
+		return nil, errorf(t.pos, "unexpected end of expression")
+	}
+	return nil, errorf(t.pos, "unexpected %q", t.text)
+}
+
+func (p *parser) parseCall(name token) (node, error) {
+	fn, ok := functions[name.text]
+	if !ok {
+		return nil, errorf(name.pos, "unknown function %s", name.text)
+	}
+	if err := p.enter(name.pos); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+
+	var args []node
+	if _, ok := p.acceptOp(")"); !ok {
+		for {
+			arg, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			if _, ok := p.acceptOp(","); ok {
+				continue
+			}
+			if _, ok := p.acceptOp(")"); ok {
+				break
+			}
+			return nil, errorf(p.peek().pos, "expected , or ) in call of %s", name.text)
+		}
+	}
+
+	if len(args) < fn.minArgs || (fn.maxArgs >= 0 && len(args) > fn.maxArgs) {
+		return nil, errorf(name.pos, "%s: %s", name.text, fn.arity)
+	}
+	return &callNode{name: name.text, fn: fn, args: args, pos: name.pos}, nil
+}
+
+// --- вычисление ---
+
+type node interface {
+	eval(vars map[string]float64) (float64, error)
+}
+
+type numNode float64
+
+func (n numNode) eval(map[string]float64) (float64, error) { return float64(n), nil }
+
+type varNode struct {
+	name string
+	pos  int
+}
+
+func (n *varNode) eval(vars map[string]float64) (float64, error) {
+	v, ok := vars[n.name]
+	if !ok {
+		return 0, errorf(n.pos, "unknown variable %s", n.name)
+	}
+	return v, nil
+}
+
+type unaryNode struct {
+	op string
+	x  node
+}
+
+func (n *unaryNode) eval(vars map[string]float64) (float64, error) {
+	x, err := n.x.eval(vars)
+	if err != nil {
+		return 0, err
+	}
+	switch n.op {
+	case "-":
+		return -x, nil
+	case "!":
+		return boolNum(x == 0), nil
+	}
+	return x, nil
+}
+
+type binaryNode struct {
+	op   string
+	l, r node
+	pos  int
+}
+
+func (n *binaryNode) eval(vars map[string]float64) (float64, error) {
+	l, err := n.l.eval(vars)
+	if err != nil {
+		return 0, err
+	}
+
+	// && и || не вычисляют правую часть, если ответ уже ясен
+	switch n.op {
+	case "&&":
+		if l == 0 {
+			return 0, nil
+		}
+	case "||":
+		if l != 0 {
+			return 1, nil
+		}
+	}
+
+	r, err := n.r.eval(vars)
+	if err != nil {
+		return 0, err
+	}
+
+	switch n.op {
+	case "+":
+		return l + r, nil
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	case "/":
+		if r == 0 {
+			return 0, errorf(n.pos, "division by zero")
+		}
+		return l / r, nil
+	case "%":
+		if r == 0 {
+			return 0, errorf(n.pos, "division by zero")
+		}
+		return math.Mod(l, r), nil
+	case "^":
+		v := math.Pow(l, r)
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return 0, errorf(n.pos, "power result is not a finite number")
+		}
+		return v, nil
+	case "==":
+		return boolNum(l == r), nil
+	case "!=":
+		return boolNum(l != r), nil
+	case "<":
+		return boolNum(l < r), nil
+	case "<=":
+		return boolNum(l <= r), nil
+	case ">":
+		return boolNum(l > r), nil
+	case ">=":
+		return boolNum(l >= r), nil
+	case "&&", "||":
+		return boolNum(r != 0), nil
+	}
+	return 0, errorf(n.pos, "unknown operator %s", n.op)
+}
+
+type callNode struct {
+	name string
+	fn   function
+	args []node
+	pos  int
+}
+
+func (n *callNode) eval(vars map[string]float64) (float64, error) {
+	// if вычисляет только нужную ветку
+	if n.name == "if" {
+		c, err := n.args[0].eval(vars)
+		if err != nil {
+			return 0, err
+		}
+		if c != 0 {
+			return n.args[1].eval(vars)
+		}
+		return n.args[2].eval(vars)
+	}
+
+	args := make([]float64, len(n.args))
+	for i, a := range n.args {
+		v, err := a.eval(vars)
+		if err != nil {
+			return 0, err
+		}
+		args[i] = v
+	}
+	v, err := n.fn.call(args)
+	if err != nil {
+		return 0, errorf(n.pos, "%s: %v", n.name, err)
+	}
+	return v, nil
+}
+
+func boolNum(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// --- функции ---
+
+type function struct {
+	minArgs, maxArgs int // maxArgs < 0 — без ограничения
+	arity            string
+	call             func(args []float64) (float64, error)
+}
+
+var functions = map[string]function{
+	"if": {3, 3, "expects if(condition, then, else)", nil},
+	"min": {1, -1, "expects at least one argument", func(a []float64) (float64, error) {
+		m := a[0]
+		for _, v := range a[1:] {
+			m = math.Min(m, v)
+		}
+		return m, nil
+	}},
+	"max": {1, -1, "expects at least one argument", func(a []float64) (float64, error) {
+		m := a[0]
+		for _, v := range a[1:] {
+			m = math.Max(m, v)
+		}
+		return m, nil
+	}},
+	"round": {1, 2, "expects round(x) or round(x, digits)", func(a []float64) (float64, error) {
+		if len(a) == 1 {
+			return math.Round(a[0]), nil
+		}
+		d := a[1]
+		if d != math.Trunc(d) || d < 0 || d > 10 {
+			return 0, fmt.Errorf("digits must be an integer from 0 to 10")
+		}
+		p := math.Pow(10, d)
+		return math.Round(a[0]*p) / p, nil
+	}},
+	"floor": {1, 1, "expects one argument", func(a []float64) (float64, error) { return math.Floor(a[0]), nil }},
+	"ceil":  {1, 1, "expects one argument", func(a []float64) (float64, error) { return math.Ceil(a[0]), nil }},
+	"abs":   {1, 1, "expects one argument", func(a []float64) (float64, error) { return math.Abs(a[0]), nil }},
+}
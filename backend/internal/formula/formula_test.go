@@ -0,0 +1,180 @@
+package formula
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEval(t *testing.T) {
+	vars := map[string]float64{"x": 5, "zero": 0}
+	tests := []struct {
+		expr string
+		want float64
+	}{
+		// приоритеты и ассоциативность
+		{"1 + 2 * 3", 7},
+		{"(1 + 2) * 3", 9},
+		{"10 - 4 - 3", 3},
+		{"12 / 3 / 2", 2},
+		{"2 ^ 3 ^ 2", 512},
+		{"2 * 3 ^ 2", 18},
+		{"7 % 3 + 1", 2},
+		{"1 + 2 == 3", 1},
+		{"1 < 2 && 2 < 3", 1},
+		{"0 || 1 && 0", 0},
+
+		// унарные операторы
+		{"-2 ^ 2", -4},
+		{"(-2) ^ 2", 4},
+		{"2 ^ -1", 0.5},
+		{"--3", 3},
+		{"-x + 1", -4},
+		{"+x", 5},
+		{"!0", 1},
+		{"!x", 0},
+		{"2 * -x", -10},
+
+		// числа, константы, переменные
+		{".5 + 1.25", 1.75},
+		{"true + true", 2},
+		{"false", 0},
+		{"x * x", 25},
+
+		// функции
+		{"if(x > 3, 10, 20)", 10},
+		{"if(x < 3, 10, 20)", 20},
+		{"min(3, 1, 2)", 1},
+		{"max(1, x)", 5},
+		{"round(2.5)", 3},
+		{"round(1234.5678, 2)", 1234.57},
+		{"floor(-1.5)", -2},
+		{"ceil(1.2)", 2},
+		{"abs(-3)", 3},
+
+		// ленивые ветки не вычисляются
+		{"if(1, 2, 1 / 0)", 2},
+		{"if(zero, 1 / zero, 3)", 3},
+		{"0 && 1 / 0", 0},
+		{"1 || 1 / 0", 1},
+	}
+	for _, tt := range tests {
+		e, err := Parse(tt.expr)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.expr, err)
+			continue
+		}
+		got, err := e.Eval(vars)
+		if err != nil {
+			t.Errorf("Eval(%q): %v", tt.expr, err)
+			continue
+		}
+		if math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("Eval(%q) = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	vars := map[string]float64{"x": 5, "zero": 0}
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"1 / 0", "division by zero"},
+		{"x / zero", "division by zero"},
+		{"x % 0", "division by zero"},
+		{"y + 1", "unknown variable y"},
+		{"if(1, missing, 0)", "unknown variable missing"},
+		{"10 ^ 400", "power result is not a finite number"},
+		{"round(1, 11)", "round"},
+		{"round(1, -1)", "round"},
+	}
+	for _, tt := range tests {
+		e, err := Parse(tt.expr)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.expr, err)
+			continue
+		}
+		_, err = e.Eval(vars)
+		if err == nil {
+			t.Errorf("Eval(%q): expected error", tt.expr)
+			continue
+		}
+		if _, ok := err.(*Error); !ok {
+			t.Errorf("Eval(%q): error %T is not *Error", tt.expr, err)
+		}
+		if !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Eval(%q) = %q, want it to contain %q", tt.expr, err, tt.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+		want string
+	}{
+		{"empty", "", "expression is empty"},
+		{"blank", "   ", "expression is empty"},
+		{"too long", "1" + strings.Repeat(" + 1", MaxLength/4), "longer than"},
+		{"too deep parens", strings.Repeat("(", MaxDepth+1) + "1" + strings.Repeat(")", MaxDepth+1), "nested deeper"},
+		{"too deep unary", strings.Repeat("-", MaxDepth+1) + "1", "nested deeper"},
+		{"too deep calls", strings.Repeat("abs(", MaxDepth+1) + "1" + strings.Repeat(")", MaxDepth+1), "nested deeper"},
+		{"dangling operator", "1 +", "unexpected end of expression"},
+		{"unclosed paren", "(1 + 2", "missing )"},
+		{"extra paren", "1 + 2)", `unexpected ")"`},
+		{"two numbers", "1 2", `unexpected "2"`},
+		{"empty parens", "()", `unexpected ")"`},
+		{"leading operator", "* 2", `unexpected "*"`},
+		{"single equals", "x = 1", "use == to compare values"},
+		{"bad character", "1 $ 2", "unexpected character"},
+		{"bad number", "1.2.3", "bad number"},
+		{"unknown function", "foo(1)", "unknown function foo"},
+		{"function without call", "min + 1", "min is a function"},
+		{"too few args", "if(1, 2)", "if"},
+		{"missing comma", "max(1 2)", "expected , or )"},
+		{"unclosed call", "max(1, 2", "expected , or )"},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.expr)
+		if err == nil {
+			t.Errorf("%s: Parse(%q): expected error", tt.name, tt.expr)
+			continue
+		}
+		if _, ok := err.(*Error); !ok {
+			t.Errorf("%s: error %T is not *Error", tt.name, err)
+		}
+		if !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: Parse(%q) = %q, want it to contain %q", tt.name, tt.expr, err, tt.want)
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	ok := []string{
+		strings.Repeat("1+", (MaxLength-1)/2) + "1",
+		strings.Repeat("(", MaxDepth) + "1" + strings.Repeat(")", MaxDepth),
+		strings.Repeat("-", MaxDepth) + "1",
+	}
+	for _, expr := range ok {
+		if len(expr) > MaxLength {
+			t.Fatalf("test expression is %d characters long", len(expr))
+		}
+		if _, err := Parse(expr); err != nil {
+			t.Errorf("Parse(%.20q...): %v", expr, err)
+		}
+	}
+}
+
+func TestVars(t *testing.T) {
+	e, err := Parse("b * a + if(c, b, min(a, 1)) + true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := e.Vars(), []string{"b", "a", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Vars() = %v, want %v", got, want)
+	}
+}
@@ -1,9 +1,13 @@
 package handlers
 
 import (
+	"database/sql"
 	"encoding/json"
 	"net/http"
 	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
 
 	"saas-calc-backend/internal/domain"
 )
@@ -23,14 +27,30 @@ type adminUserDTO struct {
 	Plan         *domain.Plan `json:"plan,omitempty"`
 }
 
-// GET /api/admin/users
+// запрос на создание пользователя администратором.
+// Пароль можно задать сразу или отправить приглашение (sendInvite),
+// по которому пользователь сам установит пароль.
+type adminCreateUserRequest struct {
+	Name       string `json:"name"`
+	Email      string `json:"email"`
+	Role       string `json:"role"`
+	PlanID     string `json:"planId"`
+	PlanActive *bool  `json:"planActive"` // не передан — тариф активен
+	Password   string `json:"password"`
+	SendInvite bool   `json:"sendInvite"`
+}
+
+// GET /api/admin/users   — список
+// POST /api/admin/users  — создать (и, при желании, пригласить по email)
 func (e *Env) HandleAdminUsers(w http.ResponseWriter, r *http.Request) {
-	cur := e.CurrentUser(r)
-	if cur == nil || cur.Role != domain.RoleAdmin {
-		http.Error(w, "forbidden", http.StatusForbidden)
+	if e.requireAdmin(w, r) == nil {
 		return
 	}
 
+	if r.Method == http.MethodPost {
+		e.handleAdminUserCreate(w, r)
+		return
+	}
 	if r.Method != http.MethodGet {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
@@ -56,10 +76,12 @@ func (e *Env) HandleAdminUsers(w http.ResponseWriter, r *http.Request) {
 
 // /api/admin/users/{id}
 // /api/admin/users/{id}/password
+// /api/admin/users/{id}/invite
+// /api/admin/users/{id}/unlock
+// /api/admin/users/{id}/impersonate
 func (e *Env) HandleAdminUserDetail(w http.ResponseWriter, r *http.Request) {
-	cur := e.CurrentUser(r)
-	if cur == nil || cur.Role != domain.RoleAdmin {
-		http.Error(w, "forbidden", http.StatusForbidden)
+	admin := e.requireAdmin(w, r)
+	if admin == nil {
 		return
 	}
 
@@ -92,9 +114,190 @@ func (e *Env) HandleAdminUserDetail(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if len(parts) == 2 && parts[1] == "invite" {
+		// /api/admin/users/{id}/invite  -> POST (отправить приглашение повторно)
+		e.handleAdminUserInvite(w, r, parts[0])
+		return
+	}
+
+	if len(parts) == 2 && parts[1] == "unlock" {
+		// /api/admin/users/{id}/unlock  -> POST (снять блокировку входа)
+		e.handleAdminUserUnlock(w, r, parts[0])
+		return
+	}
+
+	if len(parts) == 2 && parts[1] == "impersonate" {
+		// /api/admin/users/{id}/impersonate  -> POST (войти под пользователем)
+		e.handleAdminUserImpersonate(w, r, admin, parts[0])
+		return
+	}
+
 	http.NotFound(w, r)
 }
 
+func (e *Env) handleAdminUserCreate(w http.ResponseWriter, r *http.Request) {
+	if e.DB == nil {
+		http.Error(w, "db is nil", http.StatusInternalServerError)
+		return
+	}
+	defer r.Body.Close()
+
+	var req adminCreateUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	email := strings.TrimSpace(req.Email)
+	name := strings.TrimSpace(req.Name)
+	if !looksLikeEmail(email) {
+		http.Error(w, "valid email is required", http.StatusBadRequest)
+		return
+	}
+	if req.Role != "admin" && req.Role != "user" {
+		http.Error(w, "invalid role", http.StatusBadRequest)
+		return
+	}
+	planID := req.PlanID
+	if planID == "" {
+		// plan_id в users обязателен — по умолчанию самый дешёвый тариф, как при регистрации
+		if p := domain.CheapestPlan(e.Plans); p != nil {
+			planID = p.ID
+		}
+	}
+	if domain.FindPlan(e.Plans, planID) == nil {
+		http.Error(w, "unknown planId", http.StatusBadRequest)
+		return
+	}
+	if req.Password == "" && !req.SendInvite {
+		http.Error(w, "password or sendInvite is required", http.StatusBadRequest)
+		return
+	}
+	if req.Password != "" && len(req.Password) < minPasswordLength {
+		http.Error(w, "password is too short", http.StatusBadRequest)
+		return
+	}
+	if name == "" {
+		name = email
+	}
+
+	exists, err := e.emailTaken(r.Context(), email)
+	if err != nil {
+		http.Error(w, "failed to check email: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if exists {
+		http.Error(w, "email already registered", http.StatusConflict)
+		return
+	}
+
+	hash := ""
+	if req.Password != "" {
+		b, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			http.Error(w, "failed to hash password: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		hash = string(b)
+	}
+
+	planActive := true
+	if req.PlanActive != nil {
+		planActive = *req.PlanActive
+	}
+
+	u := &domain.User{
+		ID:         domain.NewUserID(),
+		Email:      email,
+		Name:       name,
+		Role:       domain.Role(req.Role),
+		PlanID:     planID,
+		PlanActive: planActive,
+		CreatedAt:  time.Now(),
+		// адрес подтвердится, когда пользователь примет приглашение;
+		// без приглашения за адрес ручается администратор
+		EmailConfirmed: !req.SendInvite,
+	}
+
+	err = e.inTx(r.Context(), func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(r.Context(), `
+INSERT INTO users (id, email, name, role, password_hash, plan_id, plan_active, created_at, email_confirmed)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+`,
+			u.ID,
+			u.Email,
+			u.Name,
+			string(u.Role),
+			hash,
+			u.PlanID,
+			u.PlanActive,
+			u.CreatedAt,
+			u.EmailConfirmed,
+		); err != nil {
+			return err
+		}
+		return e.createPersonalOrg(r.Context(), tx, u)
+	})
+	if err != nil {
+		if isUniqueViolation(err) {
+			http.Error(w, "email already registered", http.StatusConflict)
+			return
+		}
+		http.Error(w, "failed to create user: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if req.SendInvite {
+		if err := e.sendInviteEmail(r.Context(), u); err != nil {
+			http.Error(w, "user created, but invite failed: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	dto := adminUserDTO{User: u, Plan: domain.FindPlan(e.Plans, u.PlanID)}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(dto)
+}
+
+func (e *Env) handleAdminUserInvite(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	u, err := e.GetUserByID(r.Context(), id)
+	if err != nil {
+		http.Error(w, "failed to load user: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if u == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	// приглашение задаёт пароль и входит в кабинет: для активного аккаунта это была бы ссылка для входа
+	var hasPassword bool
+	if err := e.DB.QueryRowContext(r.Context(),
+		`SELECT password_hash <> '' FROM users WHERE id = $1`,
+		u.ID,
+	).Scan(&hasPassword); err != nil {
+		http.Error(w, "failed to load user: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if hasPassword || u.EmailConfirmed {
+		http.Error(w, "account is already active", http.StatusConflict)
+		return
+	}
+
+	if err := e.sendInviteEmail(r.Context(), u); err != nil {
+		http.Error(w, "failed to send invite: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func (e *Env) handleAdminUserUpdate(w http.ResponseWriter, r *http.Request, id string) {
 	defer r.Body.Close()
 
@@ -0,0 +1,291 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"saas-calc-backend/internal/domain"
+)
+
+// apiKeyPrefix — все ключи начинаются с него, чтобы их было легко узнать в конфигах.
+const apiKeyPrefix = "sk_"
+
+type createAPIKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+// ответ на создание: сам ключ показываем только один раз
+type createAPIKeyResponse struct {
+	*domain.APIKey
+	Key string `json:"key"`
+}
+
+// /api/me/api-keys       GET (список), POST (создать)
+// /api/me/api-keys/{id}  DELETE (отозвать)
+func (e *Env) HandleMeAPIKeys(w http.ResponseWriter, r *http.Request) {
+	u := e.requireUser(w, r)
+	if u == nil {
+		return
+	}
+
+	const prefix = "/api/me/api-keys"
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, prefix), "/")
+
+	if rest == "" {
+		switch r.Method {
+		case http.MethodGet:
+			e.handleListAPIKeys(w, r, u)
+		case http.MethodPost:
+			e.handleCreateAPIKey(w, r, u)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	if strings.Contains(rest, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodDelete {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	e.handleRevokeAPIKey(w, r, u, rest)
+}
+
+func (e *Env) handleListAPIKeys(w http.ResponseWriter, r *http.Request, u *domain.User) {
+	rows, err := e.DB.QueryContext(r.Context(), `
+SELECT id, user_id, name, prefix, scopes, created_at, last_used_at, revoked_at
+FROM api_keys
+WHERE user_id = $1
+ORDER BY created_at DESC
+`, u.ID)
+	if err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	items := make([]*domain.APIKey, 0)
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			http.Error(w, "db scan error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		items = append(items, k)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, "db rows error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	e.writeJSON(w, map[string]interface{}{
+		"items":  items,
+		"scopes": domain.AllScopes(),
+	})
+}
+
+func (e *Env) handleCreateAPIKey(w http.ResponseWriter, r *http.Request, u *domain.User) {
+	defer r.Body.Close()
+
+	var req createAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+	if len(req.Scopes) == 0 {
+		http.Error(w, "at least one scope is required", http.StatusBadRequest)
+		return
+	}
+	for _, s := range req.Scopes {
+		if !domain.ValidScope(s) {
+			http.Error(w, "unknown scope: "+s, http.StatusBadRequest)
+			return
+		}
+	}
+
+	secret, err := randomHex(20)
+	if err != nil {
+		http.Error(w, "failed to generate key: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	id, err := randomHex(8)
+	if err != nil {
+		http.Error(w, "failed to generate key: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	plain := apiKeyPrefix + secret
+
+	k := &domain.APIKey{
+		ID:        "key_" + id,
+		UserID:    u.ID,
+		Name:      name,
+		Prefix:    plain[:len(apiKeyPrefix)+8],
+		Scopes:    req.Scopes,
+		CreatedAt: time.Now(),
+	}
+
+	_, err = e.DB.ExecContext(r.Context(), `
+INSERT INTO api_keys (id, user_id, name, prefix, key_hash, scopes, created_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+`,
+		k.ID,
+		k.UserID,
+		k.Name,
+		k.Prefix,
+		hashToken(plain),
+		pq.Array(k.Scopes),
+		k.CreatedAt,
+	)
+	if err != nil {
+		http.Error(w, "db insert error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(createAPIKeyResponse{APIKey: k, Key: plain})
+}
+
+func (e *Env) handleRevokeAPIKey(w http.ResponseWriter, r *http.Request, u *domain.User, id string) {
+	res, err := e.DB.ExecContext(r.Context(), `
+UPDATE api_keys
+SET revoked_at = now()
+WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+`, id, u.ID)
+	if err != nil {
+		http.Error(w, "db update error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// userFromAPIKey — пользователь по ключу из "Authorization: Bearer ...".
+// Ключ должен быть не отозван и иметь скоуп, нужный для этого запроса.
+func (e *Env) userFromAPIKey(r *http.Request, plain string) *domain.User {
+	scope := requiredScope(r)
+	if scope == "" {
+		// остальные эндпоинты ключами не вызываются
+		return nil
+	}
+
+	row := e.DB.QueryRowContext(r.Context(), `
+SELECT id, user_id, name, prefix, scopes, created_at, last_used_at, revoked_at
+FROM api_keys
+WHERE key_hash = $1 AND revoked_at IS NULL
+`, hashToken(plain))
+
+	k, err := scanAPIKey(row)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("api key lookup: %v", err)
+		}
+		return nil
+	}
+	if !k.HasScope(scope) {
+		return nil
+	}
+
+	// отметка последнего использования — не критично, если не получится
+	if _, err := e.DB.ExecContext(r.Context(),
+		`UPDATE api_keys SET last_used_at = now() WHERE id = $1`,
+		k.ID,
+	); err != nil {
+		log.Printf("api key %s: update last_used_at: %v", k.ID, err)
+	}
+
+	u, err := e.GetUserByID(r.Context(), k.UserID)
+	if err != nil {
+		log.Printf("api key %s: load user: %v", k.ID, err)
+		return nil
+	}
+	return u
+}
+
+// requiredScope — какой скоуп нужен ключу для этого запроса ("" — ключом нельзя).
+func requiredScope(r *http.Request) string {
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/api/calculators":
+		return domain.ScopeCalculatorsRead
+	case r.Method == http.MethodPost && (r.URL.Path == "/api/distance/calc" || r.URL.Path == "/api/mortgage/calc" ||
+		r.URL.Path == "/api/onsite/calc" || r.URL.Path == "/api/formula/calc" || r.URL.Path == "/api/form/calc"):
+		return domain.ScopeCalcRun
+	case r.Method == http.MethodGet && r.URL.Path == "/api/leads":
+		return domain.ScopeLeadsRead
+	}
+	return ""
+}
+
+// bearerToken достаёт токен из заголовка Authorization.
+func bearerToken(r *http.Request) string {
+	h := r.Header.Get("Authorization")
+	const p = "Bearer "
+	if len(h) <= len(p) || !strings.EqualFold(h[:len(p)], p) {
+		return ""
+	}
+	return strings.TrimSpace(h[len(p):])
+}
+
+// checkOptionalAPIKey — для публичных эндпоинтов расчёта: без ключа пускаем всех,
+// но если ключ передан, он обязан быть валидным (иначе 401).
+func (e *Env) checkOptionalAPIKey(w http.ResponseWriter, r *http.Request) bool {
+	if bearerToken(r) == "" {
+		return true
+	}
+	if e.CurrentUser(r) == nil {
+		http.Error(w, "invalid api key", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanAPIKey(row rowScanner) (*domain.APIKey, error) {
+	var k domain.APIKey
+	var lastUsed, revoked pq.NullTime
+	if err := row.Scan(
+		&k.ID,
+		&k.UserID,
+		&k.Name,
+		&k.Prefix,
+		pq.Array(&k.Scopes),
+		&k.CreatedAt,
+		&lastUsed,
+		&revoked,
+	); err != nil {
+		return nil, err
+	}
+	if lastUsed.Valid {
+		t := lastUsed.Time
+		k.LastUsedAt = &t
+	}
+	if revoked.Valid {
+		t := revoked.Time
+		k.RevokedAt = &t
+	}
+	return &k, nil
+}
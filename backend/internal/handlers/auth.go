@@ -0,0 +1,371 @@
+package handlers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"saas-calc-backend/internal/domain"
+	"saas-calc-backend/internal/ratelimit"
+)
+
+const (
+	// SessionCookieName — имя cookie с идентификатором сессии.
+	SessionCookieName = "saas_session"
+	// SessionTTL — сколько живёт сессия после входа.
+	SessionTTL = 7 * 24 * time.Hour
+)
+
+type loginRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// POST /api/auth/login { "email": "...", "password": "..." }
+func (e *Env) HandleAuthLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	defer r.Body.Close()
+
+	var req loginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	email := strings.TrimSpace(req.Email)
+	if email == "" || req.Password == "" {
+		http.Error(w, "email and password are required", http.StatusBadRequest)
+		return
+	}
+
+	// перебор с одного адреса: экспоненциальная задержка по IP
+	ip := ratelimit.ClientIP(r)
+	if wait := e.LoginBackoff.Blocked(ip); wait > 0 {
+		ratelimit.TooManyRequests(w, wait)
+		return
+	}
+
+	// перебор пароля к одной учётной записи: временная блокировка
+	wait, err := e.accountLockedFor(r.Context(), email)
+	if err != nil {
+		http.Error(w, "login failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if wait > 0 {
+		ratelimit.TooManyRequests(w, wait)
+		return
+	}
+
+	u, err := e.authenticatePassword(r.Context(), email, req.Password)
+	if err != nil {
+		http.Error(w, "login failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if u == nil {
+		e.LoginBackoff.Fail(ip)
+		if err := e.recordLoginFailure(r.Context(), email); err != nil {
+			log.Printf("login: record failure for %s: %v", email, err)
+		}
+		// не уточняем, что именно не так — email или пароль
+		http.Error(w, "invalid email or password", http.StatusUnauthorized)
+		return
+	}
+
+	// счётчик по IP успешным входом не сбрасываем: иначе между попытками подбора
+	// можно входить в свой аккаунт и обнулять задержку. Он забывается сам, без неудач.
+	if err := e.resetLoginFailures(r.Context(), u.ID); err != nil {
+		log.Printf("login: reset failures for %s: %v", u.ID, err)
+	}
+
+	e.beginSession(w, r, u)
+}
+
+// beginSession открывает сессию после проверки пароля и отвечает пользователем.
+// Если включена 2FA — сессии ещё нет: в ответе mfaToken, сессию откроет /api/auth/login/2fa.
+func (e *Env) beginSession(w http.ResponseWriter, r *http.Request, u *domain.User) {
+	if u.TwoFactor {
+		token, err := e.issueUserToken(r.Context(), u.ID, tokenKindMFA, mfaTTL)
+		if err != nil {
+			http.Error(w, "login failed: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		e.writeJSON(w, loginMFAResponse{MFARequired: true, MFAToken: token})
+		return
+	}
+
+	if err := e.startSession(w, r, u.ID); err != nil {
+		http.Error(w, "failed to create session: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	e.writeJSON(w, u)
+}
+
+// POST /api/auth/logout
+func (e *Env) HandleAuthLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if sid := e.sessionIDFromRequest(r); sid != "" && e.DB != nil {
+		if _, err := e.DB.ExecContext(r.Context(), `DELETE FROM sessions WHERE id = $1`, sid); err != nil {
+			log.Printf("logout: delete session: %v", err)
+		}
+	}
+
+	clearSessionCookie(w, r)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CurrentUser возвращает пользователя по cookie сессии
+// или по API-ключу из "Authorization: Bearer ...".
+// Анонимный запрос, просроченная или подделанная сессия — nil.
+func (e *Env) CurrentUser(r *http.Request) *domain.User {
+	if e.DB == nil {
+		return nil
+	}
+
+	if key := bearerToken(r); key != "" {
+		return e.userFromAPIKey(r, key)
+	}
+
+	sess := e.currentSession(r)
+	if sess == nil {
+		return nil
+	}
+
+	u, err := e.GetUserByID(r.Context(), sess.UserID)
+	if err != nil {
+		log.Printf("CurrentUser: load user %s: %v", sess.UserID, err)
+		return nil
+	}
+	return u
+}
+
+// sessionInfo — активная сессия из таблицы sessions.
+type sessionInfo struct {
+	ID     string
+	UserID string // под кем работает запрос (effective user)
+
+	// для сессий входа "под пользователем": кто на самом деле админ
+	// и к какой его сессии вернуться после выхода из режима
+	ImpersonatorID  string
+	ParentSessionID string
+
+	ExpiresAt time.Time
+}
+
+// RealUserID — кто на самом деле сидит за клавиатурой.
+func (s *sessionInfo) RealUserID() string {
+	if s.ImpersonatorID != "" {
+		return s.ImpersonatorID
+	}
+	return s.UserID
+}
+
+type sessionCtxKey struct{}
+
+// sessionCtxValue — обёртка, чтобы отличать "сессии нет" от "сессию ещё не искали".
+type sessionCtxValue struct {
+	sess *sessionInfo
+}
+
+// currentSession — сессия запроса: из контекста (её кладёт WithSession) или из БД по cookie.
+func (e *Env) currentSession(r *http.Request) *sessionInfo {
+	if v, ok := r.Context().Value(sessionCtxKey{}).(sessionCtxValue); ok {
+		return v.sess
+	}
+	return e.lookupSession(r.Context(), e.sessionIDFromRequest(r))
+}
+
+// lookupSession — не просроченная сессия по id (nil, если нет).
+func (e *Env) lookupSession(ctx context.Context, sid string) *sessionInfo {
+	if sid == "" || e.DB == nil {
+		return nil
+	}
+
+	s := sessionInfo{ID: sid}
+	err := e.DB.QueryRowContext(ctx, `
+SELECT user_id, COALESCE(impersonator_id, ''), COALESCE(parent_session_id, ''), expires_at
+FROM sessions
+WHERE id = $1 AND expires_at > now()
+`, sid).Scan(&s.UserID, &s.ImpersonatorID, &s.ParentSessionID, &s.ExpiresAt)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("session lookup: %v", err)
+		}
+		return nil
+	}
+	return &s
+}
+
+// requireUser — как CurrentUser, но сам отвечает 401 анонимам.
+func (e *Env) requireUser(w http.ResponseWriter, r *http.Request) *domain.User {
+	u := e.CurrentUser(r)
+	if u == nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return nil
+	}
+	return u
+}
+
+// requireAdmin — 401 для анонимов, 403 для всех, кроме администратора
+// (и для администратора без 2FA, если она обязательна).
+func (e *Env) requireAdmin(w http.ResponseWriter, r *http.Request) *domain.User {
+	u := e.requireUser(w, r)
+	if u == nil {
+		return nil
+	}
+	if u.Role != domain.RoleAdmin {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return nil
+	}
+
+	// если 2FA для админов обязательна — без неё в админку не пускаем,
+	// подключить её можно в /api/me/2fa
+	if !u.TwoFactor {
+		required, err := e.adminRequires2FA(r.Context())
+		if err != nil {
+			http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+			return nil
+		}
+		if required {
+			http.Error(w, "2fa required: enable it at /api/me/2fa", http.StatusForbidden)
+			return nil
+		}
+	}
+	return u
+}
+
+// dummyPasswordHash — bcrypt-хеш с той же стоимостью, что у настоящих паролей.
+// С ним сверяем пароль, когда сверять не с чем, чтобы время ответа не выдавало, есть ли такой email.
+const dummyPasswordHash = "$2a$10$G9K.6HNmIrn5Z92Ki/.F6OnCvevFUzo3FNVQ3WMUVfyLFcHrJnjra"
+
+// authenticatePassword проверяет пару email/пароль по bcrypt-хешу из users.
+// Неверная пара — (nil, nil).
+func (e *Env) authenticatePassword(ctx context.Context, email, password string) (*domain.User, error) {
+	if e.DB == nil {
+		return nil, errors.New("db is nil")
+	}
+
+	var id, hash string
+	err := e.DB.QueryRowContext(ctx, `
+SELECT id, password_hash
+FROM users
+WHERE lower(email) = lower($1)
+`, email).Scan(&id, &hash)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			_ = bcrypt.CompareHashAndPassword([]byte(dummyPasswordHash), []byte(password))
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	// у приглашённых и пришедших через SSO пароля может не быть
+	if hash == "" {
+		_ = bcrypt.CompareHashAndPassword([]byte(dummyPasswordHash), []byte(password))
+		return nil, nil
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return nil, nil
+	}
+
+	return e.GetUserByID(ctx, id)
+}
+
+// startSession создаёт запись в sessions и ставит подписанную cookie.
+func (e *Env) startSession(w http.ResponseWriter, r *http.Request, userID string) error {
+	if e.DB == nil {
+		return errors.New("db is nil")
+	}
+
+	sid, err := randomHex(32)
+	if err != nil {
+		return err
+	}
+	expires := time.Now().Add(SessionTTL)
+
+	if _, err := e.DB.ExecContext(r.Context(), `
+INSERT INTO sessions (id, user_id, expires_at)
+VALUES ($1, $2, $3)
+`, sid, userID, expires); err != nil {
+		return err
+	}
+
+	e.setSessionCookie(w, r, sid, expires)
+	return nil
+}
+
+func (e *Env) setSessionCookie(w http.ResponseWriter, r *http.Request, sid string, expires time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    sid + "." + e.signSessionID(sid),
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// sessionIDFromRequest достаёт id сессии из cookie и проверяет подпись.
+func (e *Env) sessionIDFromRequest(r *http.Request) string {
+	c, err := r.Cookie(SessionCookieName)
+	if err != nil || c.Value == "" {
+		return ""
+	}
+
+	dot := strings.LastIndex(c.Value, ".")
+	if dot <= 0 {
+		return ""
+	}
+	sid, sig := c.Value[:dot], c.Value[dot+1:]
+
+	if !hmac.Equal([]byte(sig), []byte(e.signSessionID(sid))) {
+		return ""
+	}
+	return sid
+}
+
+func (e *Env) signSessionID(sid string) string {
+	mac := hmac.New(sha256.New, e.SessionSecret)
+	mac.Write([]byte(sid))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func clearSessionCookie(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// randomHex — n случайных байт в hex.
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,344 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"saas-calc-backend/internal/domain"
+)
+
+const (
+	// bundleMaxSize — предел размера загружаемого архива.
+	bundleMaxSize = 50 << 20
+	// bundleMaxEntrySize — предел размера одного файла внутри архива (как у /api/upload).
+	bundleMaxEntrySize = 10 << 20
+)
+
+// --- GET /api/calculators/{id}/export ---
+
+// Архив собирается из черновика конфига (то, что видно в конструкторе).
+// Картинки, которых нет на диске, пропускаются: при импорте ссылка останется как есть.
+func (e *Env) handleExportCalculator(w http.ResponseWriter, r *http.Request, u *domain.User, id string) {
+	c := e.requireCalculator(w, r, u, id, domain.OrgRoleViewer)
+	if c == nil {
+		return
+	}
+
+	var cfg interface{}
+	hasConfig, err := e.loadCalculatorConfig(r.Context(), c.ID, &cfg)
+	if err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	manifest := domain.CalculatorBundleManifest{
+		Format:     domain.CalculatorBundleFormat,
+		Version:    domain.CalculatorBundleVersion,
+		ExportedAt: time.Now(),
+		HasConfig:  hasConfig,
+		Files:      map[string]string{},
+	}
+
+	// собираем архив в память целиком: при ошибке ещё можно ответить 500
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+
+	if hasConfig {
+		mapUploadURLs(cfg, func(url string) string {
+			if _, done := manifest.Files[url]; done {
+				return url
+			}
+			name, err := e.addUploadToBundle(zw, url)
+			if err != nil {
+				log.Printf("export %s: %v", c.ID, err)
+				return url
+			}
+			manifest.Files[url] = name
+			return url
+		})
+	}
+
+	meta := domain.CalculatorBundleMeta{
+		SourceID: c.ID,
+		Name:     c.Name,
+		Type:     c.Type,
+		Status:   c.Status,
+	}
+	err = writeBundleJSON(zw, "manifest.json", manifest)
+	if err == nil {
+		err = writeBundleJSON(zw, "calculator.json", meta)
+	}
+	if err == nil && hasConfig {
+		err = writeBundleJSON(zw, "config.json", cfg)
+	}
+	if err == nil {
+		err = zw.Close()
+	}
+	if err != nil {
+		http.Error(w, "failed to build bundle: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+c.ID+`.zip"`)
+	_, _ = w.Write(buf.Bytes())
+}
+
+// addUploadToBundle кладёт загруженный файл в uploads/ архива и возвращает путь внутри архива.
+func (e *Env) addUploadToBundle(zw *zip.Writer, url string) (string, error) {
+	srcPath, err := e.uploadFilePath(url)
+	if err != nil {
+		return "", err
+	}
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	name := "uploads/" + path.Base(srcPath)
+	dst, err := zw.Create(name)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+func writeBundleJSON(zw *zip.Writer, name string, v interface{}) error {
+	dst, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(dst)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// --- POST /api/calculators/import ---
+
+// bundleUploadPath — допустимые пути картинок внутри архива.
+var bundleUploadPath = regexp.MustCompile(`^uploads/[^/\\]+$`)
+
+// handleImportCalculator принимает multipart/form-data: file — архив из export,
+// orgId — организация (пусто — личная), name — новое имя (пусто — как в архиве).
+// Калькулятор создаётся черновиком под текущим пользователем, картинки сохраняются
+// под новыми именами, ссылки /uploads/... в конфиге переписываются на них.
+func (e *Env) handleImportCalculator(w http.ResponseWriter, r *http.Request, u *domain.User) {
+	r.Body = http.MaxBytesReader(w, r.Body, bundleMaxSize)
+	if err := r.ParseMultipartForm(10 << 20); err != nil {
+		http.Error(w, "bad multipart form: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "file field is required: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	zr, err := zip.NewReader(file, header.Size)
+	if err != nil {
+		http.Error(w, "bad bundle: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	entries := map[string]*zip.File{}
+	for _, f := range zr.File {
+		entries[f.Name] = f
+	}
+
+	// --- manifest.json, calculator.json, config.json ---
+	var manifest domain.CalculatorBundleManifest
+	if err := readBundleJSON(entries, "manifest.json", &manifest); err != nil {
+		http.Error(w, "bad bundle: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if manifest.Format != domain.CalculatorBundleFormat {
+		http.Error(w, "bad bundle: unknown format", http.StatusBadRequest)
+		return
+	}
+	if manifest.Version > domain.CalculatorBundleVersion {
+		http.Error(w, fmt.Sprintf("bad bundle: unsupported version %d", manifest.Version), http.StatusBadRequest)
+		return
+	}
+
+	var meta domain.CalculatorBundleMeta
+	if err := readBundleJSON(entries, "calculator.json", &meta); err != nil {
+		http.Error(w, "bad bundle: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !domain.ValidCalculatorType(meta.Type) {
+		http.Error(w, "bad bundle: unknown calculator type", http.StatusBadRequest)
+		return
+	}
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		name = strings.TrimSpace(meta.Name)
+	}
+	if name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+
+	var cfg interface{}
+	if manifest.HasConfig {
+		raw, err := readBundleEntry(entries, "config.json")
+		if err != nil {
+			http.Error(w, "bad bundle: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		raw, err = validateCalculatorConfig(meta.Type, raw)
+		if err != nil {
+			http.Error(w, "bad bundle config: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := json.Unmarshal(raw, &cfg); err != nil {
+			http.Error(w, "bad bundle config: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// --- организация и лимит тарифа, как при создании ---
+	orgID := r.FormValue("orgId")
+	if orgID == "" {
+		orgID = domain.PersonalOrgID(u.ID)
+	}
+	role, err := e.orgRole(r.Context(), u, orgID)
+	if err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !role.AtLeast(domain.OrgRoleEditor) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if !e.checkCalculatorLimit(w, r, u, orgID) {
+		return
+	}
+
+	// --- картинки: сохраняем под новыми именами и переписываем ссылки ---
+	stored := make([]string, 0, len(manifest.Files))
+	cleanup := func() {
+		for _, url := range stored {
+			e.removeUpload(url)
+		}
+	}
+	if cfg != nil {
+		mapped := map[string]string{}
+		var storeErr error
+		cfg = mapUploadURLs(cfg, func(url string) string {
+			if nu, ok := mapped[url]; ok {
+				return nu
+			}
+			nu := url
+			if entryName, ok := manifest.Files[url]; ok && storeErr == nil {
+				var err error
+				nu, err = e.storeBundleUpload(entries, entryName)
+				if err != nil {
+					storeErr = err
+					nu = url
+				} else {
+					stored = append(stored, nu)
+				}
+			}
+			mapped[url] = nu
+			return nu
+		})
+		if storeErr != nil {
+			cleanup()
+			http.Error(w, "bad bundle: "+storeErr.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	c, err := e.newCalculator(r.Context(), u, orgID, name, meta.Type)
+	if err != nil {
+		cleanup()
+		http.Error(w, "failed to allocate id: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := e.insertCalculator(r.Context(), c); err != nil {
+		cleanup()
+		http.Error(w, "db insert error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if cfg != nil {
+		if err := e.saveCalculatorConfig(r.Context(), c.ID, u.ID, cfg); err != nil {
+			if _, derr := e.DB.ExecContext(r.Context(), `DELETE FROM calculators WHERE id = $1`, c.ID); derr != nil {
+				log.Printf("import: cleanup %s: %v", c.ID, derr)
+			}
+			cleanup()
+			http.Error(w, "failed to save config: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		c.UnpublishedChanges = true
+	}
+
+	e.Calculators = append(e.Calculators, c)
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(c)
+}
+
+// storeBundleUpload сохраняет картинку из архива в каталог загрузок и возвращает новую ссылку.
+func (e *Env) storeBundleUpload(entries map[string]*zip.File, name string) (string, error) {
+	if !bundleUploadPath.MatchString(name) {
+		return "", errors.New("bad file path " + name)
+	}
+	data, err := readBundleEntry(entries, name)
+	if err != nil {
+		return "", err
+	}
+	return e.storeUpload(path.Base(name), bytes.NewReader(data))
+}
+
+// readBundleEntry — содержимое файла из архива (с ограничением размера).
+func readBundleEntry(entries map[string]*zip.File, name string) ([]byte, error) {
+	f, ok := entries[name]
+	if !ok {
+		return nil, errors.New("missing " + name)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(rc, bundleMaxEntrySize+1))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	if len(data) > bundleMaxEntrySize {
+		return nil, errors.New(name + " is too large")
+	}
+	return data, nil
+}
+
+func readBundleJSON(entries map[string]*zip.File, name string, dst interface{}) error {
+	data, err := readBundleEntry(entries, name)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, dst); err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	return nil
+}
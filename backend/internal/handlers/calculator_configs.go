@@ -0,0 +1,450 @@
+package handlers
+
+import (
+	"context"
+	"crypto/hmac"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"saas-calc-backend/internal/domain"
+)
+
+// loadCalculatorConfig читает черновик конфига калькулятора в dst.
+// false — конфиг ещё не сохраняли (dst не трогаем, остаются значения по умолчанию).
+func (e *Env) loadCalculatorConfig(ctx context.Context, calcID string, dst interface{}) (bool, error) {
+	return e.scanCalculatorConfig(ctx, calcID, dst,
+		`SELECT config FROM calculator_configs WHERE calculator_id = $1`)
+}
+
+// loadPublishedCalculatorConfig читает опубликованный снимок конфига в dst.
+// false — ещё ничего не публиковали.
+func (e *Env) loadPublishedCalculatorConfig(ctx context.Context, calcID string, dst interface{}) (bool, error) {
+	return e.scanCalculatorConfig(ctx, calcID, dst, `
+SELECT v.config
+FROM calculator_configs c
+JOIN calculator_config_versions v ON v.calculator_id = c.calculator_id AND v.version = c.published_version
+WHERE c.calculator_id = $1
+`)
+}
+
+func (e *Env) scanCalculatorConfig(ctx context.Context, calcID string, dst interface{}, query string) (bool, error) {
+	if e.DB == nil || calcID == "" {
+		return false, nil
+	}
+
+	var raw []byte
+	err := e.DB.QueryRowContext(ctx, query, calcID).Scan(&raw)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	if err := json.Unmarshal(raw, dst); err != nil {
+		return false, fmt.Errorf("config of %s: %v", calcID, err)
+	}
+	return true, nil
+}
+
+// saveCalculatorConfig сохраняет конфиг калькулятора новой версией от имени authorID.
+func (e *Env) saveCalculatorConfig(ctx context.Context, calcID, authorID string, cfg interface{}) error {
+	raw, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	_, err = e.saveCalculatorConfigVersion(ctx, calcID, authorID, raw, 0)
+	return err
+}
+
+// saveCalculatorConfigVersion делает raw текущим конфигом и дописывает его в историю.
+// Номер версии растёт атомарно в calculator_configs, поэтому одновременные сохранения не столкнутся.
+// restoredFrom > 0 — версия получена откатом к restoredFrom.
+func (e *Env) saveCalculatorConfigVersion(ctx context.Context, calcID, authorID string, raw []byte, restoredFrom int) (int, error) {
+	if e.DB == nil {
+		return 0, errors.New("db is nil")
+	}
+
+	tx, err := e.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var version int
+	if err := tx.QueryRowContext(ctx, `
+INSERT INTO calculator_configs (calculator_id, config, updated_at, version)
+VALUES ($1, $2, now(), 1)
+ON CONFLICT (calculator_id) DO UPDATE
+SET config = EXCLUDED.config, updated_at = EXCLUDED.updated_at, version = calculator_configs.version + 1
+RETURNING version
+`, calcID, raw).Scan(&version); err != nil {
+		return 0, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+INSERT INTO calculator_config_versions (calculator_id, version, config, author_id, restored_from)
+VALUES ($1, $2, $3, $4, $5)
+`, calcID, version, raw, nullableString(authorID), nullableInt(restoredFrom)); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// listConfigVersions — история конфига калькулятора, новые сверху (без самих конфигов).
+func (e *Env) listConfigVersions(ctx context.Context, calcID string) ([]*domain.ConfigVersion, error) {
+	rows, err := e.DB.QueryContext(ctx, `
+SELECT v.version, COALESCE(v.author_id, ''), COALESCE(u.email, ''), COALESCE(v.restored_from, 0), v.created_at,
+       v.version = COALESCE(c.version, 0), v.version = COALESCE(c.published_version, 0)
+FROM calculator_config_versions v
+LEFT JOIN users u ON u.id = v.author_id
+LEFT JOIN calculator_configs c ON c.calculator_id = v.calculator_id
+WHERE v.calculator_id = $1
+ORDER BY v.version DESC
+`, calcID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]*domain.ConfigVersion, 0)
+	for rows.Next() {
+		v := &domain.ConfigVersion{CalculatorID: calcID}
+		if err := rows.Scan(&v.Version, &v.AuthorID, &v.AuthorEmail, &v.RestoredFrom, &v.CreatedAt, &v.Current, &v.Published); err != nil {
+			return nil, err
+		}
+		items = append(items, v)
+	}
+	return items, rows.Err()
+}
+
+// getConfigVersion — версия конфига вместе с самим конфигом (nil, если такой нет).
+func (e *Env) getConfigVersion(ctx context.Context, calcID string, version int) (*domain.ConfigVersion, error) {
+	v := &domain.ConfigVersion{CalculatorID: calcID}
+	var raw []byte
+	err := e.DB.QueryRowContext(ctx, `
+SELECT v.version, COALESCE(v.author_id, ''), COALESCE(u.email, ''), COALESCE(v.restored_from, 0), v.created_at,
+       v.version = COALESCE(c.version, 0), v.version = COALESCE(c.published_version, 0), v.config
+FROM calculator_config_versions v
+LEFT JOIN users u ON u.id = v.author_id
+LEFT JOIN calculator_configs c ON c.calculator_id = v.calculator_id
+WHERE v.calculator_id = $1 AND v.version = $2
+`, calcID, version).Scan(&v.Version, &v.AuthorID, &v.AuthorEmail, &v.RestoredFrom, &v.CreatedAt, &v.Current, &v.Published, &raw)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	v.Config = raw
+	return v, nil
+}
+
+// copyCalculatorConfig копирует конфиг калькулятора srcID в dstID.
+// Картинки из /uploads/ копируются под новыми именами, чтобы правки копии не задевали оригинал.
+// Если конфиг у исходного калькулятора не сохраняли — у копии тоже будет стартовый.
+// Копия начинает свою историю с версии 1 от имени authorID.
+func (e *Env) copyCalculatorConfig(ctx context.Context, srcID, dstID, authorID string) error {
+	var cfg interface{}
+	ok, err := e.loadCalculatorConfig(ctx, srcID, &cfg)
+	if err != nil || !ok {
+		return err
+	}
+
+	return e.saveCalculatorConfig(ctx, dstID, authorID, e.copyConfigUploads(cfg))
+}
+
+// copyConfigUploads копирует все картинки /uploads/ из распарсенного конфига
+// и возвращает конфиг со ссылками на копии (одна картинка в нескольких местах копируется один раз).
+func (e *Env) copyConfigUploads(cfg interface{}) interface{} {
+	copied := map[string]string{}
+	return mapUploadURLs(cfg, func(url string) string {
+		if nu, ok := copied[url]; ok {
+			return nu
+		}
+		nu, err := e.copyUpload(url)
+		if err != nil {
+			// файла нет (удалили руками и т.п.) — оставляем ссылку как есть
+			log.Printf("copy config uploads: %v", err)
+			nu = url
+		}
+		copied[url] = nu
+		return nu
+	})
+}
+
+// mapUploadURLs проходит по распарсенному JSON и заменяет строки-ссылки на /uploads/ через fn.
+func mapUploadURLs(v interface{}, fn func(string) string) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, item := range t {
+			t[k] = mapUploadURLs(item, fn)
+		}
+	case []interface{}:
+		for i, item := range t {
+			t[i] = mapUploadURLs(item, fn)
+		}
+	case string:
+		if strings.HasPrefix(t, uploadURLPrefix) {
+			return fn(t)
+		}
+	}
+	return v
+}
+
+// publishCalculatorConfig делает текущий черновик конфига опубликованным снимком.
+// Если конфиг ни разу не сохраняли, публикуется стартовый: у опубликованного калькулятора
+// снимок есть всегда, а его отсутствие значит «посетителям считать нечего».
+func (e *Env) publishCalculatorConfig(ctx context.Context, c *domain.Calculator, authorID string) error {
+	if cfg := defaultCalculatorConfig(c.Type); cfg != nil {
+		var exists bool
+		if err := e.DB.QueryRowContext(ctx,
+			`SELECT EXISTS (SELECT 1 FROM calculator_configs WHERE calculator_id = $1)`,
+			c.ID,
+		).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			if err := e.saveCalculatorConfig(ctx, c.ID, authorID, cfg); err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err := e.DB.ExecContext(ctx, `
+UPDATE calculator_configs
+SET published_version = version, published_at = now()
+WHERE calculator_id = $1
+`, c.ID)
+	return err
+}
+
+// EnsurePublishedConfigs публикует стартовый конфиг у опубликованных калькуляторов, у которых
+// снимка нет (демо-калькуляторы и опубликованные до того, как публикация стала создавать снимок).
+func (e *Env) EnsurePublishedConfigs(ctx context.Context) error {
+	if e.DB == nil {
+		return nil
+	}
+	rows, err := e.DB.QueryContext(ctx, `
+SELECT c.id, c.type
+FROM calculators c
+LEFT JOIN calculator_configs cc ON cc.calculator_id = c.id
+WHERE c.status = $1 AND c.deleted_at IS NULL AND (cc.calculator_id IS NULL OR cc.published_version IS NULL)
+`, domain.CalculatorStatusPublished)
+	if err != nil {
+		return err
+	}
+	var calcs []*domain.Calculator
+	for rows.Next() {
+		var c domain.Calculator
+		var t string
+		if err := rows.Scan(&c.ID, &t); err != nil {
+			rows.Close()
+			return err
+		}
+		c.Type = domain.CalculatorType(t)
+		calcs = append(calcs, &c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, c := range calcs {
+		if err := e.publishCalculatorConfig(ctx, c, ""); err != nil {
+			return fmt.Errorf("publish config of %s: %v", c.ID, err)
+		}
+	}
+	return nil
+}
+
+// defaultCalculatorConfig — стартовый конфиг типа (nil — у типа нет конфига, как у ипотеки).
+func defaultCalculatorConfig(t domain.CalculatorType) interface{} {
+	switch t {
+	case domain.CalculatorTypeLayered:
+		return domain.NewDefaultLayeredConfig()
+	case domain.CalculatorTypeDistance:
+		return domain.NewDefaultDistanceConfig()
+	case domain.CalculatorTypeOnSite:
+		return domain.NewDefaultOnSiteConfig()
+	case domain.CalculatorTypeFormula:
+		return domain.NewDefaultFormulaConfig()
+	case domain.CalculatorTypeForm:
+		return domain.NewDefaultFormConfig()
+	}
+	return nil
+}
+
+// previewTokenValid — открывает ли token предпросмотр калькулятора (черновик и непубличные статусы).
+func previewTokenValid(c *domain.Calculator, token string) bool {
+	return token != "" && c.PreviewToken != "" && hmac.Equal([]byte(token), []byte(c.PreviewToken))
+}
+
+// publishedConfigRequested — считать ли по опубликованному конфигу (false — по черновику,
+// если передан верный токен предпросмотра калькулятора calcID).
+func (e *Env) publishedConfigRequested(ctx context.Context, calcID, previewToken string) (bool, error) {
+	if e.DB == nil || calcID == "" || previewToken == "" {
+		return true, nil
+	}
+	c, err := e.GetCalculatorByID(ctx, calcID)
+	if err != nil {
+		return true, err
+	}
+	return c == nil || !previewTokenValid(c, previewToken), nil
+}
+
+// Что видит посетитель калькулятора без токена предпросмотра.
+const (
+	calcPublic      = iota // опубликован, владелец может публиковать
+	calcUnavailable        // черновик или архив — «калькулятор недоступен»
+	calcHidden             // владелец не подтвердил email — как будто калькулятора нет
+)
+
+// publicCalcState — доступен ли калькулятор посетителям. Одна проверка и для публичной
+// страницы, и для API расчёта: иначе непубличный калькулятор можно было бы считать напрямую.
+func (e *Env) publicCalcState(ctx context.Context, c *domain.Calculator) int {
+	// публиковать могут только владельцы с подтверждённым email
+	if !e.ownerCanPublish(ctx, c.OwnerID) {
+		return calcHidden
+	}
+	if c.Status != domain.CalculatorStatusPublished {
+		return calcUnavailable
+	}
+	return calcPublic
+}
+
+// requireCalcTarget — проверка calculatorId публичного расчёта. Без него считаем по стартовому
+// конфигу (демо). Неизвестный, удалённый в корзину, калькулятор другого типа или недоступный
+// посетителям (черновик, архив, владелец без подтверждённого email) — 404: иначе расчёт прошёл бы
+// мимо публикации, попал в счётчик и в уведомления владельцу. С верным токеном предпросмотра
+// непубличный калькулятор считать можно.
+func (e *Env) requireCalcTarget(w http.ResponseWriter, r *http.Request, calcID, previewToken string, calcType domain.CalculatorType) bool {
+	if calcID == "" || e.DB == nil {
+		return true
+	}
+	c, err := e.GetCalculatorByID(r.Context(), calcID)
+	if err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	if c == nil || c.Type != calcType {
+		http.Error(w, "calculator not found", http.StatusNotFound)
+		return false
+	}
+	if !previewTokenValid(c, previewToken) && e.publicCalcState(r.Context(), c) != calcPublic {
+		http.Error(w, "calculator not found", http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+// errConfigNotPublished — у калькулятора нет опубликованного снимка конфига: посетителям
+// считать не по чему (стартовый конфиг с демо-ценами им не показываем).
+var errConfigNotPublished = errors.New("calculator config is not published")
+
+// loadConfigFor — черновик (published=false) или опубликованный снимок конфига.
+// Без calculatorId (демо) остаётся стартовый конфиг, а у калькулятора без снимка — errConfigNotPublished.
+func (e *Env) loadConfigFor(ctx context.Context, calcID string, published bool, dst interface{}) error {
+	if !published {
+		_, err := e.loadCalculatorConfig(ctx, calcID, dst)
+		return err
+	}
+	found, err := e.loadPublishedCalculatorConfig(ctx, calcID, dst)
+	if err != nil {
+		return err
+	}
+	if !found && calcID != "" && e.DB != nil {
+		return errConfigNotPublished
+	}
+	return nil
+}
+
+// configLoadFailed отвечает на ошибку загрузки конфига для посетителя:
+// нет опубликованного снимка — 404, остальное — ошибка БД.
+func configLoadFailed(w http.ResponseWriter, err error) {
+	if errors.Is(err, errConfigNotPublished) {
+		http.Error(w, "calculator not found", http.StatusNotFound)
+		return
+	}
+	http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+}
+
+// layeredConfigFor — конфиг послойного калькулятора (стартовый, если ещё не настраивали):
+// черновик для конструктора и предпросмотра, опубликованный снимок — для посетителей.
+func (e *Env) layeredConfigFor(ctx context.Context, calcID string, published bool) (*domain.LayeredConfig, error) {
+	cfg := domain.NewDefaultLayeredConfig()
+	if err := e.loadConfigFor(ctx, calcID, published, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// distanceConfigFor — то же для калькулятора доставки.
+func (e *Env) distanceConfigFor(ctx context.Context, calcID string, published bool) (*domain.DistanceConfig, error) {
+	cfg := domain.NewDefaultDistanceConfig()
+	if err := e.loadConfigFor(ctx, calcID, published, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// onSiteConfigFor — то же для калькулятора выезда специалиста.
+func (e *Env) onSiteConfigFor(ctx context.Context, calcID string, published bool) (*domain.OnSiteConfig, error) {
+	cfg := domain.NewDefaultOnSiteConfig()
+	if err := e.loadConfigFor(ctx, calcID, published, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// formulaConfigFor — то же для формульного калькулятора.
+func (e *Env) formulaConfigFor(ctx context.Context, calcID string, published bool) (*domain.FormulaConfig, error) {
+	cfg := domain.NewDefaultFormulaConfig()
+	if err := e.loadConfigFor(ctx, calcID, published, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// formConfigFor — то же для калькулятора-формы.
+func (e *Env) formConfigFor(ctx context.Context, calcID string, published bool) (*domain.FormConfig, error) {
+	cfg := domain.NewDefaultFormConfig()
+	if err := e.loadConfigFor(ctx, calcID, published, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// requireConfigCalculator — калькулятор из ?calculatorId= для редактора конфига:
+// проверяет доступ (viewer на чтение, editor на запись) и тип.
+// Сам отвечает клиенту и возвращает nil, если дальше идти нельзя.
+func (e *Env) requireConfigCalculator(w http.ResponseWriter, r *http.Request, u *domain.User, t domain.CalculatorType) *domain.Calculator {
+	if e.DB == nil {
+		http.Error(w, "db is not configured", http.StatusServiceUnavailable)
+		return nil
+	}
+
+	need := domain.OrgRoleViewer
+	if r.Method == http.MethodPost {
+		need = domain.OrgRoleEditor
+	}
+
+	c := e.requireCalculator(w, r, u, r.URL.Query().Get("calculatorId"), need)
+	if c == nil {
+		return nil
+	}
+	if c.Type != t {
+		http.Error(w, "calculator is not of type "+string(t), http.StatusBadRequest)
+		return nil
+	}
+	return c
+}
@@ -3,9 +3,9 @@ package handlers
 import (
 	"database/sql"
 	"encoding/json"
+	"log"
 	"net/http"
-	"strconv"
-	"time"
+	"strings"
 
 	"saas-calc-backend/internal/domain"
 )
@@ -16,15 +16,20 @@ type calculatorsResponse struct {
 }
 
 type createCalculatorRequest struct {
-	Name string `json:"name"`
-	Type string `json:"type"`
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	OrgID string `json:"orgId"` // пусто — личная организация
+
+	// шаблон из /api/templates: тип и имя можно не передавать, конфиг заполнится из шаблона
+	TemplateID string `json:"templateId"`
 }
 
 // HandleCalculators обслуживает /api/calculators.
 //
-// GET    -> список калькуляторов для текущего пользователя (админ видит все).
-// POST   -> создать новый калькулятор для текущего пользователя (+ проверка лимита тарифа).
-// DELETE -> удалить калькулятор по id (админ — любой, пользователь — только свой).
+// GET    -> калькуляторы организаций текущего пользователя (админ видит все) постранично:
+//           ?orgId=&q=&type=&status=&tag=&owner=&folderId=&sort=&cursor=&limit= -> {items, total, nextCursor}.
+// POST   -> создать калькулятор в организации (editor и выше, + проверка лимита тарифа организации).
+// DELETE -> удалить калькулятор по id в корзину (нужна роль owner в организации калькулятора).
 func (e *Env) HandleCalculators(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
@@ -38,89 +43,364 @@ func (e *Env) HandleCalculators(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// --- GET /api/calculators ---
+type updateCalculatorRequest struct {
+	Name     *string   `json:"name"`
+	Status   *string   `json:"status"`   // draft / published / archived
+	Tags     *[]string `json:"tags"`     // заменяет все метки
+	FolderID *string   `json:"folderId"` // пусто — убрать из папки
+}
 
-func (e *Env) handleGetCalculators(w http.ResponseWriter, r *http.Request) {
-	u := e.CurrentUser(r)
+type cloneCalculatorRequest struct {
+	Name string `json:"name"` // пусто — "<имя> (копия)"
+}
+
+// HandleCalculatorDetail обслуживает /api/calculators/{id}/...
+//
+// PUT  /api/calculators/{id}       -> переименование, смена статуса (draft ⇄ published ⇄ archived), метки и папка, нужна роль editor.
+// POST /api/calculators/{id}/clone -> копия калькулятора вместе с конфигом и картинками, нужна роль editor.
+// POST /api/calculators/{id}/publish -> опубликовать черновик конфига (черновик калькулятора заодно становится published).
+// GET  /api/calculators/{id}/export -> zip-архив с конфигом и картинками (viewer).
+// POST /api/calculators/import      -> создать калькулятор из такого архива (см. handleImportCalculator).
+// PUT  /api/calculators/{id}/slug          -> адрес /c/{slug} (owner).
+// POST /api/calculators/{id}/rotate-token  -> новый публичный токен, старая ссылка — в историю (owner).
+// /api/calculators/{id}/redirects          -> история старых адресов (см. handleCalculatorRedirects).
+// GET  /api/calculators/trash              -> корзина: удалённые калькуляторы со сроком окончательного удаления.
+// POST /api/calculators/{id}/restore       -> вернуть калькулятор из корзины (owner).
+// /api/calculators/{id}/transfer           -> передать калькулятор другому пользователю (см. handleCalculatorTransfer).
+// /api/calculators/transfers/...           -> входящие передачи: принять / отклонить (см. handleTransfers).
+// /api/calculators/{id}/versions/... -> история конфига и откат (см. handleConfigVersions).
+func (e *Env) HandleCalculatorDetail(w http.ResponseWriter, r *http.Request) {
+	u := e.requireUser(w, r)
 	if u == nil {
-		http.Error(w, "user not found", http.StatusUnauthorized)
+		return
+	}
+	if e.DB == nil {
+		http.Error(w, "db is nil", http.StatusInternalServerError)
 		return
 	}
 
-	// Если есть БД — читаем из неё
-	if e.DB != nil {
-		var rows *sql.Rows
-		var err error
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/calculators/"), "/")
+	parts := strings.Split(rest, "/")
+	id := parts[0]
+	if id == "" {
+		http.NotFound(w, r)
+		return
+	}
 
-		if u.Role == domain.RoleAdmin {
-			rows, err = e.DB.Query(`
-				SELECT id, name, type, owner_id, status, created_at, public_token, public_path, calc_count
-				FROM calculators
-				ORDER BY created_at DESC
-			`)
-		} else {
-			rows, err = e.DB.Query(`
-				SELECT id, name, type, owner_id, status, created_at, public_token, public_path, calc_count
-				FROM calculators
-				WHERE owner_id = $1
-				ORDER BY created_at DESC
-			`, u.ID)
+	switch {
+	case len(parts) == 1 && id == "trash":
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
 		}
+		e.handleListTrash(w, r, u)
 
-		if err != nil {
+	case len(parts) == 1 && id == "import":
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		e.handleImportCalculator(w, r, u)
+
+	case id == "transfers":
+		e.handleTransfers(w, r, u, parts[1:])
+
+	case len(parts) == 1:
+		if r.Method != http.MethodPut {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		e.handleUpdateCalculator(w, r, u, id)
+
+	case len(parts) == 2 && parts[1] == "clone":
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		e.handleCloneCalculator(w, r, u, id)
+
+	case len(parts) == 2 && parts[1] == "export":
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		e.handleExportCalculator(w, r, u, id)
+
+	case len(parts) == 2 && parts[1] == "restore":
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		e.handleRestoreCalculator(w, r, u, id)
+
+	case len(parts) == 2 && parts[1] == "slug":
+		if r.Method != http.MethodPut {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		e.handleCalculatorSlug(w, r, u, id)
+
+	case len(parts) == 2 && parts[1] == "rotate-token":
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		e.handleRotatePublicToken(w, r, u, id)
+
+	case len(parts) == 2 && parts[1] == "transfer":
+		e.handleCalculatorTransfer(w, r, u, id)
+
+	case len(parts) == 2 && parts[1] == "redirects":
+		e.handleCalculatorRedirects(w, r, u, id)
+
+	case len(parts) == 2 && parts[1] == "publish":
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		e.handlePublishCalculator(w, r, u, id)
+
+	case parts[1] == "versions":
+		e.handleConfigVersions(w, r, u, id, parts[2:])
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// --- POST /api/calculators/{id}/publish ---
+
+// Посетители видят только опубликованный снимок конфига; правки в конструкторе
+// попадают на сайт после публикации. Архивный калькулятор остаётся в архиве.
+func (e *Env) handlePublishCalculator(w http.ResponseWriter, r *http.Request, u *domain.User, id string) {
+	c := e.requireCalculator(w, r, u, id, domain.OrgRoleEditor)
+	if c == nil {
+		return
+	}
+
+	// публиковать могут только владельцы с подтверждённым email
+	if !e.ownerCanPublish(r.Context(), c.OwnerID) {
+		http.Error(w, "owner email is not confirmed", http.StatusForbidden)
+		return
+	}
+
+	if err := e.publishCalculatorConfig(r.Context(), c, u.ID); err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if c.Status == domain.CalculatorStatusDraft {
+		if _, err := e.DB.ExecContext(r.Context(),
+			`UPDATE calculators SET status = $1 WHERE id = $2`,
+			domain.CalculatorStatusPublished, c.ID,
+		); err != nil {
 			http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
-		defer rows.Close()
-
-		items := make([]*domain.Calculator, 0)
-		for rows.Next() {
-			var (
-				id, name, ctypeStr, ownerID, status, publicToken, publicPath string
-				createdAt                                                    time.Time
-				calcCount                                                    int
-			)
-			if err := rows.Scan(
-				&id,
-				&name,
-				&ctypeStr,
-				&ownerID,
-				&status,
-				&createdAt,
-				&publicToken,
-				&publicPath,
-				&calcCount,
-			); err != nil {
-				http.Error(w, "db scan error: "+err.Error(), http.StatusInternalServerError)
-				return
+		for _, cached := range e.Calculators {
+			if cached.ID == c.ID {
+				cached.Status = domain.CalculatorStatusPublished
+				break
 			}
+		}
+	}
 
-			calc := &domain.Calculator{
-				ID:          id,
-				Name:        name,
-				Type:        domain.CalculatorType(ctypeStr),
-				OwnerID:     ownerID,
-				Status:      status,
-				CreatedAt:   createdAt,
-				PublicToken: publicToken,
-				PublicPath:  publicPath,
-				CalcCount:   calcCount,
-			}
-			items = append(items, calc)
+	updated, err := e.GetCalculatorByID(r.Context(), c.ID)
+	if err != nil || updated == nil {
+		http.Error(w, "failed to load calculator", http.StatusInternalServerError)
+		return
+	}
+	e.writeJSON(w, updated)
+}
+
+// --- POST /api/calculators/{id}/clone ---
+
+// Копия создаётся в той же организации черновиком: новые публичный токен и ссылка,
+// счётчик расчётов с нуля, конфиг копируется вместе с загруженными картинками.
+func (e *Env) handleCloneCalculator(w http.ResponseWriter, r *http.Request, u *domain.User, id string) {
+	src := e.requireCalculator(w, r, u, id, domain.OrgRoleEditor)
+	if src == nil {
+		return
+	}
+	defer r.Body.Close()
+
+	var req cloneCalculatorRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad json: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		name = src.Name + " (копия)"
+	}
+
+	// копия занимает место в тарифе, как и новый калькулятор
+	if !e.checkCalculatorLimit(w, r, u, src.OrgID) {
+		return
+	}
+
+	c, err := e.newCalculator(r.Context(), u, src.OrgID, name, src.Type)
+	if err != nil {
+		http.Error(w, "failed to allocate id: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := e.insertCalculator(r.Context(), c); err != nil {
+		http.Error(w, "db insert error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := e.copyCalculatorConfig(r.Context(), src.ID, c.ID, u.ID); err != nil {
+		// без конфига копия бесполезна — убираем её
+		if _, derr := e.DB.ExecContext(r.Context(), `DELETE FROM calculators WHERE id = $1`, c.ID); derr != nil {
+			log.Printf("clone %s: cleanup %s: %v", src.ID, c.ID, derr)
 		}
-		if err := rows.Err(); err != nil {
-			http.Error(w, "db rows error: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "failed to copy config: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	e.Calculators = append(e.Calculators, c)
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(c)
+}
+
+// --- PUT /api/calculators/{id} ---
+
+func (e *Env) handleUpdateCalculator(w http.ResponseWriter, r *http.Request, u *domain.User, id string) {
+	c := e.requireCalculator(w, r, u, id, domain.OrgRoleEditor)
+	if c == nil {
+		return
+	}
+	defer r.Body.Close()
+
+	var req updateCalculatorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	name := c.Name
+	if req.Name != nil {
+		name = strings.TrimSpace(*req.Name)
+		if name == "" {
+			http.Error(w, "name is required", http.StatusBadRequest)
 			return
 		}
+	}
 
-		// Обновим in-memory кэш для совместимости с существующим кодом
-		if u.Role == domain.RoleAdmin {
-			e.Calculators = items
-		} else {
-			// для обычного пользователя кэш не трогаем, чтобы админский список не терять
+	status := c.Status
+	if req.Status != nil && *req.Status != c.Status {
+		status = *req.Status
+		if !domain.ValidCalculatorStatus(status) {
+			http.Error(w, "unknown status", http.StatusBadRequest)
+			return
 		}
+		if !domain.CanTransitionCalculator(c.Status, status) {
+			http.Error(w, "cannot change status from "+c.Status+" to "+status, http.StatusConflict)
+			return
+		}
+		// публиковать могут только владельцы с подтверждённым email
+		if status == domain.CalculatorStatusPublished && !e.ownerCanPublish(r.Context(), c.OwnerID) {
+			http.Error(w, "owner email is not confirmed", http.StatusForbidden)
+			return
+		}
+	}
 
-		e.writeJSON(w, calculatorsResponse{Items: items})
+	tags := c.Tags
+	if req.Tags != nil {
+		var err error
+		tags, err = domain.NormalizeTags(*req.Tags)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	folderID := c.FolderID
+	if req.FolderID != nil {
+		folderID = *req.FolderID
+		if folderID != "" {
+			// папка должна быть в той же организации
+			f, err := e.getFolder(r.Context(), folderID)
+			if err != nil {
+				http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if f == nil || f.OrgID != c.OrgID {
+				http.Error(w, "folder not found", http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	err := e.inTx(r.Context(), func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(r.Context(),
+			`UPDATE calculators SET name = $1, status = $2, folder_id = $3 WHERE id = $4`,
+			name, status, nullableString(folderID), c.ID,
+		); err != nil {
+			return err
+		}
+		if req.Tags == nil {
+			return nil
+		}
+		return setCalculatorTags(r.Context(), tx, c.ID, tags)
+	})
+	if err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// выход в публикацию — это и публикация текущего черновика конфига
+	if status == domain.CalculatorStatusPublished && c.Status != status {
+		if err := e.publishCalculatorConfig(r.Context(), c, u.ID); err != nil {
+			http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		c.UnpublishedChanges = false
+	}
+	c.Name = name
+	c.Status = status
+	c.Tags = tags
+	c.FolderID = folderID
+
+	// синхронизируем in-memory кэш
+	for _, cached := range e.Calculators {
+		if cached.ID == c.ID {
+			cached.Name = name
+			cached.Status = status
+			cached.Tags = tags
+			cached.FolderID = folderID
+			break
+		}
+	}
+
+	e.writeJSON(w, c)
+}
+
+// --- GET /api/calculators ---
+
+func (e *Env) handleGetCalculators(w http.ResponseWriter, r *http.Request) {
+	u := e.CurrentUser(r)
+	if u == nil {
+		http.Error(w, "user not found", http.StatusUnauthorized)
+		return
+	}
+
+	// Если есть БД — фильтры, сортировка и страницы считаются в SQL (см. queryCalculators)
+	if e.DB != nil {
+		q, err := parseCalculatorQuery(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		page, err := e.queryCalculators(r.Context(), u, q)
+		if err != nil {
+			http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		e.writeJSON(w, page)
 		return
 	}
 
@@ -159,6 +439,34 @@ func (e *Env) handlePostCalculator(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// --- шаблон: из него берём тип, имя по умолчанию и конфиг ---
+	var tpl *domain.CalculatorTemplate
+	if req.TemplateID != "" {
+		if e.DB == nil {
+			http.Error(w, "templates require db", http.StatusBadRequest)
+			return
+		}
+		var err error
+		tpl, err = e.getTemplate(r.Context(), req.TemplateID)
+		if err != nil {
+			http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if tpl == nil {
+			http.Error(w, "unknown template", http.StatusBadRequest)
+			return
+		}
+		if req.Type == "" {
+			req.Type = string(tpl.Type)
+		} else if req.Type != string(tpl.Type) {
+			http.Error(w, "type does not match template", http.StatusBadRequest)
+			return
+		}
+		if req.Name == "" {
+			req.Name = tpl.Name
+		}
+	}
+
 	if req.Name == "" {
 		http.Error(w, "name is required", http.StatusBadRequest)
 		return
@@ -169,91 +477,57 @@ func (e *Env) handlePostCalculator(w http.ResponseWriter, r *http.Request) {
 	}
 
 	calcType := domain.CalculatorType(req.Type)
-	switch calcType {
-	case domain.CalculatorTypeLayered,
-		domain.CalculatorTypeDistance,
-		domain.CalculatorTypeOnSite,
-		domain.CalculatorTypeMortgage:
-		// ok
-	default:
+	if !domain.ValidCalculatorType(calcType) {
 		http.Error(w, "unknown calculator type", http.StatusBadRequest)
 		return
 	}
 
-	// --- проверка лимита по тарифу (кроме администратора) ---
-	plan := domain.FindPlan(e.Plans, u.PlanID)
-	if u.Role != domain.RoleAdmin && plan != nil && plan.MaxCalculators > 0 {
-		// считаем существующие калькуляторы владельца
-		count := 0
-
-		if e.DB != nil {
-			if err := e.DB.QueryRow(
-				`SELECT COUNT(*) FROM calculators WHERE owner_id = $1`,
-				u.ID,
-			).Scan(&count); err != nil {
-				http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
-				return
-			}
-		} else {
-			for _, existing := range e.Calculators {
-				if existing.OwnerID == u.ID {
-					count++
-				}
-			}
+	// --- организация: по умолчанию личная, создавать может editor и выше ---
+	orgID := req.OrgID
+	if orgID == "" {
+		orgID = domain.PersonalOrgID(u.ID)
+	}
+	if e.DB != nil {
+		role, err := e.orgRole(r.Context(), u, orgID)
+		if err != nil {
+			http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+			return
 		}
-
-		if count >= plan.MaxCalculators {
-			w.Header().Set("Content-Type", "application/json; charset=utf-8")
-			w.WriteHeader(http.StatusBadRequest)
-			_ = json.NewEncoder(w).Encode(map[string]interface{}{
-				"error": "Вы достигли лимита калькуляторов для вашего тарифа",
-				"limit": plan.MaxCalculators,
-			})
+		if !role.AtLeast(domain.OrgRoleEditor) {
+			http.Error(w, "forbidden", http.StatusForbidden)
 			return
 		}
 	}
-	// --- конец проверки лимита ---
-
-	id := "calc_" + strconv.Itoa(e.NextCalcID)
-	e.NextCalcID++
 
-	token := domain.GeneratePublicToken()
-	publicPath := "/p/" + u.ID + "/" + token
+	// --- проверка лимита по тарифу организации (кроме администратора) ---
+	if !e.checkCalculatorLimit(w, r, u, orgID) {
+		return
+	}
 
-	c := &domain.Calculator{
-		ID:          id,
-		Name:        req.Name,
-		Type:        calcType,
-		OwnerID:     u.ID,
-		Status:      "draft",
-		CreatedAt:   time.Now(),
-		PublicToken: token,
-		PublicPath:  publicPath,
-		CalcCount:   0,
+	c, err := e.newCalculator(r.Context(), u, orgID, req.Name, calcType)
+	if err != nil {
+		http.Error(w, "failed to allocate id: "+err.Error(), http.StatusInternalServerError)
+		return
 	}
 
 	// Сначала сохраняем в БД, если она есть
 	if e.DB != nil {
-		_, err := e.DB.Exec(`
-			INSERT INTO calculators (
-				id, name, type, owner_id, status, created_at,
-				public_token, public_path, calc_count
-			) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9)
-		`,
-			c.ID,
-			c.Name,
-			string(c.Type),
-			c.OwnerID,
-			c.Status,
-			c.CreatedAt,
-			c.PublicToken,
-			c.PublicPath,
-			c.CalcCount,
-		)
-		if err != nil {
+		if err := e.insertCalculator(r.Context(), c); err != nil {
 			http.Error(w, "db insert error: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
+
+		if tpl != nil {
+			if err := e.applyTemplateConfig(r.Context(), c.ID, u.ID, tpl); err != nil {
+				// калькулятор без обещанного конфига не оставляем
+				if _, derr := e.DB.ExecContext(r.Context(), `DELETE FROM calculators WHERE id = $1`, c.ID); derr != nil {
+					log.Printf("create from template %s: cleanup %s: %v", tpl.ID, c.ID, derr)
+				}
+				http.Error(w, "failed to apply template: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			c.UnpublishedChanges = true
+		}
 	}
 
 	// Обновляем in-memory список для совместимости
@@ -263,6 +537,55 @@ func (e *Env) handlePostCalculator(w http.ResponseWriter, r *http.Request) {
 	_ = json.NewEncoder(w).Encode(c)
 }
 
+// checkCalculatorLimit проверяет лимит калькуляторов по тарифу организации.
+// Если лимит исчерпан — сам отвечает клиенту и возвращает false. Админа не ограничиваем.
+func (e *Env) checkCalculatorLimit(w http.ResponseWriter, r *http.Request, u *domain.User, orgID string) bool {
+	if u.Role == domain.RoleAdmin {
+		return true
+	}
+
+	planID := u.PlanID
+	count := 0
+
+	if e.DB != nil {
+		org, err := e.GetOrg(r.Context(), orgID)
+		if err != nil {
+			http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+			return false
+		}
+		if org == nil {
+			http.Error(w, "organization not found", http.StatusNotFound)
+			return false
+		}
+		planID = org.PlanID
+
+		count, err = e.countOrgCalculators(r.Context(), orgID)
+		if err != nil {
+			http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+			return false
+		}
+	} else {
+		for _, existing := range e.Calculators {
+			if existing.OwnerID == u.ID {
+				count++
+			}
+		}
+	}
+
+	plan := domain.FindPlan(e.Plans, planID)
+	if plan == nil || plan.MaxCalculators <= 0 || count < plan.MaxCalculators {
+		return true
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusBadRequest)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": "Вы достигли лимита калькуляторов для вашего тарифа",
+		"limit": plan.MaxCalculators,
+	})
+	return false
+}
+
 // --- DELETE /api/calculators?id=calc_123 ---
 
 func (e *Env) handleDeleteCalculator(w http.ResponseWriter, r *http.Request) {
@@ -278,28 +601,15 @@ func (e *Env) handleDeleteCalculator(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Удаляем в БД, если она есть
+	// В БД калькулятор уходит в корзину (удалять может только owner организации):
+	// его можно восстановить, пока не истёк срок хранения, потом его удалит PurgeTrash
 	if e.DB != nil {
-		if u.Role == domain.RoleAdmin {
-			_, err := e.DB.Exec(`DELETE FROM calculators WHERE id = $1`, id)
-			if err != nil {
-				http.Error(w, "db delete error: "+err.Error(), http.StatusInternalServerError)
-				return
-			}
-		} else {
-			res, err := e.DB.Exec(
-				`DELETE FROM calculators WHERE id = $1 AND owner_id = $2`,
-				id, u.ID,
-			)
-			if err != nil {
-				http.Error(w, "db delete error: "+err.Error(), http.StatusInternalServerError)
-				return
-			}
-			n, _ := res.RowsAffected()
-			if n == 0 {
-				http.Error(w, "not found", http.StatusNotFound)
-				return
-			}
+		if e.requireCalculator(w, r, u, id, domain.OrgRoleOwner) == nil {
+			return
+		}
+		if _, err := e.DB.Exec(`UPDATE calculators SET deleted_at = now() WHERE id = $1 AND deleted_at IS NULL`, id); err != nil {
+			http.Error(w, "db delete error: "+err.Error(), http.StatusInternalServerError)
+			return
 		}
 	}
 
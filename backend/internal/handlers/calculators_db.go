@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/lib/pq"
+
+	"saas-calc-backend/internal/domain"
+)
+
+// calculatorColumns — набор колонок для scanCalculator.
+// Последняя колонка — есть ли в черновике конфига неопубликованные правки.
+// Удалённые в корзину калькуляторы (deleted_at) запросы должны отсекать сами.
+const calculatorColumns = `id, name, type, owner_id, COALESCE(org_id, ''), status, created_at, public_token, public_path, calc_count, preview_token, COALESCE(slug, ''), deleted_at,
+	COALESCE(folder_id, ''), ARRAY(SELECT t.tag FROM calculator_tags t WHERE t.calculator_id = calculators.id ORDER BY t.tag),
+	COALESCE((SELECT cc.version <> COALESCE(cc.published_version, 0) FROM calculator_configs cc WHERE cc.calculator_id = calculators.id), FALSE)`
+
+func scanCalculator(row rowScanner) (*domain.Calculator, error) {
+	var c domain.Calculator
+	var ctype string
+	var deletedAt sql.NullTime
+	if err := row.Scan(
+		&c.ID,
+		&c.Name,
+		&ctype,
+		&c.OwnerID,
+		&c.OrgID,
+		&c.Status,
+		&c.CreatedAt,
+		&c.PublicToken,
+		&c.PublicPath,
+		&c.CalcCount,
+		&c.PreviewToken,
+		&c.Slug,
+		&deletedAt,
+		&c.FolderID,
+		pq.Array(&c.Tags),
+		&c.UnpublishedChanges,
+	); err != nil {
+		return nil, err
+	}
+	c.Type = domain.CalculatorType(ctype)
+	if deletedAt.Valid {
+		c.DeletedAt = &deletedAt.Time
+	}
+	if c.Tags == nil {
+		c.Tags = []string{}
+	}
+	return &c, nil
+}
+
+// newCalculator — новый черновик в организации orgID со свежими id, публичным токеном и ссылкой.
+func (e *Env) newCalculator(ctx context.Context, u *domain.User, orgID, name string, t domain.CalculatorType) (*domain.Calculator, error) {
+	id, err := e.allocateCalculatorID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	token := domain.GeneratePublicToken()
+
+	return &domain.Calculator{
+		ID:           id,
+		Name:         name,
+		Type:         t,
+		OwnerID:      u.ID,
+		OrgID:        orgID,
+		Status:       domain.CalculatorStatusDraft,
+		CreatedAt:    time.Now(),
+		PublicToken:  token,
+		PublicPath:   domain.TokenPublicPath(u.ID, token),
+		PreviewToken: domain.GeneratePublicToken(),
+		CalcCount:    0,
+		Tags:         []string{},
+	}, nil
+}
+
+// allocateCalculatorID выдаёт id нового калькулятора.
+// С БД номер берётся из последовательности calculator_id_seq — он уникален при параллельных
+// запросах и нескольких экземплярах сервера; без БД — случайный суффикс.
+func (e *Env) allocateCalculatorID(ctx context.Context) (string, error) {
+	if e.DB == nil {
+		suffix, err := randomHex(8)
+		if err != nil {
+			return "", err
+		}
+		return "calc_" + suffix, nil
+	}
+
+	var id string
+	if err := e.DB.QueryRowContext(ctx, `SELECT 'calc_' || nextval('calculator_id_seq')`).Scan(&id); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// insertCalculator сохраняет новый калькулятор в БД.
+func (e *Env) insertCalculator(ctx context.Context, c *domain.Calculator) error {
+	_, err := e.DB.ExecContext(ctx, `
+INSERT INTO calculators (
+    id, name, type, owner_id, org_id, status, created_at,
+    public_token, public_path, calc_count, preview_token
+) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+`,
+		c.ID,
+		c.Name,
+		string(c.Type),
+		c.OwnerID,
+		c.OrgID,
+		c.Status,
+		c.CreatedAt,
+		c.PublicToken,
+		c.PublicPath,
+		c.CalcCount,
+		c.PreviewToken,
+	)
+	return err
+}
+
+// GetCalculatorByID достаёт калькулятор из БД (nil, если нет или он в корзине).
+func (e *Env) GetCalculatorByID(ctx context.Context, id string) (*domain.Calculator, error) {
+	if e.DB == nil {
+		return nil, errors.New("db is nil")
+	}
+
+	c, err := scanCalculator(e.DB.QueryRowContext(ctx,
+		`SELECT `+calculatorColumns+` FROM calculators WHERE id = $1 AND deleted_at IS NULL`,
+		id,
+	))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return c, nil
+}
+
+// findPublicCalculator — калькулятор по публичной ссылке /p/{ownerId}/{token} (nil, если нет).
+// Без БД ищем в in-memory списке.
+func (e *Env) findPublicCalculator(ctx context.Context, ownerID, token string) (*domain.Calculator, error) {
+	if e.DB == nil {
+		for _, c := range e.Calculators {
+			if c.OwnerID == ownerID && c.PublicToken == token {
+				return c, nil
+			}
+		}
+		return nil, nil
+	}
+
+	c, err := scanCalculator(e.DB.QueryRowContext(ctx,
+		`SELECT `+calculatorColumns+` FROM calculators WHERE owner_id = $1 AND public_token = $2 AND deleted_at IS NULL`,
+		ownerID, token,
+	))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return c, nil
+}
+
+// calculatorRole — калькулятор и роль пользователя в его организации.
+// Если калькулятора нет или пользователь не участник — (nil, "", nil).
+func (e *Env) calculatorRole(ctx context.Context, u *domain.User, calcID string) (*domain.Calculator, domain.OrgRole, error) {
+	c, err := e.GetCalculatorByID(ctx, calcID)
+	if err != nil || c == nil {
+		return nil, "", err
+	}
+
+	role, err := e.orgRole(ctx, u, c.OrgID)
+	if err != nil {
+		return nil, "", err
+	}
+	if role == "" {
+		return nil, "", nil
+	}
+	return c, role, nil
+}
+
+// requireCalculator — калькулятор, на который у пользователя есть роль не ниже min.
+// Сам отвечает клиенту: 404, если калькулятор не найден или недоступен, 403 — если роли мало.
+func (e *Env) requireCalculator(w http.ResponseWriter, r *http.Request, u *domain.User, calcID string, min domain.OrgRole) *domain.Calculator {
+	if calcID == "" {
+		http.Error(w, "calculatorId is required", http.StatusBadRequest)
+		return nil
+	}
+
+	c, role, err := e.calculatorRole(r.Context(), u, calcID)
+	if err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return nil
+	}
+	if c == nil {
+		http.Error(w, "calculator not found", http.StatusNotFound)
+		return nil
+	}
+	if !role.AtLeast(min) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return nil
+	}
+	return c
+}
@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"saas-calc-backend/internal/domain"
+)
+
+const (
+	defaultCalculatorsLimit = 50
+	maxCalculatorsLimit     = 200
+)
+
+// calculatorSort — порядок списка калькуляторов. Ко всем порядкам добавляется id,
+// чтобы курсор однозначно указывал место в списке.
+type calculatorSort struct {
+	column string // колонка сортировки
+	cast   string // тип значения курсора в SQL
+	desc   bool
+}
+
+var calculatorSorts = map[string]calculatorSort{
+	"created_desc": {column: "created_at", cast: "timestamptz", desc: true},
+	"created_asc":  {column: "created_at", cast: "timestamptz"},
+	"name_asc":     {column: "name", cast: "text"},
+	"name_desc":    {column: "name", cast: "text", desc: true},
+	"calcs_desc":   {column: "calc_count", cast: "integer", desc: true},
+}
+
+// value — значение колонки сортировки у калькулятора (для следующего курсора).
+func (s calculatorSort) value(c *domain.Calculator) string {
+	switch s.column {
+	case "name":
+		return c.Name
+	case "calc_count":
+		return strconv.Itoa(c.CalcCount)
+	default:
+		return c.CreatedAt.UTC().Format(time.RFC3339Nano)
+	}
+}
+
+// parse разбирает значение курсора в тип колонки сортировки: испорченный курсор — 400, а не ошибка БД.
+func (s calculatorSort) parse(v string) (interface{}, error) {
+	switch s.cast {
+	case "timestamptz":
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return nil, errors.New("bad cursor")
+		}
+		return t, nil
+	case "integer":
+		n, err := strconv.ParseInt(v, 10, 32)
+		if err != nil {
+			return nil, errors.New("bad cursor")
+		}
+		return n, nil
+	}
+	return v, nil
+}
+
+// calculatorCursor — позиция после последнего калькулятора страницы.
+type calculatorCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    string `json:"id"`
+
+	value interface{} // Value, разобранное по типу колонки сортировки (см. calculatorSort.parse)
+}
+
+func encodeCalculatorCursor(cur calculatorCursor) string {
+	raw, _ := json.Marshal(cur)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeCalculatorCursor(s string) (*calculatorCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("bad cursor")
+	}
+	var cur calculatorCursor
+	if err := json.Unmarshal(raw, &cur); err != nil || cur.ID == "" {
+		return nil, errors.New("bad cursor")
+	}
+	return &cur, nil
+}
+
+// calculatorQuery — фильтры списка калькуляторов из GET /api/calculators.
+type calculatorQuery struct {
+	OrgID    string
+	Q        string
+	Type     string
+	Status   string
+	Tag      string
+	Owner    string
+	FolderID string // "none" — калькуляторы без папки
+	SortKey  string
+	Cursor   *calculatorCursor
+	Limit    int
+}
+
+func parseCalculatorQuery(v url.Values) (*calculatorQuery, error) {
+	q := &calculatorQuery{
+		OrgID:    v.Get("orgId"),
+		Q:        strings.TrimSpace(v.Get("q")),
+		Type:     v.Get("type"),
+		Status:   v.Get("status"),
+		Tag:      strings.ToLower(strings.TrimSpace(v.Get("tag"))),
+		Owner:    v.Get("owner"),
+		FolderID: v.Get("folderId"),
+		SortKey:  v.Get("sort"),
+		Limit:    defaultCalculatorsLimit,
+	}
+
+	if q.Type != "" && !domain.ValidCalculatorType(domain.CalculatorType(q.Type)) {
+		return nil, errors.New("unknown calculator type")
+	}
+	if q.Status != "" && !domain.ValidCalculatorStatus(q.Status) {
+		return nil, errors.New("unknown status")
+	}
+	if q.SortKey == "" {
+		q.SortKey = "created_desc"
+	}
+	if _, ok := calculatorSorts[q.SortKey]; !ok {
+		return nil, errors.New("unknown sort")
+	}
+	if s := v.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			return nil, errors.New("bad limit")
+		}
+		if n > maxCalculatorsLimit {
+			n = maxCalculatorsLimit
+		}
+		q.Limit = n
+	}
+	if s := v.Get("cursor"); s != "" {
+		cur, err := decodeCalculatorCursor(s)
+		if err != nil {
+			return nil, err
+		}
+		// курсор от другого порядка сортировки не подходит
+		if cur.Sort != q.SortKey {
+			return nil, errors.New("cursor does not match sort")
+		}
+		if cur.value, err = calculatorSorts[q.SortKey].parse(cur.Value); err != nil {
+			return nil, err
+		}
+		q.Cursor = cur
+	}
+	return q, nil
+}
+
+// sqlArgs собирает позиционные параметры $1, $2, ...
+type sqlArgs []interface{}
+
+func (a *sqlArgs) add(v interface{}) string {
+	*a = append(*a, v)
+	return "$" + strconv.Itoa(len(*a))
+}
+
+// calculatorsPage — страница списка: калькуляторы, сколько всего подходит под фильтры и курсор следующей страницы.
+type calculatorsPage struct {
+	Items      []*domain.Calculator `json:"items"`
+	Total      int                  `json:"total"`
+	NextCursor string               `json:"nextCursor,omitempty"`
+}
+
+// queryCalculators выполняет фильтры, сортировку и пагинацию в SQL.
+// Обычный пользователь видит калькуляторы своих организаций, администратор — все.
+func (e *Env) queryCalculators(ctx context.Context, u *domain.User, q *calculatorQuery) (*calculatorsPage, error) {
+	var args sqlArgs
+	conds := []string{"deleted_at IS NULL"}
+
+	if u.Role != domain.RoleAdmin {
+		conds = append(conds, "org_id IN (SELECT org_id FROM org_members WHERE user_id = "+args.add(u.ID)+")")
+	}
+	if q.OrgID != "" {
+		conds = append(conds, "org_id = "+args.add(q.OrgID))
+	}
+	if q.Q != "" {
+		p := args.add(q.Q)
+		conds = append(conds, "(strpos(lower(name), lower("+p+")) > 0 OR id = "+p+" OR lower(COALESCE(slug, '')) = lower("+p+"))")
+	}
+	if q.Type != "" {
+		conds = append(conds, "type = "+args.add(q.Type))
+	}
+	if q.Status != "" {
+		conds = append(conds, "status = "+args.add(q.Status))
+	}
+	if q.Tag != "" {
+		conds = append(conds, "EXISTS (SELECT 1 FROM calculator_tags t WHERE t.calculator_id = calculators.id AND t.tag = "+args.add(q.Tag)+")")
+	}
+	if q.Owner != "" {
+		conds = append(conds, "owner_id = "+args.add(q.Owner))
+	}
+	if q.FolderID == "none" {
+		conds = append(conds, "folder_id IS NULL")
+	} else if q.FolderID != "" {
+		conds = append(conds, "folder_id = "+args.add(q.FolderID))
+	}
+
+	where := strings.Join(conds, " AND ")
+
+	page := &calculatorsPage{Items: make([]*domain.Calculator, 0)}
+	if err := e.DB.QueryRowContext(ctx, `SELECT COUNT(*) FROM calculators WHERE `+where, args...).Scan(&page.Total); err != nil {
+		return nil, err
+	}
+
+	s := calculatorSorts[q.SortKey]
+	dir, cmp := "ASC", ">"
+	if s.desc {
+		dir, cmp = "DESC", "<"
+	}
+	if q.Cursor != nil {
+		where += " AND (" + s.column + ", id) " + cmp + " (" + args.add(q.Cursor.value) + "::" + s.cast + ", " + args.add(q.Cursor.ID) + ")"
+	}
+
+	// берём на одну запись больше — так видно, есть ли следующая страница
+	rows, err := e.DB.QueryContext(ctx, `
+SELECT `+calculatorColumns+`
+FROM calculators
+WHERE `+where+`
+ORDER BY `+s.column+` `+dir+`, id `+dir+`
+LIMIT `+args.add(q.Limit+1), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		c, err := scanCalculator(rows)
+		if err != nil {
+			return nil, err
+		}
+		page.Items = append(page.Items, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(page.Items) > q.Limit {
+		page.Items = page.Items[:q.Limit]
+		last := page.Items[len(page.Items)-1]
+		page.NextCursor = encodeCalculatorCursor(calculatorCursor{Sort: q.SortKey, Value: s.value(last), ID: last.ID})
+	}
+	return page, nil
+}
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"net/url"
+	"testing"
+	"time"
+
+	"saas-calc-backend/internal/domain"
+)
+
+func cursorQuery(sort, cursor string) url.Values {
+	v := url.Values{"cursor": {cursor}}
+	if sort != "" {
+		v.Set("sort", sort)
+	}
+	return v
+}
+
+func TestCalculatorCursorRoundTrip(t *testing.T) {
+	c := &domain.Calculator{
+		ID:        "calc_1",
+		Name:      `Ёлка "под ключ"`,
+		CalcCount: 42,
+		CreatedAt: time.Date(2024, 3, 5, 10, 20, 30, 123456789, time.FixedZone("MSK", 3*3600)),
+	}
+
+	for key, sort := range calculatorSorts {
+		enc := encodeCalculatorCursor(calculatorCursor{Sort: key, Value: sort.value(c), ID: c.ID})
+		q, err := parseCalculatorQuery(cursorQuery(key, enc))
+		if err != nil {
+			t.Errorf("%s: %v", key, err)
+			continue
+		}
+		if q.Cursor == nil || q.Cursor.ID != c.ID {
+			t.Errorf("%s: cursor = %+v", key, q.Cursor)
+			continue
+		}
+
+		switch v := q.Cursor.value.(type) {
+		case time.Time:
+			if sort.cast != "timestamptz" || !v.Equal(c.CreatedAt) {
+				t.Errorf("%s: value = %v, want %v", key, v, c.CreatedAt)
+			}
+		case int64:
+			if sort.cast != "integer" || v != int64(c.CalcCount) {
+				t.Errorf("%s: value = %v, want %d", key, v, c.CalcCount)
+			}
+		case string:
+			if sort.cast != "text" || v != c.Name {
+				t.Errorf("%s: value = %q, want %q", key, v, c.Name)
+			}
+		default:
+			t.Errorf("%s: value has type %T", key, v)
+		}
+	}
+}
+
+func TestCalculatorCursorDefaultSort(t *testing.T) {
+	enc := encodeCalculatorCursor(calculatorCursor{Sort: "created_desc", Value: time.Now().UTC().Format(time.RFC3339Nano), ID: "calc_1"})
+	if _, err := parseCalculatorQuery(cursorQuery("", enc)); err != nil {
+		t.Errorf("cursor of the default sort: %v", err)
+	}
+}
+
+// Испорченный курсор должен давать ошибку разбора (400), а не доходить до SQL.
+func TestCalculatorCursorRejected(t *testing.T) {
+	b64 := base64.RawURLEncoding.EncodeToString
+	cur := func(sort, value string) string {
+		return encodeCalculatorCursor(calculatorCursor{Sort: sort, Value: value, ID: "calc_1"})
+	}
+
+	tests := []struct {
+		name   string
+		sort   string
+		cursor string
+	}{
+		{"not base64", "created_desc", "!!!"},
+		{"padded base64", "created_desc", base64.URLEncoding.EncodeToString([]byte(`{"s":"created_desc","v":"x","id":"calc_1"}`))},
+		{"not json", "created_desc", b64([]byte("hello"))},
+		{"json array", "created_desc", b64([]byte(`["created_desc"]`))},
+		{"no id", "created_desc", b64([]byte(`{"s":"created_desc","v":"2024-01-01T00:00:00Z"}`))},
+		{"other sort", "name_asc", cur("created_desc", "2024-01-01T00:00:00Z")},
+		{"other sort, same column", "created_asc", cur("created_desc", "2024-01-01T00:00:00Z")},
+		{"time is not a time", "created_desc", cur("created_desc", "yesterday")},
+		{"time without zone", "created_asc", cur("created_asc", "2024-01-01 00:00:00")},
+		{"count is text", "calcs_desc", cur("calcs_desc", "many")},
+		{"count is fractional", "calcs_desc", cur("calcs_desc", "1.5")},
+		{"count overflows integer", "calcs_desc", cur("calcs_desc", "99999999999")},
+		{"count is empty", "calcs_desc", cur("calcs_desc", "")},
+	}
+	for _, tt := range tests {
+		q, err := parseCalculatorQuery(cursorQuery(tt.sort, tt.cursor))
+		if err == nil {
+			t.Errorf("%s: accepted, cursor = %+v", tt.name, q.Cursor)
+		}
+	}
+}
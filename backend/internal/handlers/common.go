@@ -9,6 +9,8 @@ import (
     "time"
 
     "saas-calc-backend/internal/domain"
+    "saas-calc-backend/internal/mail"
+    "saas-calc-backend/internal/ratelimit"
 )
 
 
@@ -16,21 +18,33 @@ import (
 type Env struct {
     DB *sql.DB
 
-    LayeredConfig  *domain.LayeredConfig
-    DistanceConfig *domain.DistanceConfig
-
     UploadDir string
     Plans     []domain.Plan
 
     // старые in-memory поля можно оставить, но не использовать как источник истины
     Users       []*domain.User
     Calculators []*domain.Calculator
-    NextCalcID  int
 
     // базовые URL для сервисов карт
     OSRMBaseURL      string
     NominatimBaseURL string
     TelegramBotToken string
+
+    // ключ для подписи cookie сессий
+    SessionSecret []byte
+
+    // отправка писем (подтверждение email и т.п.) и адрес сервера для ссылок в них
+    Mailer        mail.Sender
+    PublicBaseURL string
+
+    // задержка входа по IP после серии неудачных попыток (nil — без ограничения)
+    LoginBackoff *ratelimit.Backoff
+
+    // частота писем по запросу пользователя (повторное подтверждение email и т.п.) на адресата (nil — без ограничения)
+    MailLimit *ratelimit.Limiter
+
+    // сколько удалённый калькулятор лежит в корзине до окончательного удаления (0 — domain.DefaultTrashRetention)
+    TrashRetention time.Duration
 }
 
 // writeJSON — простой helper для JSON-ответов
@@ -41,6 +55,18 @@ func (e *Env) writeJSON(w http.ResponseWriter, v interface{}) {
         http.Error(w, err.Error(), http.StatusInternalServerError)
     }
 }
+// allowMail — можно ли сейчас отправить письмо по ключу (id пользователя, email); иначе отвечает 429.
+func (e *Env) allowMail(w http.ResponseWriter, key string) bool {
+    if e.MailLimit == nil {
+        return true
+    }
+    if ok, wait := e.MailLimit.Allow(key); !ok {
+        ratelimit.TooManyRequests(w, wait)
+        return false
+    }
+    return true
+}
+
 // IncrementCalcCount увеличивает calc_count для указанного калькулятора
 // и в БД, и (по возможности) в in-memory кэше.
 func (e *Env) IncrementCalcCount(calcID string) {
@@ -53,10 +79,11 @@ func (e *Env) IncrementCalcCount(calcID string) {
         ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
         defer cancel()
 
+        // калькулятор в корзине не считает
         _, err := e.DB.ExecContext(ctx,
             `UPDATE calculators
                SET calc_count = calc_count + 1
-             WHERE id = $1`,
+             WHERE id = $1 AND deleted_at IS NULL`,
             calcID,
         )
         if err != nil {
@@ -88,51 +115,3 @@ func WithCORS(next http.Handler) http.Handler {
         next.ServeHTTP(w, r)
     })
 }
-
-// CurrentUser — теперь берём пользователя из БД по ?as=admin|user1|user2
-// (то есть выбор в шапке <select id="user-switch"> остаётся тем же, просто источник истины — БД).
-func (e *Env) CurrentUser(r *http.Request) *domain.User {
-    as := r.URL.Query().Get("as")
-    if as == "" {
-        as = "admin"
-    }
-
-    // сначала пытаемся достать пользователя из БД
-    if e.DB != nil {
-        row := e.DB.QueryRow(`
-SELECT id, email, name, role, plan_id, plan_active, created_at
-FROM users
-WHERE id = $1
-`, as)
-
-        var u domain.User
-        var planID string
-        var planActive bool
-
-        if err := row.Scan(
-            &u.ID,
-            &u.Email,
-            &u.Name,
-            &u.Role,
-            &planID,
-            &planActive,
-            &u.CreatedAt,
-        ); err == nil {
-            u.PlanID = planID
-            u.PlanActive = planActive
-            return &u
-        }
-    }
-
-    // фолбэк на старый in-memory список, если что-то не так с БД
-    for _, u := range e.Users {
-        if u.ID == as {
-            return u
-        }
-    }
-    if len(e.Users) > 0 {
-        return e.Users[0]
-    }
-    return nil
-}
-
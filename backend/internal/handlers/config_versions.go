@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"saas-calc-backend/internal/domain"
+)
+
+type configDiffResponse struct {
+	From    int                   `json:"from"`
+	To      int                   `json:"to"`
+	Changes []domain.ConfigChange `json:"changes"`
+}
+
+// handleConfigVersions обслуживает /api/calculators/{id}/versions/...
+//
+// GET  .../versions                  -> история конфига (viewer)
+// GET  .../versions/diff?from=1&to=3 -> отличия между версиями; без to — с текущей (viewer)
+// GET  .../versions/{v}              -> версия вместе с конфигом (viewer)
+// POST .../versions/{v}/restore      -> сделать версию текущей; сохраняется как новая версия (editor)
+func (e *Env) handleConfigVersions(w http.ResponseWriter, r *http.Request, u *domain.User, id string, parts []string) {
+	need := domain.OrgRoleViewer
+	if r.Method == http.MethodPost {
+		need = domain.OrgRoleEditor
+	}
+	c := e.requireCalculator(w, r, u, id, need)
+	if c == nil {
+		return
+	}
+
+	switch {
+	case len(parts) == 0:
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		items, err := e.listConfigVersions(r.Context(), c.ID)
+		if err != nil {
+			http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		e.writeJSON(w, map[string]interface{}{"items": items})
+
+	case len(parts) == 1 && parts[0] == "diff":
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		e.handleConfigDiff(w, r, c)
+
+	case len(parts) == 1:
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		v := e.requireConfigVersion(w, r, c.ID, parts[0])
+		if v == nil {
+			return
+		}
+		e.writeJSON(w, v)
+
+	case len(parts) == 2 && parts[1] == "restore":
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		e.handleConfigRestore(w, r, u, c, parts[0])
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (e *Env) handleConfigDiff(w http.ResponseWriter, r *http.Request, c *domain.Calculator) {
+	q := r.URL.Query()
+
+	from := e.requireConfigVersion(w, r, c.ID, q.Get("from"))
+	if from == nil {
+		return
+	}
+
+	toParam := q.Get("to")
+	if toParam == "" {
+		items, err := e.listConfigVersions(r.Context(), c.ID)
+		if err != nil {
+			http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// список отсортирован от новых к старым, текущая — первая
+		toParam = strconv.Itoa(items[0].Version)
+	}
+	to := e.requireConfigVersion(w, r, c.ID, toParam)
+	if to == nil {
+		return
+	}
+
+	changes, err := domain.DiffConfigs(from.Config, to.Config)
+	if err != nil {
+		http.Error(w, "failed to diff configs: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	e.writeJSON(w, configDiffResponse{From: from.Version, To: to.Version, Changes: changes})
+}
+
+func (e *Env) handleConfigRestore(w http.ResponseWriter, r *http.Request, u *domain.User, c *domain.Calculator, versionParam string) {
+	v := e.requireConfigVersion(w, r, c.ID, versionParam)
+	if v == nil {
+		return
+	}
+	if v.Current {
+		http.Error(w, "version is already current", http.StatusConflict)
+		return
+	}
+
+	// история неизменяема: откат — это новая версия с содержимым старой
+	version, err := e.saveCalculatorConfigVersion(r.Context(), c.ID, u.ID, v.Config, v.Version)
+	if err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	restored, err := e.getConfigVersion(r.Context(), c.ID, version)
+	if err != nil || restored == nil {
+		http.Error(w, "failed to load restored version", http.StatusInternalServerError)
+		return
+	}
+	e.writeJSON(w, restored)
+}
+
+// requireConfigVersion — версия конфига по номеру из запроса; сам отвечает 400/404.
+func (e *Env) requireConfigVersion(w http.ResponseWriter, r *http.Request, calcID, param string) *domain.ConfigVersion {
+	n, err := strconv.Atoi(param)
+	if err != nil || n <= 0 {
+		http.Error(w, "bad version", http.StatusBadRequest)
+		return nil
+	}
+
+	v, err := e.getConfigVersion(r.Context(), calcID, n)
+	if err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return nil
+	}
+	if v == nil {
+		http.Error(w, "version not found", http.StatusNotFound)
+		return nil
+	}
+	return v
+}
@@ -20,50 +20,60 @@ type DistanceConfigDTO struct {
 	VehicleCoefs   map[string]float64 `json:"vehicleCoefs"`
 }
 
-// GET/POST /api/distance/config
+// GET/POST /api/distance/config?calculatorId=...
+// Конфиг свой у каждого калькулятора; читать — viewer, сохранять — editor и выше.
 func (e *Env) HandleDistanceConfig(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case http.MethodGet:
-		cfg := e.DistanceConfig
-		if cfg == nil {
-			cfg = domain.NewDefaultDistanceConfig()
-			e.DistanceConfig = cfg
-		}
-		e.writeJSON(w, cfg)
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
 
-	case http.MethodPost:
-		defer r.Body.Close()
+	u := e.requireUser(w, r)
+	if u == nil {
+		return
+	}
 
-		var req DistanceConfigDTO
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "bad json: "+err.Error(), http.StatusBadRequest)
-			return
-		}
+	calc := e.requireConfigCalculator(w, r, u, domain.CalculatorTypeDistance)
+	if calc == nil {
+		return
+	}
 
-		if e.DistanceConfig == nil {
-			e.DistanceConfig = domain.NewDefaultDistanceConfig()
-		}
-		cfg := e.DistanceConfig
+	cfg, err := e.distanceConfigFor(r.Context(), calc.ID, false)
+	if err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
 
-		cfg.BasePrice = req.BasePrice
-		cfg.PricePerKm = req.PricePerKm
-		cfg.LoadingPrice = req.LoadingPrice
-		cfg.UnloadingPrice = req.UnloadingPrice
+	if r.Method == http.MethodGet {
+		e.writeJSON(w, cfg)
+		return
+	}
 
-		if cfg.VehicleCoefs == nil {
-			cfg.VehicleCoefs = map[string]float64{}
-		}
-		if req.VehicleCoefs != nil {
-			for k, v := range req.VehicleCoefs {
-				cfg.VehicleCoefs[k] = v
-			}
-		}
+	defer r.Body.Close()
 
-		e.writeJSON(w, cfg)
+	var req DistanceConfigDTO
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json: "+err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	default:
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	cfg.BasePrice = req.BasePrice
+	cfg.PricePerKm = req.PricePerKm
+	cfg.LoadingPrice = req.LoadingPrice
+	cfg.UnloadingPrice = req.UnloadingPrice
+
+	if cfg.VehicleCoefs == nil {
+		cfg.VehicleCoefs = map[string]float64{}
+	}
+	for k, v := range req.VehicleCoefs {
+		cfg.VehicleCoefs[k] = v
+	}
+
+	if err := e.saveCalculatorConfig(r.Context(), calc.ID, u.ID, cfg); err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
 	}
+	e.writeJSON(w, cfg)
 }
 
 // --- Расчёт маршрута через Nominatim + OSRM ---
@@ -74,6 +84,7 @@ type DistanceCalcRequest struct {
 	Vehicle      string `json:"vehicle"`
 	RoundTrip    bool   `json:"roundTrip"`
 	CalculatorID string `json:"calculatorId"`
+	PreviewToken string `json:"previewToken"` // со страницы предпросмотра: считать по черновику
 }
 
 type RoutePoint struct {
@@ -223,6 +234,9 @@ func (e *Env) HandleDistanceCalc(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	if !e.checkOptionalAPIKey(w, r) {
+		return
+	}
 	defer r.Body.Close()
 
 	var req DistanceCalcRequest
@@ -236,10 +250,21 @@ func (e *Env) HandleDistanceCalc(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	cfg := e.DistanceConfig
-	if cfg == nil {
-		cfg = domain.NewDefaultDistanceConfig()
-		e.DistanceConfig = cfg
+	if !e.requireCalcTarget(w, r, req.CalculatorID, req.PreviewToken, domain.CalculatorTypeDistance) {
+		return
+	}
+
+	// тарифы конкретного калькулятора (без calculatorId — стартовые):
+	// посетителям — опубликованные, на странице предпросмотра — из черновика
+	published, err := e.publishedConfigRequested(r.Context(), req.CalculatorID, req.PreviewToken)
+	if err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	cfg, err := e.distanceConfigFor(r.Context(), req.CalculatorID, published)
+	if err != nil {
+		configLoadFailed(w, err)
+		return
 	}
 
 	lat1, lon1, err := e.geocodeAddress(req.From)
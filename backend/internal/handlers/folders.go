@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"saas-calc-backend/internal/domain"
+)
+
+type folderRequest struct {
+	Name string `json:"name"`
+}
+
+// listFolders — папки организации с числом калькуляторов в каждой.
+func (e *Env) listFolders(ctx context.Context, orgID string) ([]*domain.CalculatorFolder, error) {
+	rows, err := e.DB.QueryContext(ctx, `
+SELECT f.id, f.org_id, f.name, f.created_at,
+       (SELECT COUNT(*) FROM calculators c WHERE c.folder_id = f.id AND c.deleted_at IS NULL)
+FROM calculator_folders f
+WHERE f.org_id = $1
+ORDER BY lower(f.name)
+`, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]*domain.CalculatorFolder, 0)
+	for rows.Next() {
+		var f domain.CalculatorFolder
+		if err := rows.Scan(&f.ID, &f.OrgID, &f.Name, &f.CreatedAt, &f.Calculators); err != nil {
+			return nil, err
+		}
+		items = append(items, &f)
+	}
+	return items, rows.Err()
+}
+
+// getFolder — папка по id (nil, если нет).
+func (e *Env) getFolder(ctx context.Context, id string) (*domain.CalculatorFolder, error) {
+	var f domain.CalculatorFolder
+	err := e.DB.QueryRowContext(ctx,
+		`SELECT id, org_id, name, created_at FROM calculator_folders WHERE id = $1`,
+		id,
+	).Scan(&f.ID, &f.OrgID, &f.Name, &f.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &f, nil
+}
+
+// handleOrgFolders обслуживает /api/orgs/{orgId}/folders/...
+//
+// GET    .../folders      -> папки организации (viewer)
+// POST   .../folders      -> создать папку (editor)
+// PUT    .../folders/{id} -> переименовать (editor)
+// DELETE .../folders/{id} -> удалить; калькуляторы из неё остаются без папки (editor)
+func (e *Env) handleOrgFolders(w http.ResponseWriter, r *http.Request, orgID string, role domain.OrgRole, folderID string) {
+	if r.Method != http.MethodGet && !role.AtLeast(domain.OrgRoleEditor) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	if folderID == "" {
+		switch r.Method {
+		case http.MethodGet:
+			items, err := e.listFolders(r.Context(), orgID)
+			if err != nil {
+				http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			e.writeJSON(w, map[string]interface{}{"items": items})
+		case http.MethodPost:
+			e.handleSaveFolder(w, r, orgID, "")
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	f, err := e.getFolder(r.Context(), folderID)
+	if err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if f == nil || f.OrgID != orgID {
+		http.Error(w, "folder not found", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		e.handleSaveFolder(w, r, orgID, f.ID)
+	case http.MethodDelete:
+		if _, err := e.DB.ExecContext(r.Context(), `DELETE FROM calculator_folders WHERE id = $1`, f.ID); err != nil {
+			http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, cached := range e.Calculators {
+			if cached.FolderID == f.ID {
+				cached.FolderID = ""
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// id пустой — создание, иначе переименование.
+func (e *Env) handleSaveFolder(w http.ResponseWriter, r *http.Request, orgID, id string) {
+	defer r.Body.Close()
+
+	var req folderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+
+	created := id == ""
+	var err error
+	if created {
+		var suffix string
+		suffix, err = randomHex(8)
+		if err != nil {
+			http.Error(w, "failed to generate id: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		id = "fld_" + suffix
+		_, err = e.DB.ExecContext(r.Context(),
+			`INSERT INTO calculator_folders (id, org_id, name) VALUES ($1, $2, $3)`,
+			id, orgID, name,
+		)
+	} else {
+		_, err = e.DB.ExecContext(r.Context(),
+			`UPDATE calculator_folders SET name = $1 WHERE id = $2`,
+			name, id,
+		)
+	}
+	if err != nil {
+		if isUniqueViolation(err) {
+			http.Error(w, "folder with this name already exists", http.StatusConflict)
+			return
+		}
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	f, err := e.getFolder(r.Context(), id)
+	if err != nil || f == nil {
+		http.Error(w, "failed to load folder", http.StatusInternalServerError)
+		return
+	}
+	if created {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(f)
+		return
+	}
+	e.writeJSON(w, f)
+}
+
+// --- GET /api/orgs/{orgId}/tags ---
+
+// Метки калькуляторов организации с числом калькуляторов — для фильтра в списке.
+func (e *Env) handleOrgTags(w http.ResponseWriter, r *http.Request, orgID string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	rows, err := e.DB.QueryContext(r.Context(), `
+SELECT t.tag, COUNT(*)
+FROM calculator_tags t
+JOIN calculators c ON c.id = t.calculator_id
+WHERE c.org_id = $1 AND c.deleted_at IS NULL
+GROUP BY t.tag
+ORDER BY t.tag
+`, orgID)
+	if err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	items := make([]domain.TagCount, 0)
+	for rows.Next() {
+		var tc domain.TagCount
+		if err := rows.Scan(&tc.Tag, &tc.Count); err != nil {
+			http.Error(w, "db scan error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		items = append(items, tc)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	e.writeJSON(w, map[string]interface{}{"items": items})
+}
+
+// setCalculatorTags заменяет метки калькулятора (tags уже нормализованы).
+func setCalculatorTags(ctx context.Context, tx *sql.Tx, calcID string, tags []string) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM calculator_tags WHERE calculator_id = $1`, calcID); err != nil {
+		return err
+	}
+	for _, t := range tags {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO calculator_tags (calculator_id, tag) VALUES ($1, $2)`,
+			calcID, t,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
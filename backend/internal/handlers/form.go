@@ -0,0 +1,450 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"saas-calc-backend/internal/domain"
+)
+
+// --- Конфиг калькулятора-формы ---
+
+// GET/POST /api/form/config?calculatorId=...
+// Конфиг свой у каждого калькулятора; читать — viewer, сохранять — editor и выше.
+func (e *Env) HandleFormConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	u := e.requireUser(w, r)
+	if u == nil {
+		return
+	}
+
+	calc := e.requireConfigCalculator(w, r, u, domain.CalculatorTypeForm)
+	if calc == nil {
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		cfg, err := e.formConfigFor(r.Context(), calc.ID, false)
+		if err != nil {
+			http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		e.writeJSON(w, cfg)
+		return
+	}
+
+	defer r.Body.Close()
+
+	var req domain.FormConfig
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	for i := range req.Fields {
+		f := &req.Fields[i]
+		f.ID = strings.TrimSpace(f.ID)
+		f.Label = strings.TrimSpace(f.Label)
+		for j := range f.Options {
+			f.Options[j].ID = strings.TrimSpace(f.Options[j].ID)
+		}
+	}
+	if err := req.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := e.saveCalculatorConfig(r.Context(), calc.ID, u.ID, &req); err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	e.writeJSON(w, &req)
+}
+
+// --- Расчёт стоимости по форме ---
+
+type FormCalcRequest struct {
+	// id поля -> значение: число (number, slider), id варианта (radio),
+	// список id вариантов (multiselect) или строка (text); нет — пусто или значение по умолчанию
+	Values       map[string]json.RawMessage `json:"values"`
+	CalculatorID string                     `json:"calculatorId"`
+	PreviewToken string                     `json:"previewToken"` // со страницы предпросмотра: считать по черновику
+}
+
+// Виды строк расчёта.
+const (
+	formItemBase       = "base"       // базовая цена
+	formItemPrice      = "price"      // прибавка от поля или варианта
+	formItemMultiplier = "multiplier" // наценка/скидка от множителя
+	formItemMinimum    = "minimum"    // доплата до минимального заказа
+)
+
+// FormLineItem — строка расчёта: откуда взялась сумма.
+type FormLineItem struct {
+	Kind    string  `json:"kind"`
+	FieldID string  `json:"fieldId,omitempty"`
+	Label   string  `json:"label"`
+	Detail  string  `json:"detail,omitempty"` // 50 м² × 60 ₽, название варианта, ×1.5
+	Amount  float64 `json:"amount"`
+}
+
+type FormCalcResponse struct {
+	Items    []FormLineItem `json:"items"`
+	Subtotal float64        `json:"subtotal"` // сумма прибавок до множителей
+	Total    float64        `json:"total"`
+	Visible  []string       `json:"visible"` // id полей, которые видны при этих ответах
+}
+
+// formValue — ответ посетителя на одно поле после проверки.
+type formValue struct {
+	num      float64
+	text     string
+	selected []*domain.FormOption // в порядке вариантов в конфиге
+}
+
+// filled — заполнено ли поле. Число 0 считается пустым: у числовых полей всегда есть значение
+// (хотя бы default), поэтому «заполнено» для них значит «не 0» — и для цены, и для условий, и для Required.
+func (v *formValue) filled(f *domain.FormField) bool {
+	switch {
+	case v == nil:
+		return false
+	case f.Numeric():
+		return v.num != 0
+	case f.Kind == domain.FormFieldText:
+		return v.text != ""
+	}
+	return len(v.selected) > 0
+}
+
+func (v *formValue) has(optionID string) bool {
+	if v == nil {
+		return false
+	}
+	for _, o := range v.selected {
+		if o.ID == optionID {
+			return true
+		}
+	}
+	return false
+}
+
+// formConditionHolds — выполнено ли условие видимости; v == nil — поле скрыто (считается пустым).
+func formConditionHolds(cond domain.FormCondition, f *domain.FormField, v *formValue) bool {
+	var num float64
+	if v != nil {
+		num = v.num
+	}
+	switch cond.Op {
+	case domain.FormCondFilled:
+		return v.filled(f)
+	case domain.FormCondEmpty:
+		return !v.filled(f)
+	case domain.FormCondEq:
+		return v != nil && num == cond.Value
+	case domain.FormCondNe:
+		return v == nil || num != cond.Value
+	case domain.FormCondGt:
+		return v != nil && num > cond.Value
+	case domain.FormCondGte:
+		return v != nil && num >= cond.Value
+	case domain.FormCondLt:
+		return v != nil && num < cond.Value
+	case domain.FormCondLte:
+		return v != nil && num <= cond.Value
+	case domain.FormCondSelected:
+		return v.has(cond.Option)
+	case domain.FormCondNotSelected:
+		return !v.has(cond.Option)
+	}
+	return false
+}
+
+// parseFormValue разбирает и проверяет ответ на поле; raw == nil — ответа нет.
+func parseFormValue(f *domain.FormField, raw json.RawMessage) (*formValue, error) {
+	v := &formValue{}
+	if len(raw) == 0 || string(raw) == "null" {
+		raw = nil
+	}
+
+	switch f.Kind {
+	case domain.FormFieldNumber, domain.FormFieldSlider:
+		v.num = f.Default
+		if raw != nil {
+			if err := json.Unmarshal(raw, &v.num); err != nil {
+				return nil, fmt.Errorf("%s: expected a number", f.ID)
+			}
+		}
+		if f.Min != nil && v.num < *f.Min {
+			return nil, fmt.Errorf("%s: must be at least %s", f.ID, formatFormNumber(*f.Min))
+		}
+		if f.Max != nil && v.num > *f.Max {
+			return nil, fmt.Errorf("%s: must be at most %s", f.ID, formatFormNumber(*f.Max))
+		}
+		if !domain.OnStep(v.num, f.Min, f.Step) {
+			return nil, fmt.Errorf("%s: must be min plus a multiple of step %s", f.ID, formatFormNumber(f.Step))
+		}
+
+	case domain.FormFieldText:
+		if raw != nil {
+			if err := json.Unmarshal(raw, &v.text); err != nil {
+				return nil, fmt.Errorf("%s: expected a string", f.ID)
+			}
+		}
+		v.text = strings.TrimSpace(v.text)
+		if utf8.RuneCountInString(v.text) > domain.FormMaxTextLen {
+			return nil, fmt.Errorf("%s: at most %d characters", f.ID, domain.FormMaxTextLen)
+		}
+
+	case domain.FormFieldRadio:
+		var id string
+		if raw != nil {
+			if err := json.Unmarshal(raw, &id); err != nil {
+				return nil, fmt.Errorf("%s: expected an option id", f.ID)
+			}
+		}
+		if id != "" {
+			o := f.FindOption(id)
+			if o == nil {
+				return nil, fmt.Errorf("%s: unknown option %s", f.ID, id)
+			}
+			v.selected = []*domain.FormOption{o}
+		}
+
+	case domain.FormFieldMultiselect:
+		var ids []string
+		if raw != nil {
+			if err := json.Unmarshal(raw, &ids); err != nil {
+				return nil, fmt.Errorf("%s: expected a list of option ids", f.ID)
+			}
+		}
+		chosen := map[string]bool{}
+		for _, id := range ids {
+			if f.FindOption(id) == nil {
+				return nil, fmt.Errorf("%s: unknown option %s", f.ID, id)
+			}
+			chosen[id] = true
+		}
+		for i := range f.Options {
+			if chosen[f.Options[i].ID] {
+				v.selected = append(v.selected, &f.Options[i])
+			}
+		}
+	}
+
+	if f.Required && !v.filled(f) {
+		if f.Numeric() {
+			return nil, fmt.Errorf("%s: required, must not be 0", f.ID)
+		}
+		return nil, fmt.Errorf("%s: required", f.ID)
+	}
+	return v, nil
+}
+
+// formMultiplier — множитель, который применится к сумме после всех прибавок.
+type formMultiplier struct {
+	fieldID, label, detail string
+	factor                 float64
+}
+
+// calcForm считает стоимость по ответам посетителя: поля проходятся по порядку, скрытые
+// условиями видимости пропускаются (их ответы игнорируются), сначала складываются прибавки,
+// затем по очереди применяются множители, в конце — доплата до минимального заказа.
+func calcForm(cfg *domain.FormConfig, raw map[string]json.RawMessage) (*FormCalcResponse, map[string]*formValue, error) {
+	for id := range raw {
+		if cfg.FindField(id) == nil {
+			return nil, nil, fmt.Errorf("unknown field %s", id)
+		}
+	}
+
+	resp := &FormCalcResponse{Items: []FormLineItem{}, Visible: []string{}}
+	values := map[string]*formValue{}
+	var multipliers []formMultiplier
+
+	if cfg.BasePrice > 0 {
+		label := cfg.BaseLabel
+		if label == "" {
+			label = "Базовая стоимость"
+		}
+		resp.Items = append(resp.Items, FormLineItem{Kind: formItemBase, Label: label, Amount: cfg.BasePrice})
+	}
+
+	for i := range cfg.Fields {
+		f := &cfg.Fields[i]
+
+		visible := true
+		for _, cond := range f.VisibleIf {
+			if !formConditionHolds(cond, cfg.FindField(cond.Field), values[cond.Field]) {
+				visible = false
+				break
+			}
+		}
+		if !visible {
+			continue
+		}
+		resp.Visible = append(resp.Visible, f.ID)
+
+		v, err := parseFormValue(f, raw[f.ID])
+		if err != nil {
+			return nil, nil, err
+		}
+		values[f.ID] = v
+		if !v.filled(f) {
+			continue
+		}
+
+		label := f.Label
+		if label == "" {
+			label = f.ID
+		}
+		if f.Numeric() && f.UnitPrice > 0 {
+			detail := formatFormNumber(v.num)
+			if f.Unit != "" {
+				detail += " " + f.Unit
+			}
+			resp.Items = append(resp.Items, FormLineItem{
+				Kind:    formItemPrice,
+				FieldID: f.ID,
+				Label:   label,
+				Detail:  detail + " × " + formatFormNumber(f.UnitPrice) + " ₽",
+				Amount:  v.num * f.UnitPrice,
+			})
+		}
+		if f.Price > 0 {
+			resp.Items = append(resp.Items, FormLineItem{Kind: formItemPrice, FieldID: f.ID, Label: label, Amount: f.Price})
+		}
+		if f.Multiplier > 0 && f.Multiplier != 1 {
+			multipliers = append(multipliers, formMultiplier{fieldID: f.ID, label: label, factor: f.Multiplier})
+		}
+		for _, o := range v.selected {
+			if o.Price > 0 {
+				resp.Items = append(resp.Items, FormLineItem{Kind: formItemPrice, FieldID: f.ID, Label: label, Detail: o.Label, Amount: o.Price})
+			}
+			if o.Multiplier > 0 && o.Multiplier != 1 {
+				multipliers = append(multipliers, formMultiplier{fieldID: f.ID, label: label, detail: o.Label, factor: o.Multiplier})
+			}
+		}
+	}
+
+	for _, it := range resp.Items {
+		resp.Subtotal += it.Amount
+	}
+
+	total := resp.Subtotal
+	for _, m := range multipliers {
+		delta := total * (m.factor - 1)
+		detail := "×" + formatFormNumber(m.factor)
+		if m.detail != "" {
+			detail = m.detail + " " + detail
+		}
+		resp.Items = append(resp.Items, FormLineItem{Kind: formItemMultiplier, FieldID: m.fieldID, Label: m.label, Detail: detail, Amount: delta})
+		total += delta
+	}
+
+	if cfg.MinTotal > 0 && total < cfg.MinTotal {
+		resp.Items = append(resp.Items, FormLineItem{
+			Kind:   formItemMinimum,
+			Label:  "Доплата до минимального заказа",
+			Detail: formatFormNumber(cfg.MinTotal) + " ₽",
+			Amount: cfg.MinTotal - total,
+		})
+		total = cfg.MinTotal
+	}
+
+	// без max посетитель может прислать 1e308 — сумма уйдёт в +Inf, и JSON её не закодирует
+	if !finite(total) || !finite(resp.Subtotal) {
+		return nil, nil, errors.New("total is too large")
+	}
+	for i := range resp.Items {
+		if !finite(resp.Items[i].Amount) {
+			return nil, nil, fmt.Errorf("%s: amount is too large", resp.Items[i].FieldID)
+		}
+		resp.Items[i].Amount = roundMoney(resp.Items[i].Amount)
+	}
+	resp.Subtotal = roundMoney(resp.Subtotal)
+	resp.Total = roundMoney(total)
+	return resp, values, nil
+}
+
+func finite(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
+
+func roundMoney(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+func formatFormNumber(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// POST /api/form/calc
+// Сумму считает только сервер по опубликованному конфигу: цены из запроса не принимаются.
+func (e *Env) HandleFormCalc(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !e.checkOptionalAPIKey(w, r) {
+		return
+	}
+	defer r.Body.Close()
+
+	var req FormCalcRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !e.requireCalcTarget(w, r, req.CalculatorID, req.PreviewToken, domain.CalculatorTypeForm) {
+		return
+	}
+
+	// посетителям — опубликованный конфиг, на странице предпросмотра — черновик
+	published, err := e.publishedConfigRequested(r.Context(), req.CalculatorID, req.PreviewToken)
+	if err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	cfg, err := e.formConfigFor(r.Context(), req.CalculatorID, published)
+	if err != nil {
+		configLoadFailed(w, err)
+		return
+	}
+
+	// конфиг проверен при сохранении, но шаблон или импорт могли принести и старый
+	if err := cfg.Validate(); err != nil {
+		http.Error(w, "calculator config is invalid: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	resp, values, err := calcForm(cfg, req.Values)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// инкрементируем счётчик расчётов и уведомляем владельца, если передан calculatorId
+	if req.CalculatorID != "" {
+		e.IncrementCalcCount(req.CalculatorID)
+
+		// текстовые ответы (комментарии) владельцу важнее строк расчёта — шлём их тоже
+		var notes []string
+		for _, f := range cfg.Fields {
+			if v := values[f.ID]; f.Kind == domain.FormFieldText && v != nil && v.text != "" {
+				notes = append(notes, f.Label+": "+v.text)
+			}
+		}
+		e.NotifyTelegramFormCalc(r.Context(), req.CalculatorID, resp.Items, notes, resp.Total)
+	}
+
+	e.writeJSON(w, resp)
+}
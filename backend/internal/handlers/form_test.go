@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"saas-calc-backend/internal/domain"
+)
+
+// chainFormConfig — цепочка видимости: этаж виден при доставке, лифт — при этаже выше 3-го.
+func chainFormConfig() *domain.FormConfig {
+	return &domain.FormConfig{Fields: []domain.FormField{
+		{ID: "delivery", Kind: domain.FormFieldRadio, Options: []domain.FormOption{
+			{ID: "yes", Price: 500},
+			{ID: "no"},
+		}},
+		{ID: "floor", Kind: domain.FormFieldNumber, UnitPrice: 100,
+			VisibleIf: []domain.FormCondition{{Field: "delivery", Op: domain.FormCondSelected, Option: "yes"}}},
+		{ID: "lift", Kind: domain.FormFieldRadio, Required: true, Options: []domain.FormOption{
+			{ID: "yes"},
+			{ID: "no", Price: 300},
+		}, VisibleIf: []domain.FormCondition{{Field: "floor", Op: domain.FormCondGt, Value: 3}}},
+	}}
+}
+
+func TestCalcForm(t *testing.T) {
+	def := domain.NewDefaultFormConfig()
+	chain := chainFormConfig()
+	qty := &domain.FormConfig{Fields: []domain.FormField{
+		{ID: "qty", Kind: domain.FormFieldNumber, Required: true, UnitPrice: 10},
+	}}
+	unbounded := &domain.FormConfig{Fields: []domain.FormField{
+		{ID: "qty", Kind: domain.FormFieldNumber, UnitPrice: 10},
+		{ID: "rush", Kind: domain.FormFieldRadio, Options: []domain.FormOption{{ID: "x1000", Multiplier: 1000}}},
+	}}
+
+	tests := []struct {
+		name    string
+		cfg     *domain.FormConfig
+		values  string
+		total   float64
+		visible string // id видимых полей через запятую
+		wantErr string
+	}{
+		// шаблон уборки: 1000 выезд + 50 м² × 60 + 1 санузел × 500
+		{"defaults", def, `{"type":"regular"}`, 4500, "type,area,bathrooms,extras,comment", ""},
+		{"multiplier over all additions", def, `{"type":"general","area":100,"extras":["balcony"],"balcony_area":5}`, 12750, "type,area,bathrooms,extras,balcony_area,comment", ""},
+		{"hidden answer ignored", def, `{"type":"regular","extras":["windows"],"balcony_area":1000}`, 6000, "type,area,bathrooms,extras,comment", ""},
+		{"min total top-up", def, `{"type":"regular","area":10}`, 3000, "type,area,bathrooms,extras,comment", ""},
+		{"null is no answer", def, `{"type":"regular","area":null,"comment":null}`, 4500, "type,area,bathrooms,extras,comment", ""},
+
+		{"chain fully visible", chain, `{"delivery":"yes","floor":5,"lift":"no"}`, 1300, "delivery,floor,lift", ""},
+		{"chain required at the end", chain, `{"delivery":"yes","floor":5}`, 0, "", "lift: required"},
+		{"chain stops at condition", chain, `{"delivery":"yes","floor":2}`, 700, "delivery,floor", ""},
+		{"chain hidden from the top", chain, `{"delivery":"no","floor":10,"lift":"bogus"}`, 0, "delivery", ""},
+		{"chain empty", chain, `{}`, 0, "delivery", ""},
+
+		{"required number is 0", qty, `{"qty":0}`, 0, "", "qty: required, must not be 0"},
+		{"required number by default 0", qty, `{}`, 0, "", "qty: required, must not be 0"},
+		{"required number filled", qty, `{"qty":3}`, 30, "qty", ""},
+
+		{"missing required radio", def, `{}`, 0, "", "type: required"},
+		{"off step", def, `{"type":"regular","area":50.5}`, 0, "", "area: must be min plus a multiple of step 1"},
+		{"below min", def, `{"type":"regular","area":5}`, 0, "", "area: must be at least 10"},
+		{"above max", def, `{"type":"regular","area":600}`, 0, "", "area: must be at most 500"},
+		{"slider below min", def, `{"type":"regular","bathrooms":0}`, 0, "", "bathrooms: must be at least 1"},
+		{"number as string", def, `{"type":"regular","area":"50"}`, 0, "", "area: expected a number"},
+		{"unknown field", def, `{"type":"regular","price":1}`, 0, "", "unknown field price"},
+		{"unknown radio option", def, `{"type":"deluxe"}`, 0, "", "type: unknown option deluxe"},
+		{"unknown multiselect option", def, `{"type":"regular","extras":["windows","pool"]}`, 0, "", "extras: unknown option pool"},
+
+		{"amount overflows", unbounded, `{"qty":1e308}`, 0, "", "too large"},
+		{"multiplier overflows", unbounded, `{"qty":1e306,"rush":"x1000"}`, 0, "", "too large"},
+		{"large but finite", unbounded, `{"qty":1e300}`, 1e301, "qty,rush", ""},
+	}
+	for _, tt := range tests {
+		var raw map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(tt.values), &raw); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		resp, _, err := calcForm(tt.cfg, raw)
+		if tt.wantErr != "" {
+			if err == nil {
+				t.Errorf("%s: total = %v, want error %q", tt.name, resp.Total, tt.wantErr)
+			} else if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: error %q, want it to contain %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if resp.Total != tt.total {
+			t.Errorf("%s: total = %v, want %v", tt.name, resp.Total, tt.total)
+		}
+		if got := strings.Join(resp.Visible, ","); got != tt.visible {
+			t.Errorf("%s: visible = %s, want %s", tt.name, got, tt.visible)
+		}
+		if _, err := json.Marshal(resp); err != nil {
+			t.Errorf("%s: response does not encode: %v", tt.name, err)
+		}
+	}
+}
+
+// Множители применяются по порядку полей к сумме всех прибавок — и тех, что ниже по форме.
+func TestCalcFormItems(t *testing.T) {
+	cfg := &domain.FormConfig{
+		BasePrice: 1000,
+		MinTotal:  2000,
+		Fields: []domain.FormField{
+			{ID: "urgent", Kind: domain.FormFieldRadio, Options: []domain.FormOption{{ID: "yes", Multiplier: 2}}},
+			{ID: "promo", Kind: domain.FormFieldMultiselect, Options: []domain.FormOption{{ID: "half", Multiplier: 0.5}}},
+			{ID: "extra", Kind: domain.FormFieldRadio, Options: []domain.FormOption{{ID: "oven", Price: 400}}},
+		},
+	}
+	raw := map[string]json.RawMessage{
+		"urgent": json.RawMessage(`"yes"`),
+		"promo":  json.RawMessage(`["half"]`),
+		"extra":  json.RawMessage(`"oven"`),
+	}
+
+	resp, _, err := calcForm(cfg, raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		kind, field string
+		amount      float64
+	}{
+		{formItemBase, "", 1000},
+		{formItemPrice, "extra", 400},
+		{formItemMultiplier, "urgent", 1400}, // 1400 × 2
+		{formItemMultiplier, "promo", -1400}, // 2800 × 0.5
+		{formItemMinimum, "", 600},           // 1400 до 2000
+	}
+	if len(resp.Items) != len(want) {
+		t.Fatalf("items = %+v", resp.Items)
+	}
+	for i, w := range want {
+		it := resp.Items[i]
+		if it.Kind != w.kind || it.FieldID != w.field || it.Amount != w.amount {
+			t.Errorf("item %d = %+v, want %s %s %v", i, it, w.kind, w.field, w.amount)
+		}
+	}
+	if resp.Subtotal != 1400 || resp.Total != 2000 {
+		t.Errorf("subtotal = %v, total = %v; want 1400, 2000", resp.Subtotal, resp.Total)
+	}
+}
@@ -0,0 +1,371 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"saas-calc-backend/internal/domain"
+	"saas-calc-backend/internal/formula"
+)
+
+// Ограничения на размер формульного калькулятора.
+const (
+	formulaMaxInputs  = 50
+	formulaMaxOutputs = 20
+	formulaMaxOptions = 50
+)
+
+var formulaNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,31}$`)
+
+// FormulaConfigError — ошибка в конфиге с указанием места: "inputs[1].name", "outputs[0].expr".
+type FormulaConfigError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// compileFormulaConfig проверяет конфиг и разбирает формулы результатов (по одной на output).
+// Каждая формула может ссылаться только на поля и на результаты выше по списку — так нет циклов.
+func compileFormulaConfig(cfg *domain.FormulaConfig) ([]*formula.Expr, []FormulaConfigError) {
+	var errs []FormulaConfigError
+	add := func(field, format string, args ...interface{}) {
+		errs = append(errs, FormulaConfigError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if len(cfg.Inputs) > formulaMaxInputs {
+		add("inputs", "at most %d inputs allowed", formulaMaxInputs)
+	}
+	if len(cfg.Outputs) == 0 {
+		add("outputs", "at least one output is required")
+	}
+	if len(cfg.Outputs) > formulaMaxOutputs {
+		add("outputs", "at most %d outputs allowed", formulaMaxOutputs)
+	}
+
+	// имя -> "input" / "output", для понятных сообщений о повторах
+	names := map[string]string{}
+	checkName := func(field, name string) bool {
+		switch {
+		case !formulaNameRe.MatchString(name):
+			add(field, "name must start with a latin letter or _ and contain only latin letters, digits and _ (up to 32)")
+		case formula.IsFunction(name):
+			add(field, "%s is a reserved word", name)
+		case names[name] != "":
+			add(field, "name %s is already used by another %s", name, names[name])
+		default:
+			return true
+		}
+		return false
+	}
+
+	for i, in := range cfg.Inputs {
+		field := fmt.Sprintf("inputs[%d]", i)
+		if checkName(field+".name", in.Name) {
+			names[in.Name] = "input"
+		}
+
+		switch in.Kind {
+		case domain.FormulaInputNumber:
+			if in.Min != nil && in.Max != nil && *in.Min > *in.Max {
+				add(field+".min", "min must not exceed max")
+			}
+			if (in.Min != nil && in.Default < *in.Min) || (in.Max != nil && in.Default > *in.Max) {
+				add(field+".default", "default is out of min/max range")
+			}
+			if in.Step < 0 {
+				add(field+".step", "step must be >= 0")
+			} else if !domain.OnStep(in.Default, in.Min, in.Step) {
+				add(field+".default", "default must be min plus a multiple of step")
+			}
+		case domain.FormulaInputSelect:
+			if len(in.Options) == 0 || len(in.Options) > formulaMaxOptions {
+				add(field+".options", "select needs from 1 to %d options", formulaMaxOptions)
+			} else if !in.HasOption(in.Default) {
+				add(field+".default", "default must be one of the option values")
+			}
+			for j, o := range in.Options {
+				if strings.TrimSpace(o.Label) == "" {
+					add(fmt.Sprintf("%s.options[%d].label", field, j), "option label is required")
+				}
+			}
+		case domain.FormulaInputCheckbox:
+		default:
+			add(field+".kind", "kind must be number, select or checkbox")
+		}
+	}
+
+	exprs := make([]*formula.Expr, len(cfg.Outputs))
+	for i, out := range cfg.Outputs {
+		field := fmt.Sprintf("outputs[%d]", i)
+		if out.Decimals < 0 || out.Decimals > 10 {
+			add(field+".decimals", "decimals must be from 0 to 10")
+		}
+
+		expr, err := formula.Parse(out.Expr)
+		if err != nil {
+			add(field+".expr", "%v", err)
+		} else {
+			exprs[i] = expr
+			for _, v := range expr.Vars() {
+				if names[v] != "" {
+					continue
+				}
+				if v == out.Name {
+					add(field+".expr", "output cannot refer to itself")
+				} else if laterOutput(cfg.Outputs[i+1:], v) {
+					add(field+".expr", "%s is calculated below this output; move it higher", v)
+				} else {
+					add(field+".expr", "unknown variable %s", v)
+				}
+			}
+		}
+
+		// имя регистрируем после разбора: формула не может ссылаться сама на себя
+		if checkName(field+".name", out.Name) {
+			names[out.Name] = "output"
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return exprs, nil
+}
+
+func laterOutput(outputs []domain.FormulaOutput, name string) bool {
+	for _, o := range outputs {
+		if o.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// writeFormulaConfigErrors — 400 со списком ошибок конфига, чтобы конструктор подсветил поля.
+func writeFormulaConfigErrors(w http.ResponseWriter, errs []FormulaConfigError) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusBadRequest)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":  "invalid formula config: " + errs[0].Field + ": " + errs[0].Message,
+		"errors": errs,
+	})
+}
+
+// --- Конфиг формульного калькулятора ---
+
+// GET/POST /api/formula/config?calculatorId=...
+// Конфиг свой у каждого калькулятора; читать — viewer, сохранять — editor и выше.
+// Формулы разбираются при сохранении: конфиг с ошибками не сохраняется, ошибки возвращаются списком.
+func (e *Env) HandleFormulaConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	u := e.requireUser(w, r)
+	if u == nil {
+		return
+	}
+
+	calc := e.requireConfigCalculator(w, r, u, domain.CalculatorTypeFormula)
+	if calc == nil {
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		cfg, err := e.formulaConfigFor(r.Context(), calc.ID, false)
+		if err != nil {
+			http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		e.writeJSON(w, cfg)
+		return
+	}
+
+	defer r.Body.Close()
+
+	var req domain.FormulaConfig
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	for i := range req.Inputs {
+		req.Inputs[i].Name = strings.TrimSpace(req.Inputs[i].Name)
+		req.Inputs[i].Label = strings.TrimSpace(req.Inputs[i].Label)
+	}
+	for i := range req.Outputs {
+		req.Outputs[i].Name = strings.TrimSpace(req.Outputs[i].Name)
+		req.Outputs[i].Label = strings.TrimSpace(req.Outputs[i].Label)
+		req.Outputs[i].Expr = strings.TrimSpace(req.Outputs[i].Expr)
+	}
+
+	if _, errs := compileFormulaConfig(&req); len(errs) > 0 {
+		writeFormulaConfigErrors(w, errs)
+		return
+	}
+
+	if err := e.saveCalculatorConfig(r.Context(), calc.ID, u.ID, &req); err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	e.writeJSON(w, &req)
+}
+
+// --- Расчёт по формулам ---
+
+type FormulaCalcRequest struct {
+	Values       map[string]interface{} `json:"values"` // имя поля -> число (number, select) или bool (checkbox); нет — значение по умолчанию
+	CalculatorID string                 `json:"calculatorId"`
+	PreviewToken string                 `json:"previewToken"` // со страницы предпросмотра: считать по черновику
+}
+
+type FormulaResult struct {
+	Name     string  `json:"name"`
+	Label    string  `json:"label"`
+	Value    float64 `json:"value"`
+	Unit     string  `json:"unit,omitempty"`
+	Decimals int     `json:"decimals"`
+}
+
+type FormulaCalcResponse struct {
+	Values  map[string]float64 `json:"values"` // значения полей, по которым считали
+	Results []FormulaResult    `json:"results"`
+}
+
+// formulaInputValues — значения полей из запроса с проверкой по конфигу; пропущенные — по умолчанию.
+func formulaInputValues(cfg *domain.FormulaConfig, raw map[string]interface{}) (map[string]float64, error) {
+	for name := range raw {
+		if cfg.FindInput(name) == nil {
+			return nil, fmt.Errorf("unknown input %s", name)
+		}
+	}
+
+	vars := make(map[string]float64, len(cfg.Inputs)+len(cfg.Outputs))
+	for _, in := range cfg.Inputs {
+		v := in.Default
+		val, ok := raw[in.Name]
+
+		switch in.Kind {
+		case domain.FormulaInputCheckbox:
+			checked := v != 0
+			if ok && val != nil {
+				switch x := val.(type) {
+				case bool:
+					checked = x
+				case float64:
+					checked = x != 0
+				default:
+					return nil, fmt.Errorf("%s: expected true or false", in.Name)
+				}
+			}
+			v = 0
+			if checked {
+				v = in.Value
+			}
+
+		default:
+			if ok && val != nil {
+				x, isNum := val.(float64)
+				if !isNum {
+					return nil, fmt.Errorf("%s: expected a number", in.Name)
+				}
+				v = x
+			}
+			if in.Kind == domain.FormulaInputSelect && !in.HasOption(v) {
+				return nil, fmt.Errorf("%s: unknown option", in.Name)
+			}
+			if in.Min != nil && v < *in.Min {
+				return nil, fmt.Errorf("%s: must be at least %g", in.Name, *in.Min)
+			}
+			if in.Max != nil && v > *in.Max {
+				return nil, fmt.Errorf("%s: must be at most %g", in.Name, *in.Max)
+			}
+			if !domain.OnStep(v, in.Min, in.Step) {
+				return nil, fmt.Errorf("%s: must be min plus a multiple of step %g", in.Name, in.Step)
+			}
+		}
+		vars[in.Name] = v
+	}
+	return vars, nil
+}
+
+// POST /api/formula/calc
+func (e *Env) HandleFormulaCalc(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !e.checkOptionalAPIKey(w, r) {
+		return
+	}
+	defer r.Body.Close()
+
+	var req FormulaCalcRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !e.requireCalcTarget(w, r, req.CalculatorID, req.PreviewToken, domain.CalculatorTypeFormula) {
+		return
+	}
+
+	// посетителям — опубликованный конфиг, на странице предпросмотра — черновик
+	published, err := e.publishedConfigRequested(r.Context(), req.CalculatorID, req.PreviewToken)
+	if err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	cfg, err := e.formulaConfigFor(r.Context(), req.CalculatorID, published)
+	if err != nil {
+		configLoadFailed(w, err)
+		return
+	}
+
+	// конфиг проверен при сохранении, но шаблон или импорт могли принести и старый
+	exprs, errs := compileFormulaConfig(cfg)
+	if len(errs) > 0 {
+		http.Error(w, "calculator config is invalid: "+errs[0].Field+": "+errs[0].Message, http.StatusUnprocessableEntity)
+		return
+	}
+
+	vars, err := formulaInputValues(cfg, req.Values)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp := FormulaCalcResponse{Values: make(map[string]float64, len(vars)), Results: []FormulaResult{}}
+	for k, v := range vars {
+		resp.Values[k] = v
+	}
+
+	for i, out := range cfg.Outputs {
+		v, err := exprs[i].Eval(vars)
+		if err != nil {
+			http.Error(w, out.Name+": "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		vars[out.Name] = v
+		if !out.Hidden {
+			resp.Results = append(resp.Results, FormulaResult{
+				Name:     out.Name,
+				Label:    out.Label,
+				Value:    v,
+				Unit:     out.Unit,
+				Decimals: out.Decimals,
+			})
+		}
+	}
+
+	// инкрементируем счётчик расчётов и уведомляем владельца, если передан calculatorId
+	if req.CalculatorID != "" {
+		e.IncrementCalcCount(req.CalculatorID)
+
+		e.NotifyTelegramFormulaCalc(r.Context(), req.CalculatorID, cfg, resp.Values, resp.Results)
+	}
+
+	e.writeJSON(w, resp)
+}
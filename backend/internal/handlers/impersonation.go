@@ -0,0 +1,294 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"saas-calc-backend/internal/domain"
+)
+
+// ImpersonationTTL — сколько живёт сессия входа администратора "под пользователем".
+const ImpersonationTTL = time.Hour
+
+// Заголовки, которыми помечается каждый ответ API авторизованному пользователю.
+const (
+	HeaderRealUserID      = "X-Real-User-Id"
+	HeaderEffectiveUserID = "X-Effective-User-Id"
+)
+
+type impersonationResponse struct {
+	User      *domain.User `json:"user"`
+	ExpiresAt time.Time    `json:"expiresAt"`
+}
+
+// POST /api/admin/users/{id}/impersonate — войти под пользователем.
+// Сессия админа не удаляется: к ней возвращаемся через /api/auth/impersonation/stop.
+func (e *Env) handleAdminUserImpersonate(w http.ResponseWriter, r *http.Request, admin *domain.User, id string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sess := e.currentSession(r)
+	if sess == nil {
+		// по API-ключу в админку не попасть, так что сюда дойти можно только с сессией
+		http.Error(w, "session required", http.StatusUnauthorized)
+		return
+	}
+	if sess.ImpersonatorID != "" {
+		http.Error(w, "already impersonating", http.StatusConflict)
+		return
+	}
+
+	target, err := e.GetUserByID(r.Context(), id)
+	if err != nil {
+		http.Error(w, "failed to load user: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if target == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if target.ID == admin.ID {
+		http.Error(w, "cannot impersonate yourself", http.StatusBadRequest)
+		return
+	}
+	if target.Role == domain.RoleAdmin {
+		http.Error(w, "cannot impersonate another admin", http.StatusForbidden)
+		return
+	}
+
+	sid, err := randomHex(32)
+	if err != nil {
+		http.Error(w, "failed to create session: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	expires := time.Now().Add(ImpersonationTTL)
+	if sess.ExpiresAt.Before(expires) {
+		expires = sess.ExpiresAt
+	}
+
+	if _, err := e.DB.ExecContext(r.Context(), `
+INSERT INTO sessions (id, user_id, expires_at, impersonator_id, parent_session_id)
+VALUES ($1, $2, $3, $4, $5)
+`, sid, target.ID, expires, admin.ID, sess.ID); err != nil {
+		http.Error(w, "failed to create session: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	e.writeAudit(r.Context(), &domain.AuditEntry{
+		ActorID:         admin.ID,
+		EffectiveUserID: target.ID,
+		Action:          domain.AuditImpersonationStart,
+		Method:          r.Method,
+		Path:            r.URL.Path,
+		Status:          http.StatusOK,
+	})
+
+	e.setSessionCookie(w, r, sid, expires)
+	w.Header().Set(HeaderRealUserID, admin.ID)
+	w.Header().Set(HeaderEffectiveUserID, target.ID)
+	e.writeJSON(w, impersonationResponse{User: target, ExpiresAt: expires})
+}
+
+// POST /api/auth/impersonation/stop — выйти из режима "под пользователем"
+// и вернуться в сессию администратора.
+func (e *Env) HandleAuthImpersonationStop(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sess := e.currentSession(r)
+	if sess == nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if sess.ImpersonatorID == "" {
+		http.Error(w, "not impersonating", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := e.DB.ExecContext(r.Context(), `DELETE FROM sessions WHERE id = $1`, sess.ID); err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	e.writeAudit(r.Context(), &domain.AuditEntry{
+		ActorID:         sess.ImpersonatorID,
+		EffectiveUserID: sess.UserID,
+		Action:          domain.AuditImpersonationStop,
+		Method:          r.Method,
+		Path:            r.URL.Path,
+		Status:          http.StatusOK,
+	})
+
+	parent := e.lookupSession(r.Context(), sess.ParentSessionID)
+	if parent == nil {
+		// сессия админа успела истечь — просто разлогиниваем
+		clearSessionCookie(w, r)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	admin, err := e.GetUserByID(r.Context(), parent.UserID)
+	if err != nil {
+		http.Error(w, "failed to load user: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	e.setSessionCookie(w, r, parent.ID, parent.ExpiresAt)
+	w.Header().Set(HeaderRealUserID, parent.UserID)
+	w.Header().Set(HeaderEffectiveUserID, parent.UserID)
+	e.writeJSON(w, admin)
+}
+
+// GET /api/admin/audit?actorId=&userId=&limit=100 — журнал аудита.
+func (e *Env) HandleAdminAudit(w http.ResponseWriter, r *http.Request) {
+	if e.requireAdmin(w, r) == nil {
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	limit := 100
+	if v, err := strconv.Atoi(q.Get("limit")); err == nil && v > 0 && v <= 1000 {
+		limit = v
+	}
+
+	rows, err := e.DB.QueryContext(r.Context(), `
+SELECT id, created_at, actor_id, effective_user_id, action, method, path, status
+FROM audit_log
+WHERE ($1 = '' OR actor_id = $1)
+  AND ($2 = '' OR effective_user_id = $2)
+ORDER BY created_at DESC, id DESC
+LIMIT $3
+`, q.Get("actorId"), q.Get("userId"), limit)
+	if err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	items := make([]*domain.AuditEntry, 0)
+	for rows.Next() {
+		var a domain.AuditEntry
+		if err := rows.Scan(
+			&a.ID,
+			&a.CreatedAt,
+			&a.ActorID,
+			&a.EffectiveUserID,
+			&a.Action,
+			&a.Method,
+			&a.Path,
+			&a.Status,
+		); err != nil {
+			http.Error(w, "db scan error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		items = append(items, &a)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, "db rows error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	e.writeJSON(w, map[string]interface{}{"items": items})
+}
+
+// WithSession один раз на запрос находит сессию по cookie и кладёт её в контекст,
+// помечает ответ заголовками X-Real-User-Id / X-Effective-User-Id,
+// а изменяющие запросы, сделанные "под пользователем", пишет в журнал аудита
+// (ключи API и 2FA под пользователем менять нельзя).
+func (e *Env) WithSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// запросы по API-ключу к сессиям отношения не имеют
+		if e.DB == nil || !strings.HasPrefix(r.URL.Path, "/api/") || bearerToken(r) != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		sess := e.lookupSession(r.Context(), e.sessionIDFromRequest(r))
+		r = r.WithContext(context.WithValue(r.Context(), sessionCtxKey{}, sessionCtxValue{sess: sess}))
+
+		if sess == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set(HeaderRealUserID, sess.RealUserID())
+		w.Header().Set(HeaderEffectiveUserID, sess.UserID)
+
+		// выход из режима пишет в журнал сам
+		if sess.ImpersonatorID == "" || !isMutatingMethod(r.Method) || r.URL.Path == "/api/auth/impersonation/stop" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		if isCredentialPath(r.URL.Path) {
+			// ключи API и 2FA пережили бы сессию "под пользователем" — менять их нельзя
+			http.Error(rec, "not allowed while impersonating", http.StatusForbidden)
+		} else {
+			next.ServeHTTP(rec, r)
+		}
+
+		e.writeAudit(r.Context(), &domain.AuditEntry{
+			ActorID:         sess.ImpersonatorID,
+			EffectiveUserID: sess.UserID,
+			Action:          domain.AuditRequest,
+			Method:          r.Method,
+			Path:            r.URL.Path,
+			Status:          rec.status,
+		})
+	})
+}
+
+// writeAudit пишет запись в журнал; ошибка только логируется — запрос уже выполнен.
+func (e *Env) writeAudit(ctx context.Context, a *domain.AuditEntry) {
+	if e.DB == nil {
+		return
+	}
+	if _, err := e.DB.ExecContext(ctx, `
+INSERT INTO audit_log (actor_id, effective_user_id, action, method, path, status)
+VALUES ($1, $2, $3, $4, $5, $6)
+`, a.ActorID, a.EffectiveUserID, a.Action, a.Method, a.Path, a.Status); err != nil {
+		log.Printf("audit %s %s -> %s: %v", a.Action, a.ActorID, a.EffectiveUserID, err)
+	}
+}
+
+// isCredentialPath — эндпоинты, которые меняют учётные данные пользователя (ключи API, 2FA).
+func isCredentialPath(p string) bool {
+	for _, prefix := range []string{"/api/me/api-keys", "/api/me/2fa"} {
+		if p == prefix || strings.HasPrefix(p, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func isMutatingMethod(m string) bool {
+	switch m {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// statusRecorder запоминает код ответа для журнала.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	s.status = code
+	s.ResponseWriter.WriteHeader(code)
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"saas-calc-backend/internal/domain"
+	"saas-calc-backend/internal/mail"
+)
+
+// inviteTTL — сколько живёт ссылка-приглашение.
+const inviteTTL = 7 * 24 * time.Hour
+
+type acceptInviteRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// POST /api/auth/invite/accept { "token": "...", "password": "..." }
+//
+// Приглашённый пользователь задаёт себе пароль. Email при этом считается подтверждённым,
+// пользователь сразу входит в кабинет (с включённой 2FA — после кода, как при обычном входе).
+func (e *Env) HandleAuthInviteAccept(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	defer r.Body.Close()
+
+	var req acceptInviteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.Password) < minPasswordLength {
+		http.Error(w, "password is too short", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := e.consumeUserToken(r.Context(), req.Token, tokenKindInvite)
+	if err != nil {
+		http.Error(w, "failed to check token: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if userID == "" {
+		http.Error(w, "invalid or expired token", http.StatusBadRequest)
+		return
+	}
+
+	if err := e.SetUserPassword(r.Context(), userID, req.Password); err != nil {
+		http.Error(w, "failed to set password: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if _, err := e.DB.ExecContext(r.Context(),
+		`UPDATE users SET email_confirmed = TRUE WHERE id = $1`,
+		userID,
+	); err != nil {
+		http.Error(w, "failed to confirm email: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// старые приглашения больше не нужны
+	if err := e.revokeUserTokens(r.Context(), userID, tokenKindInvite); err != nil {
+		log.Printf("invite accept: revoke tokens for %s: %v", userID, err)
+	}
+
+	u, err := e.GetUserByID(r.Context(), userID)
+	if err != nil || u == nil {
+		http.Error(w, "failed to load user", http.StatusInternalServerError)
+		return
+	}
+
+	// вход — как после пароля: с 2FA сначала код
+	e.beginSession(w, r, u)
+}
+
+// sendInviteEmail выпускает токен приглашения и отправляет ссылку на установку пароля.
+func (e *Env) sendInviteEmail(ctx context.Context, u *domain.User) error {
+	token, err := e.issueUserToken(ctx, u.ID, tokenKindInvite, inviteTTL)
+	if err != nil {
+		return err
+	}
+
+	link := e.absoluteURL("/app?inviteToken=" + url.QueryEscape(token))
+	e.sendMail(ctx, mail.Message{
+		To:      u.Email,
+		Subject: "Приглашение в кабинет",
+		Body: "Здравствуйте, " + u.Name + "!\n\n" +
+			"Для вас создана учётная запись в конструкторе калькуляторов. " +
+			"Чтобы задать пароль и войти, перейдите по ссылке:\n" +
+			link + "\n\n" +
+			"Ссылка действует 7 дней.",
+	})
+	return nil
+}
@@ -7,35 +7,35 @@ import (
     "saas-calc-backend/internal/domain"
 )
 
-// HandleLayeredConfig — конфиг послойного калькулятора.
+// HandleLayeredConfig — конфиг послойного калькулятора: GET/POST /api/layers/config?calculatorId=...
 //
-// Доступен только если у текущего пользователя есть хотя бы один калькулятор
-// типа layered (или он админ и в системе вообще есть layered-калькуляторы).
+// У каждого калькулятора свой конфиг (таблица calculator_configs).
+// Читать может любой участник организации калькулятора (viewer), сохранять — editor и выше.
 func (e *Env) HandleLayeredConfig(w http.ResponseWriter, r *http.Request) {
-    u := e.CurrentUser(r)
-    if u == nil {
-        http.Error(w, "user not found", http.StatusUnauthorized)
+    if r.Method != http.MethodGet && r.Method != http.MethodPost {
+        http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
         return
     }
 
-    // проверяем наличие доступного layered-калькулятора
-    hasLayered := false
-    for _, c := range e.Calculators {
-        if c.Type == domain.CalculatorTypeLayered &&
-            (u.Role == domain.RoleAdmin || c.OwnerID == u.ID) {
-            hasLayered = true
-            break
-        }
+    u := e.requireUser(w, r)
+    if u == nil {
+        return
     }
 
-    if !hasLayered {
-        http.Error(w, "no layered calculator for this user", http.StatusForbidden)
+    calc := e.requireConfigCalculator(w, r, u, domain.CalculatorTypeLayered)
+    if calc == nil {
         return
     }
 
     switch r.Method {
     case http.MethodGet:
-        e.writeJSON(w, e.LayeredConfig)
+        cfg, err := e.layeredConfigFor(r.Context(), calc.ID, false)
+        if err != nil {
+            http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+            return
+        }
+        e.writeJSON(w, cfg)
+
     case http.MethodPost:
         defer r.Body.Close()
 
@@ -45,10 +45,10 @@ func (e *Env) HandleLayeredConfig(w http.ResponseWriter, r *http.Request) {
             return
         }
 
-        e.LayeredConfig = &cfg
-        e.writeJSON(w, e.LayeredConfig)
-
-    default:
-        http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        if err := e.saveCalculatorConfig(r.Context(), calc.ID, u.ID, &cfg); err != nil {
+            http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+            return
+        }
+        e.writeJSON(w, &cfg)
     }
 }
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+
+	"saas-calc-backend/internal/domain"
+)
+
+// GET /api/leads?limit=100 — заявки текущего пользователя (админ видит все).
+// Доступно и по API-ключу со скоупом leads:read.
+func (e *Env) HandleLeads(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	u := e.requireUser(w, r)
+	if u == nil {
+		return
+	}
+
+	limit := 100
+	if v, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && v > 0 && v <= 1000 {
+		limit = v
+	}
+
+	var rows *sql.Rows
+	var err error
+	if u.Role == domain.RoleAdmin {
+		rows, err = e.DB.QueryContext(r.Context(), `
+SELECT id, owner_id, COALESCE(calc_id, ''), payload, created_at
+FROM leads
+ORDER BY created_at DESC
+LIMIT $1
+`, limit)
+	} else {
+		rows, err = e.DB.QueryContext(r.Context(), `
+SELECT id, owner_id, COALESCE(calc_id, ''), payload, created_at
+FROM leads
+WHERE owner_id = $1
+ORDER BY created_at DESC
+LIMIT $2
+`, u.ID, limit)
+	}
+	if err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	items := make([]*domain.Lead, 0)
+	for rows.Next() {
+		var l domain.Lead
+		var payload []byte
+		if err := rows.Scan(&l.ID, &l.OwnerID, &l.CalcID, &payload, &l.CreatedAt); err != nil {
+			http.Error(w, "db scan error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if len(payload) > 0 {
+			l.Payload = payload
+		}
+		items = append(items, &l)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, "db rows error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	e.writeJSON(w, map[string]interface{}{"items": items})
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"saas-calc-backend/internal/ratelimit"
+)
+
+// Блокировка входа по email: после loginFailThreshold неудачных входов подряд
+// вход закрывается на loginLockBase, каждая следующая неудача удваивает срок (до loginLockMax).
+// Считаем по самому адресу, есть такой пользователь или нет: иначе 429 подтверждал бы, что аккаунт существует.
+// Счётчик забывается после loginFailForget без неудач.
+const (
+	loginFailThreshold = 5
+	loginLockBase      = time.Minute
+	loginLockMax       = time.Hour
+	loginFailForget    = 24 * time.Hour
+)
+
+// accountLockedFor — сколько ещё заблокирован вход для email (0 — не заблокирован).
+func (e *Env) accountLockedFor(ctx context.Context, email string) (time.Duration, error) {
+	var until sql.NullTime
+	err := e.DB.QueryRowContext(ctx,
+		`SELECT locked_until FROM login_failures WHERE email = lower($1)`,
+		email,
+	).Scan(&until)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	if !until.Valid {
+		return 0, nil
+	}
+	if wait := time.Until(until.Time); wait > 0 {
+		return wait, nil
+	}
+	return 0, nil
+}
+
+// recordLoginFailure увеличивает счётчик неудач для email и, если порог пройден, блокирует вход.
+func (e *Env) recordLoginFailure(ctx context.Context, email string) error {
+	forgetBefore := time.Now().Add(-loginFailForget)
+
+	// давно забытые адреса выбрасываем, чтобы перебор случайных email не раздувал таблицу
+	if _, err := e.DB.ExecContext(ctx, `
+DELETE FROM login_failures
+WHERE last_failure < $1 AND (locked_until IS NULL OR locked_until < now())
+`, forgetBefore); err != nil {
+		return err
+	}
+
+	var count int
+	err := e.DB.QueryRowContext(ctx, `
+INSERT INTO login_failures (email, failures, last_failure)
+VALUES (lower($1), 1, now())
+ON CONFLICT (email) DO UPDATE
+SET failures = CASE WHEN login_failures.last_failure < $2 THEN 1 ELSE login_failures.failures + 1 END,
+    last_failure = now()
+RETURNING failures
+`, email, forgetBefore).Scan(&count)
+	if err != nil {
+		return err
+	}
+
+	d := ratelimit.Delay(count, loginFailThreshold, loginLockBase, loginLockMax)
+	if d <= 0 {
+		return nil
+	}
+	until := time.Now().Add(d)
+	if _, err := e.DB.ExecContext(ctx,
+		`UPDATE login_failures SET locked_until = $1 WHERE email = lower($2)`,
+		until, email,
+	); err != nil {
+		return err
+	}
+	_, err = e.DB.ExecContext(ctx,
+		`UPDATE users SET locked_until = $1 WHERE lower(email) = lower($2)`,
+		until, email,
+	)
+	return err
+}
+
+// resetLoginFailures — после успешного входа (или разблокировки администратором).
+func (e *Env) resetLoginFailures(ctx context.Context, userID string) error {
+	_, err := e.DB.ExecContext(ctx, `
+WITH u AS (
+    UPDATE users SET failed_logins = 0, locked_until = NULL
+    WHERE id = $1
+    RETURNING lower(email) AS email
+)
+DELETE FROM login_failures WHERE email IN (SELECT email FROM u)
+`, userID)
+	return err
+}
+
+// POST /api/admin/users/{id}/unlock — снять блокировку входа.
+func (e *Env) handleAdminUserUnlock(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	u, err := e.GetUserByID(r.Context(), id)
+	if err != nil {
+		http.Error(w, "failed to load user: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if u == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if err := e.resetLoginFailures(r.Context(), id); err != nil {
+		http.Error(w, "failed to unlock user: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -17,6 +17,12 @@ type MeResponse struct {
 
 	LeadsUsed int `json:"leadsUsed"` // сколько заявок уже создано пользователем
 	CalcsUsed int `json:"calcsUsed"` // сколько расчётов уже сделано
+
+	// организации пользователя с его ролью; тариф (Plan) — тариф личной организации
+	Organizations []*domain.Organization `json:"organizations"`
+
+	// администратор, вошедший под этим пользователем (nil — обычная сессия)
+	ImpersonatedBy *domain.User `json:"impersonatedBy,omitempty"`
 }
 
 type changeTelegramRequest struct {
@@ -45,6 +51,16 @@ func (e *Env) HandleMe(w http.ResponseWriter, r *http.Request) {
 
 	leadsUsed, calcsUsed := e.usageForUser(r.Context(), u)
 
+	orgs := make([]*domain.Organization, 0)
+	if e.DB != nil {
+		list, err := e.ListUserOrgs(r.Context(), u.ID)
+		if err != nil {
+			http.Error(w, "failed to load organizations: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		orgs = list
+	}
+
 	resp := MeResponse{
 		User:       u,
 		Plan:       currentPlan,
@@ -53,6 +69,14 @@ func (e *Env) HandleMe(w http.ResponseWriter, r *http.Request) {
 
 		LeadsUsed: leadsUsed,
 		CalcsUsed: calcsUsed,
+
+		Organizations: orgs,
+	}
+
+	if sess := e.currentSession(r); sess != nil && sess.ImpersonatorID != "" && bearerToken(r) == "" {
+		if admin, err := e.GetUserByID(r.Context(), sess.ImpersonatorID); err == nil {
+			resp.ImpersonatedBy = admin
+		}
 	}
 
 	e.writeJSON(w, resp)
@@ -117,6 +141,11 @@ func (e *Env) HandleMePlan(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "user not found", http.StatusUnauthorized)
 		return
 	}
+	// оплаты в сервисе нет: тариф меняет только администратор (см. /api/admin/users)
+	if u.Role != domain.RoleAdmin {
+		http.Error(w, "plan can be changed only by an administrator", http.StatusForbidden)
+		return
+	}
 
 	defer r.Body.Close()
 
@@ -175,6 +204,11 @@ func (e *Env) HandleMePlan(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "failed to update user plan in db: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
+		// лимиты теперь считаются по организации — меняем тариф и личной организации
+		if err := e.setPersonalOrgPlan(r.Context(), u.ID, req.PlanID, true); err != nil {
+			http.Error(w, "failed to update organization plan: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
 	}
 
 	// и сразу отдаём обновлённое состояние /me
@@ -199,10 +233,11 @@ func (e *Env) usageForUser(ctx context.Context, u *domain.User) (leadsUsed int,
 			_ = row.Scan(&leadsUsed)
 		}
 
-		// расчёты: SUM(calc_count) из calculators по owner_id
+		// расчёты: SUM(calc_count) по калькуляторам личной организации — тариф в /me её,
+		// и лимиты считаются по организации; корзина не в счёт
 		if row := e.DB.QueryRowContext(ctx,
-			`SELECT COALESCE(SUM(calc_count), 0) FROM calculators WHERE owner_id = $1`,
-			u.ID,
+			`SELECT COALESCE(SUM(calc_count), 0) FROM calculators WHERE org_id = $1 AND deleted_at IS NULL`,
+			domain.PersonalOrgID(u.ID),
 		); row != nil {
 			_ = row.Scan(&calcsUsed)
 		}
@@ -212,9 +247,10 @@ func (e *Env) usageForUser(ctx context.Context, u *domain.User) (leadsUsed int,
 
 	// --- Фоллбэк: старая in-memory логика, если БД ещё не подключена ---
 
-	// расчёты — суммируем CalcCount по калькуляторам владельца
+	// расчёты — суммируем CalcCount по калькуляторам личной организации
+	orgID := domain.PersonalOrgID(u.ID)
 	for _, c := range e.Calculators {
-		if c.OwnerID == u.ID {
+		if c.OrgID == orgID && c.DeletedAt == nil {
 			calcsUsed += c.CalcCount
 		}
 	}
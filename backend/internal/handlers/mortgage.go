@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"math"
 	"net/http"
+
+	"saas-calc-backend/internal/domain"
 )
 
 type MortgageCalcRequest struct {
@@ -11,6 +13,7 @@ type MortgageCalcRequest struct {
 	Rate         float64 `json:"rate"`         // годовая ставка, %
 	Years        int     `json:"years"`        // срок в годах
 	CalculatorID string  `json:"calculatorId"` // ID калькулятора для счётчика и Telegram
+	PreviewToken string  `json:"previewToken"` // со страницы предпросмотра: можно считать и непубличный
 }
 
 type MortgageCalcResponse struct {
@@ -25,6 +28,9 @@ func (e *Env) HandleMortgageCalc(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	if !e.checkOptionalAPIKey(w, r) {
+		return
+	}
 	defer r.Body.Close()
 
 	var req MortgageCalcRequest
@@ -32,6 +38,9 @@ func (e *Env) HandleMortgageCalc(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "bad json: "+err.Error(), http.StatusBadRequest)
 		return
 	}
+	if !e.requireCalcTarget(w, r, req.CalculatorID, req.PreviewToken, domain.CalculatorTypeMortgage) {
+		return
+	}
 
 	if req.Amount <= 0 || req.Years <= 0 || req.Rate < 0 {
 		http.Error(w, "amount, years, rate must be > 0", http.StatusBadRequest)
@@ -0,0 +1,368 @@
+package handlers
+
+import (
+	"context"
+	"crypto/hmac"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"saas-calc-backend/internal/domain"
+	"saas-calc-backend/internal/oidc"
+)
+
+const (
+	// oidcCookieName — cookie с state/nonce/PKCE verifier между редиректами к провайдеру.
+	oidcCookieName = "saas_oidc"
+	// oidcFlowTTL — сколько есть на вход у провайдера.
+	oidcFlowTTL = 10 * time.Minute
+	// oidcCallbackPath — redirect_uri, который нужно прописать у провайдера.
+	oidcCallbackPath = "/api/auth/oidc/callback"
+	// mfaCookieName — mfaToken после входа через провайдера: в адресе он попал бы в историю и логи.
+	mfaCookieName = "saas_mfa"
+)
+
+// errOIDCNoLink — учётную запись с этим email нельзя связать со входом через провайдера.
+var errOIDCNoLink = errors.New("account cannot be linked")
+
+// состояние входа через провайдера, хранится в подписанной cookie
+type oidcFlow struct {
+	State    string `json:"s"`
+	Nonce    string `json:"n"`
+	Verifier string `json:"v"`
+	Expires  int64  `json:"e"`
+}
+
+// GET /api/auth/oidc — включён ли вход через корпоративного провайдера (для кнопки на форме входа).
+func (e *Env) HandleAuthOIDC(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	cfg, err := e.oidcConfig(r.Context())
+	if err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	e.writeJSON(w, map[string]interface{}{
+		"enabled":  cfg != nil,
+		"loginUrl": "/api/auth/oidc/login",
+	})
+}
+
+// GET /api/auth/oidc/login — редирект к провайдеру (authorization code + PKCE).
+func (e *Env) HandleAuthOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	cfg, err := e.oidcConfig(r.Context())
+	if err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if cfg == nil {
+		http.Error(w, "sso is not configured", http.StatusNotFound)
+		return
+	}
+
+	provider, err := oidc.Discover(r.Context(), cfg.Issuer)
+	if err != nil {
+		log.Printf("oidc login: %v", err)
+		http.Error(w, "identity provider is unavailable", http.StatusBadGateway)
+		return
+	}
+
+	var flow oidcFlow
+	for _, p := range []*string{&flow.State, &flow.Nonce, &flow.Verifier} {
+		if *p, err = oidc.RandomString(32); err != nil {
+			http.Error(w, "failed to start login: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	flow.Expires = time.Now().Add(oidcFlowTTL).Unix()
+
+	if err := e.setOIDCFlowCookie(w, r, &flow); err != nil {
+		http.Error(w, "failed to start login: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, provider.AuthCodeURL(*cfg, flow.State, flow.Nonce, flow.Verifier), http.StatusFound)
+}
+
+// GET /api/auth/oidc/callback?code=...&state=... — возврат от провайдера.
+// Находим пользователя по email из id_token (или создаём на базовом тарифе) и открываем сессию.
+func (e *Env) HandleAuthOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	flow := e.oidcFlowFromRequest(r)
+	clearOIDCFlowCookie(w, r)
+
+	q := r.URL.Query()
+	if errCode := q.Get("error"); errCode != "" {
+		log.Printf("oidc callback: provider error %s: %s", errCode, q.Get("error_description"))
+		redirectSSOError(w, r, "denied")
+		return
+	}
+	if flow == nil || q.Get("state") == "" || q.Get("state") != flow.State {
+		redirectSSOError(w, r, "state")
+		return
+	}
+
+	cfg, err := e.oidcConfig(r.Context())
+	if err != nil || cfg == nil {
+		redirectSSOError(w, r, "config")
+		return
+	}
+
+	provider, err := oidc.Discover(r.Context(), cfg.Issuer)
+	if err != nil {
+		log.Printf("oidc callback: %v", err)
+		redirectSSOError(w, r, "provider")
+		return
+	}
+
+	claims, err := provider.Exchange(r.Context(), *cfg, q.Get("code"), flow.Verifier, flow.Nonce)
+	if err != nil {
+		log.Printf("oidc callback: %v", err)
+		redirectSSOError(w, r, "token")
+		return
+	}
+
+	email := strings.TrimSpace(claims.Email)
+	if !looksLikeEmail(email) {
+		redirectSSOError(w, r, "email")
+		return
+	}
+	// неподтверждённому у провайдера адресу не доверяем: иначе можно войти в чужую учётку.
+	// Провайдер, который email_verified не передаёт, подтверждением не считается.
+	verified := claims.EmailVerified != nil && *claims.EmailVerified
+	if claims.EmailVerified != nil && !verified {
+		redirectSSOError(w, r, "email_unverified")
+		return
+	}
+
+	u, err := e.userForOIDCLogin(r.Context(), email, claims.Name, verified)
+	if errors.Is(err, errOIDCNoLink) {
+		log.Printf("oidc callback: refused to link %s (verified=%v)", email, verified)
+		redirectSSOError(w, r, "link")
+		return
+	}
+	if err != nil {
+		log.Printf("oidc callback: user for %s: %v", email, err)
+		redirectSSOError(w, r, "user")
+		return
+	}
+
+	// своя 2FA у пользователя остаётся в силе и при входе через провайдера
+	if u.TwoFactor {
+		token, err := e.issueUserToken(r.Context(), u.ID, tokenKindMFA, mfaTTL)
+		if err != nil {
+			log.Printf("oidc callback: mfa token for %s: %v", u.ID, err)
+			redirectSSOError(w, r, "user")
+			return
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     mfaCookieName,
+			Value:    token,
+			Path:     "/api/auth/login/2fa",
+			MaxAge:   int(mfaTTL / time.Second),
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteStrictMode,
+		})
+		http.Redirect(w, r, "/app?sso=2fa", http.StatusFound)
+		return
+	}
+
+	if err := e.startSession(w, r, u.ID); err != nil {
+		log.Printf("oidc callback: session for %s: %v", u.ID, err)
+		redirectSSOError(w, r, "session")
+		return
+	}
+	http.Redirect(w, r, "/app", http.StatusFound)
+}
+
+// userForOIDCLogin — пользователь с таким email; если нет — создаём на самом дешёвом тарифе.
+// С существующей учётной записью вход связывается, только если провайдер подтвердил email
+// (verified), и никогда — с администраторской: иначе её захватил бы любой, кто заведёт этот адрес у провайдера.
+// Новый пользователь получает подтверждённый email, если его подтвердил провайдер.
+func (e *Env) userForOIDCLogin(ctx context.Context, email, name string, verified bool) (*domain.User, error) {
+	var id, role string
+	err := e.DB.QueryRowContext(ctx,
+		`SELECT id, role FROM users WHERE lower(email) = lower($1)`,
+		email,
+	).Scan(&id, &role)
+	switch {
+	case err == nil:
+		if !verified || domain.Role(role) == domain.RoleAdmin {
+			return nil, errOIDCNoLink
+		}
+		if _, err := e.DB.ExecContext(ctx,
+			`UPDATE users SET email_confirmed = TRUE WHERE id = $1 AND NOT email_confirmed`,
+			id,
+		); err != nil {
+			return nil, err
+		}
+		return e.GetUserByID(ctx, id)
+	case !errors.Is(err, sql.ErrNoRows):
+		return nil, err
+	}
+
+	plans := e.Plans
+	if len(plans) == 0 {
+		plans = domain.DefaultPlans()
+	}
+	plan := domain.CheapestPlan(plans)
+	if plan == nil {
+		return nil, errors.New("no plans configured")
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = email
+	}
+
+	u := &domain.User{
+		ID:             domain.NewUserID(),
+		Email:          email,
+		Name:           name,
+		Role:           domain.RoleUser,
+		PlanID:         plan.ID,
+		PlanActive:     true,
+		CreatedAt:      time.Now(),
+		EmailConfirmed: verified,
+	}
+
+	// пароля нет: войти можно только через провайдера (или задать пароль через сброс)
+	err = e.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `
+INSERT INTO users (id, email, name, role, password_hash, plan_id, plan_active, created_at, email_confirmed)
+VALUES ($1, $2, $3, $4, '', $5, $6, $7, $8)
+`,
+			u.ID,
+			u.Email,
+			u.Name,
+			string(u.Role),
+			u.PlanID,
+			u.PlanActive,
+			u.CreatedAt,
+			u.EmailConfirmed,
+		); err != nil {
+			return err
+		}
+		return e.createPersonalOrg(ctx, tx, u)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// oidcConfig — настройки провайдера из settings (nil, если вход через провайдера не настроен).
+func (e *Env) oidcConfig(ctx context.Context) (*oidc.Config, error) {
+	if e.DB == nil {
+		return nil, nil
+	}
+
+	var issuer, clientID, secret string
+	err := e.DB.QueryRowContext(ctx, `
+SELECT COALESCE(oidc_issuer, ''), COALESCE(oidc_client_id, ''), COALESCE(oidc_client_secret, '')
+FROM settings
+WHERE id = 1
+`).Scan(&issuer, &clientID, &secret)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if issuer == "" || clientID == "" {
+		return nil, nil
+	}
+
+	return &oidc.Config{
+		Issuer:       issuer,
+		ClientID:     clientID,
+		ClientSecret: secret,
+		RedirectURL:  e.absoluteURL(oidcCallbackPath),
+	}, nil
+}
+
+func (e *Env) setOIDCFlowCookie(w http.ResponseWriter, r *http.Request, flow *oidcFlow) error {
+	b, err := json.Marshal(flow)
+	if err != nil {
+		return err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(b)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookieName,
+		Value:    payload + "." + e.signSessionID(payload),
+		Path:     "/api/auth/oidc",
+		MaxAge:   int(oidcFlowTTL / time.Second),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		// Lax: cookie должна прийти на редирект от провайдера (GET верхнего уровня)
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// oidcFlowFromRequest достаёт и проверяет (подпись, срок) состояние входа из cookie.
+func (e *Env) oidcFlowFromRequest(r *http.Request) *oidcFlow {
+	c, err := r.Cookie(oidcCookieName)
+	if err != nil || c.Value == "" {
+		return nil
+	}
+
+	dot := strings.LastIndex(c.Value, ".")
+	if dot <= 0 {
+		return nil
+	}
+	payload, sig := c.Value[:dot], c.Value[dot+1:]
+	if !hmac.Equal([]byte(sig), []byte(e.signSessionID(payload))) {
+		return nil
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil
+	}
+	var flow oidcFlow
+	if err := json.Unmarshal(b, &flow); err != nil {
+		return nil
+	}
+	if time.Now().Unix() > flow.Expires {
+		return nil
+	}
+	return &flow
+}
+
+func clearOIDCFlowCookie(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookieName,
+		Value:    "",
+		Path:     "/api/auth/oidc",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// ошибки входа показываем в кабинете, подробности — только в логе
+func redirectSSOError(w http.ResponseWriter, r *http.Request, reason string) {
+	http.Redirect(w, r, "/app?ssoError="+url.QueryEscape(reason), http.StatusFound)
+}
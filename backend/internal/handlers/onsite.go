@@ -0,0 +1,211 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"saas-calc-backend/internal/domain"
+)
+
+// --- Конфиг калькулятора выезда специалиста ---
+
+// GET/POST /api/onsite/config?calculatorId=...
+// Конфиг свой у каждого калькулятора; читать — viewer, сохранять — editor и выше.
+// При сохранении адрес базы геокодируется один раз, чтобы не тратить квоту Nominatim на каждый расчёт.
+func (e *Env) HandleOnSiteConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	u := e.requireUser(w, r)
+	if u == nil {
+		return
+	}
+
+	calc := e.requireConfigCalculator(w, r, u, domain.CalculatorTypeOnSite)
+	if calc == nil {
+		return
+	}
+
+	cfg, err := e.onSiteConfigFor(r.Context(), calc.ID, false)
+	if err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		e.writeJSON(w, cfg)
+		return
+	}
+
+	defer r.Body.Close()
+
+	var req domain.OnSiteConfig
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.BaseAddress = strings.TrimSpace(req.BaseAddress)
+	if err := req.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// координаты базы с клиента не принимаем: берём прежние или геокодируем новый адрес
+	req.BaseLat, req.BaseLon = 0, 0
+	if req.BaseAddress == cfg.BaseAddress && cfg.HasBaseCoords() {
+		req.BaseLat, req.BaseLon = cfg.BaseLat, cfg.BaseLon
+	} else {
+		lat, lon, err := e.geocodeAddress(req.BaseAddress)
+		if err != nil {
+			http.Error(w, "geocode base address: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		req.BaseLat, req.BaseLon = lat, lon
+	}
+
+	if err := e.saveCalculatorConfig(r.Context(), calc.ID, u.ID, &req); err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	e.writeJSON(w, &req)
+}
+
+// --- Расчёт стоимости выезда ---
+
+type OnSiteCalcRequest struct {
+	Address      string `json:"address"` // куда выезжать
+	Service      string `json:"service"` // id услуги (пусто — первая)
+	Urgency      string `json:"urgency"` // id срочности (пусто — первая)
+	CalculatorID string `json:"calculatorId"`
+	PreviewToken string `json:"previewToken"` // со страницы предпросмотра: считать по черновику
+}
+
+type OnSiteCalcResponse struct {
+	DistanceKm   float64      `json:"distanceKm"`   // от базы до адреса по дорогам
+	FreeRadiusKm float64      `json:"freeRadiusKm"` // бесплатный радиус
+	PaidKm       float64      `json:"paidKm"`       // оплачиваемые км (с обратной дорогой, если так настроено)
+	Service      string       `json:"service"`
+	Urgency      string       `json:"urgency,omitempty"`
+	VisitFee     float64      `json:"visitFee"`
+	PriceKm      float64      `json:"priceKm"`
+	Surcharge    float64      `json:"surcharge"`
+	PriceTotal   float64      `json:"priceTotal"`
+	Route        []RoutePoint `json:"route"` // маршрут от базы для отрисовки на карте
+}
+
+// POST /api/onsite/calc
+func (e *Env) HandleOnSiteCalc(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !e.checkOptionalAPIKey(w, r) {
+		return
+	}
+	defer r.Body.Close()
+
+	var req OnSiteCalcRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if strings.TrimSpace(req.Address) == "" {
+		http.Error(w, "address required", http.StatusBadRequest)
+		return
+	}
+
+	if !e.requireCalcTarget(w, r, req.CalculatorID, req.PreviewToken, domain.CalculatorTypeOnSite) {
+		return
+	}
+
+	// посетителям — опубликованный конфиг, на странице предпросмотра — черновик
+	published, err := e.publishedConfigRequested(r.Context(), req.CalculatorID, req.PreviewToken)
+	if err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	cfg, err := e.onSiteConfigFor(r.Context(), req.CalculatorID, published)
+	if err != nil {
+		configLoadFailed(w, err)
+		return
+	}
+
+	service := cfg.FindService(req.Service)
+	if service == nil {
+		http.Error(w, "unknown service", http.StatusBadRequest)
+		return
+	}
+	urgency := cfg.FindUrgency(req.Urgency)
+	if urgency == nil && req.Urgency != "" {
+		http.Error(w, "unknown urgency", http.StatusBadRequest)
+		return
+	}
+
+	baseLat, baseLon := cfg.BaseLat, cfg.BaseLon
+	if !cfg.HasBaseCoords() {
+		baseLat, baseLon, err = e.geocodeAddress(cfg.BaseAddress)
+		if err != nil {
+			http.Error(w, "geocode base: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	lat, lon, err := e.geocodeAddress(req.Address)
+	if err != nil {
+		http.Error(w, "geocode address: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	distMeters, route, err := e.osrmRoute(baseLat, baseLon, lat, lon)
+	if err != nil {
+		http.Error(w, "osrm route: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	distKm := distMeters / 1000.0
+
+	paidKm := distKm - cfg.FreeRadiusKm
+	if paidKm < 0 {
+		paidKm = 0
+	}
+	if cfg.RoundTrip {
+		paidKm *= 2
+	}
+
+	kmCost := paidKm * cfg.PricePerKm
+	subtotal := service.VisitFee + kmCost
+
+	resp := OnSiteCalcResponse{
+		DistanceKm:   distKm,
+		FreeRadiusKm: cfg.FreeRadiusKm,
+		PaidKm:       paidKm,
+		Service:      service.Name,
+		VisitFee:     service.VisitFee,
+		PriceKm:      kmCost,
+		Route:        route,
+	}
+	if urgency != nil {
+		resp.Urgency = urgency.Name
+		resp.Surcharge = subtotal*urgency.SurchargePercent/100 + urgency.SurchargeFixed
+	}
+	resp.PriceTotal = subtotal + resp.Surcharge
+
+	// инкрементируем счётчик расчётов и уведомляем владельца, если передан calculatorId
+	if req.CalculatorID != "" {
+		e.IncrementCalcCount(req.CalculatorID)
+
+		e.NotifyTelegramOnSiteCalc(
+			r.Context(),
+			req.CalculatorID,
+			req.Address,
+			resp.Service,
+			resp.Urgency,
+			resp.DistanceKm,
+			resp.PriceTotal,
+		)
+	}
+
+	e.writeJSON(w, resp)
+}
@@ -0,0 +1,269 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"saas-calc-backend/internal/domain"
+	"saas-calc-backend/internal/mail"
+)
+
+type inviteMemberRequest struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+// listOrgInvites — действующие приглашения по условию where (по организации или по email).
+func (e *Env) listOrgInvites(ctx context.Context, where string, args ...interface{}) ([]*domain.OrgInvite, error) {
+	rows, err := e.DB.QueryContext(ctx, `
+SELECT i.org_id, o.name, i.email, i.role, COALESCE(u.email, ''), i.created_at, i.expires_at
+FROM org_invites i
+JOIN organizations o ON o.id = i.org_id
+LEFT JOIN users u ON u.id = i.invited_by
+WHERE `+where+` AND i.expires_at > now()
+ORDER BY i.created_at DESC
+`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make([]*domain.OrgInvite, 0)
+	for rows.Next() {
+		var inv domain.OrgInvite
+		var role string
+		if err := rows.Scan(&inv.OrgID, &inv.OrgName, &inv.Email, &role, &inv.InvitedBy, &inv.CreatedAt, &inv.ExpiresAt); err != nil {
+			return nil, err
+		}
+		inv.Role = domain.OrgRole(role)
+		res = append(res, &inv)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// --- POST /api/orgs/{id}/members { email, role } ---
+
+// Участник не добавляется сразу: по email создаётся приглашение, которое он должен принять.
+// Ответ не зависит от того, зарегистрирован ли адрес, — так нельзя проверять, чьи email есть в сервисе.
+func (e *Env) handleInviteOrgMember(w http.ResponseWriter, r *http.Request, u *domain.User, orgID string, role domain.OrgRole) {
+	if !role.AtLeast(domain.OrgRoleOwner) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	defer r.Body.Close()
+
+	var req inviteMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	memberRole := domain.OrgRole(req.Role)
+	if !domain.ValidOrgRole(memberRole) {
+		http.Error(w, "invalid role", http.StatusBadRequest)
+		return
+	}
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+	if !looksLikeEmail(email) {
+		http.Error(w, "invalid email", http.StatusBadRequest)
+		return
+	}
+
+	// участники организации owner и так видит — тут ничего не раскрывается
+	var isMember bool
+	if err := e.DB.QueryRowContext(r.Context(), `
+SELECT EXISTS (
+    SELECT 1 FROM org_members m JOIN users u ON u.id = m.user_id
+    WHERE m.org_id = $1 AND lower(u.email) = $2
+)`, orgID, email).Scan(&isMember); err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if isMember {
+		http.Error(w, "user is already a member", http.StatusConflict)
+		return
+	}
+
+	// повторное приглашение обновляет роль и срок
+	if _, err := e.DB.ExecContext(r.Context(), `
+INSERT INTO org_invites (org_id, email, role, invited_by, expires_at)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (org_id, email) DO UPDATE
+SET role = EXCLUDED.role, invited_by = EXCLUDED.invited_by, created_at = now(), expires_at = EXCLUDED.expires_at
+`, orgID, email, string(memberRole), u.ID, time.Now().Add(domain.OrgInviteTTL)); err != nil {
+		http.Error(w, "db insert error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	o, err := e.GetOrg(r.Context(), orgID)
+	if err != nil || o == nil {
+		http.Error(w, "failed to load organization", http.StatusInternalServerError)
+		return
+	}
+	e.sendOrgInviteEmail(email, u.Email, o.Name)
+
+	invites, err := e.listOrgInvites(r.Context(), `i.org_id = $1`, orgID)
+	if err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"items": invites})
+}
+
+// sendOrgInviteEmail отправляет приглашение в фоне. Частые письма на один адрес молча пропускаем.
+func (e *Env) sendOrgInviteEmail(to, from, orgName string) {
+	if e.MailLimit != nil {
+		if ok, _ := e.MailLimit.Allow("org-invite:" + to); !ok {
+			return
+		}
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		e.sendMail(ctx, mail.Message{
+			To:      to,
+			Subject: "Приглашение в организацию",
+			Body: "Здравствуйте!\n\n" +
+				from + " приглашает вас в организацию «" + orgName + "».\n" +
+				"Принять или отклонить приглашение можно в кабинете (войдите или зарегистрируйтесь с этим email):\n" +
+				e.absoluteURL("/app") + "\n\n" +
+				"Приглашение действует 7 дней.",
+		})
+	}()
+}
+
+// --- DELETE /api/orgs/{id}/invites/{email} ---
+func (e *Env) handleRevokeOrgInvite(w http.ResponseWriter, r *http.Request, orgID string, role domain.OrgRole, email string) {
+	if !role.AtLeast(domain.OrgRoleOwner) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	res, err := e.DB.ExecContext(r.Context(),
+		`DELETE FROM org_invites WHERE org_id = $1 AND email = lower($2)`,
+		orgID, strings.TrimSpace(email),
+	)
+	if err != nil {
+		http.Error(w, "db delete error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "invite not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// --- /api/orgs/invites/... ---
+
+// GET  /api/orgs/invites                  -> приглашения на мой email, которые ждут ответа
+// POST /api/orgs/invites/{orgId}/accept   -> вступить в организацию
+// POST /api/orgs/invites/{orgId}/decline  -> отказаться
+func (e *Env) handleMyOrgInvites(w http.ResponseWriter, r *http.Request, u *domain.User, parts []string) {
+	if len(parts) == 0 {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		invites, err := e.listOrgInvites(r.Context(), `i.email = lower($1)`, u.Email)
+		if err != nil {
+			http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		e.writeJSON(w, map[string]interface{}{"items": invites})
+		return
+	}
+	if len(parts) != 2 || (parts[1] != "accept" && parts[1] != "decline") {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	orgID := parts[0]
+
+	if parts[1] == "decline" {
+		res, err := e.DB.ExecContext(r.Context(),
+			`DELETE FROM org_invites WHERE org_id = $1 AND email = lower($2)`,
+			orgID, u.Email,
+		)
+		if err != nil {
+			http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			http.Error(w, "invite not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// приглашение адресовано email — принять его может только тот, кто этим адресом владеет
+	if !u.EmailConfirmed {
+		http.Error(w, "confirm your email to accept the invite", http.StatusForbidden)
+		return
+	}
+
+	var found, expired bool
+	err := e.inTx(r.Context(), func(tx *sql.Tx) error {
+		var role string
+		var expiresAt time.Time
+		err := tx.QueryRowContext(r.Context(),
+			`DELETE FROM org_invites WHERE org_id = $1 AND email = lower($2) RETURNING role, expires_at`,
+			orgID, u.Email,
+		).Scan(&role, &expiresAt)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		found = true
+		// просроченное приглашение просто удаляем
+		if !expiresAt.After(time.Now()) {
+			expired = true
+			return nil
+		}
+		_, err = tx.ExecContext(r.Context(), `
+INSERT INTO org_members (org_id, user_id, role)
+VALUES ($1, $2, $3)
+ON CONFLICT (org_id, user_id) DO NOTHING
+`, orgID, u.ID, role)
+		return err
+	})
+	if err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "invite not found", http.StatusNotFound)
+		return
+	}
+	if expired {
+		http.Error(w, "invite has expired", http.StatusGone)
+		return
+	}
+
+	o, err := e.GetOrg(r.Context(), orgID)
+	if err != nil || o == nil {
+		http.Error(w, "failed to load organization", http.StatusInternalServerError)
+		return
+	}
+	if o.Role, err = e.orgRole(r.Context(), u, orgID); err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	e.writeJSON(w, o)
+}
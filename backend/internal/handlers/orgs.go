@@ -0,0 +1,443 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"saas-calc-backend/internal/domain"
+)
+
+type createOrgRequest struct {
+	Name string `json:"name"`
+}
+
+type updateOrgRequest struct {
+	Name   string `json:"name"`
+	PlanID string `json:"planId"`
+}
+
+type updateMemberRequest struct {
+	Role string `json:"role"`
+}
+
+// ответ на GET /api/orgs/{id}
+type orgDetailResponse struct {
+	*domain.Organization
+	Plan           *domain.Plan        `json:"plan,omitempty"`
+	Members        []*domain.OrgMember `json:"members"`
+	Invites        []*domain.OrgInvite `json:"invites,omitempty"` // ждут ответа; видны только owner
+	CalculatorsCnt int                 `json:"calculatorsCount"`
+}
+
+// /api/orgs                          GET (мои организации), POST (создать)
+// /api/orgs/invites[/{orgId}/...]    приглашения мне: список, принять / отклонить (см. handleMyOrgInvites)
+// /api/orgs/{id}                     GET, PUT (имя; тариф — только администратор), DELETE
+// /api/orgs/{id}/members             POST (пригласить участника по email)
+// /api/orgs/{id}/members/{userId}    PUT (сменить роль), DELETE (исключить / выйти)
+// /api/orgs/{id}/invites/{email}     DELETE (отозвать приглашение)
+// /api/orgs/{id}/folders[/{folderId}] папки калькуляторов (см. handleOrgFolders)
+// /api/orgs/{id}/tags                GET (метки калькуляторов организации)
+func (e *Env) HandleOrgs(w http.ResponseWriter, r *http.Request) {
+	u := e.requireUser(w, r)
+	if u == nil {
+		return
+	}
+	if e.DB == nil {
+		http.Error(w, "db is nil", http.StatusInternalServerError)
+		return
+	}
+
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/orgs"), "/")
+	if rest == "" {
+		switch r.Method {
+		case http.MethodGet:
+			e.handleListOrgs(w, r, u)
+		case http.MethodPost:
+			e.handleCreateOrg(w, r, u)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	parts := strings.Split(rest, "/")
+	if parts[0] == "invites" {
+		e.handleMyOrgInvites(w, r, u, parts[1:])
+		return
+	}
+	orgID := parts[0]
+
+	role, err := e.orgRole(r.Context(), u, orgID)
+	if err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if role == "" {
+		http.Error(w, "organization not found", http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case len(parts) == 1:
+		switch r.Method {
+		case http.MethodGet:
+			e.handleGetOrg(w, r, orgID, role)
+		case http.MethodPut:
+			e.handleUpdateOrg(w, r, u, orgID, role)
+		case http.MethodDelete:
+			e.handleDeleteOrg(w, r, orgID, role)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+
+	case len(parts) == 2 && parts[1] == "members":
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		e.handleInviteOrgMember(w, r, u, orgID, role)
+
+	case len(parts) == 3 && parts[1] == "members":
+		memberID := parts[2]
+		switch r.Method {
+		case http.MethodPut:
+			e.handleUpdateOrgMember(w, r, orgID, role, memberID)
+		case http.MethodDelete:
+			e.handleRemoveOrgMember(w, r, u, orgID, role, memberID)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+
+	case len(parts) == 3 && parts[1] == "invites":
+		if r.Method != http.MethodDelete {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		e.handleRevokeOrgInvite(w, r, orgID, role, parts[2])
+
+	case len(parts) <= 3 && parts[1] == "folders":
+		folderID := ""
+		if len(parts) == 3 {
+			folderID = parts[2]
+		}
+		e.handleOrgFolders(w, r, orgID, role, folderID)
+
+	case len(parts) == 2 && parts[1] == "tags":
+		e.handleOrgTags(w, r, orgID)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (e *Env) handleListOrgs(w http.ResponseWriter, r *http.Request, u *domain.User) {
+	orgs, err := e.ListUserOrgs(r.Context(), u.ID)
+	if err != nil {
+		http.Error(w, "failed to list organizations: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	e.writeJSON(w, map[string]interface{}{"items": orgs})
+}
+
+// maxOrgsPerUser — сколько организаций (кроме личной) может создать пользователь.
+// Иначе лимиты тарифа обходятся созданием новых организаций.
+const maxOrgsPerUser = 3
+
+// новая организация стартует на самом дешёвом тарифе, создатель — owner
+func (e *Env) handleCreateOrg(w http.ResponseWriter, r *http.Request, u *domain.User) {
+	defer r.Body.Close()
+
+	if u.Role != domain.RoleAdmin {
+		var created int
+		if err := e.DB.QueryRowContext(r.Context(),
+			`SELECT COUNT(*) FROM organizations WHERE created_by = $1`,
+			u.ID,
+		).Scan(&created); err != nil {
+			http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if created >= maxOrgsPerUser {
+			http.Error(w, "organization limit reached", http.StatusForbidden)
+			return
+		}
+	}
+
+	var req createOrgRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+
+	plans := e.Plans
+	if len(plans) == 0 {
+		plans = domain.DefaultPlans()
+	}
+	plan := domain.CheapestPlan(plans)
+	if plan == nil {
+		http.Error(w, "no plans configured", http.StatusInternalServerError)
+		return
+	}
+
+	suffix, err := randomHex(8)
+	if err != nil {
+		http.Error(w, "failed to generate id: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	o := &domain.Organization{
+		ID:         "org_" + suffix,
+		Name:       name,
+		PlanID:     plan.ID,
+		PlanActive: true,
+		CreatedAt:  time.Now(),
+		Role:       domain.OrgRoleOwner,
+	}
+
+	_, err = e.DB.ExecContext(r.Context(), `
+WITH o AS (
+    INSERT INTO organizations (id, name, plan_id, plan_active, created_at, created_by)
+    VALUES ($1, $2, $3, $4, $5, $6)
+    RETURNING id
+)
+INSERT INTO org_members (org_id, user_id, role)
+SELECT id, $6, 'owner' FROM o
+`, o.ID, o.Name, o.PlanID, o.PlanActive, o.CreatedAt, u.ID)
+	if err != nil {
+		http.Error(w, "db insert error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(o)
+}
+
+func (e *Env) handleGetOrg(w http.ResponseWriter, r *http.Request, orgID string, role domain.OrgRole) {
+	o, err := e.GetOrg(r.Context(), orgID)
+	if err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if o == nil {
+		http.Error(w, "organization not found", http.StatusNotFound)
+		return
+	}
+	o.Role = role
+
+	members, err := e.ListOrgMembers(r.Context(), orgID)
+	if err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	cnt, err := e.countOrgCalculators(r.Context(), orgID)
+	if err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var invites []*domain.OrgInvite
+	if role.AtLeast(domain.OrgRoleOwner) {
+		if invites, err = e.listOrgInvites(r.Context(), `i.org_id = $1`, orgID); err != nil {
+			http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	e.writeJSON(w, orgDetailResponse{
+		Organization:   o,
+		Plan:           domain.FindPlan(e.Plans, o.PlanID),
+		Members:        members,
+		Invites:        invites,
+		CalculatorsCnt: cnt,
+	})
+}
+
+func (e *Env) handleUpdateOrg(w http.ResponseWriter, r *http.Request, u *domain.User, orgID string, role domain.OrgRole) {
+	if !role.AtLeast(domain.OrgRoleOwner) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	defer r.Body.Close()
+
+	var req updateOrgRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	o, err := e.GetOrg(r.Context(), orgID)
+	if err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if o == nil {
+		http.Error(w, "organization not found", http.StatusNotFound)
+		return
+	}
+
+	if name := strings.TrimSpace(req.Name); name != "" {
+		o.Name = name
+	}
+	if req.PlanID != "" && req.PlanID != o.PlanID {
+		// оплаты в сервисе нет: тариф меняет только администратор
+		if u.Role != domain.RoleAdmin {
+			http.Error(w, "plan can be changed only by an administrator", http.StatusForbidden)
+			return
+		}
+		if domain.FindPlan(e.Plans, req.PlanID) == nil {
+			http.Error(w, "unknown planId", http.StatusBadRequest)
+			return
+		}
+		o.PlanID = req.PlanID
+		// как и в /api/me/plan: при смене тариф активируется
+		o.PlanActive = true
+	}
+
+	if _, err := e.DB.ExecContext(r.Context(), `
+UPDATE organizations
+SET name = $1, plan_id = $2, plan_active = $3
+WHERE id = $4
+`, o.Name, o.PlanID, o.PlanActive, o.ID); err != nil {
+		http.Error(w, "db update error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// тариф личной организации дублируется в users.plan_id
+	if o.PersonalFor != "" {
+		if _, err := e.DB.ExecContext(r.Context(),
+			`UPDATE users SET plan_id = $1, plan_active = $2 WHERE id = $3`,
+			o.PlanID, o.PlanActive, o.PersonalFor,
+		); err != nil {
+			http.Error(w, "db update error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	o.Role = role
+	e.writeJSON(w, o)
+}
+
+func (e *Env) handleDeleteOrg(w http.ResponseWriter, r *http.Request, orgID string, role domain.OrgRole) {
+	if !role.AtLeast(domain.OrgRoleOwner) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	o, err := e.GetOrg(r.Context(), orgID)
+	if err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if o == nil {
+		http.Error(w, "organization not found", http.StatusNotFound)
+		return
+	}
+	if o.PersonalFor != "" {
+		http.Error(w, "personal organization cannot be deleted", http.StatusBadRequest)
+		return
+	}
+
+	// калькуляторы организации удалятся каскадом
+	if _, err := e.DB.ExecContext(r.Context(), `DELETE FROM organizations WHERE id = $1`, orgID); err != nil {
+		http.Error(w, "db delete error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (e *Env) handleUpdateOrgMember(w http.ResponseWriter, r *http.Request, orgID string, role domain.OrgRole, memberID string) {
+	if !role.AtLeast(domain.OrgRoleOwner) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	defer r.Body.Close()
+
+	var req updateMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	newRole := domain.OrgRole(req.Role)
+	if !domain.ValidOrgRole(newRole) {
+		http.Error(w, "invalid role", http.StatusBadRequest)
+		return
+	}
+
+	if newRole != domain.OrgRoleOwner {
+		// создатель личной организации остаётся её owner
+		if orgID == domain.PersonalOrgID(memberID) {
+			http.Error(w, "owner of a personal organization cannot be demoted", http.StatusBadRequest)
+			return
+		}
+		if !e.keepsAnotherOwner(w, r, orgID, memberID) {
+			return
+		}
+	}
+
+	res, err := e.DB.ExecContext(r.Context(),
+		`UPDATE org_members SET role = $1 WHERE org_id = $2 AND user_id = $3`,
+		string(newRole), orgID, memberID,
+	)
+	if err != nil {
+		http.Error(w, "db update error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "member not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// исключить участника может owner, выйти сам — любой участник
+func (e *Env) handleRemoveOrgMember(w http.ResponseWriter, r *http.Request, u *domain.User, orgID string, role domain.OrgRole, memberID string) {
+	if memberID != u.ID && !role.AtLeast(domain.OrgRoleOwner) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if orgID == domain.PersonalOrgID(memberID) {
+		http.Error(w, "cannot leave personal organization", http.StatusBadRequest)
+		return
+	}
+	if !e.keepsAnotherOwner(w, r, orgID, memberID) {
+		return
+	}
+
+	res, err := e.DB.ExecContext(r.Context(),
+		`DELETE FROM org_members WHERE org_id = $1 AND user_id = $2`,
+		orgID, memberID,
+	)
+	if err != nil {
+		http.Error(w, "db delete error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "member not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// keepsAnotherOwner — после понижения/исключения memberID в организации останется хотя бы один owner.
+func (e *Env) keepsAnotherOwner(w http.ResponseWriter, r *http.Request, orgID, memberID string) bool {
+	var others int
+	if err := e.DB.QueryRowContext(r.Context(), `
+SELECT COUNT(*)
+FROM org_members
+WHERE org_id = $1 AND role = 'owner' AND user_id <> $2
+`, orgID, memberID).Scan(&others); err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	if others == 0 {
+		http.Error(w, "organization must keep at least one owner", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"saas-calc-backend/internal/domain"
+)
+
+// ListUserOrgs — организации, в которых состоит пользователь, с его ролью.
+func (e *Env) ListUserOrgs(ctx context.Context, userID string) ([]*domain.Organization, error) {
+	if e.DB == nil {
+		return nil, errors.New("db is nil")
+	}
+
+	rows, err := e.DB.QueryContext(ctx, `
+SELECT o.id, o.name, o.plan_id, o.plan_active, COALESCE(o.personal_for, ''), o.created_at, m.role
+FROM organizations o
+JOIN org_members m ON m.org_id = o.id
+WHERE m.user_id = $1
+ORDER BY (o.personal_for IS NULL), o.created_at
+`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make([]*domain.Organization, 0)
+	for rows.Next() {
+		var o domain.Organization
+		var role string
+		if err := rows.Scan(
+			&o.ID,
+			&o.Name,
+			&o.PlanID,
+			&o.PlanActive,
+			&o.PersonalFor,
+			&o.CreatedAt,
+			&role,
+		); err != nil {
+			return nil, err
+		}
+		o.Role = domain.OrgRole(role)
+		res = append(res, &o)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// GetOrg достаёт организацию по id (nil, если нет).
+func (e *Env) GetOrg(ctx context.Context, id string) (*domain.Organization, error) {
+	if e.DB == nil {
+		return nil, errors.New("db is nil")
+	}
+
+	var o domain.Organization
+	err := e.DB.QueryRowContext(ctx, `
+SELECT id, name, plan_id, plan_active, COALESCE(personal_for, ''), created_at
+FROM organizations
+WHERE id = $1
+`, id).Scan(
+		&o.ID,
+		&o.Name,
+		&o.PlanID,
+		&o.PlanActive,
+		&o.PersonalFor,
+		&o.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &o, nil
+}
+
+// orgRole — роль пользователя в организации ("" — не участник).
+// Администратор системы считается владельцем любой организации.
+func (e *Env) orgRole(ctx context.Context, u *domain.User, orgID string) (domain.OrgRole, error) {
+	if u == nil {
+		return "", nil
+	}
+	if u.Role == domain.RoleAdmin {
+		return domain.OrgRoleOwner, nil
+	}
+	if e.DB == nil {
+		return "", errors.New("db is nil")
+	}
+
+	var role string
+	err := e.DB.QueryRowContext(ctx,
+		`SELECT role FROM org_members WHERE org_id = $1 AND user_id = $2`,
+		orgID, u.ID,
+	).Scan(&role)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil
+		}
+		return "", err
+	}
+	return domain.OrgRole(role), nil
+}
+
+// ListOrgMembers — участники организации.
+func (e *Env) ListOrgMembers(ctx context.Context, orgID string) ([]*domain.OrgMember, error) {
+	if e.DB == nil {
+		return nil, errors.New("db is nil")
+	}
+
+	rows, err := e.DB.QueryContext(ctx, `
+SELECT m.org_id, m.user_id, u.email, u.name, m.role, m.created_at
+FROM org_members m
+JOIN users u ON u.id = m.user_id
+WHERE m.org_id = $1
+ORDER BY m.created_at
+`, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make([]*domain.OrgMember, 0)
+	for rows.Next() {
+		var m domain.OrgMember
+		var role string
+		if err := rows.Scan(&m.OrgID, &m.UserID, &m.Email, &m.Name, &role, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		m.Role = domain.OrgRole(role)
+		res = append(res, &m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// createPersonalOrg создаёт личную организацию нового пользователя
+// с его тарифом и делает его владельцем.
+func (e *Env) createPersonalOrg(ctx context.Context, tx *sql.Tx, u *domain.User) error {
+	_, err := tx.ExecContext(ctx, `
+WITH o AS (
+    INSERT INTO organizations (id, name, plan_id, plan_active, personal_for)
+    VALUES ($1, $2, $3, $4, $5)
+    RETURNING id
+)
+INSERT INTO org_members (org_id, user_id, role)
+SELECT id, $5, 'owner' FROM o
+`,
+		domain.PersonalOrgID(u.ID),
+		u.Name,
+		u.PlanID,
+		u.PlanActive,
+		u.ID,
+	)
+	return err
+}
+
+// setPersonalOrgPlan синхронизирует тариф личной организации с users.plan_id.
+func (e *Env) setPersonalOrgPlan(ctx context.Context, userID, planID string, active bool) error {
+	if e.DB == nil {
+		return errors.New("db is nil")
+	}
+	_, err := e.DB.ExecContext(ctx, `
+UPDATE organizations
+SET plan_id = $1, plan_active = $2
+WHERE personal_for = $3
+`, planID, active, userID)
+	return err
+}
+
+// countOrgCalculators — сколько калькуляторов уже есть у организации (для лимита тарифа).
+// Калькуляторы в корзине не считаются: при восстановлении лимит проверяется заново.
+func (e *Env) countOrgCalculators(ctx context.Context, orgID string) (int, error) {
+	if e.DB == nil {
+		return 0, errors.New("db is nil")
+	}
+	var n int
+	err := e.DB.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM calculators WHERE org_id = $1 AND deleted_at IS NULL`,
+		orgID,
+	).Scan(&n)
+	return n, err
+}
@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"saas-calc-backend/internal/mail"
+)
+
+// resetPasswordTTL — сколько живёт ссылка сброса пароля.
+const resetPasswordTTL = time.Hour
+
+type forgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+type resetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// POST /api/auth/password/forgot { "email": "..." }
+//
+// Всегда отвечает 204, чтобы по ответу нельзя было понять, есть ли такой email.
+func (e *Env) HandleAuthPasswordForgot(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if e.DB == nil {
+		http.Error(w, "db is nil", http.StatusInternalServerError)
+		return
+	}
+	defer r.Body.Close()
+
+	var req forgotPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	email := strings.TrimSpace(req.Email)
+	if email == "" {
+		http.Error(w, "email is required", http.StatusBadRequest)
+		return
+	}
+
+	var userID, name string
+	err := e.DB.QueryRowContext(r.Context(),
+		`SELECT id, name FROM users WHERE lower(email) = lower($1)`,
+		email,
+	).Scan(&userID, &name)
+	switch {
+	case err == nil:
+		// письмо уходит в фоне: время ответа не должно выдавать, есть ли такой аккаунт.
+		// Частые запросы на один адрес молча пропускаем — ответ тот же, что и для чужого email.
+		if e.MailLimit != nil {
+			if ok, _ := e.MailLimit.Allow("reset:" + userID); !ok {
+				break
+			}
+		}
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+			e.sendResetPasswordEmail(ctx, userID, name, email)
+		}()
+	case !errors.Is(err, sql.ErrNoRows):
+		log.Printf("password forgot: lookup %s: %v", email, err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// POST /api/auth/password/reset { "token": "...", "password": "..." }
+//
+// Ставит новый пароль и завершает все сессии пользователя.
+func (e *Env) HandleAuthPasswordReset(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	defer r.Body.Close()
+
+	var req resetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.Password) < minPasswordLength {
+		http.Error(w, "password is too short", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := e.consumeUserToken(r.Context(), req.Token, tokenKindResetPassword)
+	if err != nil {
+		http.Error(w, "failed to check token: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if userID == "" {
+		http.Error(w, "invalid or expired token", http.StatusBadRequest)
+		return
+	}
+
+	if err := e.SetUserPassword(r.Context(), userID, req.Password); err != nil {
+		http.Error(w, "failed to set password: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// остальные ссылки сброса больше не нужны
+	if err := e.revokeUserTokens(r.Context(), userID, tokenKindResetPassword); err != nil {
+		log.Printf("password reset: revoke tokens for %s: %v", userID, err)
+	}
+	if err := e.DeleteUserSessions(r.Context(), userID); err != nil {
+		http.Error(w, "failed to reset sessions: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	clearSessionCookie(w, r)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (e *Env) sendResetPasswordEmail(ctx context.Context, userID, name, email string) {
+	token, err := e.issueUserToken(ctx, userID, tokenKindResetPassword, resetPasswordTTL)
+	if err != nil {
+		log.Printf("password forgot: issue token for %s: %v", userID, err)
+		return
+	}
+
+	link := e.absoluteURL("/app?resetToken=" + url.QueryEscape(token))
+	e.sendMail(ctx, mail.Message{
+		To:      email,
+		Subject: "Сброс пароля",
+		Body: "Здравствуйте, " + name + "!\n\n" +
+			"Кто-то (возможно, вы) запросил сброс пароля. Чтобы задать новый пароль, перейдите по ссылке:\n" +
+			link + "\n\n" +
+			"Ссылка действует 1 час. Если вы не запрашивали сброс — просто проигнорируйте письмо.",
+	})
+}
+
+// DeleteUserSessions завершает все сессии пользователя.
+func (e *Env) DeleteUserSessions(ctx context.Context, userID string) error {
+	if e.DB == nil {
+		return errors.New("db is nil")
+	}
+	_, err := e.DB.ExecContext(ctx, `DELETE FROM sessions WHERE user_id = $1`, userID)
+	return err
+}
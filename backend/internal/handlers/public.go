@@ -22,31 +22,81 @@ func (e *Env) HandlePublicCalculatorPage(w http.ResponseWriter, r *http.Request)
 	ownerID := parts[0]
 	token := parts[1]
 
-	var calc *domain.Calculator
-	for _, c := range e.Calculators {
-		if c.OwnerID == ownerID && c.PublicToken == token {
-			calc = c
-			break
-		}
+	calc, err := e.findPublicCalculator(r.Context(), ownerID, token)
+	if err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
 	}
+	e.servePublicCalculator(w, r, calc, domain.TokenPublicPath(ownerID, token))
+}
 
-	if calc == nil {
+// /c/{slug}
+func (e *Env) HandlePublicSlugPage(w http.ResponseWriter, r *http.Request) {
+	slug := strings.TrimPrefix(r.URL.Path, "/c/")
+	if slug == "" || strings.Contains(slug, "/") {
 		http.NotFound(w, r)
 		return
 	}
 
+	calc, err := e.findCalculatorBySlug(r.Context(), slug)
+	if err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	e.servePublicCalculator(w, r, calc, domain.SlugPublicPath(domain.NormalizeSlug(slug)))
+}
+
+// servePublicCalculator отдаёт публичную страницу калькулятора.
+// calc == nil — по адресу path калькулятора нет: пробуем переадресацию со старого адреса.
+func (e *Env) servePublicCalculator(w http.ResponseWriter, r *http.Request, calc *domain.Calculator, path string) {
+	if calc == nil {
+		redirected, err := e.redirectOldPublicPath(w, r, path)
+		if err != nil {
+			http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !redirected {
+			http.NotFound(w, r)
+		}
+		return
+	}
+
+	// ?preview=<токен предпросмотра> открывает черновик и архив (для владельца и коллег)
+	// и показывает черновик конфига вместо опубликованного снимка
+	previewToken := r.URL.Query().Get("preview")
+	preview := previewTokenValid(calc, previewToken)
+	if !preview {
+		previewToken = ""
+	}
+
+	if !preview {
+		switch e.publicCalcState(r.Context(), calc) {
+		case calcHidden:
+			http.NotFound(w, r)
+			return
+		case calcUnavailable:
+			renderPublicUnavailable(w)
+			return
+		}
+	} else {
+		// предпросмотр не должен попадать в поисковики
+		w.Header().Set("X-Robots-Tag", "noindex")
+	}
+
 	// 🔢 НОВОЕ: учитываем открытие публичной страницы layer-калькулятора
 	// distance уже считает реальные расчёты через /api/distance/calc,
 	// поэтому здесь специально ограничиваемся только layered.
-	if calc.Type == domain.CalculatorTypeLayered {
+	// Предпросмотр в тариф не засчитываем.
+	if calc.Type == domain.CalculatorTypeLayered && !preview {
 		e.IncrementCalcCount(calc.ID)
 	}
 
 	switch calc.Type {
 	case domain.CalculatorTypeLayered:
-		cfg := e.LayeredConfig
-		if cfg == nil {
-			cfg = domain.NewDefaultLayeredConfig()
+		cfg, err := e.layeredConfigFor(r.Context(), calc.ID, !preview)
+		if err != nil {
+			configLoadFailed(w, err)
+			return
 		}
 
 		cfgJSON, err := json.Marshal(cfg)
@@ -59,9 +109,33 @@ func (e *Env) HandlePublicCalculatorPage(w http.ResponseWriter, r *http.Request)
 
 	case domain.CalculatorTypeDistance:
 		// публичный виджет расчёта доставки
-		renderDistancePublic(w, calc)
+		renderDistancePublic(w, calc, previewToken)
 	case domain.CalculatorTypeMortgage: 
-	renderMortgagePublic(w, calc)
+	renderMortgagePublic(w, calc, previewToken)
+
+	case domain.CalculatorTypeOnSite:
+		cfg, err := e.onSiteConfigFor(r.Context(), calc.ID, !preview)
+		if err != nil {
+			configLoadFailed(w, err)
+			return
+		}
+		renderOnSitePublic(w, calc, cfg, previewToken)
+
+	case domain.CalculatorTypeFormula:
+		cfg, err := e.formulaConfigFor(r.Context(), calc.ID, !preview)
+		if err != nil {
+			configLoadFailed(w, err)
+			return
+		}
+		renderFormulaPublic(w, calc, cfg, previewToken)
+
+	case domain.CalculatorTypeForm:
+		cfg, err := e.formConfigFor(r.Context(), calc.ID, !preview)
+		if err != nil {
+			configLoadFailed(w, err)
+			return
+		}
+		renderFormPublic(w, calc, cfg, previewToken)
 
 	default:
 		// простая заглушка для остальных типов
@@ -70,7 +144,8 @@ func (e *Env) HandlePublicCalculatorPage(w http.ResponseWriter, r *http.Request)
 }
 
 // публичный виджет для ипотечного калькулятора
-func renderMortgagePublic(w http.ResponseWriter, calc *domain.Calculator) {
+// previewToken непустой только на странице предпросмотра — тогда можно считать и непубличный калькулятор.
+func renderMortgagePublic(w http.ResponseWriter, calc *domain.Calculator, previewToken string) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 
 	name := calc.Name
@@ -250,6 +325,7 @@ func renderMortgagePublic(w http.ResponseWriter, calc *domain.Calculator) {
   <script>
     (function() {
       const calculatorId = %q;
+      const previewToken = %q;
 
       function formatMoney(num) {
         return Math.round(num).toLocaleString('ru-RU') + ' ₽';
@@ -301,7 +377,8 @@ func renderMortgagePublic(w http.ResponseWriter, calc *domain.Calculator) {
                 amount: amount,
                 rate: rate,
                 years: years,
-                calculatorId: calculatorId
+                calculatorId: calculatorId,
+                previewToken: previewToken
               })
             });
 
@@ -341,9 +418,65 @@ func renderMortgagePublic(w http.ResponseWriter, calc *domain.Calculator) {
 		escName,
 		idHTML,
 		idJS,
+		previewToken,
 	)
 }
 
+// калькулятор есть, но не опубликован (черновик или архив)
+func renderPublicUnavailable(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusNotFound)
+	fmt.Fprint(w, `<!DOCTYPE html>
+<html lang="ru">
+<head>
+	<meta charset="utf-8">
+	<title>Калькулятор недоступен</title>
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+	<meta name="robots" content="noindex">
+	<style>
+		body {
+			margin: 0;
+			font-family: system-ui, -apple-system, BlinkMacSystemFont, "Segoe UI", sans-serif;
+			background: #f3f4f6;
+			color: #111827;
+		}
+		.wrapper {
+			min-height: 100vh;
+			display: flex;
+			align-items: center;
+			justify-content: center;
+			padding: 24px;
+		}
+		.card {
+			background: #ffffff;
+			border-radius: 16px;
+			box-shadow: 0 20px 45px rgba(15, 23, 42, 0.18);
+			max-width: 480px;
+			width: 100%;
+			padding: 24px;
+			text-align: center;
+		}
+		h1 {
+			font-size: 20px;
+			margin: 0 0 8px 0;
+		}
+		p {
+			margin: 4px 0;
+			color: #6b7280;
+		}
+	</style>
+</head>
+<body>
+	<div class="wrapper">
+		<div class="card">
+			<h1>Калькулятор недоступен</h1>
+			<p>Владелец ещё не опубликовал этот калькулятор или снял его с публикации.</p>
+		</div>
+	</div>
+</body>
+</html>`)
+}
+
 // простая заглушка для других типов
 func renderPublicStub(w http.ResponseWriter, calc *domain.Calculator) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
@@ -803,7 +936,8 @@ func renderLayeredPublic(w http.ResponseWriter, calc *domain.Calculator, cfgJSON
 }
 
 // публичный виджет для калькулятора доставки (distance)
-func renderDistancePublic(w http.ResponseWriter, calc *domain.Calculator) {
+// previewToken непустой только на странице предпросмотра — тогда расчёт идёт по черновику конфига.
+func renderDistancePublic(w http.ResponseWriter, calc *domain.Calculator, previewToken string) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 
 	name := calc.Name
@@ -1074,6 +1208,7 @@ func renderDistancePublic(w http.ResponseWriter, calc *domain.Calculator) {
   <script>
     (function() {
       const calculatorId = %q;
+      const previewToken = %q;
 
       function formatMoney(num) {
         return Math.round(num).toLocaleString('ru-RU') + ' ₽';
@@ -1166,7 +1301,8 @@ func renderDistancePublic(w http.ResponseWriter, calc *domain.Calculator) {
               to: to,
               vehicle: vehicleSelect.value,
               roundTrip: roundtripInput.checked,
-              calculatorId: calculatorId
+              calculatorId: calculatorId,
+              previewToken: previewToken
             };
 
             const res = await fetch('/api/distance/calc', {
@@ -1227,5 +1363,1335 @@ func renderDistancePublic(w http.ResponseWriter, calc *domain.Calculator) {
 		escName,
 		idHTML,
 		idJS,
+		previewToken,
+	)
+}
+
+// onSitePublicOptions — то, что виджету выезда нужно знать о конфиге (без координат базы).
+type onSitePublicOptions struct {
+	BaseAddress  string                 `json:"baseAddress"`
+	FreeRadiusKm float64                `json:"freeRadiusKm"`
+	Services     []domain.OnSiteService `json:"services"`
+	Urgencies    []domain.OnSiteUrgency `json:"urgencies"`
+}
+
+// публичный виджет для калькулятора выезда специалиста (on_site)
+// Услуги и срочности берутся из конфига, сам расчёт — через /api/onsite/calc.
+// previewToken непустой только на странице предпросмотра — тогда расчёт идёт по черновику конфига.
+func renderOnSitePublic(w http.ResponseWriter, calc *domain.Calculator, cfg *domain.OnSiteConfig, previewToken string) {
+	optsJSON, err := json.Marshal(onSitePublicOptions{
+		BaseAddress:  cfg.BaseAddress,
+		FreeRadiusKm: cfg.FreeRadiusKm,
+		Services:     cfg.Services,
+		Urgencies:    cfg.Urgencies,
+	})
+	if err != nil {
+		http.Error(w, "failed to marshal config", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	name := calc.Name
+	if strings.TrimSpace(name) == "" {
+		name = "Выезд специалиста"
+	}
+	escName := template.HTMLEscapeString(name)
+	idHTML := template.HTMLEscapeString(calc.ID)
+	idJS := template.JSEscapeString(calc.ID)
+
+	fmt.Fprintf(w, `<!doctype html>
+<html lang="ru">
+<head>
+  <meta charset="utf-8" />
+  <title>%s – калькулятор</title>
+  <meta name="viewport" content="width=device-width, initial-scale=1" />
+
+  <link
+    rel="stylesheet"
+    href="https://unpkg.com/leaflet@1.9.4/dist/leaflet.css"
+    integrity="sha256-p4NxAoJBhIIN+hmNHrzRCf9tD/miZyoHS5obTRR9BMY="
+    crossorigin=""
+  />
+
+  <style>
+    * { box-sizing: border-box; }
+
+    body {
+      margin: 0;
+      padding: 16px;
+      font-family: system-ui, -apple-system, BlinkMacSystemFont, "Segoe UI", sans-serif;
+      background: #f3f4f6;
+      color: #111827;
+    }
+
+    .widget-root {
+      max-width: 960px;
+      margin: 0 auto;
+    }
+
+    .card {
+      background: #ffffff;
+      border-radius: 16px;
+      padding: 16px 18px;
+      box-shadow: 0 10px 30px rgba(15,23,42,0.15);
+      margin-bottom: 16px;
+    }
+
+    .card-title {
+      font-size: 18px;
+      font-weight: 600;
+      margin-bottom: 4px;
+    }
+
+    .card-subtitle {
+      font-size: 13px;
+      color: #6b7280;
+      margin-bottom: 10px;
+    }
+
+    .badge {
+      display: inline-flex;
+      align-items: center;
+      border-radius: 999px;
+      padding: 2px 10px;
+      font-size: 11px;
+      background: #eef2ff;
+      color: #4f46e5;
+      margin-bottom: 8px;
+    }
+
+    .meta {
+      font-size: 11px;
+      color: #9ca3af;
+      margin-top: 6px;
+    }
+
+    .field {
+      margin-bottom: 10px;
+    }
+
+    .field-label {
+      display: block;
+      font-size: 13px;
+      margin-bottom: 4px;
+    }
+
+    input[type="text"],
+    select {
+      width: 100%%;
+      padding: 8px 10px;
+      border-radius: 10px;
+      border: 1px solid #d1d5db;
+      font-size: 14px;
+      outline: none;
+    }
+    input:focus, select:focus {
+      border-color: #6366f1;
+      box-shadow: 0 0 0 1px rgba(99,102,241,0.3);
+    }
+
+    .btn {
+      border-radius: 999px;
+      border: none;
+      padding: 8px 16px;
+      font-size: 14px;
+      cursor: pointer;
+      display: inline-flex;
+      align-items: center;
+      gap: 6px;
+    }
+    .btn-primary {
+      background: #4f46e5;
+      color: white;
+    }
+    .btn-primary:hover {
+      background: #4338ca;
+    }
+    .btn-secondary {
+      background: #e5e7eb;
+      color: #111827;
+    }
+
+    .result-box {
+      border-radius: 12px;
+      background: #f9fafb;
+      padding: 10px 12px;
+      margin-top: 10px;
+      font-size: 14px;
+    }
+
+    .result-row {
+      display: flex;
+      justify-content: space-between;
+      font-size: 13px;
+      margin-bottom: 4px;
+    }
+    .result-label {
+      color: #6b7280;
+    }
+    .result-value {
+      font-weight: 500;
+    }
+    .result-total {
+      margin-top: 6px;
+      font-size: 15px;
+      font-weight: 600;
+    }
+
+    .error-box {
+      margin-top: 8px;
+      padding: 8px 10px;
+      border-radius: 10px;
+      background: #fee2e2;
+      color: #b91c1c;
+      font-size: 13px;
+      display: none;
+    }
+
+    #onsite-map {
+      width: 100%%;
+      height: 320px;
+      margin-top: 10px;
+      border-radius: 14px;
+      overflow: hidden;
+    }
+
+    .map-caption {
+      font-size: 12px;
+      color: #9ca3af;
+      margin-top: 4px;
+    }
+  </style>
+</head>
+<body>
+  <div class="widget-root">
+    <div class="card">
+      <div class="badge">Публичная ссылка</div>
+      <div class="card-title">%s</div>
+      <div class="card-subtitle" id="onsite-subtitle">
+        Стоимость выезда специалиста на ваш объект.
+      </div>
+
+      <form id="onsite-form">
+        <div class="field">
+          <label class="field-label">Адрес объекта</label>
+          <input type="text" id="onsite-address" placeholder="Например, Химки, Ленинградская 5" />
+        </div>
+
+        <div class="field">
+          <label class="field-label">Услуга</label>
+          <select id="onsite-service"></select>
+        </div>
+
+        <div class="field" id="onsite-urgency-field">
+          <label class="field-label">Когда нужен выезд</label>
+          <select id="onsite-urgency"></select>
+        </div>
+
+        <div style="display:flex; gap:8px; align-items:center; margin-top:8px;">
+          <button type="submit" class="btn btn-primary">
+            <span>🧰</span>
+            <span>Рассчитать выезд</span>
+          </button>
+          <button type="button" id="onsite-reset" class="btn btn-secondary">Сбросить</button>
+        </div>
+      </form>
+
+      <div class="meta">
+        ID калькулятора: %s
+      </div>
+
+      <div id="onsite-error" class="error-box"></div>
+
+      <div id="onsite-result" class="result-box" style="display:none;">
+        <div class="result-row">
+          <div class="result-label">Расстояние до объекта</div>
+          <div class="result-value" id="onsite-distance">—</div>
+        </div>
+        <div class="result-row">
+          <div class="result-label" id="onsite-service-label">Выезд</div>
+          <div class="result-value" id="onsite-fee">—</div>
+        </div>
+        <div class="result-row">
+          <div class="result-label" id="onsite-km-label">Дорога за пределами бесплатного радиуса</div>
+          <div class="result-value" id="onsite-km">—</div>
+        </div>
+        <div class="result-row" id="onsite-surcharge-row" style="display:none;">
+          <div class="result-label" id="onsite-surcharge-label">Срочность</div>
+          <div class="result-value" id="onsite-surcharge">—</div>
+        </div>
+        <div class="result-total">
+          Итого ориентировочно: <span id="onsite-total">—</span>
+        </div>
+      </div>
+
+      <div id="onsite-map"></div>
+      <div class="map-caption">Маршрут и карта — на базе OpenStreetMap / Leaflet.</div>
+    </div>
+  </div>
+
+  <script
+    src="https://unpkg.com/leaflet@1.9.4/dist/leaflet.js"
+    integrity="sha256-20nQCchB9co0qIjJZRGuk2/Z9VM+kNiyxNV1lvTlZBo="
+    crossorigin=""
+  ></script>
+
+  <script>
+    (function() {
+      const calculatorId = %q;
+      const previewToken = %q;
+      const options = %s;
+
+      function formatMoney(num) {
+        return Math.round(num).toLocaleString('ru-RU') + ' ₽';
+      }
+      function formatKm(num) {
+        return (Math.round(num * 10) / 10).toLocaleString('ru-RU') + ' км';
+      }
+      function addOptions(select, items, label) {
+        (items || []).forEach(function(item) {
+          const opt = document.createElement('option');
+          opt.value = item.id;
+          opt.textContent = label(item);
+          select.appendChild(opt);
+        });
+      }
+
+      let map = null;
+      let routeLayer = null;
+
+      function ensureMap() {
+        if (!window.L) {
+          console.warn('Leaflet не загружен');
+          return null;
+        }
+        if (!map) {
+          map = L.map('onsite-map').setView([55.751244, 37.618423], 9);
+          L.tileLayer('https://{s}.tile.openstreetmap.org/{z}/{x}/{y}.png', {
+            attribution: '&copy; OpenStreetMap contributors',
+          }).addTo(map);
+        }
+        return map;
+      }
+
+      function drawRoute(route) {
+        const m = ensureMap();
+        if (!m || !route || !route.length) return;
+
+        const latlngs = route
+          .map(function(p) { return [p.lat, p.lon]; })
+          .filter(function(arr) { return arr[0] && arr[1]; });
+
+        if (!latlngs.length) return;
+
+        if (routeLayer) {
+          routeLayer.remove();
+          routeLayer = null;
+        }
+
+        routeLayer = L.polyline(latlngs, { weight: 4 }).addTo(m);
+        m.fitBounds(routeLayer.getBounds(), { padding: [20, 20] });
+      }
+
+      document.addEventListener('DOMContentLoaded', function() {
+        const form = document.getElementById('onsite-form');
+        const addressInput = document.getElementById('onsite-address');
+        const serviceSelect = document.getElementById('onsite-service');
+        const urgencySelect = document.getElementById('onsite-urgency');
+        const resetBtn = document.getElementById('onsite-reset');
+
+        const errorBox = document.getElementById('onsite-error');
+        const resultBox = document.getElementById('onsite-result');
+        const distanceEl = document.getElementById('onsite-distance');
+        const serviceLabel = document.getElementById('onsite-service-label');
+        const feeEl = document.getElementById('onsite-fee');
+        const kmLabel = document.getElementById('onsite-km-label');
+        const kmEl = document.getElementById('onsite-km');
+        const surchargeRow = document.getElementById('onsite-surcharge-row');
+        const surchargeLabel = document.getElementById('onsite-surcharge-label');
+        const surchargeEl = document.getElementById('onsite-surcharge');
+        const totalEl = document.getElementById('onsite-total');
+
+        addOptions(serviceSelect, options.services, function(s) {
+          return s.name + ' — ' + formatMoney(s.visitFee || 0);
+        });
+        addOptions(urgencySelect, options.urgencies, function(u) {
+          const parts = [];
+          if (u.surchargePercent) parts.push('+' + u.surchargePercent + '%%');
+          if (u.surchargeFixed) parts.push('+' + formatMoney(u.surchargeFixed));
+          return u.name + (parts.length ? ' (' + parts.join(', ') + ')' : '');
+        });
+        if (!(options.urgencies || []).length) {
+          document.getElementById('onsite-urgency-field').style.display = 'none';
+        }
+        if (options.freeRadiusKm > 0) {
+          document.getElementById('onsite-subtitle').textContent =
+            'Стоимость выезда специалиста на ваш объект. Дорога в пределах ' +
+            formatKm(options.freeRadiusKm) + ' от ' + options.baseAddress + ' бесплатна.';
+        }
+
+        function showError(msg) {
+          errorBox.textContent = msg;
+          errorBox.style.display = 'block';
+        }
+        function hideError() {
+          errorBox.textContent = '';
+          errorBox.style.display = 'none';
+        }
+        function hideResult() {
+          resultBox.style.display = 'none';
+        }
+
+        form.addEventListener('submit', async function(e) {
+          e.preventDefault();
+          hideError();
+
+          const address = addressInput.value.trim();
+          if (!address) {
+            showError('Укажите адрес объекта.');
+            return;
+          }
+
+          try {
+            const body = {
+              address: address,
+              service: serviceSelect.value,
+              urgency: urgencySelect.value,
+              calculatorId: calculatorId,
+              previewToken: previewToken
+            };
+
+            const res = await fetch('/api/onsite/calc', {
+              method: 'POST',
+              headers: { 'Content-Type': 'application/json' },
+              body: JSON.stringify(body)
+            });
+
+            if (!res.ok) {
+              const text = await res.text();
+              showError('Ошибка расчёта: ' + (text || ('HTTP ' + res.status)));
+              hideResult();
+              return;
+            }
+
+            const data = await res.json();
+
+            resultBox.style.display = 'block';
+            distanceEl.textContent = formatKm(data.distanceKm || 0);
+            serviceLabel.textContent = data.service || 'Выезд';
+            feeEl.textContent = formatMoney(data.visitFee || 0);
+            kmLabel.textContent = 'Дорога за пределами бесплатного радиуса (' + formatKm(data.paidKm || 0) + ')';
+            kmEl.textContent = formatMoney(data.priceKm || 0);
+            if (data.surcharge) {
+              surchargeRow.style.display = 'flex';
+              surchargeLabel.textContent = 'Срочность: ' + (data.urgency || '');
+              surchargeEl.textContent = formatMoney(data.surcharge);
+            } else {
+              surchargeRow.style.display = 'none';
+            }
+            totalEl.textContent = formatMoney(data.priceTotal || 0);
+
+            drawRoute(data.route || []);
+          } catch (err) {
+            console.error(err);
+            showError('Не удалось рассчитать выезд. Попробуйте ещё раз.');
+            hideResult();
+          }
+        });
+
+        resetBtn.addEventListener('click', function() {
+          addressInput.value = '';
+          serviceSelect.selectedIndex = 0;
+          urgencySelect.selectedIndex = 0;
+          hideError();
+          hideResult();
+          if (routeLayer && map) {
+            routeLayer.remove();
+            routeLayer = null;
+          }
+        });
+      });
+    })();
+  </script>
+</body>
+</html>`,
+		escName,
+		escName,
+		idHTML,
+		idJS,
+		previewToken,
+		string(optsJSON),
+	)
+}
+
+// formulaPublicOutput — подпись результата для виджета (без формулы).
+type formulaPublicOutput struct {
+	Name     string `json:"name"`
+	Label    string `json:"label"`
+	Unit     string `json:"unit,omitempty"`
+	Decimals int    `json:"decimals"`
+}
+
+// formulaPublicOptions — то, что виджету формульного калькулятора нужно знать о конфиге.
+// Формулы посетителю не отдаём: считает только сервер.
+type formulaPublicOptions struct {
+	Description string                `json:"description"`
+	Inputs      []domain.FormulaInput `json:"inputs"`
+	Outputs     []formulaPublicOutput `json:"outputs"`
+}
+
+// публичный виджет для формульного калькулятора (formula)
+// Форма собирается из полей конфига, сам расчёт — через /api/formula/calc.
+// previewToken непустой только на странице предпросмотра — тогда расчёт идёт по черновику конфига.
+func renderFormulaPublic(w http.ResponseWriter, calc *domain.Calculator, cfg *domain.FormulaConfig, previewToken string) {
+	opts := formulaPublicOptions{
+		Description: cfg.Description,
+		Inputs:      cfg.Inputs,
+		Outputs:     []formulaPublicOutput{},
+	}
+	for _, o := range cfg.Outputs {
+		if o.Hidden {
+			continue
+		}
+		opts.Outputs = append(opts.Outputs, formulaPublicOutput{Name: o.Name, Label: o.Label, Unit: o.Unit, Decimals: o.Decimals})
+	}
+	optsJSON, err := json.Marshal(opts)
+	if err != nil {
+		http.Error(w, "failed to marshal config", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	name := calc.Name
+	if strings.TrimSpace(name) == "" {
+		name = "Калькулятор"
+	}
+	escName := template.HTMLEscapeString(name)
+	idHTML := template.HTMLEscapeString(calc.ID)
+	idJS := template.JSEscapeString(calc.ID)
+
+	fmt.Fprintf(w, `<!doctype html>
+<html lang="ru">
+<head>
+  <meta charset="utf-8" />
+  <title>%s – калькулятор</title>
+  <meta name="viewport" content="width=device-width, initial-scale=1" />
+
+  <style>
+    * { box-sizing: border-box; }
+
+    body {
+      margin: 0;
+      padding: 16px;
+      font-family: system-ui, -apple-system, BlinkMacSystemFont, "Segoe UI", sans-serif;
+      background: #f3f4f6;
+      color: #111827;
+    }
+
+    .widget-root {
+      max-width: 640px;
+      margin: 0 auto;
+    }
+
+    .card {
+      background: #ffffff;
+      border-radius: 16px;
+      padding: 16px 18px;
+      box-shadow: 0 10px 30px rgba(15,23,42,0.15);
+      margin-bottom: 16px;
+    }
+
+    .card-title {
+      font-size: 18px;
+      font-weight: 600;
+      margin-bottom: 4px;
+    }
+
+    .card-subtitle {
+      font-size: 13px;
+      color: #6b7280;
+      margin-bottom: 10px;
+    }
+
+    .badge {
+      display: inline-flex;
+      align-items: center;
+      border-radius: 999px;
+      padding: 2px 10px;
+      font-size: 11px;
+      background: #eef2ff;
+      color: #4f46e5;
+      margin-bottom: 8px;
+    }
+
+    .meta {
+      font-size: 11px;
+      color: #9ca3af;
+      margin-top: 6px;
+    }
+
+    .field {
+      margin-bottom: 10px;
+    }
+
+    .field-label {
+      display: block;
+      font-size: 13px;
+      margin-bottom: 4px;
+    }
+
+    .field-row {
+      display: flex;
+      align-items: center;
+      gap: 8px;
+    }
+
+    .field-unit {
+      font-size: 13px;
+      color: #6b7280;
+    }
+
+    input[type="number"],
+    select {
+      width: 100%%;
+      padding: 8px 10px;
+      border-radius: 10px;
+      border: 1px solid #d1d5db;
+      font-size: 14px;
+      outline: none;
+    }
+    input:focus, select:focus {
+      border-color: #6366f1;
+      box-shadow: 0 0 0 1px rgba(99,102,241,0.3);
+    }
+
+    .checkbox-label {
+      display: flex;
+      align-items: center;
+      gap: 8px;
+      font-size: 14px;
+      cursor: pointer;
+    }
+
+    .btn {
+      border-radius: 999px;
+      border: none;
+      padding: 8px 16px;
+      font-size: 14px;
+      cursor: pointer;
+      display: inline-flex;
+      align-items: center;
+      gap: 6px;
+    }
+    .btn-primary {
+      background: #4f46e5;
+      color: white;
+    }
+    .btn-primary:hover {
+      background: #4338ca;
+    }
+    .btn-secondary {
+      background: #e5e7eb;
+      color: #111827;
+    }
+
+    .result-box {
+      border-radius: 12px;
+      background: #f9fafb;
+      padding: 10px 12px;
+      margin-top: 10px;
+      font-size: 14px;
+    }
+
+    .result-row {
+      display: flex;
+      justify-content: space-between;
+      font-size: 13px;
+      margin-bottom: 4px;
+    }
+    .result-row:last-child {
+      margin-top: 6px;
+      font-size: 15px;
+      font-weight: 600;
+    }
+    .result-label {
+      color: #6b7280;
+    }
+    .result-value {
+      font-weight: 500;
+    }
+
+    .error-box {
+      margin-top: 8px;
+      padding: 8px 10px;
+      border-radius: 10px;
+      background: #fee2e2;
+      color: #b91c1c;
+      font-size: 13px;
+      display: none;
+    }
+  </style>
+</head>
+<body>
+  <div class="widget-root">
+    <div class="card">
+      <div class="badge">Публичная ссылка</div>
+      <div class="card-title">%s</div>
+      <div class="card-subtitle" id="formula-subtitle"></div>
+
+      <form id="formula-form">
+        <div id="formula-fields"></div>
+
+        <div style="display:flex; gap:8px; align-items:center; margin-top:8px;">
+          <button type="submit" class="btn btn-primary">
+            <span>🧮</span>
+            <span>Рассчитать</span>
+          </button>
+          <button type="button" id="formula-reset" class="btn btn-secondary">Сбросить</button>
+        </div>
+      </form>
+
+      <div class="meta">
+        ID калькулятора: %s
+      </div>
+
+      <div id="formula-error" class="error-box"></div>
+
+      <div id="formula-result" class="result-box" style="display:none;"></div>
+    </div>
+  </div>
+
+  <script>
+    (function() {
+      const calculatorId = %q;
+      const previewToken = %q;
+      const options = %s;
+
+      function formatValue(num, decimals, unit) {
+        const text = Number(num || 0).toLocaleString('ru-RU', {
+          minimumFractionDigits: decimals || 0,
+          maximumFractionDigits: decimals || 0
+        });
+        return unit ? text + ' ' + unit : text;
+      }
+
+      document.addEventListener('DOMContentLoaded', function() {
+        const form = document.getElementById('formula-form');
+        const fieldsEl = document.getElementById('formula-fields');
+        const resetBtn = document.getElementById('formula-reset');
+        const errorBox = document.getElementById('formula-error');
+        const resultBox = document.getElementById('formula-result');
+
+        document.getElementById('formula-subtitle').textContent = options.description || '';
+
+        // поле формы по описанию из конфига; controls[name] отдаёт текущее значение
+        const controls = {};
+        (options.inputs || []).forEach(function(input) {
+          const field = document.createElement('div');
+          field.className = 'field';
+          const label = input.label || input.name;
+
+          if (input.kind === 'checkbox') {
+            const wrap = document.createElement('label');
+            wrap.className = 'checkbox-label';
+            const box = document.createElement('input');
+            box.type = 'checkbox';
+            box.checked = !!input.default;
+            wrap.appendChild(box);
+            wrap.appendChild(document.createTextNode(label));
+            field.appendChild(wrap);
+            controls[input.name] = {
+              get: function() { return box.checked; },
+              reset: function() { box.checked = !!input.default; }
+            };
+          } else {
+            const title = document.createElement('label');
+            title.className = 'field-label';
+            title.textContent = label;
+            field.appendChild(title);
+
+            const row = document.createElement('div');
+            row.className = 'field-row';
+            let control;
+            if (input.kind === 'select') {
+              control = document.createElement('select');
+              (input.options || []).forEach(function(o, i) {
+                const opt = document.createElement('option');
+                opt.value = String(i);
+                opt.textContent = o.label;
+                if (o.value === input.default) opt.selected = true;
+                control.appendChild(opt);
+              });
+              controls[input.name] = {
+                get: function() { return input.options[Number(control.value)].value; },
+                reset: function() {
+                  const i = input.options.findIndex(function(o) { return o.value === input.default; });
+                  control.value = String(i < 0 ? 0 : i);
+                }
+              };
+            } else {
+              control = document.createElement('input');
+              control.type = 'number';
+              if (input.min != null) control.min = input.min;
+              if (input.max != null) control.max = input.max;
+              control.step = input.step || 'any';
+              control.value = input.default;
+              controls[input.name] = {
+                get: function() { return Number(control.value); },
+                reset: function() { control.value = input.default; }
+              };
+            }
+            row.appendChild(control);
+            if (input.unit) {
+              const unit = document.createElement('span');
+              unit.className = 'field-unit';
+              unit.textContent = input.unit;
+              row.appendChild(unit);
+            }
+            field.appendChild(row);
+          }
+          fieldsEl.appendChild(field);
+        });
+
+        function showError(msg) {
+          errorBox.textContent = msg;
+          errorBox.style.display = 'block';
+        }
+        function hideError() {
+          errorBox.textContent = '';
+          errorBox.style.display = 'none';
+        }
+        function hideResult() {
+          resultBox.style.display = 'none';
+        }
+
+        form.addEventListener('submit', async function(e) {
+          e.preventDefault();
+          hideError();
+
+          const values = {};
+          Object.keys(controls).forEach(function(name) {
+            values[name] = controls[name].get();
+          });
+
+          try {
+            const res = await fetch('/api/formula/calc', {
+              method: 'POST',
+              headers: { 'Content-Type': 'application/json' },
+              body: JSON.stringify({
+                values: values,
+                calculatorId: calculatorId,
+                previewToken: previewToken
+              })
+            });
+
+            if (!res.ok) {
+              const text = await res.text();
+              showError('Ошибка расчёта: ' + (text || ('HTTP ' + res.status)));
+              hideResult();
+              return;
+            }
+
+            const data = await res.json();
+
+            resultBox.innerHTML = '';
+            (data.results || []).forEach(function(r) {
+              const row = document.createElement('div');
+              row.className = 'result-row';
+              const label = document.createElement('div');
+              label.className = 'result-label';
+              label.textContent = r.label || r.name;
+              const value = document.createElement('div');
+              value.className = 'result-value';
+              value.textContent = formatValue(r.value, r.decimals, r.unit);
+              row.appendChild(label);
+              row.appendChild(value);
+              resultBox.appendChild(row);
+            });
+            resultBox.style.display = 'block';
+          } catch (err) {
+            console.error(err);
+            showError('Не удалось выполнить расчёт. Попробуйте ещё раз.');
+            hideResult();
+          }
+        });
+
+        resetBtn.addEventListener('click', function() {
+          Object.keys(controls).forEach(function(name) {
+            controls[name].reset();
+          });
+          hideError();
+          hideResult();
+        });
+      });
+    })();
+  </script>
+</body>
+</html>`,
+		escName,
+		escName,
+		idHTML,
+		idJS,
+		previewToken,
+		string(optsJSON),
+	)
+}
+
+// публичный виджет для калькулятора-формы (form)
+// Поля и условия видимости берутся из конфига, сумму считает только сервер через /api/form/calc.
+// previewToken непустой только на странице предпросмотра — тогда расчёт идёт по черновику конфига.
+func renderFormPublic(w http.ResponseWriter, calc *domain.Calculator, cfg *domain.FormConfig, previewToken string) {
+	cfgJSON, err := json.Marshal(cfg)
+	if err != nil {
+		http.Error(w, "failed to marshal config", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	name := calc.Name
+	if strings.TrimSpace(name) == "" {
+		name = "Калькулятор"
+	}
+	escName := template.HTMLEscapeString(name)
+	idHTML := template.HTMLEscapeString(calc.ID)
+	idJS := template.JSEscapeString(calc.ID)
+
+	fmt.Fprintf(w, `<!doctype html>
+<html lang="ru">
+<head>
+  <meta charset="utf-8" />
+  <title>%s – калькулятор</title>
+  <meta name="viewport" content="width=device-width, initial-scale=1" />
+
+  <style>
+    * { box-sizing: border-box; }
+
+    body {
+      margin: 0;
+      padding: 16px;
+      font-family: system-ui, -apple-system, BlinkMacSystemFont, "Segoe UI", sans-serif;
+      background: #f3f4f6;
+      color: #111827;
+    }
+
+    .widget-root {
+      max-width: 640px;
+      margin: 0 auto;
+    }
+
+    .card {
+      background: #ffffff;
+      border-radius: 16px;
+      padding: 16px 18px;
+      box-shadow: 0 10px 30px rgba(15,23,42,0.15);
+      margin-bottom: 16px;
+    }
+
+    .card-title {
+      font-size: 18px;
+      font-weight: 600;
+      margin-bottom: 4px;
+    }
+
+    .card-subtitle {
+      font-size: 13px;
+      color: #6b7280;
+      margin-bottom: 10px;
+    }
+
+    .badge {
+      display: inline-flex;
+      align-items: center;
+      border-radius: 999px;
+      padding: 2px 10px;
+      font-size: 11px;
+      background: #eef2ff;
+      color: #4f46e5;
+      margin-bottom: 8px;
+    }
+
+    .meta {
+      font-size: 11px;
+      color: #9ca3af;
+      margin-top: 6px;
+    }
+
+    .field {
+      margin-bottom: 10px;
+    }
+
+    .field-label {
+      display: block;
+      font-size: 13px;
+      margin-bottom: 4px;
+    }
+
+    .field-row {
+      display: flex;
+      align-items: center;
+      gap: 8px;
+    }
+
+    .field-unit {
+      font-size: 13px;
+      color: #6b7280;
+    }
+
+    input[type="number"],
+    input[type="text"],
+    select {
+      width: 100%%;
+      padding: 8px 10px;
+      border-radius: 10px;
+      border: 1px solid #d1d5db;
+      font-size: 14px;
+      outline: none;
+    }
+    input:focus, select:focus {
+      border-color: #6366f1;
+      box-shadow: 0 0 0 1px rgba(99,102,241,0.3);
+    }
+
+    input[type="range"] {
+      flex: 1;
+    }
+
+    .field-hint {
+      font-size: 12px;
+      color: #9ca3af;
+      margin-top: 2px;
+    }
+
+    .checkbox-label {
+      display: flex;
+      align-items: center;
+      gap: 8px;
+      font-size: 14px;
+      cursor: pointer;
+    }
+
+    .btn {
+      border-radius: 999px;
+      border: none;
+      padding: 8px 16px;
+      font-size: 14px;
+      cursor: pointer;
+      display: inline-flex;
+      align-items: center;
+      gap: 6px;
+    }
+    .btn-primary {
+      background: #4f46e5;
+      color: white;
+    }
+    .btn-primary:hover {
+      background: #4338ca;
+    }
+    .btn-secondary {
+      background: #e5e7eb;
+      color: #111827;
+    }
+
+    .result-box {
+      border-radius: 12px;
+      background: #f9fafb;
+      padding: 10px 12px;
+      margin-top: 10px;
+      font-size: 14px;
+    }
+
+    .result-row {
+      display: flex;
+      justify-content: space-between;
+      font-size: 13px;
+      margin-bottom: 4px;
+    }
+    .result-total {
+      margin-top: 6px;
+      font-size: 15px;
+      font-weight: 600;
+    }
+    .result-label {
+      color: #6b7280;
+    }
+    .result-value {
+      font-weight: 500;
+    }
+
+    .error-box {
+      margin-top: 8px;
+      padding: 8px 10px;
+      border-radius: 10px;
+      background: #fee2e2;
+      color: #b91c1c;
+      font-size: 13px;
+      display: none;
+    }
+  </style>
+</head>
+<body>
+  <div class="widget-root">
+    <div class="card">
+      <div class="badge">Публичная ссылка</div>
+      <div class="card-title">%s</div>
+      <div class="card-subtitle" id="form-subtitle"></div>
+
+      <form id="form-form">
+        <div id="form-fields"></div>
+
+        <div style="display:flex; gap:8px; align-items:center; margin-top:8px;">
+          <button type="submit" class="btn btn-primary">
+            <span>📝</span>
+            <span>Рассчитать</span>
+          </button>
+          <button type="button" id="form-reset" class="btn btn-secondary">Сбросить</button>
+        </div>
+      </form>
+
+      <div class="meta">
+        ID калькулятора: %s
+      </div>
+
+      <div id="form-error" class="error-box"></div>
+
+      <div id="form-result" class="result-box" style="display:none;"></div>
+    </div>
+  </div>
+
+  <script>
+    (function() {
+      const calculatorId = %q;
+      const previewToken = %q;
+      const config = %s;
+
+      function formatMoney(num) {
+        return Number(num || 0).toLocaleString('ru-RU', { maximumFractionDigits: 2 }) + ' ₽';
+      }
+
+      document.addEventListener('DOMContentLoaded', function() {
+        const form = document.getElementById('form-form');
+        const fieldsEl = document.getElementById('form-fields');
+        const resetBtn = document.getElementById('form-reset');
+        const errorBox = document.getElementById('form-error');
+        const resultBox = document.getElementById('form-result');
+
+        document.getElementById('form-subtitle').textContent = config.description || '';
+
+        // controls[id] = { field, el, get, reset }; get() возвращает значение в формате /api/form/calc
+        const controls = {};
+
+        function priceHint(price, multiplier) {
+          const parts = [];
+          if (price) parts.push('+' + formatMoney(price));
+          if (multiplier && multiplier !== 1) parts.push('×' + multiplier);
+          return parts.length ? ' (' + parts.join(', ') + ')' : '';
+        }
+
+        (config.fields || []).forEach(function(field) {
+          const el = document.createElement('div');
+          el.className = 'field';
+          const title = document.createElement('label');
+          title.className = 'field-label';
+          title.textContent = (field.label || field.id) + (field.required ? ' *' : '') +
+            priceHint(field.price, field.multiplier);
+          el.appendChild(title);
+
+          let ctrl;
+          if (field.kind === 'radio' || field.kind === 'multiselect') {
+            const boxes = [];
+            (field.options || []).forEach(function(o) {
+              const wrap = document.createElement('label');
+              wrap.className = 'checkbox-label';
+              const input = document.createElement('input');
+              input.type = field.kind === 'radio' ? 'radio' : 'checkbox';
+              input.name = 'form-' + field.id;
+              input.value = o.id;
+              wrap.appendChild(input);
+              wrap.appendChild(document.createTextNode(o.label + priceHint(o.price, o.multiplier)));
+              el.appendChild(wrap);
+              boxes.push(input);
+            });
+            ctrl = {
+              get: function() {
+                const ids = boxes.filter(function(b) { return b.checked; }).map(function(b) { return b.value; });
+                return field.kind === 'radio' ? (ids[0] || '') : ids;
+              },
+              reset: function() {
+                boxes.forEach(function(b) { b.checked = false; });
+              }
+            };
+          } else if (field.kind === 'text') {
+            const input = document.createElement('input');
+            input.type = 'text';
+            input.maxLength = 500;
+            input.placeholder = field.placeholder || '';
+            el.appendChild(input);
+            ctrl = {
+              get: function() { return input.value.trim(); },
+              reset: function() { input.value = ''; }
+            };
+          } else {
+            const row = document.createElement('div');
+            row.className = 'field-row';
+            const input = document.createElement('input');
+            input.type = field.kind === 'slider' ? 'range' : 'number';
+            if (field.min != null) input.min = field.min;
+            if (field.max != null) input.max = field.max;
+            input.step = field.step || 'any';
+            input.value = field.default || 0;
+            row.appendChild(input);
+            const unit = document.createElement('span');
+            unit.className = 'field-unit';
+            row.appendChild(unit);
+            function showUnit() {
+              unit.textContent = (field.kind === 'slider' ? input.value + ' ' : '') + (field.unit || '');
+            }
+            input.addEventListener('input', showUnit);
+            showUnit();
+            el.appendChild(row);
+            if (field.unitPrice) {
+              const hint = document.createElement('div');
+              hint.className = 'field-hint';
+              hint.textContent = formatMoney(field.unitPrice) + ' за ' + (field.unit || 'единицу');
+              el.appendChild(hint);
+            }
+            ctrl = {
+              get: function() { return Number(input.value); },
+              reset: function() { input.value = field.default || 0; showUnit(); }
+            };
+          }
+
+          ctrl.field = field;
+          ctrl.el = el;
+          controls[field.id] = ctrl;
+          fieldsEl.appendChild(el);
+        });
+
+        // то же правило, что на сервере: поля по порядку, скрытое поле считается пустым
+        function filled(field, v) {
+          if (v == null) return false;
+          if (field.kind === 'number' || field.kind === 'slider') return v !== 0;
+          if (field.kind === 'multiselect') return v.length > 0;
+          return v !== '';
+        }
+        function conditionHolds(cond, values) {
+          const target = controls[cond.field] && controls[cond.field].field;
+          if (!target) return false;
+          const v = values[cond.field];
+          const num = typeof v === 'number' ? v : 0;
+          const has = v != null && (Array.isArray(v) ? v.indexOf(cond.option) >= 0 : v === cond.option);
+          switch (cond.op) {
+            case 'filled': return filled(target, v);
+            case 'empty': return !filled(target, v);
+            case 'eq': return v != null && num === (cond.value || 0);
+            case 'ne': return v == null || num !== (cond.value || 0);
+            case 'gt': return v != null && num > (cond.value || 0);
+            case 'gte': return v != null && num >= (cond.value || 0);
+            case 'lt': return v != null && num < (cond.value || 0);
+            case 'lte': return v != null && num <= (cond.value || 0);
+            case 'selected': return has;
+            case 'not_selected': return !has;
+          }
+          return false;
+        }
+        function visibleValues() {
+          const values = {};
+          (config.fields || []).forEach(function(field) {
+            const ctrl = controls[field.id];
+            const visible = (field.visibleIf || []).every(function(c) { return conditionHolds(c, values); });
+            ctrl.el.style.display = visible ? '' : 'none';
+            if (visible) values[field.id] = ctrl.get();
+          });
+          return values;
+        }
+
+        form.addEventListener('input', visibleValues);
+        form.addEventListener('change', visibleValues);
+        visibleValues();
+
+        function showError(msg) {
+          errorBox.textContent = msg;
+          errorBox.style.display = 'block';
+        }
+        function hideError() {
+          errorBox.textContent = '';
+          errorBox.style.display = 'none';
+        }
+        function hideResult() {
+          resultBox.style.display = 'none';
+        }
+
+        form.addEventListener('submit', async function(e) {
+          e.preventDefault();
+          hideError();
+
+          try {
+            const res = await fetch('/api/form/calc', {
+              method: 'POST',
+              headers: { 'Content-Type': 'application/json' },
+              body: JSON.stringify({
+                values: visibleValues(),
+                calculatorId: calculatorId,
+                previewToken: previewToken
+              })
+            });
+
+            if (!res.ok) {
+              const text = await res.text();
+              showError('Ошибка расчёта: ' + (text || ('HTTP ' + res.status)));
+              hideResult();
+              return;
+            }
+
+            const data = await res.json();
+
+            resultBox.innerHTML = '';
+            (data.items || []).forEach(function(item) {
+              const row = document.createElement('div');
+              row.className = 'result-row';
+              const label = document.createElement('div');
+              label.className = 'result-label';
+              label.textContent = item.label + (item.detail ? ': ' + item.detail : '');
+              const value = document.createElement('div');
+              value.className = 'result-value';
+              value.textContent = (item.amount < 0 ? '−' : '') + formatMoney(Math.abs(item.amount));
+              row.appendChild(label);
+              row.appendChild(value);
+              resultBox.appendChild(row);
+            });
+            const total = document.createElement('div');
+            total.className = 'result-total';
+            total.textContent = 'Итого: ' + formatMoney(data.total);
+            resultBox.appendChild(total);
+            resultBox.style.display = 'block';
+          } catch (err) {
+            console.error(err);
+            showError('Не удалось выполнить расчёт. Попробуйте ещё раз.');
+            hideResult();
+          }
+        });
+
+        resetBtn.addEventListener('click', function() {
+          Object.keys(controls).forEach(function(id) {
+            controls[id].reset();
+          });
+          visibleValues();
+          hideError();
+          hideResult();
+        });
+      });
+    })();
+  </script>
+</body>
+</html>`,
+		escName,
+		escName,
+		idHTML,
+		idJS,
+		previewToken,
+		string(cfgJSON),
 	)
 }
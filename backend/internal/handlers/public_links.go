@@ -0,0 +1,336 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"saas-calc-backend/internal/domain"
+)
+
+// findCalculatorBySlug — калькулятор по адресу /c/{slug} (nil, если нет).
+func (e *Env) findCalculatorBySlug(ctx context.Context, slug string) (*domain.Calculator, error) {
+	slug = domain.NormalizeSlug(slug)
+	if e.DB == nil {
+		for _, c := range e.Calculators {
+			if c.Slug != "" && c.Slug == slug {
+				return c, nil
+			}
+		}
+		return nil, nil
+	}
+
+	c, err := scanCalculator(e.DB.QueryRowContext(ctx,
+		`SELECT `+calculatorColumns+` FROM calculators WHERE lower(slug) = $1 AND deleted_at IS NULL`,
+		slug,
+	))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return c, nil
+}
+
+const redirectColumns = `path, calculator_id, reason, created_at, expires_at`
+
+func scanRedirect(row rowScanner) (*domain.CalculatorRedirect, error) {
+	var rd domain.CalculatorRedirect
+	var expires sql.NullTime
+	if err := row.Scan(&rd.Path, &rd.CalculatorID, &rd.Reason, &rd.CreatedAt, &expires); err != nil {
+		return nil, err
+	}
+	if expires.Valid {
+		rd.ExpiresAt = &expires.Time
+	}
+	return &rd, nil
+}
+
+// findRedirect — запись истории для старого публичного адреса (nil, если нет).
+func (e *Env) findRedirect(ctx context.Context, path string) (*domain.CalculatorRedirect, error) {
+	if e.DB == nil {
+		return nil, nil
+	}
+	rd, err := scanRedirect(e.DB.QueryRowContext(ctx,
+		`SELECT `+redirectColumns+` FROM calculator_redirects WHERE path = $1`,
+		path,
+	))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return rd, nil
+}
+
+// listCalculatorRedirects — старые адреса калькулятора, новые сверху.
+func (e *Env) listCalculatorRedirects(ctx context.Context, calcID string) ([]*domain.CalculatorRedirect, error) {
+	rows, err := e.DB.QueryContext(ctx, `
+SELECT `+redirectColumns+`
+FROM calculator_redirects
+WHERE calculator_id = $1
+ORDER BY created_at DESC
+`, calcID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]*domain.CalculatorRedirect, 0)
+	for rows.Next() {
+		rd, err := scanRedirect(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, rd)
+	}
+	return items, rows.Err()
+}
+
+// addRedirect запоминает старый адрес калькулятора; expiresAt nil — переадресация бессрочная.
+// Если адрес уже был в истории, запись перезаписывается.
+func addRedirect(ctx context.Context, tx *sql.Tx, path, calcID, reason string, expiresAt *time.Time) error {
+	_, err := tx.ExecContext(ctx, `
+INSERT INTO calculator_redirects (path, calculator_id, reason, created_at, expires_at)
+VALUES ($1, $2, $3, now(), $4)
+ON CONFLICT (path) DO UPDATE
+SET calculator_id = EXCLUDED.calculator_id, reason = EXCLUDED.reason,
+    created_at = EXCLUDED.created_at, expires_at = EXCLUDED.expires_at
+`, path, calcID, reason, expiresAt)
+	return err
+}
+
+// redirectOldPublicPath переадресует со старого публичного адреса на текущий.
+// false — адреса нет в истории или срок переадресации истёк (ответ не записан).
+func (e *Env) redirectOldPublicPath(w http.ResponseWriter, r *http.Request, path string) (bool, error) {
+	rd, err := e.findRedirect(r.Context(), path)
+	if err != nil || rd == nil || !rd.Active(time.Now()) {
+		return false, err
+	}
+
+	c, err := e.GetCalculatorByID(r.Context(), rd.CalculatorID)
+	if err != nil || c == nil {
+		return false, err
+	}
+
+	target := c.PublicPath
+	if r.URL.RawQuery != "" {
+		target += "?" + r.URL.RawQuery
+	}
+	// 302, а не 301: переадресация может истечь или быть снята владельцем
+	http.Redirect(w, r, target, http.StatusFound)
+	return true, nil
+}
+
+type calculatorSlugRequest struct {
+	Slug string `json:"slug"` // пусто — убрать адрес
+}
+
+type rotateTokenRequest struct {
+	// сколько часов старая ссылка ещё переадресует на новую; 0 — перестаёт работать сразу
+	GraceHours int `json:"graceHours"`
+}
+
+// --- PUT /api/calculators/{id}/slug ---
+
+// Старый slug остаётся в истории и переадресует на новый адрес, пока владелец его не снимет.
+func (e *Env) handleCalculatorSlug(w http.ResponseWriter, r *http.Request, u *domain.User, id string) {
+	c := e.requireCalculator(w, r, u, id, domain.OrgRoleOwner)
+	if c == nil {
+		return
+	}
+	defer r.Body.Close()
+
+	var req calculatorSlugRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	slug := domain.NormalizeSlug(req.Slug)
+	if slug != "" && !domain.ValidSlug(slug) {
+		http.Error(w, "bad slug: use 3-64 latin letters, digits and single hyphens", http.StatusBadRequest)
+		return
+	}
+	if slug == c.Slug {
+		e.writeJSON(w, c)
+		return
+	}
+
+	newPath := domain.TokenPublicPath(c.OwnerID, c.PublicToken)
+	if slug != "" {
+		newPath = domain.SlugPublicPath(slug)
+
+		// чужой старый адрес не отдаём, пока по нему идёт переадресация
+		rd, err := e.findRedirect(r.Context(), newPath)
+		if err != nil {
+			http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if rd != nil && rd.CalculatorID != c.ID && rd.Active(time.Now()) {
+			http.Error(w, "slug is already taken", http.StatusConflict)
+			return
+		}
+	}
+
+	err := e.inTx(r.Context(), func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(r.Context(),
+			`UPDATE calculators SET slug = $1, public_path = $2 WHERE id = $3`,
+			nullableString(slug), newPath, c.ID,
+		); err != nil {
+			return err
+		}
+		// адрес снова живой — из истории его убираем
+		if _, err := tx.ExecContext(r.Context(), `DELETE FROM calculator_redirects WHERE path = $1`, newPath); err != nil {
+			return err
+		}
+		if c.Slug == "" {
+			return nil
+		}
+		return addRedirect(r.Context(), tx, domain.SlugPublicPath(c.Slug), c.ID, domain.RedirectReasonSlugChanged, nil)
+	})
+	if err != nil {
+		if isUniqueViolation(err) {
+			http.Error(w, "slug is already taken", http.StatusConflict)
+			return
+		}
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	for _, cached := range e.Calculators {
+		if cached.ID == c.ID {
+			cached.Slug = slug
+			cached.PublicPath = newPath
+			break
+		}
+	}
+
+	e.respondUpdatedCalculator(w, r, c.ID)
+}
+
+// --- POST /api/calculators/{id}/rotate-token ---
+
+// Новый публичный токен, например если ссылка утекла. Старая ссылка либо сразу перестаёт
+// работать, либо graceHours часов переадресует на текущий адрес; в истории она остаётся в любом случае.
+func (e *Env) handleRotatePublicToken(w http.ResponseWriter, r *http.Request, u *domain.User, id string) {
+	c := e.requireCalculator(w, r, u, id, domain.OrgRoleOwner)
+	if c == nil {
+		return
+	}
+	defer r.Body.Close()
+
+	var req rotateTokenRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad json: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if req.GraceHours < 0 || req.GraceHours > domain.MaxTokenGraceHours {
+		http.Error(w, "graceHours is out of range", http.StatusBadRequest)
+		return
+	}
+
+	token := domain.GeneratePublicToken()
+	newPath := domain.TokenPublicPath(c.OwnerID, token)
+	if c.Slug != "" {
+		newPath = domain.SlugPublicPath(c.Slug)
+	}
+	expiresAt := time.Now().Add(time.Duration(req.GraceHours) * time.Hour)
+
+	err := e.inTx(r.Context(), func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(r.Context(),
+			`UPDATE calculators SET public_token = $1, public_path = $2 WHERE id = $3`,
+			token, newPath, c.ID,
+		); err != nil {
+			return err
+		}
+		return addRedirect(r.Context(), tx, domain.TokenPublicPath(c.OwnerID, c.PublicToken), c.ID,
+			domain.RedirectReasonTokenRotated, &expiresAt)
+	})
+	if err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	for _, cached := range e.Calculators {
+		if cached.ID == c.ID {
+			cached.PublicToken = token
+			cached.PublicPath = newPath
+			break
+		}
+	}
+
+	e.respondUpdatedCalculator(w, r, c.ID)
+}
+
+// --- /api/calculators/{id}/redirects ---
+
+// GET    -> история старых адресов (viewer)
+// DELETE ?path=/p/... -> снять переадресацию: старая ссылка перестаёт работать (owner)
+func (e *Env) handleCalculatorRedirects(w http.ResponseWriter, r *http.Request, u *domain.User, id string) {
+	switch r.Method {
+	case http.MethodGet:
+		c := e.requireCalculator(w, r, u, id, domain.OrgRoleViewer)
+		if c == nil {
+			return
+		}
+		items, err := e.listCalculatorRedirects(r.Context(), c.ID)
+		if err != nil {
+			http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		e.writeJSON(w, map[string]interface{}{"items": items})
+
+	case http.MethodDelete:
+		c := e.requireCalculator(w, r, u, id, domain.OrgRoleOwner)
+		if c == nil {
+			return
+		}
+		res, err := e.DB.ExecContext(r.Context(),
+			`DELETE FROM calculator_redirects WHERE path = $1 AND calculator_id = $2`,
+			r.URL.Query().Get("path"), c.ID,
+		)
+		if err != nil {
+			http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			http.Error(w, "redirect not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// respondUpdatedCalculator отвечает калькулятором в его текущем состоянии из БД.
+func (e *Env) respondUpdatedCalculator(w http.ResponseWriter, r *http.Request, id string) {
+	updated, err := e.GetCalculatorByID(r.Context(), id)
+	if err != nil || updated == nil {
+		http.Error(w, "failed to load calculator", http.StatusInternalServerError)
+		return
+	}
+	e.writeJSON(w, updated)
+}
+
+// inTx выполняет fn в транзакции: коммит, если fn вернула nil, иначе откат.
+func (e *Env) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := e.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
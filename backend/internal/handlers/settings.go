@@ -4,6 +4,7 @@ import (
     "database/sql"
     "encoding/json"
     "net/http"
+    "strings"
 
     "saas-calc-backend/internal/domain"
 )
@@ -13,13 +14,31 @@ type AdminSettings struct {
     OSRMBaseURL      string `json:"osrmBaseUrl"`
     NominatimBaseURL string `json:"nominatimBaseUrl"`
     TelegramBotToken string `json:"telegramBotToken"`
+
+    // обязательная двухфакторная аутентификация для всех администраторов
+    RequireAdmin2FA bool `json:"requireAdmin2fa"`
+
+    // вход через OpenID Connect; секрет клиента наружу не отдаём, только признак, что он задан
+    OIDCIssuer          string `json:"oidcIssuer"`
+    OIDCClientID        string `json:"oidcClientId"`
+    OIDCClientSecretSet bool   `json:"oidcClientSecretSet"`
+    OIDCRedirectURL     string `json:"oidcRedirectUrl"` // что прописать у провайдера, только для чтения
+}
+
+// adminSettingsRequest — как AdminSettings, но requireAdmin2fa и oidcClientSecret
+// можно не передавать (тогда значение в БД не меняется).
+type adminSettingsRequest struct {
+    AdminSettings
+    RequireAdmin2FA *bool `json:"requireAdmin2fa"`
+
+    // nil — не менять, "" — удалить
+    OIDCClientSecret *string `json:"oidcClientSecret"`
 }
 
 // GET/POST /api/admin/settings
 func (e *Env) HandleAdminSettings(w http.ResponseWriter, r *http.Request) {
-    u := e.CurrentUser(r)
-    if u == nil || u.Role != domain.RoleAdmin {
-        http.Error(w, "forbidden", http.StatusForbidden)
+    admin := e.requireAdmin(w, r)
+    if admin == nil {
         return
     }
 
@@ -27,7 +46,7 @@ func (e *Env) HandleAdminSettings(w http.ResponseWriter, r *http.Request) {
     case http.MethodGet:
         e.handleAdminSettingsGet(w, r)
     case http.MethodPost:
-        e.handleAdminSettingsPost(w, r)
+        e.handleAdminSettingsPost(w, r, admin)
     default:
         http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
     }
@@ -47,13 +66,15 @@ func (e *Env) handleAdminSettingsGet(w http.ResponseWriter, r *http.Request) {
 
     row := e.DB.QueryRowContext(
         r.Context(),
-        `SELECT osrm_base_url, nominatim_base_url, telegram_bot_token
+        `SELECT osrm_base_url, nominatim_base_url, telegram_bot_token, require_admin_2fa,
+                oidc_issuer, oidc_client_id, COALESCE(oidc_client_secret, '') <> ''
          FROM settings
          WHERE id = 1`,
     )
 
-    var osrm, nom, token sql.NullString
-    err := row.Scan(&osrm, &nom, &token)
+    var osrm, nom, token, oidcIssuer, oidcClientID sql.NullString
+    var require2FA, oidcSecretSet bool
+    err := row.Scan(&osrm, &nom, &token, &require2FA, &oidcIssuer, &oidcClientID, &oidcSecretSet)
     if err != nil {
         if err != sql.ErrNoRows {
             http.Error(w, "failed to load settings: "+err.Error(), http.StatusInternalServerError)
@@ -66,6 +87,12 @@ func (e *Env) handleAdminSettingsGet(w http.ResponseWriter, r *http.Request) {
         OSRMBaseURL:      osrm.String,
         NominatimBaseURL: nom.String,
         TelegramBotToken: token.String,
+        RequireAdmin2FA:  require2FA,
+
+        OIDCIssuer:          oidcIssuer.String,
+        OIDCClientID:        oidcClientID.String,
+        OIDCClientSecretSet: oidcSecretSet,
+        OIDCRedirectURL:     e.absoluteURL(oidcCallbackPath),
     }
 
     // заодно синхронизируем Env (чтобы distance/telegram использовали актуальное)
@@ -82,13 +109,20 @@ func (e *Env) handleAdminSettingsGet(w http.ResponseWriter, r *http.Request) {
     e.writeJSON(w, resp)
 }
 
-func (e *Env) handleAdminSettingsPost(w http.ResponseWriter, r *http.Request) {
+func (e *Env) handleAdminSettingsPost(w http.ResponseWriter, r *http.Request, admin *domain.User) {
     defer r.Body.Close()
-    var req AdminSettings
-    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+    var body adminSettingsRequest
+    if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
         http.Error(w, "bad json: "+err.Error(), http.StatusBadRequest)
         return
     }
+    req := body.AdminSettings
+
+    // иначе администратор сам себя закроет из админки
+    if body.RequireAdmin2FA != nil && *body.RequireAdmin2FA && !admin.TwoFactor {
+        http.Error(w, "enable 2fa for your own account first", http.StatusBadRequest)
+        return
+    }
 
     // сохраняем в БД, если она есть
     if e.DB != nil {
@@ -108,6 +142,38 @@ func (e *Env) handleAdminSettingsPost(w http.ResponseWriter, r *http.Request) {
             http.Error(w, "failed to save settings: "+err.Error(), http.StatusInternalServerError)
             return
         }
+
+        if _, err := e.DB.ExecContext(
+            r.Context(),
+            `UPDATE settings SET oidc_issuer = $1, oidc_client_id = $2 WHERE id = 1`,
+            strings.TrimRight(strings.TrimSpace(req.OIDCIssuer), "/"),
+            strings.TrimSpace(req.OIDCClientID),
+        ); err != nil {
+            http.Error(w, "failed to save settings: "+err.Error(), http.StatusInternalServerError)
+            return
+        }
+
+        if body.OIDCClientSecret != nil {
+            if _, err := e.DB.ExecContext(
+                r.Context(),
+                `UPDATE settings SET oidc_client_secret = $1 WHERE id = 1`,
+                *body.OIDCClientSecret,
+            ); err != nil {
+                http.Error(w, "failed to save settings: "+err.Error(), http.StatusInternalServerError)
+                return
+            }
+        }
+
+        if body.RequireAdmin2FA != nil {
+            if _, err := e.DB.ExecContext(
+                r.Context(),
+                `UPDATE settings SET require_admin_2fa = $1 WHERE id = 1`,
+                *body.RequireAdmin2FA,
+            ); err != nil {
+                http.Error(w, "failed to save settings: "+err.Error(), http.StatusInternalServerError)
+                return
+            }
+        }
     }
 
     // обновляем Env, чтобы всё в рантайме брало свежие значения
@@ -0,0 +1,298 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"golang.org/x/crypto/bcrypt"
+
+	"saas-calc-backend/internal/domain"
+	"saas-calc-backend/internal/mail"
+)
+
+const (
+	// minPasswordLength — минимальная длина пароля при регистрации/смене.
+	minPasswordLength = 8
+	// confirmEmailTTL — сколько живёт ссылка подтверждения email.
+	confirmEmailTTL = 48 * time.Hour
+)
+
+type signupRequest struct {
+	Email    string `json:"email"`
+	Name     string `json:"name"`
+	Password string `json:"password"`
+}
+
+// POST /api/auth/signup { "email": "...", "name": "...", "password": "..." }
+//
+// Создаёт пользователя на самом дешёвом тарифе и отправляет письмо со ссылкой подтверждения email.
+// Ответ всегда 202 и без сессии — и для нового адреса, и для уже зарегистрированного
+// (тому уходит письмо «у вас уже есть аккаунт»), так нельзя проверять, чьи email есть в сервисе.
+// Войти можно обычным логином.
+func (e *Env) HandleAuthSignup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if e.DB == nil {
+		http.Error(w, "db is nil", http.StatusInternalServerError)
+		return
+	}
+	defer r.Body.Close()
+
+	var req signupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	email := strings.TrimSpace(req.Email)
+	name := strings.TrimSpace(req.Name)
+	if !looksLikeEmail(email) {
+		http.Error(w, "valid email is required", http.StatusBadRequest)
+		return
+	}
+	if len(req.Password) < minPasswordLength {
+		http.Error(w, "password is too short", http.StatusBadRequest)
+		return
+	}
+	if name == "" {
+		name = email
+	}
+
+	// лимит писем на адрес одинаков для новых и существующих — 429 ничего не выдаёт
+	if !e.allowMail(w, "signup:"+strings.ToLower(email)) {
+		return
+	}
+
+	plans := e.Plans
+	if len(plans) == 0 {
+		plans = domain.DefaultPlans()
+	}
+	plan := domain.CheapestPlan(plans)
+	if plan == nil {
+		http.Error(w, "no plans configured", http.StatusInternalServerError)
+		return
+	}
+
+	// хешируем до проверки адреса, чтобы время ответа не зависело от того, занят ли он
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		http.Error(w, "failed to hash password: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	exists, err := e.emailTaken(r.Context(), email)
+	if err != nil {
+		http.Error(w, "failed to check email: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if exists {
+		e.sendAlreadyRegisteredEmail(email)
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	u := &domain.User{
+		ID:         domain.NewUserID(),
+		Email:      email,
+		Name:       name,
+		Role:       domain.RoleUser,
+		PlanID:     plan.ID,
+		PlanActive: true,
+		CreatedAt:  time.Now(),
+	}
+
+	// пользователь и его личная организация — вместе: без организации повторная регистрация упёрлась бы в занятый email
+	err = e.inTx(r.Context(), func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(r.Context(), `
+INSERT INTO users (id, email, name, role, password_hash, plan_id, plan_active, created_at, email_confirmed)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, FALSE)
+`,
+			u.ID,
+			u.Email,
+			u.Name,
+			string(u.Role),
+			string(hash),
+			u.PlanID,
+			u.PlanActive,
+			u.CreatedAt,
+		); err != nil {
+			return err
+		}
+		return e.createPersonalOrg(r.Context(), tx, u)
+	})
+	if err != nil {
+		// адрес заняли параллельным запросом — отвечаем так же, как для существующего
+		if isUniqueViolation(err) {
+			e.sendAlreadyRegisteredEmail(email)
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		http.Error(w, "failed to create user: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// письмо — в фоне, чтобы время ответа не отличалось от ветки с существующим адресом
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		e.sendConfirmEmail(ctx, u)
+	}()
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// sendAlreadyRegisteredEmail — письмо владельцу адреса, на который пытались зарегистрироваться ещё раз.
+func (e *Env) sendAlreadyRegisteredEmail(to string) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		e.sendMail(ctx, mail.Message{
+			To:      to,
+			Subject: "У вас уже есть аккаунт",
+			Body: "Здравствуйте!\n\n" +
+				"Кто-то (возможно, вы) пытался зарегистрироваться с этим email, но аккаунт с ним уже есть.\n" +
+				"Войти можно здесь:\n" +
+				e.absoluteURL("/app") + "\n\n" +
+				"Если не помните пароль, восстановите его на странице входа. " +
+				"Если это были не вы — просто проигнорируйте письмо.",
+		})
+	}()
+}
+
+// GET /api/auth/confirm?token=...  — ссылка из письма
+func (e *Env) HandleAuthConfirm(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := e.consumeUserToken(r.Context(), r.URL.Query().Get("token"), tokenKindConfirmEmail)
+	if err != nil {
+		http.Error(w, "failed to check token: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if userID == "" {
+		http.Error(w, "invalid or expired token", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := e.DB.ExecContext(r.Context(),
+		`UPDATE users SET email_confirmed = TRUE WHERE id = $1`,
+		userID,
+	); err != nil {
+		http.Error(w, "failed to confirm email: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/app?confirmed=1", http.StatusSeeOther)
+}
+
+// POST /api/auth/confirm/resend — повторно отправить письмо текущему пользователю
+func (e *Env) HandleAuthConfirmResend(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	u := e.requireUser(w, r)
+	if u == nil {
+		return
+	}
+	if u.EmailConfirmed {
+		http.Error(w, "email already confirmed", http.StatusBadRequest)
+		return
+	}
+	if !e.allowMail(w, "confirm:"+u.ID) {
+		return
+	}
+
+	e.sendConfirmEmail(r.Context(), u)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// sendConfirmEmail выпускает токен подтверждения и отправляет ссылку.
+// Ошибки только логируем: пользователь может запросить письмо повторно.
+func (e *Env) sendConfirmEmail(ctx context.Context, u *domain.User) {
+	token, err := e.issueUserToken(ctx, u.ID, tokenKindConfirmEmail, confirmEmailTTL)
+	if err != nil {
+		log.Printf("signup: issue confirm token for %s: %v", u.ID, err)
+		return
+	}
+
+	link := e.absoluteURL("/api/auth/confirm?token=" + url.QueryEscape(token))
+	e.sendMail(ctx, mail.Message{
+		To:      u.Email,
+		Subject: "Подтверждение email",
+		Body: "Здравствуйте, " + u.Name + "!\n\n" +
+			"Чтобы подтвердить email и публиковать калькуляторы, перейдите по ссылке:\n" +
+			link + "\n\n" +
+			"Ссылка действует 48 часов.",
+	})
+}
+
+// ownerCanPublish — может ли владелец калькулятора публиковать (email подтверждён).
+func (e *Env) ownerCanPublish(ctx context.Context, ownerID string) bool {
+	if e.DB == nil {
+		return true
+	}
+	owner, err := e.GetUserByID(ctx, ownerID)
+	if err != nil {
+		log.Printf("ownerCanPublish: load owner %s: %v", ownerID, err)
+		return false
+	}
+	return owner != nil && owner.EmailConfirmed
+}
+
+// sendMail отправляет письмо через Env.Mailer (по умолчанию — в лог).
+func (e *Env) sendMail(ctx context.Context, m mail.Message) {
+	sender := e.Mailer
+	if sender == nil {
+		sender = mail.LogSender{}
+	}
+	if err := sender.Send(ctx, m); err != nil {
+		log.Printf("mail: send %q to %s: %v", m.Subject, m.To, err)
+	}
+}
+
+// absoluteURL — ссылка на наш сервер для писем.
+func (e *Env) absoluteURL(path string) string {
+	base := strings.TrimRight(e.PublicBaseURL, "/")
+	if base == "" {
+		base = "http://localhost:3040"
+	}
+	return base + path
+}
+
+// emailTaken — есть ли уже пользователь с таким email (без учёта регистра).
+func (e *Env) emailTaken(ctx context.Context, email string) (bool, error) {
+	if e.DB == nil {
+		return false, errors.New("db is nil")
+	}
+	var exists bool
+	err := e.DB.QueryRowContext(ctx,
+		`SELECT EXISTS(SELECT 1 FROM users WHERE lower(email) = lower($1))`,
+		email,
+	).Scan(&exists)
+	return exists, err
+}
+
+func looksLikeEmail(s string) bool {
+	at := strings.Index(s, "@")
+	return at > 0 && at < len(s)-1 && !strings.ContainsAny(s, " \t\r\n")
+}
+
+// isUniqueViolation — нарушение UNIQUE в PostgreSQL (код 23505).
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
@@ -4,11 +4,14 @@ import (
     "context"
     "database/sql"
     "fmt"
+    "html"
     "log"
     "net/http"
     "net/url"
     "strings"
     "time"
+
+    "saas-calc-backend/internal/domain"
 )
 
 // читаем токен бота из БД (settings.id = 1), при ошибках — из Env
@@ -154,6 +157,55 @@ func (e *Env) NotifyTelegramDistanceCalc(
     }()
 }
 
+// NotifyTelegramOnSiteCalc — уведомление о новом расчёте выезда специалиста
+func (e *Env) NotifyTelegramOnSiteCalc(
+    ctx context.Context,
+    calcID string,
+    address string,
+    service string,
+    urgency string,
+    distanceKm float64,
+    totalPrice float64,
+) {
+    chatID, calcName, calcType, err := e.lookupTelegramForCalc(ctx, calcID)
+    if err != nil {
+        log.Printf("telegram: lookup failed for calc %s: %v", calcID, err)
+        return
+    }
+    if chatID == "" {
+        return
+    }
+
+    if calcName == "" {
+        calcName = calcID
+    }
+    if urgency == "" {
+        urgency = "—"
+    }
+
+    text := fmt.Sprintf(
+        "🧰 Новый расчёт выезда по калькулятору «%s» (%s)\n\n"+
+            "Адрес: %s\n"+
+            "Услуга: %s\n"+
+            "Срочность: %s\n"+
+            "Расстояние: %.1f км\n"+
+            "Итого: %.0f ₽",
+        calcName,
+        calcType,
+        address,
+        service,
+        urgency,
+        distanceKm,
+        totalPrice,
+    )
+
+    go func() {
+        bgCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+        defer cancel()
+        e.sendTelegramMessage(bgCtx, chatID, text)
+    }()
+}
+
 // NotifyTelegramMortgageCalc — уведомление о новом расчёте ипотеки
 func (e *Env) NotifyTelegramMortgageCalc(
     ctx context.Context,
@@ -203,3 +255,112 @@ func (e *Env) NotifyTelegramMortgageCalc(
         e.sendTelegramMessage(bgCtx, chatID, text)
     }()
 }
+
+// NotifyTelegramFormulaCalc — уведомление о новом расчёте по формулам:
+// введённые значения полей и показанные посетителю результаты
+func (e *Env) NotifyTelegramFormulaCalc(
+    ctx context.Context,
+    calcID string,
+    cfg *domain.FormulaConfig,
+    values map[string]float64,
+    results []FormulaResult,
+) {
+    chatID, calcName, calcType, err := e.lookupTelegramForCalc(ctx, calcID)
+    if err != nil {
+        log.Printf("telegram: lookup failed for calc %s: %v", calcID, err)
+        return
+    }
+    if chatID == "" {
+        return
+    }
+
+    if calcName == "" {
+        calcName = calcID
+    }
+
+    var b strings.Builder
+    fmt.Fprintf(&b, "🧮 Новый расчёт по калькулятору «%s» (%s)\n\n", calcName, calcType)
+    for _, in := range cfg.Inputs {
+        label := in.Label
+        if label == "" {
+            label = in.Name
+        }
+        v := values[in.Name]
+        switch in.Kind {
+        case domain.FormulaInputCheckbox:
+            answer := "нет"
+            if v != 0 {
+                answer = "да"
+            }
+            fmt.Fprintf(&b, "%s: %s\n", label, answer)
+        case domain.FormulaInputSelect:
+            for _, o := range in.Options {
+                if o.Value == v {
+                    fmt.Fprintf(&b, "%s: %s\n", label, o.Label)
+                    break
+                }
+            }
+        default:
+            fmt.Fprintf(&b, "%s: %g %s\n", label, v, in.Unit)
+        }
+    }
+    b.WriteString("\n")
+    for _, res := range results {
+        fmt.Fprintf(&b, "%s: %.*f %s\n", res.Label, res.Decimals, res.Value, res.Unit)
+    }
+    text := strings.TrimSpace(b.String())
+
+    go func() {
+        bgCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+        defer cancel()
+        e.sendTelegramMessage(bgCtx, chatID, text)
+    }()
+}
+
+// NotifyTelegramFormCalc — уведомление о новом расчёте по калькулятору-форме:
+// строки расчёта, текстовые ответы посетителя и итог
+func (e *Env) NotifyTelegramFormCalc(
+    ctx context.Context,
+    calcID string,
+    items []FormLineItem,
+    notes []string,
+    total float64,
+) {
+    chatID, calcName, calcType, err := e.lookupTelegramForCalc(ctx, calcID)
+    if err != nil {
+        log.Printf("telegram: lookup failed for calc %s: %v", calcID, err)
+        return
+    }
+    if chatID == "" {
+        return
+    }
+
+    if calcName == "" {
+        calcName = calcID
+    }
+
+    // сообщение уходит с parse_mode=HTML, а подписи и ответы набирали владелец и посетитель — экранируем
+    var b strings.Builder
+    fmt.Fprintf(&b, "📝 Новый расчёт по калькулятору «%s» (%s)\n\n", html.EscapeString(calcName), calcType)
+    for _, it := range items {
+        line := it.Label
+        if it.Detail != "" {
+            line += " (" + it.Detail + ")"
+        }
+        fmt.Fprintf(&b, "%s: %.0f ₽\n", html.EscapeString(line), it.Amount)
+    }
+    if len(notes) > 0 {
+        b.WriteString("\n")
+        for _, n := range notes {
+            b.WriteString(html.EscapeString(n) + "\n")
+        }
+    }
+    fmt.Fprintf(&b, "\nИтого: %.0f ₽", total)
+    text := b.String()
+
+    go func() {
+        bgCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+        defer cancel()
+        e.sendTelegramMessage(bgCtx, chatID, text)
+    }()
+}
@@ -0,0 +1,317 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"saas-calc-backend/internal/domain"
+)
+
+const templateColumns = `id, name, description, type, config, builtin, sort_order, created_at, updated_at`
+
+func scanTemplate(row rowScanner) (*domain.CalculatorTemplate, error) {
+	var t domain.CalculatorTemplate
+	var ctype string
+	var raw []byte
+	if err := row.Scan(
+		&t.ID,
+		&t.Name,
+		&t.Description,
+		&ctype,
+		&raw,
+		&t.Builtin,
+		&t.SortOrder,
+		&t.CreatedAt,
+		&t.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+	t.Type = domain.CalculatorType(ctype)
+	t.Config = raw
+	return &t, nil
+}
+
+// listTemplates — каталог шаблонов (ctype пустой — все типы).
+func (e *Env) listTemplates(ctx context.Context, ctype string) ([]*domain.CalculatorTemplate, error) {
+	rows, err := e.DB.QueryContext(ctx, `
+SELECT `+templateColumns+`
+FROM calculator_templates
+WHERE ($1 = '' OR type = $1)
+ORDER BY sort_order, name
+`, ctype)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]*domain.CalculatorTemplate, 0)
+	for rows.Next() {
+		t, err := scanTemplate(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, t)
+	}
+	return items, rows.Err()
+}
+
+// getTemplate — шаблон по id (nil, если нет).
+func (e *Env) getTemplate(ctx context.Context, id string) (*domain.CalculatorTemplate, error) {
+	t, err := scanTemplate(e.DB.QueryRowContext(ctx,
+		`SELECT `+templateColumns+` FROM calculator_templates WHERE id = $1`,
+		id,
+	))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return t, nil
+}
+
+// applyTemplateConfig кладёт конфиг шаблона первой версией черновика калькулятора.
+// Картинки из /uploads/ копируются: правки калькулятора не должны менять шаблон.
+func (e *Env) applyTemplateConfig(ctx context.Context, calcID, authorID string, t *domain.CalculatorTemplate) error {
+	var cfg interface{}
+	if err := json.Unmarshal(t.Config, &cfg); err != nil {
+		return err
+	}
+	return e.saveCalculatorConfig(ctx, calcID, authorID, e.copyConfigUploads(cfg))
+}
+
+// GET /api/templates?type=layered — каталог шаблонов для создания калькулятора.
+func (e *Env) HandleTemplates(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if e.requireUser(w, r) == nil {
+		return
+	}
+	if e.DB == nil {
+		e.writeJSON(w, map[string]interface{}{"items": domain.BuiltinTemplates()})
+		return
+	}
+
+	items, err := e.listTemplates(r.Context(), r.URL.Query().Get("type"))
+	if err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	e.writeJSON(w, map[string]interface{}{"items": items})
+}
+
+type templateRequest struct {
+	ID          string          `json:"id"` // только при создании; пусто — сгенерируем
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Type        string          `json:"type"`
+	Config      json.RawMessage `json:"config"`
+	SortOrder   int             `json:"sortOrder"`
+}
+
+// HandleAdminTemplates обслуживает /api/admin/templates и /api/admin/templates/{id}.
+//
+// GET    /api/admin/templates      -> все шаблоны
+// POST   /api/admin/templates      -> создать шаблон
+// PUT    /api/admin/templates/{id} -> изменить (встроенные тоже можно)
+// DELETE /api/admin/templates/{id} -> удалить (кроме встроенных)
+func (e *Env) HandleAdminTemplates(w http.ResponseWriter, r *http.Request) {
+	if e.requireAdmin(w, r) == nil {
+		return
+	}
+	if e.DB == nil {
+		http.Error(w, "db is nil", http.StatusInternalServerError)
+		return
+	}
+
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/admin/templates"), "/")
+	if strings.Contains(id, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch {
+	case id == "" && r.Method == http.MethodGet:
+		items, err := e.listTemplates(r.Context(), "")
+		if err != nil {
+			http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		e.writeJSON(w, map[string]interface{}{"items": items})
+	case id == "" && r.Method == http.MethodPost:
+		e.handleAdminTemplateSave(w, r, "")
+	case id != "" && r.Method == http.MethodPut:
+		e.handleAdminTemplateSave(w, r, id)
+	case id != "" && r.Method == http.MethodDelete:
+		e.handleAdminTemplateDelete(w, r, id)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// id пустой — создание, иначе изменение существующего шаблона.
+func (e *Env) handleAdminTemplateSave(w http.ResponseWriter, r *http.Request, id string) {
+	defer r.Body.Close()
+
+	var req templateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+	ctype := domain.CalculatorType(req.Type)
+	if !domain.ValidCalculatorType(ctype) {
+		http.Error(w, "unknown calculator type", http.StatusBadRequest)
+		return
+	}
+	cfg, err := validateCalculatorConfig(ctype, req.Config)
+	if err != nil {
+		http.Error(w, "bad config: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.Config = cfg
+
+	if id == "" {
+		id = strings.TrimSpace(req.ID)
+		if id == "" {
+			suffix, err := randomHex(6)
+			if err != nil {
+				http.Error(w, "failed to generate id: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			id = "tpl_" + suffix
+		}
+
+		res, err := e.DB.ExecContext(r.Context(), `
+INSERT INTO calculator_templates (id, name, description, type, config, sort_order)
+VALUES ($1, $2, $3, $4, $5, $6)
+ON CONFLICT (id) DO NOTHING
+`, id, name, strings.TrimSpace(req.Description), string(ctype), []byte(req.Config), req.SortOrder)
+		if err != nil {
+			http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			http.Error(w, "template with this id already exists", http.StatusConflict)
+			return
+		}
+	} else {
+		res, err := e.DB.ExecContext(r.Context(), `
+UPDATE calculator_templates
+SET name = $2, description = $3, type = $4, config = $5, sort_order = $6, updated_at = $7
+WHERE id = $1
+`, id, name, strings.TrimSpace(req.Description), string(ctype), []byte(req.Config), req.SortOrder, time.Now())
+		if err != nil {
+			http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			http.Error(w, "template not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	t, err := e.getTemplate(r.Context(), id)
+	if err != nil || t == nil {
+		http.Error(w, "failed to load template", http.StatusInternalServerError)
+		return
+	}
+	if r.Method == http.MethodPost {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(t)
+		return
+	}
+	e.writeJSON(w, t)
+}
+
+func (e *Env) handleAdminTemplateDelete(w http.ResponseWriter, r *http.Request, id string) {
+	t, err := e.getTemplate(r.Context(), id)
+	if err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if t == nil {
+		http.Error(w, "template not found", http.StatusNotFound)
+		return
+	}
+	// встроенный шаблон всё равно вернётся при следующем старте
+	if t.Builtin {
+		http.Error(w, "builtin template cannot be deleted", http.StatusConflict)
+		return
+	}
+
+	if _, err := e.DB.ExecContext(r.Context(), `DELETE FROM calculator_templates WHERE id = $1`, id); err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// validateCalculatorConfig — конфиг (шаблона, импортированный) должен быть JSON-объектом,
+// а для layered/distance/on_site/formula/form ещё и читаться как конфиг этого типа
+// (у formula ещё и формулы должны разбираться, у form и on_site — проходить проверку полей).
+// Возвращает конфиг, который нужно сохранить: у on_site координаты базы с клиента
+// не принимаем и обнуляем — их заполнит геокодер при следующем сохранении или расчёте.
+func validateCalculatorConfig(t domain.CalculatorType, raw json.RawMessage) (json.RawMessage, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || raw[0] != '{' {
+		return nil, errors.New("config must be a json object")
+	}
+
+	var dst interface{}
+	switch t {
+	case domain.CalculatorTypeLayered:
+		dst = &domain.LayeredConfig{}
+	case domain.CalculatorTypeDistance:
+		dst = &domain.DistanceConfig{}
+	case domain.CalculatorTypeOnSite:
+		var cfg domain.OnSiteConfig
+		if err := json.Unmarshal(raw, &cfg); err != nil {
+			return nil, err
+		}
+		cfg.BaseAddress = strings.TrimSpace(cfg.BaseAddress)
+		if err := cfg.Validate(); err != nil {
+			return nil, err
+		}
+		cfg.BaseLat, cfg.BaseLon = 0, 0
+		return json.Marshal(&cfg)
+	case domain.CalculatorTypeFormula:
+		var cfg domain.FormulaConfig
+		if err := json.Unmarshal(raw, &cfg); err != nil {
+			return nil, err
+		}
+		if _, errs := compileFormulaConfig(&cfg); len(errs) > 0 {
+			return nil, errors.New(errs[0].Field + ": " + errs[0].Message)
+		}
+		return raw, nil
+	case domain.CalculatorTypeForm:
+		var cfg domain.FormConfig
+		if err := json.Unmarshal(raw, &cfg); err != nil {
+			return nil, err
+		}
+		if err := cfg.Validate(); err != nil {
+			return nil, err
+		}
+		return raw, nil
+	default:
+		dst = &map[string]interface{}{}
+	}
+	if err := json.Unmarshal(raw, dst); err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
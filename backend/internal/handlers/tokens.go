@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+// Виды одноразовых токенов в таблице user_tokens.
+const (
+	tokenKindConfirmEmail  = "confirm_email"
+	tokenKindResetPassword = "reset_password"
+	tokenKindInvite        = "invite"
+	tokenKindMFA           = "mfa" // пароль верный, ждём код второго фактора
+)
+
+// issueUserToken создаёт одноразовый токен для пользователя.
+// В БД хранится только sha256 от токена, сам токен возвращается для ссылки в письме.
+func (e *Env) issueUserToken(ctx context.Context, userID, kind string, ttl time.Duration) (string, error) {
+	if e.DB == nil {
+		return "", errors.New("db is nil")
+	}
+
+	token, err := randomHex(32)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = e.DB.ExecContext(ctx, `
+INSERT INTO user_tokens (token_hash, user_id, kind, expires_at)
+VALUES ($1, $2, $3, $4)
+`, hashToken(token), userID, kind, time.Now().Add(ttl))
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// consumeUserToken гасит токен и возвращает id пользователя.
+// Неизвестный, просроченный или уже использованный токен — ("", nil).
+func (e *Env) consumeUserToken(ctx context.Context, token, kind string) (string, error) {
+	if e.DB == nil {
+		return "", errors.New("db is nil")
+	}
+	if token == "" {
+		return "", nil
+	}
+
+	var userID string
+	err := e.DB.QueryRowContext(ctx, `
+UPDATE user_tokens
+SET used_at = now()
+WHERE token_hash = $1
+  AND kind = $2
+  AND used_at IS NULL
+  AND expires_at > now()
+RETURNING user_id
+`, hashToken(token), kind).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil
+		}
+		return "", err
+	}
+	return userID, nil
+}
+
+// peekUserToken — как consumeUserToken, но токен не гасит
+// (для шага 2FA: при неверном коде можно попробовать ещё раз).
+func (e *Env) peekUserToken(ctx context.Context, token, kind string) (string, error) {
+	if e.DB == nil {
+		return "", errors.New("db is nil")
+	}
+	if token == "" {
+		return "", nil
+	}
+
+	var userID string
+	err := e.DB.QueryRowContext(ctx, `
+SELECT user_id
+FROM user_tokens
+WHERE token_hash = $1
+  AND kind = $2
+  AND used_at IS NULL
+  AND expires_at > now()
+`, hashToken(token), kind).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil
+		}
+		return "", err
+	}
+	return userID, nil
+}
+
+// revokeUserTokens гасит все ещё не использованные токены пользователя данного вида.
+func (e *Env) revokeUserTokens(ctx context.Context, userID, kind string) error {
+	if e.DB == nil {
+		return errors.New("db is nil")
+	}
+	_, err := e.DB.ExecContext(ctx, `
+UPDATE user_tokens
+SET used_at = now()
+WHERE user_id = $1 AND kind = $2 AND used_at IS NULL
+`, userID, kind)
+	return err
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
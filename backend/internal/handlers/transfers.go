@@ -0,0 +1,502 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"saas-calc-backend/internal/domain"
+	"saas-calc-backend/internal/mail"
+)
+
+const transferColumns = `t.id, t.calculator_id, c.name, t.from_org_id, t.from_user_id, fu.email,
+	COALESCE(t.to_user_id, ''), t.to_email, t.status, t.created_at, t.expires_at, t.resolved_at`
+
+const transferTables = `calculator_transfers t
+JOIN calculators c ON c.id = t.calculator_id
+JOIN users fu ON fu.id = t.from_user_id`
+
+func scanTransfer(row rowScanner) (*domain.CalculatorTransfer, error) {
+	var t domain.CalculatorTransfer
+	var resolved sql.NullTime
+	if err := row.Scan(
+		&t.ID, &t.CalculatorID, &t.CalculatorName, &t.FromOrgID, &t.FromUserID, &t.FromEmail,
+		&t.ToUserID, &t.ToEmail, &t.Status, &t.CreatedAt, &t.ExpiresAt, &resolved,
+	); err != nil {
+		return nil, err
+	}
+	if resolved.Valid {
+		t.ResolvedAt = &resolved.Time
+	}
+	return &t, nil
+}
+
+// getTransfer — передача по id (nil, если нет).
+func (e *Env) getTransfer(ctx context.Context, id string) (*domain.CalculatorTransfer, error) {
+	t, err := scanTransfer(e.DB.QueryRowContext(ctx,
+		`SELECT `+transferColumns+` FROM `+transferTables+` WHERE t.id = $1`,
+		id,
+	))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return t, nil
+}
+
+// pendingTransfer — передача калькулятора, которая ещё ждёт ответа (nil, если нет).
+func (e *Env) pendingTransfer(ctx context.Context, calcID string) (*domain.CalculatorTransfer, error) {
+	t, err := scanTransfer(e.DB.QueryRowContext(ctx,
+		`SELECT `+transferColumns+` FROM `+transferTables+`
+WHERE t.calculator_id = $1 AND t.status = $2 AND t.expires_at > now()`,
+		calcID, domain.TransferStatusPending,
+	))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return t, nil
+}
+
+type transferRequest struct {
+	Email string `json:"email"` // кому передать
+	OrgID string `json:"orgId"` // только для админа: в какую организацию (по умолчанию — личная получателя)
+}
+
+type acceptTransferRequest struct {
+	OrgID string `json:"orgId"` // в какую организацию принять (по умолчанию — личная)
+}
+
+// --- /api/calculators/{id}/transfer ---
+
+// GET    -> передача, которая ждёт ответа получателя (owner)
+// POST   { email } -> предложить калькулятор по email; администратор передаёт сразу ({ email, orgId })
+// DELETE -> отозвать предложение (owner)
+func (e *Env) handleCalculatorTransfer(w http.ResponseWriter, r *http.Request, u *domain.User, id string) {
+	c := e.requireCalculator(w, r, u, id, domain.OrgRoleOwner)
+	if c == nil {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		t, err := e.pendingTransfer(r.Context(), c.ID)
+		if err != nil {
+			http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if t == nil {
+			http.Error(w, "no pending transfer", http.StatusNotFound)
+			return
+		}
+		e.writeJSON(w, t)
+
+	case http.MethodPost:
+		e.handleStartTransfer(w, r, u, c)
+
+	case http.MethodDelete:
+		res, err := e.DB.ExecContext(r.Context(), `
+UPDATE calculator_transfers SET status = $1, resolved_at = now()
+WHERE calculator_id = $2 AND status = $3
+`, domain.TransferStatusCancelled, c.ID, domain.TransferStatusPending)
+		if err != nil {
+			http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			http.Error(w, "no pending transfer", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Обычный владелец предлагает калькулятор по email: ответ одинаковый, есть ли учётная запись
+// с этим адресом или нет, — иначе через передачу можно проверять, чьи email зарегистрированы.
+// Принять предложение может только пользователь с этим (подтверждённым) email.
+func (e *Env) handleStartTransfer(w http.ResponseWriter, r *http.Request, u *domain.User, c *domain.Calculator) {
+	defer r.Body.Close()
+
+	var req transferRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+	if !looksLikeEmail(email) {
+		http.Error(w, "invalid email", http.StatusBadRequest)
+		return
+	}
+
+	// администратор переносит калькулятор сразу, без согласия получателя
+	if u.Role == domain.RoleAdmin {
+		to, err := e.userByEmail(r.Context(), email)
+		if err != nil {
+			http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if to == nil {
+			http.Error(w, "user with this email not found", http.StatusNotFound)
+			return
+		}
+		// иначе опубликованный калькулятор перестанет открываться у посетителей
+		if !to.EmailConfirmed {
+			http.Error(w, "recipient email is not confirmed", http.StatusBadRequest)
+			return
+		}
+		orgID := req.OrgID
+		if orgID == "" {
+			orgID = domain.PersonalOrgID(to.ID)
+		}
+		e.transferCalculator(w, r, c, to, orgID, "")
+		return
+	}
+
+	// email владельца калькулятора отправителю и так известен
+	owner, err := e.GetUserByID(r.Context(), c.OwnerID)
+	if err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if owner != nil && strings.EqualFold(owner.Email, email) {
+		http.Error(w, "calculator already belongs to this user", http.StatusBadRequest)
+		return
+	}
+
+	transferID, err := randomHex(12)
+	if err != nil {
+		http.Error(w, "failed to generate id: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	transferID = "trf_" + transferID
+
+	err = e.inTx(r.Context(), func(tx *sql.Tx) error {
+		// просроченное предложение не мешает сделать новое
+		if _, err := tx.ExecContext(r.Context(), `
+UPDATE calculator_transfers SET status = $1, resolved_at = now()
+WHERE calculator_id = $2 AND status = $3 AND expires_at <= now()
+`, domain.TransferStatusCancelled, c.ID, domain.TransferStatusPending); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(r.Context(), `
+INSERT INTO calculator_transfers (id, calculator_id, from_org_id, from_user_id, to_email, status, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+`, transferID, c.ID, c.OrgID, u.ID, email, domain.TransferStatusPending, time.Now().Add(domain.TransferTTL))
+		return err
+	})
+	if err != nil {
+		if isUniqueViolation(err) {
+			http.Error(w, "transfer is already pending", http.StatusConflict)
+			return
+		}
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	t, err := e.getTransfer(r.Context(), transferID)
+	if err != nil || t == nil {
+		http.Error(w, "failed to load transfer", http.StatusInternalServerError)
+		return
+	}
+
+	e.sendTransferEmail(email, u.Email, c.Name)
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(t)
+}
+
+// sendTransferEmail уведомляет получателя, если у него есть учётная запись. Поиск и отправка идут в фоне,
+// чтобы время ответа не выдавало, зарегистрирован ли адрес; частые письма на один адрес молча пропускаем.
+func (e *Env) sendTransferEmail(to, from, calcName string) {
+	if e.MailLimit != nil {
+		if ok, _ := e.MailLimit.Allow("transfer:" + to); !ok {
+			return
+		}
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+
+		recipient, err := e.userByEmail(ctx, to)
+		if err != nil {
+			log.Printf("transfer: lookup %s: %v", to, err)
+			return
+		}
+		if recipient == nil {
+			return
+		}
+		e.sendMail(ctx, mail.Message{
+			To:      recipient.Email,
+			Subject: "Вам передают калькулятор",
+			Body: "Здравствуйте, " + recipient.Name + "!\n\n" +
+				from + " предлагает передать вам калькулятор «" + calcName + "» вместе с заявками.\n" +
+				"Принять или отклонить передачу можно в кабинете:\n" +
+				e.absoluteURL("/app") + "\n\n" +
+				"Предложение действует 14 дней.",
+		})
+	}()
+}
+
+// userByEmail — пользователь с таким email без учёта регистра (nil, если нет).
+func (e *Env) userByEmail(ctx context.Context, email string) (*domain.User, error) {
+	var id string
+	err := e.DB.QueryRowContext(ctx,
+		`SELECT id FROM users WHERE lower(email) = lower($1)`,
+		email,
+	).Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return e.GetUserByID(ctx, id)
+}
+
+// --- /api/calculators/transfers/... ---
+
+// GET  /api/calculators/transfers                -> входящие передачи, которые ждут ответа
+// POST /api/calculators/transfers/{id}/accept    { orgId } -> принять калькулятор в свою организацию
+// POST /api/calculators/transfers/{id}/decline   -> отказаться
+func (e *Env) handleTransfers(w http.ResponseWriter, r *http.Request, u *domain.User, parts []string) {
+	if len(parts) == 0 {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		e.handleListIncomingTransfers(w, r, u)
+		return
+	}
+	if len(parts) != 2 || (parts[1] != "accept" && parts[1] != "decline") {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	t, err := e.getTransfer(r.Context(), parts[0])
+	if err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if t == nil || !strings.EqualFold(t.ToEmail, u.Email) {
+		http.Error(w, "transfer not found", http.StatusNotFound)
+		return
+	}
+	if !t.Pending(time.Now()) {
+		http.Error(w, "transfer is no longer pending", http.StatusGone)
+		return
+	}
+
+	if parts[1] == "decline" {
+		if _, err := e.DB.ExecContext(r.Context(),
+			`UPDATE calculator_transfers SET status = $1, resolved_at = now() WHERE id = $2 AND status = $3`,
+			domain.TransferStatusDeclined, t.ID, domain.TransferStatusPending,
+		); err != nil {
+			http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// предложение адресовано email — принять его может только тот, кто этим адресом владеет;
+	// иначе опубликованный калькулятор перестанет открываться у посетителей
+	if !u.EmailConfirmed {
+		http.Error(w, "confirm your email to accept the transfer", http.StatusForbidden)
+		return
+	}
+
+	defer r.Body.Close()
+	var req acceptTransferRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad json: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	orgID := req.OrgID
+	if orgID == "" {
+		orgID = domain.PersonalOrgID(u.ID)
+	}
+
+	c, err := e.GetCalculatorByID(r.Context(), t.CalculatorID)
+	if err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if c == nil {
+		http.Error(w, "calculator was deleted", http.StatusGone)
+		return
+	}
+
+	// предложение действительно, пока калькулятор там же, а отправитель всё ещё его owner
+	from, err := e.GetUserByID(r.Context(), t.FromUserID)
+	if err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fromRole := domain.OrgRole("")
+	if from != nil {
+		if fromRole, err = e.orgRole(r.Context(), from, c.OrgID); err != nil {
+			http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if c.OrgID != t.FromOrgID || !fromRole.AtLeast(domain.OrgRoleOwner) {
+		if _, err := e.DB.ExecContext(r.Context(),
+			`UPDATE calculator_transfers SET status = $1, resolved_at = now() WHERE id = $2`,
+			domain.TransferStatusCancelled, t.ID,
+		); err != nil {
+			http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.Error(w, "transfer is no longer valid", http.StatusGone)
+		return
+	}
+
+	e.transferCalculator(w, r, c, u, orgID, t.ID)
+}
+
+func (e *Env) handleListIncomingTransfers(w http.ResponseWriter, r *http.Request, u *domain.User) {
+	rows, err := e.DB.QueryContext(r.Context(), `
+SELECT `+transferColumns+`
+FROM `+transferTables+`
+WHERE t.to_email = lower($1) AND t.status = $2 AND t.expires_at > now() AND c.deleted_at IS NULL
+ORDER BY t.created_at DESC
+`, u.Email, domain.TransferStatusPending)
+	if err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	items := make([]*domain.CalculatorTransfer, 0)
+	for rows.Next() {
+		t, err := scanTransfer(rows)
+		if err != nil {
+			http.Error(w, "db scan error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		items = append(items, t)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	e.writeJSON(w, map[string]interface{}{"items": items})
+}
+
+// errCalculatorGone — калькулятор удалили, пока шла передача.
+var errCalculatorGone = errors.New("calculator was deleted")
+
+// transferCalculator переносит калькулятор к пользователю to в организацию orgID и отвечает калькулятором.
+// Конфиг и история версий привязаны к калькулятору и переходят вместе с ним, картинки в конфиге
+// ни за кем не закреплены; заявки калькулятора переходят к новому владельцу.
+// Ссылка /p/ содержит id владельца, поэтому она меняется, а старая навсегда переадресует на новую.
+// transferID — принятое предложение ("" — передача администратором).
+func (e *Env) transferCalculator(w http.ResponseWriter, r *http.Request, c *domain.Calculator, to *domain.User, orgID, transferID string) {
+	role, err := e.orgRole(r.Context(), to, orgID)
+	if err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !role.AtLeast(domain.OrgRoleEditor) {
+		http.Error(w, "recipient must be an editor of the target organization", http.StatusBadRequest)
+		return
+	}
+	if to.ID == c.OwnerID && orgID == c.OrgID {
+		http.Error(w, "calculator already belongs to this user", http.StatusBadRequest)
+		return
+	}
+	// в своей организации калькулятор уже посчитан
+	if orgID != c.OrgID && !e.checkCalculatorLimit(w, r, to, orgID) {
+		return
+	}
+
+	oldTokenPath := domain.TokenPublicPath(c.OwnerID, c.PublicToken)
+	newTokenPath := domain.TokenPublicPath(to.ID, c.PublicToken)
+	newPath := newTokenPath
+	if c.Slug != "" {
+		newPath = domain.SlugPublicPath(c.Slug)
+	}
+	// папки принадлежат организации
+	folderID := c.FolderID
+	if orgID != c.OrgID {
+		folderID = ""
+	}
+
+	err = e.inTx(r.Context(), func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(r.Context(), `
+UPDATE calculators SET owner_id = $1, org_id = $2, folder_id = $3, public_path = $4
+WHERE id = $5 AND deleted_at IS NULL
+`, to.ID, orgID, nullableString(folderID), newPath, c.ID)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return errCalculatorGone
+		}
+
+		if _, err := tx.ExecContext(r.Context(),
+			`UPDATE leads SET owner_id = $1 WHERE calc_id = $2`,
+			to.ID, c.ID,
+		); err != nil {
+			return err
+		}
+
+		if oldTokenPath != newTokenPath {
+			if _, err := tx.ExecContext(r.Context(), `DELETE FROM calculator_redirects WHERE path = $1`, newTokenPath); err != nil {
+				return err
+			}
+			if err := addRedirect(r.Context(), tx, oldTokenPath, c.ID, domain.RedirectReasonOwnerChanged, nil); err != nil {
+				return err
+			}
+		}
+
+		// принятое предложение закрываем (и запоминаем, кто принял), остальные ожидающие (передача админом) отзываем
+		_, err = tx.ExecContext(r.Context(), `
+UPDATE calculator_transfers
+SET status = CASE WHEN id = $1 THEN $2 ELSE $3 END,
+    to_user_id = CASE WHEN id = $1 THEN $6 ELSE to_user_id END,
+    resolved_at = now()
+WHERE calculator_id = $4 AND status = $5
+`, transferID, domain.TransferStatusAccepted, domain.TransferStatusCancelled, c.ID, domain.TransferStatusPending, to.ID)
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, errCalculatorGone) {
+			http.Error(w, err.Error(), http.StatusGone)
+			return
+		}
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	for _, cached := range e.Calculators {
+		if cached.ID == c.ID {
+			cached.OwnerID = to.ID
+			cached.OrgID = orgID
+			cached.FolderID = folderID
+			cached.PublicPath = newPath
+			break
+		}
+	}
+
+	e.respondUpdatedCalculator(w, r, c.ID)
+}
@@ -0,0 +1,281 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"saas-calc-backend/internal/domain"
+)
+
+// trashRetention — срок хранения калькулятора в корзине.
+func (e *Env) trashRetention() time.Duration {
+	if e.TrashRetention > 0 {
+		return e.TrashRetention
+	}
+	return domain.DefaultTrashRetention
+}
+
+// trashedCalculatorResponse — калькулятор из корзины и момент, когда его удалят насовсем.
+type trashedCalculatorResponse struct {
+	*domain.Calculator
+	PurgeAt time.Time `json:"purgeAt"`
+}
+
+// getTrashedCalculator — калькулятор из корзины (nil, если его нет или он не удалён).
+func (e *Env) getTrashedCalculator(ctx context.Context, id string) (*domain.Calculator, error) {
+	c, err := scanCalculator(e.DB.QueryRowContext(ctx,
+		`SELECT `+calculatorColumns+` FROM calculators WHERE id = $1 AND deleted_at IS NOT NULL`,
+		id,
+	))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return c, nil
+}
+
+// --- GET /api/calculators/trash?orgId= ---
+
+// Корзина: удалённые калькуляторы организаций пользователя (админ видит все), новые сверху.
+func (e *Env) handleListTrash(w http.ResponseWriter, r *http.Request, u *domain.User) {
+	orgID := r.URL.Query().Get("orgId")
+
+	var rows *sql.Rows
+	var err error
+	if u.Role == domain.RoleAdmin {
+		rows, err = e.DB.QueryContext(r.Context(), `
+SELECT `+calculatorColumns+`
+FROM calculators
+WHERE deleted_at IS NOT NULL AND ($1 = '' OR org_id = $1)
+ORDER BY deleted_at DESC
+`, orgID)
+	} else {
+		rows, err = e.DB.QueryContext(r.Context(), `
+SELECT `+calculatorColumns+`
+FROM calculators
+WHERE deleted_at IS NOT NULL
+  AND org_id IN (SELECT org_id FROM org_members WHERE user_id = $1)
+  AND ($2 = '' OR org_id = $2)
+ORDER BY deleted_at DESC
+`, u.ID, orgID)
+	}
+	if err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	retention := e.trashRetention()
+	items := make([]trashedCalculatorResponse, 0)
+	for rows.Next() {
+		c, err := scanCalculator(rows)
+		if err != nil {
+			http.Error(w, "db scan error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		items = append(items, trashedCalculatorResponse{Calculator: c, PurgeAt: c.DeletedAt.Add(retention)})
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	e.writeJSON(w, map[string]interface{}{"items": items})
+}
+
+// --- POST /api/calculators/{id}/restore ---
+
+// Восстановить из корзины может owner организации, пока не истёк срок хранения.
+// Калькулятор снова занимает место в тарифе, поэтому лимит проверяется заново.
+func (e *Env) handleRestoreCalculator(w http.ResponseWriter, r *http.Request, u *domain.User, id string) {
+	c, err := e.getTrashedCalculator(r.Context(), id)
+	if err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if c == nil {
+		http.Error(w, "calculator not found in trash", http.StatusNotFound)
+		return
+	}
+	role, err := e.orgRole(r.Context(), u, c.OrgID)
+	if err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if role == "" {
+		http.Error(w, "calculator not found in trash", http.StatusNotFound)
+		return
+	}
+	if !role.AtLeast(domain.OrgRoleOwner) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if time.Since(*c.DeletedAt) > e.trashRetention() {
+		http.Error(w, "restore period has expired", http.StatusGone)
+		return
+	}
+
+	if !e.checkCalculatorLimit(w, r, u, c.OrgID) {
+		return
+	}
+
+	if _, err := e.DB.ExecContext(r.Context(),
+		`UPDATE calculators SET deleted_at = NULL WHERE id = $1`,
+		c.ID,
+	); err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	restored, err := e.GetCalculatorByID(r.Context(), c.ID)
+	if err != nil || restored == nil {
+		http.Error(w, "failed to load calculator", http.StatusInternalServerError)
+		return
+	}
+	e.Calculators = append(e.Calculators, restored)
+	e.writeJSON(w, restored)
+}
+
+// --- очистка корзины ---
+
+// StartTrashPurger раз в interval удаляет насовсем калькуляторы, у которых истёк срок хранения в корзине.
+// Первый проход — сразу при старте.
+func (e *Env) StartTrashPurger(interval time.Duration) {
+	if e.DB == nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+			n, err := e.PurgeTrash(ctx)
+			cancel()
+			if err != nil {
+				log.Printf("trash purge: %v", err)
+			} else if n > 0 {
+				log.Printf("trash purge: removed %d calculators", n)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// PurgeTrash удаляет калькуляторы, пролежавшие в корзине дольше срока хранения.
+// Конфиги, история версий и переадресации удаляются каскадом, заявки остаются без ссылки на калькулятор.
+// Картинки из их конфигов удаляются, если на них больше не ссылается ни один калькулятор или шаблон.
+func (e *Env) PurgeTrash(ctx context.Context) (int, error) {
+	cutoff := time.Now().Add(-e.trashRetention())
+
+	rows, err := e.DB.QueryContext(ctx,
+		`SELECT id FROM calculators WHERE deleted_at IS NOT NULL AND deleted_at < $1`,
+		cutoff,
+	)
+	if err != nil {
+		return 0, err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, id := range ids {
+		urls, err := e.calculatorUploadURLs(ctx, id)
+		if err != nil {
+			return purged, err
+		}
+
+		// повторная проверка deleted_at: калькулятор могли восстановить, пока мы шли по списку
+		res, err := e.DB.ExecContext(ctx,
+			`DELETE FROM calculators WHERE id = $1 AND deleted_at IS NOT NULL AND deleted_at < $2`,
+			id, cutoff,
+		)
+		if err != nil {
+			return purged, err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			continue
+		}
+		purged++
+
+		for _, url := range urls {
+			used, err := e.uploadReferenced(ctx, url)
+			if err != nil {
+				return purged, err
+			}
+			if !used {
+				e.removeUpload(url)
+			}
+		}
+	}
+	return purged, nil
+}
+
+// calculatorUploadURLs — все ссылки /uploads/ из всех версий конфига калькулятора.
+func (e *Env) calculatorUploadURLs(ctx context.Context, calcID string) ([]string, error) {
+	rows, err := e.DB.QueryContext(ctx,
+		`SELECT config FROM calculator_config_versions WHERE calculator_id = $1`,
+		calcID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	seen := map[string]bool{}
+	var urls []string
+	for rows.Next() {
+		var raw []byte
+		if err := rows.Scan(&raw); err != nil {
+			return nil, err
+		}
+		var cfg interface{}
+		if err := json.Unmarshal(raw, &cfg); err != nil {
+			log.Printf("trash purge: config of %s: %v", calcID, err)
+			continue
+		}
+		mapUploadURLs(cfg, func(url string) string {
+			if !seen[url] {
+				seen[url] = true
+				urls = append(urls, url)
+			}
+			return url
+		})
+	}
+	return urls, rows.Err()
+}
+
+// uploadReferenced — ссылается ли на картинку какая-нибудь версия конфига или шаблон.
+func (e *Env) uploadReferenced(ctx context.Context, url string) (bool, error) {
+	// ищем строку JSON целиком, с кавычками, чтобы a.png не совпал с a.png.bak
+	var quoted bytes.Buffer
+	enc := json.NewEncoder(&quoted)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(url); err != nil {
+		return false, err
+	}
+	var used bool
+	err := e.DB.QueryRowContext(ctx, `
+SELECT EXISTS (SELECT 1 FROM calculator_config_versions WHERE strpos(config::text, $1) > 0)
+    OR EXISTS (SELECT 1 FROM calculator_templates WHERE strpos(config::text, $1) > 0)
+`, strings.TrimSpace(quoted.String())).Scan(&used)
+	return used, err
+}
@@ -0,0 +1,532 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"saas-calc-backend/internal/domain"
+	"saas-calc-backend/internal/ratelimit"
+	"saas-calc-backend/internal/totp"
+)
+
+const (
+	// totpIssuer — название сервиса в приложении-аутентификаторе.
+	totpIssuer = "SaaS Calc"
+	// mfaTTL — сколько после верного пароля есть на ввод кода.
+	mfaTTL = 5 * time.Minute
+	// recoveryCodeCount — сколько кодов восстановления выдаём.
+	recoveryCodeCount = 10
+)
+
+// ответ /api/auth/login, если у пользователя включена 2FA:
+// сессии ещё нет, нужен второй шаг /api/auth/login/2fa
+type loginMFAResponse struct {
+	MFARequired bool   `json:"mfaRequired"`
+	MFAToken    string `json:"mfaToken"`
+}
+
+type loginMFARequest struct {
+	MFAToken     string `json:"mfaToken"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoveryCode"`
+}
+
+type twoFactorStatus struct {
+	Enabled           bool `json:"enabled"`
+	Pending           bool `json:"pending"`  // секрет выдан, но ещё не подтверждён кодом
+	Required          bool `json:"required"` // обязательна для этого пользователя
+	RecoveryCodesLeft int  `json:"recoveryCodesLeft"`
+}
+
+type twoFactorEnrollResponse struct {
+	Secret     string `json:"secret"`
+	OtpauthURI string `json:"otpauthUri"`
+}
+
+type twoFactorCodeRequest struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoveryCode"`
+}
+
+type recoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// POST /api/auth/login/2fa { "mfaToken": "...", "code": "123456" } или { ..., "recoveryCode": "..." }
+func (e *Env) HandleAuthLogin2FA(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	defer r.Body.Close()
+
+	var req loginMFARequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// после входа через провайдера токен приходит в HttpOnly cookie, а не в теле
+	if req.MFAToken == "" {
+		if c, err := r.Cookie(mfaCookieName); err == nil {
+			req.MFAToken = c.Value
+		}
+	}
+
+	ip := ratelimit.ClientIP(r)
+	if wait := e.LoginBackoff.Blocked(ip); wait > 0 {
+		ratelimit.TooManyRequests(w, wait)
+		return
+	}
+
+	userID, err := e.peekUserToken(r.Context(), req.MFAToken, tokenKindMFA)
+	if err != nil {
+		http.Error(w, "failed to check token: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if userID == "" {
+		http.Error(w, "invalid or expired token", http.StatusUnauthorized)
+		return
+	}
+
+	u, err := e.GetUserByID(r.Context(), userID)
+	if err != nil || u == nil {
+		http.Error(w, "failed to load user", http.StatusInternalServerError)
+		return
+	}
+
+	// подбор кода тормозим так же, как подбор пароля
+	wait, err := e.accountLockedFor(r.Context(), u.Email)
+	if err != nil {
+		http.Error(w, "login failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if wait > 0 {
+		ratelimit.TooManyRequests(w, wait)
+		return
+	}
+
+	ok, err := e.checkSecondFactor(r.Context(), u.ID, req.Code, req.RecoveryCode)
+	if err != nil {
+		http.Error(w, "login failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		e.LoginBackoff.Fail(ip)
+		if err := e.recordLoginFailure(r.Context(), u.Email); err != nil {
+			log.Printf("login 2fa: record failure for %s: %v", u.ID, err)
+		}
+		http.Error(w, "invalid code", http.StatusUnauthorized)
+		return
+	}
+
+	// токен одноразовый: если его успели использовать параллельно — не пускаем второй раз
+	if id, err := e.consumeUserToken(r.Context(), req.MFAToken, tokenKindMFA); err != nil || id == "" {
+		http.Error(w, "invalid or expired token", http.StatusUnauthorized)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     mfaCookieName,
+		Value:    "",
+		Path:     "/api/auth/login/2fa",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	// счётчик по IP успешным входом не сбрасываем: иначе между попытками подбора
+	// можно входить в свой аккаунт и обнулять задержку. Он забывается сам, без неудач.
+	if err := e.resetLoginFailures(r.Context(), u.ID); err != nil {
+		log.Printf("login 2fa: reset failures for %s: %v", u.ID, err)
+	}
+
+	if err := e.startSession(w, r, u.ID); err != nil {
+		http.Error(w, "failed to create session: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	e.writeJSON(w, u)
+}
+
+// /api/me/2fa                 GET (статус)
+// /api/me/2fa/enroll          POST — выдать секрет и otpauth:// ссылку
+// /api/me/2fa/activate        POST { code } — подтвердить и включить, в ответе коды восстановления
+// /api/me/2fa/recovery-codes  POST { code } — выпустить новые коды восстановления
+// /api/me/2fa/disable         POST { code | recoveryCode } — выключить
+func (e *Env) HandleMe2FA(w http.ResponseWriter, r *http.Request) {
+	u := e.requireUser(w, r)
+	if u == nil {
+		return
+	}
+
+	action := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/me/2fa"), "/")
+
+	if action == "" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		e.handle2FAStatus(w, r, u)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch action {
+	case "enroll":
+		e.handle2FAEnroll(w, r, u)
+	case "activate":
+		e.handle2FAActivate(w, r, u)
+	case "recovery-codes":
+		e.handle2FARecoveryCodes(w, r, u)
+	case "disable":
+		e.handle2FADisable(w, r, u)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (e *Env) handle2FAStatus(w http.ResponseWriter, r *http.Request, u *domain.User) {
+	var st twoFactorStatus
+	err := e.DB.QueryRowContext(r.Context(), `
+SELECT u.totp_enabled,
+       COALESCE(u.totp_secret, '') <> '',
+       (SELECT COUNT(*) FROM recovery_codes c WHERE c.user_id = u.id AND c.used_at IS NULL)
+FROM users u
+WHERE u.id = $1
+`, u.ID).Scan(&st.Enabled, &st.Pending, &st.RecoveryCodesLeft)
+	if err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if st.Enabled {
+		st.Pending = false
+	}
+
+	if u.Role == domain.RoleAdmin {
+		required, err := e.adminRequires2FA(r.Context())
+		if err != nil {
+			http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		st.Required = required
+	}
+
+	e.writeJSON(w, st)
+}
+
+func (e *Env) handle2FAEnroll(w http.ResponseWriter, r *http.Request, u *domain.User) {
+	if u.TwoFactor {
+		http.Error(w, "2fa already enabled", http.StatusConflict)
+		return
+	}
+
+	secret, err := totp.NewSecret()
+	if err != nil {
+		http.Error(w, "failed to generate secret: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// секрет ждёт подтверждения кодом; повторный enroll просто заменяет его
+	if _, err := e.DB.ExecContext(r.Context(), `
+UPDATE users
+SET totp_secret = $1, totp_enabled = FALSE, totp_last_counter = 0
+WHERE id = $2
+`, secret, u.ID); err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	e.writeJSON(w, twoFactorEnrollResponse{
+		Secret:     secret,
+		OtpauthURI: totp.URI(totpIssuer, u.Email, secret),
+	})
+}
+
+func (e *Env) handle2FAActivate(w http.ResponseWriter, r *http.Request, u *domain.User) {
+	defer r.Body.Close()
+
+	var req twoFactorCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if u.TwoFactor {
+		http.Error(w, "2fa already enabled", http.StatusConflict)
+		return
+	}
+
+	secret, last, err := e.loadTOTP(r.Context(), u.ID)
+	if err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if secret == "" {
+		http.Error(w, "call /api/me/2fa/enroll first", http.StatusBadRequest)
+		return
+	}
+
+	counter, ok := totp.Validate(secret, req.Code, time.Now(), last)
+	if !ok {
+		http.Error(w, "invalid code", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := e.DB.ExecContext(r.Context(), `
+UPDATE users
+SET totp_enabled = TRUE, totp_last_counter = $1
+WHERE id = $2
+`, counter, u.ID); err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// сессии, открытые без второго фактора (в том числе чужие), завершаем; текущая остаётся
+	if _, err := e.DB.ExecContext(r.Context(),
+		`DELETE FROM sessions WHERE user_id = $1 AND id <> $2`,
+		u.ID, e.sessionIDFromRequest(r),
+	); err != nil {
+		http.Error(w, "failed to revoke sessions: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	codes, err := e.issueRecoveryCodes(r.Context(), u.ID)
+	if err != nil {
+		http.Error(w, "failed to issue recovery codes: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	e.writeJSON(w, recoveryCodesResponse{RecoveryCodes: codes})
+}
+
+func (e *Env) handle2FARecoveryCodes(w http.ResponseWriter, r *http.Request, u *domain.User) {
+	defer r.Body.Close()
+
+	var req twoFactorCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !u.TwoFactor {
+		http.Error(w, "2fa is not enabled", http.StatusBadRequest)
+		return
+	}
+	if !e.check2FAThrottle(w, r, u) {
+		return
+	}
+
+	ok, err := e.checkSecondFactor(r.Context(), u.ID, req.Code, "")
+	if err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		e.record2FAFailure(r, u)
+		http.Error(w, "invalid code", http.StatusBadRequest)
+		return
+	}
+
+	codes, err := e.issueRecoveryCodes(r.Context(), u.ID)
+	if err != nil {
+		http.Error(w, "failed to issue recovery codes: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	e.writeJSON(w, recoveryCodesResponse{RecoveryCodes: codes})
+}
+
+func (e *Env) handle2FADisable(w http.ResponseWriter, r *http.Request, u *domain.User) {
+	defer r.Body.Close()
+
+	var req twoFactorCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !u.TwoFactor {
+		http.Error(w, "2fa is not enabled", http.StatusBadRequest)
+		return
+	}
+
+	if u.Role == domain.RoleAdmin {
+		required, err := e.adminRequires2FA(r.Context())
+		if err != nil {
+			http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if required {
+			http.Error(w, "2fa is required for admins", http.StatusForbidden)
+			return
+		}
+	}
+
+	if !e.check2FAThrottle(w, r, u) {
+		return
+	}
+
+	ok, err := e.checkSecondFactor(r.Context(), u.ID, req.Code, req.RecoveryCode)
+	if err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		e.record2FAFailure(r, u)
+		http.Error(w, "invalid code", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := e.DB.ExecContext(r.Context(), `
+UPDATE users
+SET totp_secret = NULL, totp_enabled = FALSE, totp_last_counter = 0
+WHERE id = $1
+`, u.ID); err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if _, err := e.DB.ExecContext(r.Context(), `DELETE FROM recovery_codes WHERE user_id = $1`, u.ID); err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// check2FAThrottle — подбор кода в кабинете (выключение 2FA, новые коды восстановления)
+// тормозим так же, как при входе: по IP и по учётной записи. false — уже ответили 429.
+func (e *Env) check2FAThrottle(w http.ResponseWriter, r *http.Request, u *domain.User) bool {
+	if wait := e.LoginBackoff.Blocked(ratelimit.ClientIP(r)); wait > 0 {
+		ratelimit.TooManyRequests(w, wait)
+		return false
+	}
+	wait, err := e.accountLockedFor(r.Context(), u.Email)
+	if err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	if wait > 0 {
+		ratelimit.TooManyRequests(w, wait)
+		return false
+	}
+	return true
+}
+
+// record2FAFailure — неверный код в кабинете считается неудачной попыткой входа.
+func (e *Env) record2FAFailure(r *http.Request, u *domain.User) {
+	e.LoginBackoff.Fail(ratelimit.ClientIP(r))
+	if err := e.recordLoginFailure(r.Context(), u.Email); err != nil {
+		log.Printf("2fa: record failure for %s: %v", u.ID, err)
+	}
+}
+
+// checkSecondFactor проверяет TOTP-код (и запоминает его шаг, чтобы не принять повторно)
+// или гасит код восстановления.
+func (e *Env) checkSecondFactor(ctx context.Context, userID, code, recoveryCode string) (bool, error) {
+	if recoveryCode = normalizeRecoveryCode(recoveryCode); recoveryCode != "" {
+		res, err := e.DB.ExecContext(ctx, `
+UPDATE recovery_codes
+SET used_at = now()
+WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+`, userID, hashToken(recoveryCode))
+		if err != nil {
+			return false, err
+		}
+		n, _ := res.RowsAffected()
+		return n == 1, nil
+	}
+
+	secret, last, err := e.loadTOTP(ctx, userID)
+	if err != nil || secret == "" {
+		return false, err
+	}
+	counter, ok := totp.Validate(secret, code, time.Now(), last)
+	if !ok {
+		return false, nil
+	}
+
+	// условие на last защищает от гонки двух запросов с одним кодом
+	res, err := e.DB.ExecContext(ctx, `
+UPDATE users
+SET totp_last_counter = $1
+WHERE id = $2 AND totp_last_counter = $3
+`, counter, userID, last)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n == 1, nil
+}
+
+func (e *Env) loadTOTP(ctx context.Context, userID string) (string, int64, error) {
+	var secret string
+	var last int64
+	err := e.DB.QueryRowContext(ctx,
+		`SELECT COALESCE(totp_secret, ''), totp_last_counter FROM users WHERE id = $1`,
+		userID,
+	).Scan(&secret, &last)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", 0, nil
+	}
+	return secret, last, err
+}
+
+// issueRecoveryCodes заменяет коды восстановления новыми; в БД — только sha256.
+func (e *Env) issueRecoveryCodes(ctx context.Context, userID string) ([]string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		h, err := randomHex(8)
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, h[0:4]+"-"+h[4:8]+"-"+h[8:12]+"-"+h[12:16])
+	}
+
+	tx, err := e.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return nil, err
+	}
+	for _, c := range codes {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)`,
+			userID, hashToken(normalizeRecoveryCode(c)),
+		); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// normalizeRecoveryCode — без дефисов/пробелов и в нижнем регистре, как бы его ни ввели.
+func normalizeRecoveryCode(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	s = strings.Replace(s, "-", "", -1)
+	return strings.Replace(s, " ", "", -1)
+}
+
+// adminRequires2FA — включена ли в настройках обязательная 2FA для администраторов.
+func (e *Env) adminRequires2FA(ctx context.Context) (bool, error) {
+	if e.DB == nil {
+		return false, nil
+	}
+	var required bool
+	err := e.DB.QueryRowContext(ctx,
+		`SELECT require_admin_2fa FROM settings WHERE id = 1`,
+	).Scan(&required)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return required, err
+}
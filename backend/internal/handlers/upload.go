@@ -2,14 +2,20 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"io"
+	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"time"
 )
 
+// uploadURLPrefix — так начинаются ссылки на загруженные файлы в конфигах калькуляторов.
+const uploadURLPrefix = "/uploads/"
+
 type uploadResponse struct {
 	URL string `json:"url"`
 }
@@ -22,6 +28,9 @@ func (e *Env) HandleUpload(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	if e.requireUser(w, r) == nil {
+		return
+	}
 
 	if e.UploadDir == "" {
 		e.UploadDir = "../frontend/uploads"
@@ -83,3 +92,81 @@ func sanitizeFilename(s string) string {
 	s = strings.ReplaceAll(s, "\\", "_")
 	return s
 }
+
+// uploadNamePrefix — метка времени (и случайный суффикс у копий) в начале имени загруженного файла.
+var uploadNamePrefix = regexp.MustCompile(`^[0-9]{8}_[0-9]{6}_(?:[0-9a-f]{6}_)?`)
+
+// uploadFilePath — путь к файлу по ссылке /uploads/имя (ошибка, если ссылка не на наш файл).
+func (e *Env) uploadFilePath(url string) (string, error) {
+	name := strings.TrimPrefix(url, uploadURLPrefix)
+	if name == url || name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return "", errors.New("not an upload url: " + url)
+	}
+
+	dir := e.UploadDir
+	if dir == "" {
+		dir = "../frontend/uploads"
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// copyUpload копирует загруженный файл под новым именем и возвращает ссылку на копию.
+func (e *Env) copyUpload(url string) (string, error) {
+	srcPath, err := e.uploadFilePath(url)
+	if err != nil {
+		return "", err
+	}
+
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	return e.storeUpload(filepath.Base(srcPath), src)
+}
+
+// storeUpload сохраняет содержимое src новым загруженным файлом и возвращает ссылку на него.
+// Имя строится из name без прежней метки времени: <время>_<случайный суффикс>_<имя>.
+func (e *Env) storeUpload(name string, src io.Reader) (string, error) {
+	dir := e.UploadDir
+	if dir == "" {
+		dir = "../frontend/uploads"
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	suffix, err := randomHex(3)
+	if err != nil {
+		return "", err
+	}
+	base := sanitizeFilename(uploadNamePrefix.ReplaceAllString(filepath.Base(name), ""))
+	filename := time.Now().Format("20060102_150405") + "_" + suffix + "_" + base
+
+	dst, err := os.OpenFile(filepath.Join(dir, filename), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		os.Remove(dst.Name())
+		return "", err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(dst.Name())
+		return "", err
+	}
+	return uploadURLPrefix + filename, nil
+}
+
+// removeUpload удаляет загруженный файл по ссылке (ошибки только в лог).
+func (e *Env) removeUpload(url string) {
+	path, err := e.uploadFilePath(url)
+	if err == nil {
+		err = os.Remove(path)
+	}
+	if err != nil {
+		log.Printf("remove upload %s: %v", url, err)
+	}
+}
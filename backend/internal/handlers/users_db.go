@@ -18,7 +18,7 @@ func (e *Env) GetUserByID(ctx context.Context, id string) (*domain.User, error)
 	}
 
 	row := e.DB.QueryRowContext(ctx, `
-SELECT id, email, name, role, plan_id, plan_active, created_at
+SELECT id, email, name, role, plan_id, plan_active, created_at, email_confirmed, locked_until, totp_enabled
 FROM users
 WHERE id = $1
 `, id)
@@ -28,6 +28,7 @@ WHERE id = $1
 	var planID sql.NullString
 	var planActive sql.NullBool
 	var createdAt time.Time
+	var lockedUntil sql.NullTime
 
 	if err := row.Scan(
 		&u.ID,
@@ -37,6 +38,9 @@ WHERE id = $1
 		&planID,
 		&planActive,
 		&createdAt,
+		&u.EmailConfirmed,
+		&lockedUntil,
+		&u.TwoFactor,
 	); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
@@ -53,6 +57,9 @@ WHERE id = $1
 		u.PlanActive = planActive.Bool
 	}
 	u.CreatedAt = createdAt
+	if lockedUntil.Valid && lockedUntil.Time.After(time.Now()) {
+		u.LockedUntil = &lockedUntil.Time
+	}
 
 	return &u, nil
 }
@@ -64,7 +71,7 @@ func (e *Env) ListUsers(ctx context.Context) ([]*domain.User, error) {
 	}
 
 	rows, err := e.DB.QueryContext(ctx, `
-SELECT id, email, name, role, plan_id, plan_active, created_at
+SELECT id, email, name, role, plan_id, plan_active, created_at, email_confirmed, locked_until, totp_enabled
 FROM users
 ORDER BY created_at ASC
 `)
@@ -81,6 +88,7 @@ ORDER BY created_at ASC
 		var planID sql.NullString
 		var planActive sql.NullBool
 		var createdAt time.Time
+		var lockedUntil sql.NullTime
 
 		if err := rows.Scan(
 			&u.ID,
@@ -90,6 +98,9 @@ ORDER BY created_at ASC
 			&planID,
 			&planActive,
 			&createdAt,
+			&u.EmailConfirmed,
+			&lockedUntil,
+			&u.TwoFactor,
 		); err != nil {
 			return nil, err
 		}
@@ -103,6 +114,10 @@ ORDER BY created_at ASC
 			u.PlanActive = planActive.Bool
 		}
 		u.CreatedAt = createdAt
+		if lockedUntil.Valid && lockedUntil.Time.After(time.Now()) {
+			t := lockedUntil.Time
+			u.LockedUntil = &t
+		}
 
 		res = append(res, &u)
 	}
@@ -122,6 +137,14 @@ func nullableString(s string) interface{} {
 	return s
 }
 
+// nullableInt — то же для чисел: 0 превращается в NULL.
+func nullableInt(n int) interface{} {
+	if n == 0 {
+		return nil
+	}
+	return n
+}
+
 // UpdateUser сохраняет изменения пользователя.
 func (e *Env) UpdateUser(ctx context.Context, u *domain.User) error {
 	if e.DB == nil {
@@ -144,7 +167,15 @@ WHERE id = $6
 		u.PlanActive,
 		u.ID,
 	)
-	return err
+	if err != nil {
+		return err
+	}
+
+	// тариф пользователя — это тариф его личной организации
+	if u.PlanID != "" {
+		return e.setPersonalOrgPlan(ctx, u.ID, u.PlanID, u.PlanActive)
+	}
+	return nil
 }
 
 // DeleteUser удаляет пользователя.
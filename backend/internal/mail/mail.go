@@ -0,0 +1,125 @@
+// Package mail — отправка писем (подтверждение email, сброс пароля и т.п.).
+//
+// Отправитель подключаемый: в проде — SMTP, локально — лог или папка с .eml.
+package mail
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Message — одно письмо (только текст).
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Sender — то, через что Env отправляет письма.
+type Sender interface {
+	Send(ctx context.Context, m Message) error
+}
+
+// FromEnv выбирает отправителя по переменным окружения:
+//
+//	SMTP_ADDR (host:port), SMTP_USER, SMTP_PASSWORD, MAIL_FROM — SMTP;
+//	MAIL_DIR — письма складываются файлами в папку;
+//	иначе — письма просто пишутся в лог.
+func FromEnv() Sender {
+	if addr := os.Getenv("SMTP_ADDR"); addr != "" {
+		from := os.Getenv("MAIL_FROM")
+		if from == "" {
+			from = "no-reply@localhost"
+		}
+		return &SMTPSender{
+			Addr:     addr,
+			Username: os.Getenv("SMTP_USER"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     from,
+		}
+	}
+	if dir := os.Getenv("MAIL_DIR"); dir != "" {
+		return &FileSender{Dir: dir}
+	}
+	return LogSender{}
+}
+
+// LogSender — пишет письма в лог (для локальной разработки).
+type LogSender struct{}
+
+func (LogSender) Send(ctx context.Context, m Message) error {
+	log.Printf("mail: to=%s subject=%q\n%s", m.To, m.Subject, m.Body)
+	return nil
+}
+
+// FileSender — сохраняет каждое письмо в отдельный .eml файл в Dir.
+type FileSender struct {
+	Dir string
+}
+
+func (s *FileSender) Send(ctx context.Context, m Message) error {
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return err
+	}
+
+	name := time.Now().Format("20060102_150405.000000000") + "_" + sanitize(m.To) + ".eml"
+	data := []byte(formatMessage("no-reply@localhost", m))
+	if err := ioutil.WriteFile(filepath.Join(s.Dir, name), data, 0644); err != nil {
+		return err
+	}
+
+	log.Printf("mail: saved %q for %s to %s", m.Subject, m.To, s.Dir)
+	return nil
+}
+
+// SMTPSender — отправка через SMTP с PLAIN-авторизацией (если задан Username).
+type SMTPSender struct {
+	Addr     string
+	Username string
+	Password string
+	From     string
+}
+
+func (s *SMTPSender) Send(ctx context.Context, m Message) error {
+	var auth smtp.Auth
+	if s.Username != "" {
+		host, _, err := net.SplitHostPort(s.Addr)
+		if err != nil {
+			return fmt.Errorf("bad smtp addr: %w", err)
+		}
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+
+	return smtp.SendMail(s.Addr, auth, s.From, []string{m.To}, []byte(formatMessage(s.From, m)))
+}
+
+func formatMessage(from string, m Message) string {
+	var b strings.Builder
+	b.WriteString("From: " + from + "\r\n")
+	b.WriteString("To: " + m.To + "\r\n")
+	b.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", m.Subject) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.Replace(m.Body, "\n", "\r\n", -1))
+	return b.String()
+}
+
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_', r == '@':
+			return r
+		}
+		return '_'
+	}, s)
+}
@@ -0,0 +1,178 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testIssuer   = "https://issuer.test"
+	testClientID = "saas-calc"
+	testKeyID    = "k1"
+	testNonce    = "nonce-1"
+)
+
+// testProvider — провайдер, у которого JWKS отдаёт локальный httptest-сервер (сервер закрывает возвращаемая функция).
+func testProvider(t *testing.T) (*Provider, *rsa.PrivateKey, func()) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(JWKS{Keys: []JWK{NewRSAJWK(testKeyID, &key.PublicKey)}})
+	}))
+
+	return &Provider{
+		Issuer:                testIssuer,
+		AuthorizationEndpoint: testIssuer + "/authorize",
+		TokenEndpoint:         testIssuer + "/token",
+		JWKSURI:               ts.URL,
+	}, key, ts.Close
+}
+
+func testClaims(now time.Time) map[string]interface{} {
+	return map[string]interface{}{
+		"iss":            testIssuer,
+		"sub":            "user-1",
+		"aud":            testClientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"nonce":          testNonce,
+		"email":          "user@example.com",
+		"email_verified": true,
+	}
+}
+
+// unsignedToken — токен с произвольным заголовком и подписью из мусора.
+func unsignedToken(header, claims interface{}) string {
+	h, _ := json.Marshal(header)
+	c, _ := json.Marshal(claims)
+	enc := base64.RawURLEncoding.EncodeToString
+	return enc(h) + "." + enc(c) + "." + enc([]byte("signature"))
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	p, key, closeJWKS := testProvider(t)
+	defer closeJWKS()
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	cfg := Config{Issuer: testIssuer, ClientID: testClientID}
+
+	sign := func(k *rsa.PrivateKey, mutate func(c map[string]interface{})) string {
+		c := testClaims(now)
+		if mutate != nil {
+			mutate(c)
+		}
+		raw, err := SignRS256(k, testKeyID, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return raw
+	}
+
+	good := sign(key, nil)
+	parts := strings.Split(good, ".")
+	tampered := parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"iss":"`+testIssuer+`","aud":"`+testClientID+`","email":"admin@example.com"}`)) + "." + parts[2]
+
+	tests := []struct {
+		name    string
+		raw     string
+		nonce   string
+		wantErr string
+	}{
+		{"good token", good, testNonce, ""},
+		{"audience as array", sign(key, func(c map[string]interface{}) { c["aud"] = []string{"other", testClientID} }), testNonce, ""},
+		{"expired within leeway", sign(key, func(c map[string]interface{}) { c["exp"] = now.Add(-30 * time.Second).Unix() }), testNonce, ""},
+		{"issuer with trailing slash", sign(key, func(c map[string]interface{}) { c["iss"] = testIssuer + "/" }), testNonce, ""},
+
+		{"malformed", "abc.def", testNonce, "malformed"},
+		{"wrong alg", unsignedToken(map[string]string{"alg": "HS256", "kid": testKeyID}, testClaims(now)), testNonce, "unsupported alg"},
+		{"alg none", unsignedToken(map[string]string{"alg": "none"}, testClaims(now)), testNonce, "unsupported alg"},
+		{"signed by another key", sign(otherKey, nil), testNonce, "bad signature"},
+		{"tampered claims", tampered, testNonce, "bad signature"},
+		{"garbage signature", unsignedToken(map[string]string{"alg": "RS256", "kid": testKeyID}, testClaims(now)), testNonce, "bad signature"},
+		{"wrong issuer", sign(key, func(c map[string]interface{}) { c["iss"] = "https://evil.test" }), testNonce, "wrong issuer"},
+		{"wrong audience", sign(key, func(c map[string]interface{}) { c["aud"] = "other-client" }), testNonce, "wrong audience"},
+		{"expired", sign(key, func(c map[string]interface{}) { c["exp"] = now.Add(-2 * time.Minute).Unix() }), testNonce, "expired"},
+		{"nonce mismatch", good, "other-nonce", "nonce mismatch"},
+		{"nonce missing", sign(key, func(c map[string]interface{}) { delete(c, "nonce") }), testNonce, "nonce mismatch"},
+	}
+	for _, tt := range tests {
+		c, err := p.VerifyIDToken(context.Background(), cfg, tt.raw, tt.nonce, now)
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+			} else if c.Email != "user@example.com" {
+				t.Errorf("%s: email = %q", tt.name, c.Email)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%s: expected error containing %q", tt.name, tt.wantErr)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: error %q, want it to contain %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestVerifyIDTokenClaims(t *testing.T) {
+	p, key, closeJWKS := testProvider(t)
+	defer closeJWKS()
+	now := time.Now()
+
+	raw, err := SignRS256(key, testKeyID, testClaims(now))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := p.VerifyIDToken(context.Background(), Config{ClientID: testClientID}, raw, testNonce, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Subject != "user-1" || c.EmailVerified == nil || !*c.EmailVerified {
+		t.Errorf("claims = %+v", c)
+	}
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	p := &Provider{AuthorizationEndpoint: "https://issuer.test/authorize?tenant=1"}
+	cfg := Config{ClientID: testClientID, RedirectURL: "https://app.test/cb"}
+
+	u := p.AuthCodeURL(cfg, "st", "nn", "verifier")
+	if !strings.HasPrefix(u, "https://issuer.test/authorize?tenant=1&") {
+		t.Fatalf("AuthCodeURL = %s", u)
+	}
+	for _, want := range []string{
+		"response_type=code",
+		"client_id=" + testClientID,
+		"state=st",
+		"nonce=nn",
+		"code_challenge=" + CodeChallenge("verifier"),
+		"code_challenge_method=S256",
+	} {
+		if !strings.Contains(u, want) {
+			t.Errorf("AuthCodeURL has no %s: %s", want, u)
+		}
+	}
+}
+
+// пример из RFC 7636 (приложение B)
+func TestCodeChallenge(t *testing.T) {
+	got := CodeChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk")
+	if want := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"; got != want {
+		t.Errorf("CodeChallenge = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,260 @@
+// Package ratelimit — ограничители частоты запросов в памяти процесса:
+// token bucket для публичных эндпоинтов и экспоненциальная задержка после неудачных входов.
+package ratelimit
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TrustProxyHeaders — брать IP клиента из X-Forwarded-For / X-Real-IP.
+// Включать только за своим reverse proxy, иначе заголовок подделывается.
+var TrustProxyHeaders = false
+
+// ClientIP — IP клиента для ключа ограничителя.
+func ClientIP(r *http.Request) string {
+	if TrustProxyHeaders {
+		if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+			if i := strings.Index(xff, ","); i >= 0 {
+				xff = xff[:i]
+			}
+			if ip := strings.TrimSpace(xff); ip != "" {
+				return ip
+			}
+		}
+		if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); ip != "" {
+			return ip
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// sweepEvery — как часто чистим записи о давно не появлявшихся клиентах.
+const sweepEvery = 5 * time.Minute
+
+// Limiter — token bucket на каждый ключ (IP): ведро на Burst запросов,
+// пополняется со скоростью Rate запросов в секунду.
+type Limiter struct {
+	Rate  float64
+	Burst int
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter — perMinute запросов в минуту в среднем, но не больше burst подряд.
+func NewLimiter(perMinute float64, burst int) *Limiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &Limiter{
+		Rate:    perMinute / 60,
+		Burst:   burst,
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// Allow забирает токен для key. Если токенов нет — false и через сколько появится следующий.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.Burst), last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = math.Min(float64(l.Burst), b.tokens+now.Sub(b.last).Seconds()*l.Rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	if l.Rate <= 0 {
+		return false, time.Minute
+	}
+	wait := time.Duration((1 - b.tokens) / l.Rate * float64(time.Second))
+	return false, wait
+}
+
+// полные вёдра ничем не отличаются от отсутствующих — их можно выбросить
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepEvery {
+		return
+	}
+	l.lastSweep = now
+	for k, b := range l.buckets {
+		if float64(l.Burst) <= b.tokens+now.Sub(b.last).Seconds()*l.Rate {
+			delete(l.buckets, k)
+		}
+	}
+}
+
+// Middleware ограничивает запросы по IP клиента; при превышении — 429 с Retry-After.
+func (l *Limiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// preflight не тратит токены
+		if r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if ok, wait := l.Allow(ClientIP(r)); !ok {
+			TooManyRequests(w, wait)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// TooManyRequests отвечает 429 с заголовком Retry-After (в секундах, с округлением вверх).
+func TooManyRequests(w http.ResponseWriter, wait time.Duration) {
+	secs := int(math.Ceil(wait.Seconds()))
+	if secs < 1 {
+		secs = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(secs))
+	http.Error(w, "too many requests", http.StatusTooManyRequests)
+}
+
+// Backoff — счётчик неудач на ключ с экспоненциальной задержкой:
+// после Threshold неудач подряд ключ блокируется на Base, дальше каждая неудача удваивает срок (до Max).
+// Nil-Backoff ничего не ограничивает.
+type Backoff struct {
+	Threshold int
+	Base      time.Duration
+	Max       time.Duration
+
+	// через сколько тишины счётчик забывается
+	Forget time.Duration
+
+	mu        sync.Mutex
+	entries   map[string]*failures
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type failures struct {
+	count        int
+	last         time.Time
+	blockedUntil time.Time
+}
+
+// NewBackoff создаёт счётчик неудач; записи забываются через сутки без неудач.
+func NewBackoff(threshold int, base, max time.Duration) *Backoff {
+	return &Backoff{
+		Threshold: threshold,
+		Base:      base,
+		Max:       max,
+		Forget:    24 * time.Hour,
+		entries:   make(map[string]*failures),
+		now:       time.Now,
+	}
+}
+
+// Blocked — сколько ещё ждать ключу (0 — можно пробовать).
+func (b *Backoff) Blocked(key string) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	f, ok := b.entries[key]
+	if !ok {
+		return 0
+	}
+	if wait := f.blockedUntil.Sub(b.now()); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// Fail отмечает неудачу и возвращает, на сколько ключ теперь заблокирован.
+func (b *Backoff) Fail(key string) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	b.sweep(now)
+
+	f, ok := b.entries[key]
+	if !ok || now.Sub(f.last) > b.Forget {
+		f = &failures{}
+		b.entries[key] = f
+	}
+	f.count++
+	f.last = now
+
+	d := Delay(f.count, b.Threshold, b.Base, b.Max)
+	if d > 0 {
+		f.blockedUntil = now.Add(d)
+	}
+	return d
+}
+
+// Reset забывает неудачи ключа (после успешного входа).
+func (b *Backoff) Reset(key string) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	delete(b.entries, key)
+	b.mu.Unlock()
+}
+
+func (b *Backoff) sweep(now time.Time) {
+	if now.Sub(b.lastSweep) < sweepEvery {
+		return
+	}
+	b.lastSweep = now
+	for k, f := range b.entries {
+		if now.Sub(f.last) > b.Forget && now.After(f.blockedUntil) {
+			delete(b.entries, k)
+		}
+	}
+}
+
+// Delay — задержка после count неудач подряд: 0 до порога,
+// затем base, 2*base, 4*base... но не больше max.
+func Delay(count, threshold int, base, max time.Duration) time.Duration {
+	if count < threshold || base <= 0 {
+		return 0
+	}
+	d := base
+	for i := threshold; i < count; i++ {
+		d *= 2
+		if d >= max {
+			return max
+		}
+	}
+	if d > max {
+		return max
+	}
+	return d
+}
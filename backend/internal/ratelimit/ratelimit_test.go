@@ -0,0 +1,197 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+// fakeClock — управляемое время для ограничителей.
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time          { return c.t }
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newClock() *fakeClock {
+	return &fakeClock{t: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+func TestLimiterBurstAndRefill(t *testing.T) {
+	clock := newClock()
+	l := NewLimiter(60, 3) // токен в секунду, ведро на 3
+	l.now = clock.now
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.Allow("a"); !ok {
+			t.Fatalf("request %d within burst was denied", i+1)
+		}
+	}
+	ok, wait := l.Allow("a")
+	if ok {
+		t.Fatal("request over burst was allowed")
+	}
+	if wait <= 0 || wait > time.Second {
+		t.Errorf("wait = %v, want (0, 1s]", wait)
+	}
+
+	// другой ключ — своё ведро
+	if ok, _ := l.Allow("b"); !ok {
+		t.Error("other key was denied")
+	}
+
+	// за секунду набегает ровно один токен
+	clock.advance(time.Second)
+	if ok, _ := l.Allow("a"); !ok {
+		t.Error("request after refill was denied")
+	}
+	if ok, _ := l.Allow("a"); ok {
+		t.Error("second request after one-token refill was allowed")
+	}
+
+	// долгий простой не даёт больше burst
+	clock.advance(time.Hour)
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.Allow("a"); !ok {
+			t.Fatalf("request %d after idle was denied", i+1)
+		}
+	}
+	if ok, _ := l.Allow("a"); ok {
+		t.Error("idle bucket grew over burst")
+	}
+}
+
+func TestLimiterZeroRate(t *testing.T) {
+	clock := newClock()
+	l := NewLimiter(0, 1)
+	l.now = clock.now
+
+	if ok, _ := l.Allow("a"); !ok {
+		t.Fatal("first request was denied")
+	}
+	clock.advance(time.Hour)
+	ok, wait := l.Allow("a")
+	if ok {
+		t.Fatal("zero-rate bucket was refilled")
+	}
+	if wait != time.Minute {
+		t.Errorf("wait = %v, want 1m", wait)
+	}
+}
+
+func TestLimiterSweep(t *testing.T) {
+	clock := newClock()
+	l := NewLimiter(60, 2)
+	l.now = clock.now
+
+	l.Allow("idle")
+	l.Allow("busy")
+	l.Allow("busy")
+
+	// idle успеет наполниться, busy — нет
+	clock.advance(sweepEvery)
+	l.buckets["busy"].tokens = -1000
+	l.Allow("trigger")
+
+	if _, ok := l.buckets["idle"]; ok {
+		t.Error("full bucket was not swept")
+	}
+	if _, ok := l.buckets["busy"]; !ok {
+		t.Error("drained bucket was swept")
+	}
+}
+
+func TestDelay(t *testing.T) {
+	tests := []struct {
+		count int
+		want  time.Duration
+	}{
+		{0, 0},
+		{4, 0},
+		{5, time.Minute},
+		{6, 2 * time.Minute},
+		{7, 4 * time.Minute},
+		{11, 64 * time.Minute},
+		{12, 90 * time.Minute},
+		{1000, 90 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := Delay(tt.count, 5, time.Minute, 90*time.Minute); got != tt.want {
+			t.Errorf("Delay(%d) = %v, want %v", tt.count, got, tt.want)
+		}
+	}
+	if got := Delay(10, 5, 0, time.Hour); got != 0 {
+		t.Errorf("Delay with zero base = %v, want 0", got)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	clock := newClock()
+	b := NewBackoff(3, time.Second, 4*time.Second)
+	b.now = clock.now
+
+	want := []time.Duration{0, 0, time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second}
+	for i, w := range want {
+		if got := b.Fail("ip"); got != w {
+			t.Errorf("Fail #%d = %v, want %v", i+1, got, w)
+		}
+	}
+	if got := b.Blocked("ip"); got != 4*time.Second {
+		t.Errorf("Blocked = %v, want 4s", got)
+	}
+	if got := b.Blocked("other"); got != 0 {
+		t.Errorf("Blocked(other) = %v, want 0", got)
+	}
+
+	clock.advance(4 * time.Second)
+	if got := b.Blocked("ip"); got != 0 {
+		t.Errorf("Blocked after the delay = %v, want 0", got)
+	}
+	// счётчик помнится: следующая неудача снова с максимальной задержкой
+	if got := b.Fail("ip"); got != 4*time.Second {
+		t.Errorf("Fail after the delay = %v, want 4s", got)
+	}
+
+	// после Forget без неудач счёт начинается заново
+	clock.advance(b.Forget + time.Second)
+	if got := b.Fail("ip"); got != 0 {
+		t.Errorf("Fail after Forget = %v, want 0", got)
+	}
+
+	b.Fail("ip")
+	b.Fail("ip")
+	b.Reset("ip")
+	if got := b.Blocked("ip"); got != 0 {
+		t.Errorf("Blocked after Reset = %v, want 0", got)
+	}
+}
+
+func TestBackoffSweep(t *testing.T) {
+	clock := newClock()
+	b := NewBackoff(1, time.Hour, time.Hour)
+	b.now = clock.now
+
+	b.Fail("old")
+	clock.advance(b.Forget / 2)
+	b.Fail("recent")
+
+	// old забыт и не заблокирован, recent ещё помнится
+	clock.advance(b.Forget/2 + time.Hour + time.Second)
+	b.Fail("trigger")
+
+	if _, ok := b.entries["old"]; ok {
+		t.Error("forgotten entry was not swept")
+	}
+	if _, ok := b.entries["recent"]; !ok {
+		t.Error("recent entry was swept")
+	}
+}
+
+func TestNilBackoff(t *testing.T) {
+	var b *Backoff
+	if got := b.Fail("ip"); got != 0 {
+		t.Errorf("nil Fail = %v", got)
+	}
+	if got := b.Blocked("ip"); got != 0 {
+		t.Errorf("nil Blocked = %v", got)
+	}
+	b.Reset("ip")
+}
@@ -0,0 +1,101 @@
+// Package totp — одноразовые коды по времени (RFC 6238, HMAC-SHA1, 6 цифр, шаг 30 секунд),
+// совместимые с Google Authenticator, 1Password и т.п.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// Digits — длина кода.
+	Digits = 6
+	// Period — шаг времени в секундах.
+	Period = 30
+	// Skew — сколько соседних шагов принимаем (расхождение часов телефона).
+	Skew = 1
+)
+
+var b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewSecret — случайный секрет (160 бит) в base32 без паддинга.
+func NewSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return b32.EncodeToString(b), nil
+}
+
+// URI — otpauth:// ссылка для QR-кода в приложении-аутентификаторе.
+func URI(issuer, account, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(Digits))
+	v.Set("period", fmt.Sprint(Period))
+
+	label := url.PathEscape(issuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
+
+// Counter — номер шага времени для t.
+func Counter(t time.Time) int64 {
+	return t.Unix() / Period
+}
+
+// Code — код для секрета и шага counter.
+func Code(secret string, counter int64) (string, error) {
+	key, err := b32.DecodeString(strings.ToUpper(strings.TrimSpace(secret)))
+	if err != nil {
+		return "", err
+	}
+
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	off := sum[len(sum)-1] & 0x0f
+	bin := binary.BigEndian.Uint32(sum[off:off+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < Digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", Digits, bin%mod), nil
+}
+
+// Validate проверяет код в окне ±Skew шагов от t и возвращает шаг, которому он соответствует.
+// Шаги не больше lastUsed отвергаются, чтобы один и тот же код нельзя было использовать дважды.
+func Validate(secret, code string, t time.Time, lastUsed int64) (int64, bool) {
+	code = strings.Replace(strings.TrimSpace(code), " ", "", -1)
+	if len(code) != Digits {
+		return 0, false
+	}
+
+	now := Counter(t)
+	for c := now - Skew; c <= now+Skew; c++ {
+		if c <= lastUsed {
+			continue
+		}
+		want, err := Code(secret, c)
+		if err != nil {
+			return 0, false
+		}
+		if hmac.Equal([]byte(want), []byte(code)) {
+			return c, true
+		}
+	}
+	return 0, false
+}
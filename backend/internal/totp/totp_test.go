@@ -0,0 +1,112 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// секрет из RFC 6238 (приложение B): ASCII "12345678901234567890" в base32
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// Векторы RFC 6238 для SHA1; в RFC коды из 8 цифр, у нас 6 — младшие разряды те же.
+func TestCodeRFC6238(t *testing.T) {
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, Counter(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("Code at %d: %v", tt.unix, err)
+		}
+		if got != tt.want {
+			t.Errorf("Code at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestCodeSecretFormat(t *testing.T) {
+	want, _ := Code(rfcSecret, 1)
+	got, err := Code(" "+strings.ToLower(rfcSecret)+" ", 1)
+	if err != nil || got != want {
+		t.Errorf("Code with lower-case padded secret = %q, %v; want %q", got, err, want)
+	}
+	if _, err := Code("not base32!", 1); err == nil {
+		t.Error("Code accepted a malformed secret")
+	}
+}
+
+func TestValidateWindow(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	c := Counter(now)
+
+	tests := []struct {
+		name    string
+		counter int64
+		ok      bool
+	}{
+		{"current step", c, true},
+		{"previous step", c - 1, true},
+		{"next step", c + 1, true},
+		{"two steps behind", c - 2, false},
+		{"two steps ahead", c + 2, false},
+	}
+	for _, tt := range tests {
+		code, _ := Code(rfcSecret, tt.counter)
+		step, ok := Validate(rfcSecret, code, now, 0)
+		if ok != tt.ok {
+			t.Errorf("%s: ok = %v, want %v", tt.name, ok, tt.ok)
+			continue
+		}
+		if ok && step != tt.counter {
+			t.Errorf("%s: step = %d, want %d", tt.name, step, tt.counter)
+		}
+	}
+}
+
+func TestValidateReplay(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	c := Counter(now)
+	code, _ := Code(rfcSecret, c)
+
+	step, ok := Validate(rfcSecret, code, now, 0)
+	if !ok {
+		t.Fatal("fresh code rejected")
+	}
+	// тот же код второй раз: шаг уже использован
+	if _, ok := Validate(rfcSecret, code, now, step); ok {
+		t.Error("replayed code accepted")
+	}
+	// и код более раннего шага после использования текущего
+	prev, _ := Code(rfcSecret, c-1)
+	if _, ok := Validate(rfcSecret, prev, now, step); ok {
+		t.Error("code of an older step accepted after a newer one")
+	}
+	// а следующий шаг — можно
+	next, _ := Code(rfcSecret, c+1)
+	if _, ok := Validate(rfcSecret, next, now, step); !ok {
+		t.Error("next step rejected")
+	}
+}
+
+func TestValidateFormat(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	code, _ := Code(rfcSecret, Counter(now))
+
+	if _, ok := Validate(rfcSecret, " "+code[:3]+" "+code[3:]+" ", now, 0); !ok {
+		t.Error("code with spaces rejected")
+	}
+	for _, bad := range []string{"", "12345", "1234567", "abcdef"} {
+		if _, ok := Validate(rfcSecret, bad, now, 0); ok {
+			t.Errorf("Validate(%q) accepted", bad)
+		}
+	}
+}
@@ -3,24 +3,30 @@ const API_BASE = '/api';
 const contentEl = document.getElementById('content');
 const navItems = document.querySelectorAll('.nav-item');
 const pageTitleEl = document.getElementById('page-title');
-const userSwitchEl = document.getElementById('user-switch');
+const userNameEl = document.getElementById('user-name');
+const logoutBtnEl = document.getElementById('logout-btn');
 const avatarLetterEl = document.getElementById('avatar-letter');
 const headerPlanInfoEl = document.getElementById('header-plan-info');
 
-let currentUserId = 'admin';
 // стартуем со списка калькуляторов
 let currentSection = 'calculators';
 // текущий калькулятор для послойного редактора
 let currentLayeredCalculator = null;
 // текущий калькулятор для калькулятора расстояний
 let currentDistanceCalculator = null;
+// текущий калькулятор выезда специалиста
+let currentOnSiteCalculator = null;
+// текущий формульный калькулятор
+let currentFormulaCalculator = null;
+// текущий калькулятор-форма
+let currentFormCalculator = null;
 
 // кеш последнего /me
 let currentMe = null;
 
+// пользователь определяется по cookie сессии (см. /api/auth/login)
 function buildApiUrl(path) {
-  const sep = path.includes('?') ? '&' : '?';
-  return API_BASE + path + sep + 'as=' + encodeURIComponent(currentUserId);
+  return API_BASE + path;
 }
 
 async function fetchJSON(path) {
@@ -75,8 +81,52 @@ const CALC_TYPE_LABELS = {
   distance: 'Расчёт доставки',
   on_site: 'Выезд замерщика',
   mortgage: 'Ипотека',
+  formula: 'По формулам',
+  form: 'Форма с ценами',
 };
 
+// статусы калькулятора: draft ⇄ published ⇄ archived
+function calcStatusBadge(status) {
+  if (status === 'published') {
+    return '<span class="calc-status-badge">Опубликован</span>';
+  }
+  if (status === 'archived') {
+    return '<span class="calc-status-badge calc-status-badge--archived">В архиве</span>';
+  }
+  return '<span class="calc-status-badge calc-status-badge--draft">Черновик</span>';
+}
+
+// кнопки смены статуса для каждого статуса
+const CALC_STATUS_ACTIONS = {
+  draft: [{ status: 'published', label: 'Опубликовать' }],
+  published: [
+    { status: 'draft', label: 'Снять с публикации' },
+    { status: 'archived', label: 'В архив' },
+  ],
+  archived: [{ status: 'published', label: 'Вернуть из архива' }],
+};
+
+async function putJSON(path, body) {
+  const res = await fetch(buildApiUrl(path), {
+    method: 'PUT',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify(body),
+  });
+
+  if (!res.ok) {
+    let message = 'HTTP ' + res.status;
+    try {
+      const text = await res.text();
+      if (text) message = text;
+    } catch (_) {}
+    const err = new Error(message);
+    err.status = res.status;
+    throw err;
+  }
+
+  return res.json();
+}
+
 // popup о лимите тарифа
 function showPlanLimitPopup(serverMessage) {
   const existing = document.getElementById('plan-limit-modal');
@@ -201,35 +251,236 @@ function updateHeaderFromMe(me) {
 
 // --- init current user ---
 
-function initCurrentUser() {
-  const saved = window.localStorage.getItem('saasCurrentUserId');
-  if (saved) {
-    currentUserId = saved;
-  } else {
-    currentUserId = 'admin';
+// возвращает true, если пользователь уже вошёл
+async function initCurrentUser() {
+  if (logoutBtnEl) {
+    logoutBtnEl.addEventListener('click', async () => {
+      // в режиме "под пользователем" кнопка возвращает в сессию администратора
+      const url =
+        currentMe && currentMe.impersonatedBy ? '/auth/impersonation/stop' : '/auth/logout';
+      try {
+        await fetch(buildApiUrl(url), { method: 'POST' });
+      } finally {
+        window.location.reload();
+      }
+    });
   }
 
-  if (userSwitchEl) {
-    userSwitchEl.value = currentUserId;
-    userSwitchEl.addEventListener('change', () => {
-      currentUserId = userSwitchEl.value;
-      window.localStorage.setItem('saasCurrentUserId', currentUserId);
-      updateAvatar();
-      refreshMeAndHeader();
-      loadSection(currentSection);
-    });
+  const params = new URLSearchParams(window.location.search);
+
+  // ссылка-приглашение от администратора: сначала задаём пароль
+  const inviteToken = params.get('inviteToken');
+  if (inviteToken) {
+    showAcceptInviteModal(inviteToken);
+    return false;
+  }
+
+  // вход через SSO у пользователя с 2FA: осталось ввести код (mfaToken сервер положил в HttpOnly cookie)
+  if (params.get('sso') === '2fa') {
+    window.history.replaceState(null, '', '/app');
+    showLoginModal(true);
+    return false;
   }
 
-  updateAvatar();
-  refreshMeAndHeader();
+  try {
+    const me = await fetchJSON('/me');
+    currentMe = me;
+    if (me.impersonatedBy && logoutBtnEl) {
+      logoutBtnEl.textContent = 'Вернуться в админку';
+    }
+    updateHeaderFromMe(me);
+    updateAvatar();
+    return true;
+  } catch (err) {
+    if (err.status === 401) {
+      const ssoError = params.get('ssoError');
+      showLoginModal('', ssoError ? 'Не удалось войти через SSO (' + ssoError + ')' : '');
+      return false;
+    }
+    console.error('Failed to load /me', err);
+    return true;
+  }
 }
 
 function updateAvatar() {
-  let letter = 'A';
-  if (currentUserId === 'admin') letter = 'A';
-  if (currentUserId === 'user1') letter = '1';
-  if (currentUserId === 'user2') letter = '2';
-  if (avatarLetterEl) avatarLetterEl.textContent = letter;
+  const user = currentMe && currentMe.user;
+  const name = (user && (user.name || user.email)) || '';
+  const admin = currentMe && currentMe.impersonatedBy;
+  if (userNameEl) {
+    userNameEl.textContent = admin
+      ? name + ' (вход от имени: ' + (admin.name || admin.email) + ')'
+      : name;
+  }
+  if (avatarLetterEl) avatarLetterEl.textContent = (name.charAt(0) || '?').toUpperCase();
+}
+
+// форма входа поверх кабинета; mfaToken — если пароль уже пройден и нужен код 2FA
+// (true — после SSO: токен в cookie, в запросе его не передаём)
+function showLoginModal(initialMfaToken, initialError) {
+  const backdrop = document.createElement('div');
+  backdrop.id = 'login-modal';
+  backdrop.style.position = 'fixed';
+  backdrop.style.inset = '0';
+  backdrop.style.background = 'rgba(15, 23, 42, 0.45)';
+  backdrop.style.display = 'flex';
+  backdrop.style.alignItems = 'center';
+  backdrop.style.justifyContent = 'center';
+  backdrop.style.zIndex = '9999';
+
+  const modal = document.createElement('form');
+  modal.className = 'card';
+  modal.style.maxWidth = '360px';
+  modal.style.width = '100%';
+  modal.style.margin = '16px';
+
+  modal.innerHTML = `
+    <div class="card-title">Вход в кабинет</div>
+    <div class="field">
+      <label class="field-label">Email</label>
+      <input type="email" id="login-email" autocomplete="username" required />
+    </div>
+    <div class="field">
+      <label class="field-label">Пароль</label>
+      <input type="password" id="login-password" autocomplete="current-password" required />
+    </div>
+    <div class="field" id="login-code-field" style="display:none;">
+      <label class="field-label">Код из приложения или код восстановления</label>
+      <input type="text" id="login-code" autocomplete="one-time-code" inputmode="numeric" />
+    </div>
+    <p class="small" id="login-error" style="color:#b91c1c; display:none;"></p>
+    <div style="display:flex; justify-content:space-between; align-items:center; margin-top:8px;">
+      <a class="small" id="login-sso" href="/api/auth/oidc/login" style="display:none;">Войти через SSO</a>
+      <button type="submit" class="btn primary" style="margin-left:auto;">Войти</button>
+    </div>
+  `;
+
+  backdrop.appendChild(modal);
+  document.body.appendChild(backdrop);
+
+  const errorEl = modal.querySelector('#login-error');
+  const codeField = modal.querySelector('#login-code-field');
+  const codeInput = modal.querySelector('#login-code');
+  // после верного пароля при включённой 2FA сервер отдаёт mfaToken
+  let mfaToken = initialMfaToken || '';
+
+  if (mfaToken) {
+    modal.querySelector('#login-email').closest('.field').style.display = 'none';
+    modal.querySelector('#login-password').closest('.field').style.display = 'none';
+    modal.querySelector('#login-email').required = false;
+    modal.querySelector('#login-password').required = false;
+    codeField.style.display = '';
+  }
+  if (initialError) {
+    errorEl.textContent = initialError;
+    errorEl.style.display = 'block';
+  }
+
+  // кнопка SSO — только если администратор настроил провайдера
+  fetchJSON('/auth/oidc')
+    .then((res) => {
+      if (res && res.enabled && !mfaToken) {
+        modal.querySelector('#login-sso').style.display = '';
+      }
+    })
+    .catch(() => {});
+
+  modal.addEventListener('submit', async (e) => {
+    e.preventDefault();
+    errorEl.style.display = 'none';
+    try {
+      if (mfaToken) {
+        const code = codeInput.value.trim();
+        // 6 цифр — код из приложения, остальное считаем кодом восстановления
+        const body = mfaToken === true ? {} : { mfaToken };
+        if (/^\d{6}$/.test(code)) body.code = code;
+        else body.recoveryCode = code;
+        await postJSON('/auth/login/2fa', body);
+        window.location.reload();
+        return;
+      }
+
+      const res = await postJSON('/auth/login', {
+        email: modal.querySelector('#login-email').value.trim(),
+        password: modal.querySelector('#login-password').value,
+      });
+      if (res && res.mfaRequired) {
+        mfaToken = res.mfaToken;
+        codeField.style.display = '';
+        codeInput.focus();
+        return;
+      }
+      window.location.reload();
+    } catch (err) {
+      if (err.status === 401) {
+        errorEl.textContent = mfaToken ? 'Неверный код' : 'Неверный email или пароль';
+      } else if (err.status === 429) {
+        errorEl.textContent = 'Слишком много неудачных попыток. Попробуйте позже.';
+      } else {
+        errorEl.textContent = 'Не удалось войти';
+      }
+      errorEl.style.display = 'block';
+    }
+  });
+}
+
+// установка пароля по приглашению
+function showAcceptInviteModal(token) {
+  const backdrop = document.createElement('div');
+  backdrop.id = 'invite-modal';
+  backdrop.style.position = 'fixed';
+  backdrop.style.inset = '0';
+  backdrop.style.background = 'rgba(15, 23, 42, 0.45)';
+  backdrop.style.display = 'flex';
+  backdrop.style.alignItems = 'center';
+  backdrop.style.justifyContent = 'center';
+  backdrop.style.zIndex = '9999';
+
+  const modal = document.createElement('form');
+  modal.className = 'card';
+  modal.style.maxWidth = '360px';
+  modal.style.width = '100%';
+  modal.style.margin = '16px';
+
+  modal.innerHTML = `
+    <div class="card-title">Добро пожаловать!</div>
+    <p class="card-subtitle">Задайте пароль для входа в кабинет.</p>
+    <div class="field">
+      <label class="field-label">Пароль (не короче 8 символов)</label>
+      <input type="password" id="invite-password" autocomplete="new-password" required />
+    </div>
+    <p class="small" id="invite-error" style="color:#b91c1c; display:none;"></p>
+    <div style="display:flex; justify-content:flex-end; margin-top:8px;">
+      <button type="submit" class="btn primary">Сохранить и войти</button>
+    </div>
+  `;
+
+  backdrop.appendChild(modal);
+  document.body.appendChild(backdrop);
+
+  const errorEl = modal.querySelector('#invite-error');
+
+  modal.addEventListener('submit', async (e) => {
+    e.preventDefault();
+    errorEl.style.display = 'none';
+    try {
+      const res = await postJSON('/auth/invite/accept', {
+        token,
+        password: modal.querySelector('#invite-password').value,
+      });
+      // пароль задан, но у аккаунта включена 2FA — осталось ввести код
+      if (res && res.mfaRequired) {
+        backdrop.remove();
+        window.history.replaceState(null, '', '/app');
+        showLoginModal(res.mfaToken);
+        return;
+      }
+      window.location.href = '/app';
+    } catch (err) {
+      errorEl.textContent =
+        err.status === 400 ? 'Ссылка устарела или пароль слишком короткий' : 'Не удалось сохранить пароль';
+      errorEl.style.display = 'block';
+    }
+  });
 }
 
 // --- navigation ---
@@ -252,6 +503,9 @@ function setActiveNav(section) {
     calculators: 'Калькуляторы',
     layers: 'Послойный калькулятор',
     distance: 'Калькулятор доставки',
+    onsite: 'Выезд специалиста',
+    formula: 'Калькулятор по формулам',
+    form: 'Калькулятор-форма',
     leads: 'Заявки',
     embeds: 'Встройка',
     integrations: 'Интеграции',
@@ -267,7 +521,14 @@ function setActiveNav(section) {
 async function loadSection(section) {
   try {
     if (section === 'layers') {
-      const cfg = await fetchJSON('/layers/config');
+      currentLayeredCalculator = await pickCalculatorOfType('layered', currentLayeredCalculator);
+      if (!currentLayeredCalculator) {
+        renderNoCalculatorOfType('послойного калькулятора');
+        return;
+      }
+      const cfg = await fetchJSON(
+        '/layers/config?calculatorId=' + encodeURIComponent(currentLayeredCalculator.id)
+      );
       renderLayersBuilder(cfg, currentLayeredCalculator);
       return;
     }
@@ -287,10 +548,53 @@ async function loadSection(section) {
       return;
     }
     if (section === 'distance') {
-      const cfg = await fetchJSON('/distance/config');
+      currentDistanceCalculator = await pickCalculatorOfType('distance', currentDistanceCalculator);
+      if (!currentDistanceCalculator) {
+        renderNoCalculatorOfType('калькулятора доставки');
+        return;
+      }
+      const cfg = await fetchJSON(
+        '/distance/config?calculatorId=' + encodeURIComponent(currentDistanceCalculator.id)
+      );
       renderDistanceBuilder(cfg, currentDistanceCalculator);
       return;
     }
+    if (section === 'onsite') {
+      currentOnSiteCalculator = await pickCalculatorOfType('on_site', currentOnSiteCalculator);
+      if (!currentOnSiteCalculator) {
+        renderNoCalculatorOfType('калькулятора выезда');
+        return;
+      }
+      const cfg = await fetchJSON(
+        '/onsite/config?calculatorId=' + encodeURIComponent(currentOnSiteCalculator.id)
+      );
+      renderOnSiteBuilder(cfg, currentOnSiteCalculator);
+      return;
+    }
+    if (section === 'formula') {
+      currentFormulaCalculator = await pickCalculatorOfType('formula', currentFormulaCalculator);
+      if (!currentFormulaCalculator) {
+        renderNoCalculatorOfType('калькулятора по формулам');
+        return;
+      }
+      const cfg = await fetchJSON(
+        '/formula/config?calculatorId=' + encodeURIComponent(currentFormulaCalculator.id)
+      );
+      renderFormulaBuilder(cfg, currentFormulaCalculator);
+      return;
+    }
+    if (section === 'form') {
+      currentFormCalculator = await pickCalculatorOfType('form', currentFormCalculator);
+      if (!currentFormCalculator) {
+        renderNoCalculatorOfType('калькулятора-формы');
+        return;
+      }
+      const cfg = await fetchJSON(
+        '/form/config?calculatorId=' + encodeURIComponent(currentFormCalculator.id)
+      );
+      renderFormBuilder(cfg, currentFormCalculator);
+      return;
+    }
     if (section === 'settings') {
       await renderSettings();
       return;
@@ -312,971 +616,2002 @@ async function loadSection(section) {
   }
 }
 
-// --- Settings ---
+// ссылка предпросмотра: показывает черновик конфига (и непубличный калькулятор)
+function calcPreviewUrl(c) {
+  const publicPath = c.publicPath || (c.publicToken && c.ownerId ? `/p/${c.ownerId}/${c.publicToken}` : '');
+  if (!publicPath || !c.previewToken) return '';
+  return window.location.origin + publicPath + '?preview=' + encodeURIComponent(c.previewToken);
+}
 
-async function renderSettings() {
-  contentEl.innerHTML = `
-    <div class="card">
-      <div class="card-title">Загрузка настроек...</div>
+// публикация черновика конфига: конструктор правит черновик, посетители видят опубликованное
+function publishControlsHTML(calcMeta) {
+  if (!calcMeta) return '';
+  const previewUrl = calcPreviewUrl(calcMeta);
+  return `
+    <div style="margin-top:10px; display:flex; gap:8px; align-items:center; flex-wrap:wrap;">
+      <button class="btn primary" id="publish-config-btn" type="button">Опубликовать</button>
+      ${previewUrl ? `<a class="small" href="${previewUrl}" target="_blank" rel="noopener">Предпросмотр черновика</a>` : ''}
     </div>
+    <p class="small">Сохранённые правки видны посетителям только после публикации.</p>
   `;
+}
 
-  try {
-    const url = buildApiUrl('/admin/settings');
-    const res = await fetch(url, { method: 'GET' });
-
-    if (res.status === 403) {
-      contentEl.innerHTML = `
-        <div class="card">
-          <div class="card-title">Нет доступа</div>
-          <p class="card-subtitle">
-            Раздел "Настройки" доступен только администратору.
-            Выберите пользователя "Администратор" в правом верхнем углу.
-          </p>
-        </div>
-      `;
-      return;
+function bindPublishControls(calcMeta) {
+  const btn = document.getElementById('publish-config-btn');
+  if (!btn || !calcMeta) return;
+  btn.addEventListener('click', async () => {
+    try {
+      btn.disabled = true;
+      const updated = await postJSON('/calculators/' + encodeURIComponent(calcMeta.id) + '/publish', {});
+      Object.assign(calcMeta, updated);
+      alert('Опубликовано');
+    } catch (err) {
+      console.error(err);
+      alert(
+        err.status === 403
+          ? 'Опубликовать калькулятор можно после подтверждения email владельца'
+          : 'Не удалось опубликовать: ' + err.message
+      );
+    } finally {
+      btn.disabled = false;
     }
+  });
+}
 
-    if (!res.ok) {
-      throw new Error('HTTP ' + res.status);
-    }
+// история версий конфига: список, отличия от текущей и откат
+async function showConfigHistoryModal(calcMeta, onRestored) {
+  const base = '/calculators/' + encodeURIComponent(calcMeta.id) + '/versions';
 
-    const data = await res.json();
+  const backdrop = document.createElement('div');
+  backdrop.style.position = 'fixed';
+  backdrop.style.inset = '0';
+  backdrop.style.background = 'rgba(15, 23, 42, 0.45)';
+  backdrop.style.display = 'flex';
+  backdrop.style.alignItems = 'center';
+  backdrop.style.justifyContent = 'center';
+  backdrop.style.zIndex = '9999';
 
-    const root = document.createElement('div');
+  const modal = document.createElement('div');
+  modal.className = 'card';
+  modal.style.maxWidth = '640px';
+  modal.style.width = '100%';
+  modal.style.margin = '16px';
+  modal.style.maxHeight = '80vh';
+  modal.style.overflow = 'auto';
+  modal.innerHTML = `
+    <div style="display:flex; justify-content:space-between; align-items:center;">
+      <div class="card-title">История версий</div>
+      <button class="btn secondary" type="button" id="history-close">Закрыть</button>
+    </div>
+    <div id="history-list"><p class="small">Загрузка...</p></div>
+    <pre id="history-diff" class="small" style="display:none; white-space:pre-wrap; background:#f9fafb; padding:8px; border-radius:8px;"></pre>
+  `;
+  backdrop.appendChild(modal);
+  document.body.appendChild(backdrop);
 
-    const card = document.createElement('div');
-    card.className = 'card';
-    card.innerHTML = `
-      <div class="card-title">Технические настройки</div>
-      <div class="card-subtitle">
-        Здесь администратор может указать базовые адреса сервисов маршрутизации на основе OpenStreetMap.
-      </div>
+  const close = () => backdrop.remove();
+  modal.querySelector('#history-close').addEventListener('click', close);
+  backdrop.addEventListener('click', (e) => {
+    if (e.target === backdrop) close();
+  });
 
-      <div class="field">
-        <label class="field-label">OSRM base URL</label>
-        <input type="text" id="osrm-base-url-input" placeholder="https://router.project-osrm.org" />
-        <p class="small">
-          Сервис построения маршрутов. По умолчанию используется публичный инстанс OSRM.
-        </p>
-      </div>
+  const listEl = modal.querySelector('#history-list');
+  const diffEl = modal.querySelector('#history-diff');
 
-      <div class="field">
-        <label class="field-label">Nominatim base URL</label>
-        <input type="text" id="nominatim-base-url-input" placeholder="https://nominatim.openstreetmap.org" />
-        <p class="small">
-          Сервис геокодирования (поиск координат по адресу). По умолчанию используется публичный Nominatim.
-        </p>
-      </div>
-      <div class="field">
-        <label class="field-label">Telegram bot token</label>
-       <input type="text" id="tg-bot-token-input" placeholder="123456:ABC-DEF..." />
+  let items;
+  try {
+    const data = await fetchJSON(base);
+    items = (data && data.items) || [];
+  } catch (err) {
+    console.error(err);
+    listEl.innerHTML = '<p class="small">Не удалось загрузить историю.</p>';
+    return;
+  }
 
-        <p class="small">
-          Токен вашего Telegram-бота из @BotFather. Используется для отправки уведомлений
-          с результатами расчётов.
-        </p>
-      </div>
+  if (!items.length) {
+    listEl.innerHTML = '<p class="small">Конфиг ещё не сохраняли.</p>';
+    return;
+  }
 
-      <div class="field" style="display:flex; gap:8px; align-items:center;">
-        <button class="btn primary" id="settings-save-btn" type="button">Сохранить</button>
+  const fmt = (v) => (v === undefined ? '—' : JSON.stringify(v));
+
+  listEl.innerHTML = '';
+  items.forEach((v) => {
+    const row = document.createElement('div');
+    row.style.display = 'flex';
+    row.style.justifyContent = 'space-between';
+    row.style.alignItems = 'center';
+    row.style.gap = '8px';
+    row.style.padding = '6px 0';
+    row.style.borderBottom = '1px solid #e5e7eb';
+    row.innerHTML = `
+      <div class="small">
+        <strong>v${v.version}</strong>${v.current ? ' (черновик)' : ''}${v.published ? ' (на сайте)' : ''}
+        · ${new Date(v.createdAt).toLocaleString('ru-RU')}
+        · ${v.authorEmail || '—'}
+        ${v.restoredFrom ? ' · откат к v' + v.restoredFrom : ''}
+      </div>
+      <div style="display:flex; gap:4px;">
+        ${
+          v.current
+            ? ''
+            : `<button class="btn secondary btn-diff" type="button">Отличия</button>
+               <button class="btn secondary btn-restore" type="button">Восстановить</button>`
+        }
       </div>
     `;
+    listEl.appendChild(row);
 
-    root.appendChild(card);
-    contentEl.innerHTML = '';
-    contentEl.appendChild(root);
-
-    const osrmInput = document.getElementById('osrm-base-url-input');
-    const nominatimInput = document.getElementById('nominatim-base-url-input');
-    const tgTokenInput = document.getElementById('tg-bot-token-input');
-    const saveBtn = document.getElementById('settings-save-btn');
-
-    if (data && data.osrmBaseUrl) {
-      osrmInput.value = data.osrmBaseUrl;
-    }
-    if (data && data.nominatimBaseUrl) {
-      nominatimInput.value = data.nominatimBaseUrl;
-    }
-    if (data && data.telegramBotToken) {
-      tgTokenInput.value = data.telegramBotToken;
-    }
+    if (v.current) return;
 
-    saveBtn.addEventListener('click', async () => {
-      const osrmBaseUrl = osrmInput.value.trim();
-      const nominatimBaseUrl = nominatimInput.value.trim();
-      const telegramBotToken = tgTokenInput.value.trim();
+    row.querySelector('.btn-diff').addEventListener('click', async () => {
       try {
-        saveBtn.disabled = true;
-        saveBtn.textContent = 'Сохранение...';
+        const d = await fetchJSON(base + '/diff?from=' + v.version);
+        diffEl.style.display = 'block';
+        diffEl.textContent = d.changes.length
+          ? `v${d.from} → v${d.to}\n` +
+            d.changes
+              .map((c) => {
+                if (c.op === 'added') return '+ ' + c.path + ': ' + fmt(c.to);
+                if (c.op === 'removed') return '- ' + c.path + ': ' + fmt(c.from);
+                return '~ ' + c.path + ': ' + fmt(c.from) + ' → ' + fmt(c.to);
+              })
+              .join('\n')
+          : `v${d.from} и v${d.to} не отличаются`;
+      } catch (err) {
+        console.error(err);
+        alert('Не удалось сравнить версии');
+      }
+    });
 
-        const res2 = await fetch(buildApiUrl('/admin/settings'), {
-          method: 'POST',
-          headers: { 'Content-Type': 'application/json' },
-          body: JSON.stringify({
-            osrmBaseUrl,
-            nominatimBaseUrl,
-            telegramBotToken,
-          }),
-        });
-
-        if (!res2.ok) {
-          throw new Error('HTTP ' + res2.status);
-        }
-
-        await res2.json();
-        alert('Настройки сохранены');
+    row.querySelector('.btn-restore').addEventListener('click', async () => {
+      if (!confirm(`Вернуть конфиг к версии v${v.version}? Текущая останется в истории.`)) return;
+      try {
+        await postJSON(base + '/' + v.version + '/restore', {});
+        close();
+        if (onRestored) onRestored();
       } catch (err) {
         console.error(err);
-        alert('Не удалось сохранить настройки');
-      } finally {
-        saveBtn.disabled = false;
-        saveBtn.textContent = 'Сохранить';
+        alert('Не удалось восстановить версию: ' + err.message);
       }
     });
-  } catch (err) {
-    console.error(err);
-    contentEl.innerHTML = `
-      <div class="card">
-        <div class="card-title">Ошибка</div>
-        <p>Не удалось загрузить настройки.</p>
-      </div>
-    `;
-  }
+  });
 }
 
-// --- Billing / plans ---
+// корзина: удалённые калькуляторы можно вернуть, пока не наступил purgeAt
+async function showTrashModal(onRestored) {
+  const backdrop = document.createElement('div');
+  backdrop.style.position = 'fixed';
+  backdrop.style.inset = '0';
+  backdrop.style.background = 'rgba(15, 23, 42, 0.45)';
+  backdrop.style.display = 'flex';
+  backdrop.style.alignItems = 'center';
+  backdrop.style.justifyContent = 'center';
+  backdrop.style.zIndex = '9999';
 
-async function renderBilling() {
-  contentEl.innerHTML = `
-    <div class="card">
-      <div class="card-title">Загрузка тарифа...</div>
+  const modal = document.createElement('div');
+  modal.className = 'card';
+  modal.style.maxWidth = '640px';
+  modal.style.width = '100%';
+  modal.style.margin = '16px';
+  modal.style.maxHeight = '80vh';
+  modal.style.overflow = 'auto';
+  modal.innerHTML = `
+    <div style="display:flex; justify-content:space-between; align-items:center;">
+      <div class="card-title">Корзина</div>
+      <button class="btn secondary" type="button" id="trash-close">Закрыть</button>
     </div>
+    <div id="trash-list"><p class="small">Загрузка...</p></div>
   `;
+  backdrop.appendChild(modal);
+  document.body.appendChild(backdrop);
 
-  let me;
-  let plans;
+  const close = () => backdrop.remove();
+  modal.querySelector('#trash-close').addEventListener('click', close);
+  backdrop.addEventListener('click', (e) => {
+    if (e.target === backdrop) close();
+  });
 
+  const listEl = modal.querySelector('#trash-list');
+
+  let items;
   try {
-    [me, plans] = await Promise.all([
-      fetchJSON('/me'),
-      fetchJSON('/plans'),
-    ]);
+    const data = await fetchJSON('/calculators/trash');
+    items = (data && data.items) || [];
   } catch (err) {
     console.error(err);
-    contentEl.innerHTML = `
-      <div class="card">
-        <div class="card-title">Ошибка</div>
-        <p>Не удалось загрузить данные о текущем пользователе или тарифах.</p>
-      </div>
-    `;
+    listEl.innerHTML = '<p class="small">Не удалось загрузить корзину.</p>';
     return;
   }
 
-  const user = (me && me.user) || null;
-  const currentPlan = (me && me.plan) || null;
-  const leadsUsed = typeof me.leadsUsed === 'number' ? me.leadsUsed : 0;
-  const calcsUsed = typeof me.calcsUsed === 'number' ? me.calcsUsed : 0;
-  const userTelegramChatId =
-  user && (user.telegramChatId || user.tgChatId || '');
-
-  plans = Array.isArray(plans) ? plans : [];
-
-  const root = document.createElement('div');
-
-  // --- карточка "Текущий тариф" ---
+  if (!items.length) {
+    listEl.innerHTML = '<p class="small">Корзина пуста.</p>';
+    return;
+  }
 
-  const currentCard = document.createElement('div');
-  currentCard.className = 'card';
-  if (!currentPlan) {
-    currentCard.innerHTML = `
-      <div class="card-title">Текущий тариф</div>
-      <div class="card-subtitle">
-        Тариф не выбран. Обратитесь к администратору или выберите тариф из списка ниже.
+  listEl.innerHTML = '';
+  items.forEach((c) => {
+    const row = document.createElement('div');
+    row.style.display = 'flex';
+    row.style.justifyContent = 'space-between';
+    row.style.alignItems = 'center';
+    row.style.gap = '8px';
+    row.style.padding = '6px 0';
+    row.style.borderBottom = '1px solid #e5e7eb';
+    row.innerHTML = `
+      <div>
+        <strong>${c.name}</strong>
+        <span class="small">· ${CALC_TYPE_LABELS[c.type] || c.type}</span><br>
+        <span class="small">Удалён ${new Date(c.deletedAt).toLocaleString()}, будет удалён насовсем ${new Date(c.purgeAt).toLocaleString()}</span>
       </div>
+      <button class="btn secondary btn-sm" type="button">Восстановить</button>
     `;
-  } else {
-    const leadsLimit =
-      typeof currentPlan.maxLeads === 'number' && currentPlan.maxLeads > 0
-        ? currentPlan.maxLeads
-        : null;
-    const calcsLimit =
-      typeof currentPlan.maxCalcs === 'number' && currentPlan.maxCalcs > 0
-        ? currentPlan.maxCalcs
-        : (leadsLimit ? leadsLimit * 2 : null);
+    const btn = row.querySelector('button');
+    btn.addEventListener('click', async () => {
+      try {
+        btn.disabled = true;
+        const restored = await postJSON('/calculators/' + encodeURIComponent(c.id) + '/restore', {});
+        row.remove();
+        if (onRestored) onRestored(restored);
+      } catch (err) {
+        console.error(err);
+        if (String(err.message).toLowerCase().includes('лимит')) {
+          showPlanLimitPopup(err.message);
+        } else {
+          alert('Не удалось восстановить калькулятор: ' + err.message);
+        }
+        btn.disabled = false;
+      }
+    });
+    listEl.appendChild(row);
+  });
+}
 
-    const leadsLimitText = leadsLimit ? leadsLimit : '∞';
-    const calcsLimitText = calcsLimit ? calcsLimit : '∞';
+// публичные адреса калькулятора: slug /c/..., перевыпуск токена и история старых ссылок
+async function showPublicLinksModal(calc, onChanged) {
+  const base = '/calculators/' + encodeURIComponent(calc.id);
 
-    const price =
-      typeof currentPlan.price === 'number'
-        ? currentPlan.price.toLocaleString('ru-RU') + ' ₽/мес'
-        : String(currentPlan.price || '');
+  const backdrop = document.createElement('div');
+  backdrop.style.position = 'fixed';
+  backdrop.style.inset = '0';
+  backdrop.style.background = 'rgba(15, 23, 42, 0.45)';
+  backdrop.style.display = 'flex';
+  backdrop.style.alignItems = 'center';
+  backdrop.style.justifyContent = 'center';
+  backdrop.style.zIndex = '9999';
 
-    const planActive = !user || user.planActive !== false;
+  const modal = document.createElement('div');
+  modal.className = 'card';
+  modal.style.maxWidth = '640px';
+  modal.style.width = '100%';
+  modal.style.margin = '16px';
+  modal.style.maxHeight = '80vh';
+  modal.style.overflow = 'auto';
+  backdrop.appendChild(modal);
+  document.body.appendChild(backdrop);
 
-    currentCard.innerHTML = `
-      <div class="card-title">Ваш текущий тариф: ${currentPlan.name || currentPlan.id}</div>
-      <div class="card-subtitle">
-        ${currentPlan.description || 'Описание тарифа отсутствует.'}
-      </div>
+  const close = () => backdrop.remove();
+  backdrop.addEventListener('click', (e) => {
+    if (e.target === backdrop) close();
+  });
 
-      <div class="field" style="margin-top:8px;">
-        <div class="small">
-          Стоимость: <strong>${price || 'по договорённости'}</strong>
-        </div>
-        <div class="small">
-          Калькуляторов: <strong>${currentPlan.maxCalculators}</strong>
-        </div>
-        <div class="small">
-          Лимит заявок: <strong>${leadsUsed}/${leadsLimitText}</strong>
-        </div>
-        <div class="small">
-          Лимит расчётов: <strong>${calcsUsed}/${calcsLimitText}</strong>
-        </div>
+  const REDIRECT_REASONS = {
+    token_rotated: 'перевыпуск ссылки',
+    slug_changed: 'смена адреса',
+    owner_changed: 'смена владельца',
+  };
 
-        <div class="field" style="margin-top:12px;">
-          <label class="field-label">Уведомления в Telegram</label>
-          <p class="small">
-            Укажите ваш Telegram ID, чтобы получать сюда копии расчётов по калькуляторам.
-          </p>
-          <div style="display:flex; gap:8px; align-items:center; flex-wrap:wrap;">
-            <input type="text" id="billing-tg-chat-id" placeholder="Например, 123456789" style="max-width:220px;" />
-            <button type="button" class="btn secondary" id="billing-tg-chat-save">
-              Сохранить
-            </button>
-          </div>
-          <p class="small" style="margin-top:4px;">
-            Узнать свой ID можно через бота <code>@userinfobot</code> (команда /start).
-          </p>
+  async function render() {
+    modal.innerHTML = `
+      <div style="display:flex; justify-content:space-between; align-items:center;">
+        <div class="card-title">Публичные ссылки</div>
+        <button class="btn secondary" type="button" id="links-close">Закрыть</button>
+      </div>
+      <p class="small">Текущая ссылка: <code>${window.location.origin + calc.publicPath}</code></p>
+      <div class="field">
+        <label class="field-label">Короткий адрес</label>
+        <div style="display:flex; gap:8px; align-items:center;">
+          <span class="small">${window.location.origin}/c/</span>
+          <input type="text" id="links-slug" placeholder="trailer-builder" />
+          <button class="btn primary" type="button" id="links-slug-save">Сохранить</button>
+        </div>
+        <p class="small">Латиница, цифры и дефисы. Старый адрес продолжит вести на калькулятор.</p>
+      </div>
+      <div class="field">
+        <label class="field-label">Перевыпустить ссылку</label>
+        <div style="display:flex; gap:8px; align-items:center;">
+          <select id="links-grace">
+            <option value="0">старая ссылка перестанет работать сразу</option>
+            <option value="24">старая ссылка работает ещё сутки</option>
+            <option value="168">старая ссылка работает ещё неделю</option>
+          </select>
+          <button class="btn secondary" type="button" id="links-rotate">Перевыпустить</button>
         </div>
-
-        ${
-          planActive
-            ? ''
-            : '<div class="small" style="color:#f97316;margin-top:4px;">Тариф не активен. Обратитесь к администратору или продлите подписку.</div>'
-        }
       </div>
+      <div class="card-subtitle">Старые ссылки</div>
+      <div id="links-history"><p class="small">Загрузка...</p></div>
     `;
-  }
+    modal.querySelector('#links-close').addEventListener('click', close);
 
-  root.appendChild(currentCard);
-     // --- Telegram ID в биллинге ---
-  const tgChatInput = document.getElementById('billing-tg-chat-id');
-  const tgChatSaveBtn = document.getElementById('billing-tg-chat-save');
+    const slugInput = modal.querySelector('#links-slug');
+    slugInput.value = calc.slug || '';
 
-  if (tgChatInput && typeof userTelegramChatId === 'string') {
-    tgChatInput.value = userTelegramChatId;
-  }
+    const update = (updated) => {
+      calc = updated;
+      if (onChanged) onChanged(updated);
+      render();
+    };
 
-  if (tgChatInput && tgChatSaveBtn && user && user.id) {
-    tgChatSaveBtn.addEventListener('click', async () => {
-      const chatId = tgChatInput.value.trim();
-      if (!chatId) {
-        if (!confirm('Оставить Telegram ID пустым? Уведомления приходить не будут.')) {
-          return;
-        }
+    modal.querySelector('#links-slug-save').addEventListener('click', async () => {
+      try {
+        update(await putJSON(base + '/slug', { slug: slugInput.value.trim() }));
+      } catch (err) {
+        console.error(err);
+        alert(
+          err.status === 409
+            ? 'Этот адрес уже занят'
+            : 'Не удалось сохранить адрес: ' + err.message
+        );
       }
+    });
 
+    modal.querySelector('#links-rotate').addEventListener('click', async () => {
+      if (!confirm('Выпустить новую ссылку? Ссылки на сайтах и в рассылках нужно будет обновить.')) return;
       try {
-        tgChatSaveBtn.disabled = true;
-        tgChatSaveBtn.textContent = 'Сохраняем...';
-
-        const res = await fetch(buildApiUrl('/me/telegram'), {
-          method: 'POST',
-          headers: { 'Content-Type': 'application/json' },
-          body: JSON.stringify({ telegramChatId: chatId }),
-        });
-
-        if (!res.ok) {
-          const txt = await res.text();
-          throw new Error(txt || ('HTTP ' + res.status));
-        }
-
-        const updatedMe = await res.json();
-        currentMe = updatedMe;
-        updateHeaderFromMe(updatedMe);
-        alert('Telegram ID сохранён');
+        const graceHours = parseInt(modal.querySelector('#links-grace').value, 10) || 0;
+        update(await postJSON(base + '/rotate-token', { graceHours }));
       } catch (err) {
         console.error(err);
-        alert('Не удалось сохранить Telegram ID: ' + (err.message || err));
-      } finally {
-        tgChatSaveBtn.disabled = false;
-        tgChatSaveBtn.textContent = 'Сохранить';
+        alert('Не удалось перевыпустить ссылку: ' + err.message);
+      }
+    });
+
+    const historyEl = modal.querySelector('#links-history');
+    let items;
+    try {
+      const data = await fetchJSON(base + '/redirects');
+      items = (data && data.items) || [];
+    } catch (err) {
+      console.error(err);
+      historyEl.innerHTML = '<p class="small">Не удалось загрузить историю.</p>';
+      return;
+    }
+    if (!items.length) {
+      historyEl.innerHTML = '<p class="small">Ссылка ещё не менялась.</p>';
+      return;
+    }
+
+    historyEl.innerHTML = '';
+    const now = new Date();
+    items.forEach((rd) => {
+      const expires = rd.expiresAt ? new Date(rd.expiresAt) : null;
+      const active = !expires || expires > now;
+      const row = document.createElement('div');
+      row.style.display = 'flex';
+      row.style.justifyContent = 'space-between';
+      row.style.alignItems = 'center';
+      row.style.gap = '8px';
+      row.style.padding = '6px 0';
+      row.style.borderBottom = '1px solid #e5e7eb';
+      row.innerHTML = `
+        <div class="small">
+          <code>${rd.path}</code><br>
+          ${REDIRECT_REASONS[rd.reason] || rd.reason}, ${new Date(rd.createdAt).toLocaleString()} ·
+          ${
+            !active
+              ? 'не работает'
+              : expires
+              ? 'ведёт на калькулятор до ' + expires.toLocaleString()
+              : 'ведёт на калькулятор'
+          }
+        </div>
+        ${active ? '<button class="btn secondary btn-sm" type="button">Отключить</button>' : ''}
+      `;
+      const btn = row.querySelector('button');
+      if (btn) {
+        btn.addEventListener('click', async () => {
+          try {
+            const res = await fetch(
+              buildApiUrl(base + '/redirects?path=' + encodeURIComponent(rd.path)),
+              { method: 'DELETE' }
+            );
+            if (!res.ok) throw new Error(await res.text());
+            render();
+          } catch (err) {
+            console.error(err);
+            alert('Не удалось отключить ссылку: ' + err.message);
+          }
+        });
       }
+      historyEl.appendChild(row);
     });
   }
 
-  // --- список доступных тарифов ---
+  render();
+}
 
-  const plansCard = document.createElement('div');
-  plansCard.className = 'card';
-  plansCard.innerHTML = `
-    <div class="card-title">Доступные тарифы</div>
-    <div class="card-subtitle">
-      Тарифы загружаются из БД. Здесь можно сравнить ограничения и возможности.
-      Переключение тарифа пока демонстрационное — в реальной версии будет оплата и смена плана.
+// калькулятор для редактора конфига: выбранный в списке или первый подходящий по типу
+async function pickCalculatorOfType(type, current) {
+  if (current && current.type === type) return current;
+  const data = await fetchJSON('/calculators?limit=1&type=' + encodeURIComponent(type));
+  const items = (data && data.items) || [];
+  return items[0] || null;
+}
+
+function renderNoCalculatorOfType(label) {
+  contentEl.innerHTML = `
+    <div class="card">
+      <div class="card-title">Нет ${label}</div>
+      <p class="card-subtitle">Создайте калькулятор в разделе «Калькуляторы», затем откройте его здесь.</p>
     </div>
-    <div class="plans-grid" id="plans-grid" style="display:grid;grid-template-columns:repeat(auto-fit,minmax(220px,1fr));gap:12px;margin-top:12px;"></div>
   `;
+}
 
-  const grid = plansCard.querySelector('#plans-grid');
-
-  if (!plans.length) {
-    grid.innerHTML = `<p class="small">Тарифы пока не настроены. Добавьте записи в таблицу <code>plans</code> в БД.</p>`;
-  } else {
-    plans.forEach((p) => {
-      const isCurrent = currentPlan && currentPlan.id === p.id;
-
-      const price =
-        typeof p.price === 'number'
-          ? p.price.toLocaleString('ru-RU') + ' ₽/мес'
-          : String(p.price || '');
+// --- Settings ---
 
-      const leadsLimit =
-        typeof p.maxLeads === 'number' && p.maxLeads > 0 ? p.maxLeads : null;
-      const calcsLimit =
-        typeof p.maxCalcs === 'number' && p.maxCalcs > 0
-          ? p.maxCalcs
-          : (leadsLimit ? leadsLimit * 2 : null);
+// карточка двухфакторной аутентификации (для любого пользователя)
+async function renderTwoFactorCard() {
+  const card = document.createElement('div');
+  card.className = 'card';
 
-      const card = document.createElement('div');
-      card.className = 'card';
-      card.style.border = isCurrent ? '1px solid #4f46e5' : '1px solid #e5e7eb';
-      card.style.boxShadow = isCurrent
-        ? '0 0 0 1px rgba(79,70,229,0.3)'
-        : 'none';
+  let st;
+  try {
+    st = await fetchJSON('/me/2fa');
+  } catch (err) {
+    console.error(err);
+    card.innerHTML = '<div class="card-title">Двухфакторная аутентификация</div><p>Не удалось загрузить статус.</p>';
+    return card;
+  }
 
+  const render = () => {
+    if (st.enabled) {
       card.innerHTML = `
-        <div class="card-title" style="margin-bottom:4px;">
-          ${p.name || p.id}
-          ${
-            isCurrent
-              ? '<span class="badge" style="margin-left:6px;background:#eef2ff;color:#4f46e5;font-size:11px;padding:2px 6px;border-radius:999px;">Текущий</span>'
-              : ''
-          }
-        </div>
-        <div class="card-subtitle" style="min-height:40px;">
-          ${p.description || 'Без описания'}
-        </div>
-        <div class="field" style="margin-top:8px;">
-          <div class="small">Стоимость: <strong>${price || 'по договорённости'}</strong></div>
-          <div class="small">Калькуляторов: <strong>${p.maxCalculators}</strong></div>
-          <div class="small">Лимит заявок: <strong>${leadsLimit ? leadsLimit : '∞'}</strong></div>
-          <div class="small">Лимит расчётов: <strong>${calcsLimit ? calcsLimit : '∞'}</strong></div>
+        <div class="card-title">Двухфакторная аутентификация</div>
+        <p class="card-subtitle">Включена. Осталось кодов восстановления: ${st.recoveryCodesLeft}.</p>
+        <div class="field">
+          <label class="field-label">Код из приложения</label>
+          <input type="text" id="tfa-code" inputmode="numeric" />
         </div>
-        <div class="field" style="margin-top:8px;">
-          <button type="button" class="btn primary btn-choose-plan" data-plan-id="${p.id}" ${
-            isCurrent ? 'disabled' : ''
-          }>
-            ${isCurrent ? 'Текущий тариф' : 'Выбрать тариф'}
-          </button>
+        <div class="field" style="display:flex; gap:8px; flex-wrap:wrap;">
+          <button class="btn secondary btn-sm" id="tfa-codes-btn" type="button">Новые коды восстановления</button>
+          ${st.required ? '' : '<button class="btn secondary btn-sm" id="tfa-disable-btn" type="button">Отключить</button>'}
         </div>
+        <pre class="small" id="tfa-codes" style="display:none;"></pre>
       `;
-
-      grid.appendChild(card);
-    });
-  }
-
-  root.appendChild(plansCard);
-
-  contentEl.innerHTML = '';
-  contentEl.appendChild(root);
-
-  // обработка Telegram chat id
-  const tgIdInput = root.querySelector('#billing-tg-chat-id');
-  const tgSaveBtn = root.querySelector('#billing-tg-chat-save');
-  if (tgIdInput && tgSaveBtn && user) {
-    if (user.telegramChatId) {
-      tgIdInput.value = String(user.telegramChatId);
+      card.querySelector('#tfa-codes-btn').addEventListener('click', async () => {
+        try {
+          const res = await postJSON('/me/2fa/recovery-codes', { code: card.querySelector('#tfa-code').value.trim() });
+          showCodes(res.recoveryCodes);
+        } catch (err) {
+          alert('Не удалось выпустить коды: ' + err.message);
+        }
+      });
+      const disableBtn = card.querySelector('#tfa-disable-btn');
+      if (disableBtn) {
+        disableBtn.addEventListener('click', async () => {
+          const res = await fetch(buildApiUrl('/me/2fa/disable'), {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ code: card.querySelector('#tfa-code').value.trim() }),
+          });
+          if (!res.ok) {
+            alert('Не удалось отключить: ' + (await res.text()));
+            return;
+          }
+          st = { enabled: false, required: st.required, recoveryCodesLeft: 0 };
+          render();
+        });
+      }
+      return;
     }
 
-    tgSaveBtn.addEventListener('click', async () => {
-      const val = tgIdInput.value.trim();
-      if (!val) {
-        if (!confirm('Очистить Telegram ID?')) return;
-      }
+    card.innerHTML = `
+      <div class="card-title">Двухфакторная аутентификация</div>
+      <p class="card-subtitle">
+        ${st.required ? 'Для администраторов 2FA обязательна — без неё разделы администратора недоступны.' : 'Вход по паролю и коду из приложения-аутентификатора.'}
+      </p>
+      <button class="btn primary btn-sm" id="tfa-enroll-btn" type="button">Подключить</button>
+      <div id="tfa-enroll" style="display:none;">
+        <p class="small">Добавьте ключ в приложение (Google Authenticator, 1Password и т.п.):</p>
+        <p class="small"><code id="tfa-secret"></code></p>
+        <p class="small"><a id="tfa-uri" href="#">Открыть в приложении</a></p>
+        <div class="field">
+          <label class="field-label">Код из приложения</label>
+          <input type="text" id="tfa-activate-code" inputmode="numeric" />
+        </div>
+        <button class="btn primary btn-sm" id="tfa-activate-btn" type="button">Подтвердить</button>
+      </div>
+      <pre class="small" id="tfa-codes" style="display:none;"></pre>
+    `;
 
+    card.querySelector('#tfa-enroll-btn').addEventListener('click', async () => {
       try {
-        tgSaveBtn.disabled = true;
-        tgSaveBtn.textContent = 'Сохранение...';
-
-        await fetch(buildApiUrl('/me/telegram'), {
-          method: 'POST',
-          headers: { 'Content-Type': 'application/json' },
-          body: JSON.stringify({ chatId: val }),
-        });
-
-        // локально обновим currentMe, если он есть
-        if (currentMe && currentMe.user) {
-          currentMe.user.telegramChatId = val || null;
-        }
-
-        alert('Telegram ID сохранён');
+        const res = await postJSON('/me/2fa/enroll', {});
+        card.querySelector('#tfa-secret').textContent = res.secret;
+        card.querySelector('#tfa-uri').href = res.otpauthUri;
+        card.querySelector('#tfa-enroll').style.display = '';
       } catch (err) {
-        console.error(err);
-        alert('Не удалось сохранить Telegram ID');
-      } finally {
-        tgSaveBtn.disabled = false;
-        tgSaveBtn.textContent = 'Сохранить';
+        alert('Не удалось подключить 2FA: ' + err.message);
       }
     });
-  }
-
-  // пока делаем кнопки "демо" для смены тарифа
-  root.querySelectorAll('.btn-choose-plan').forEach((btn) => {
-    btn.addEventListener('click', async () => {
-      const planId = btn.getAttribute('data-plan-id');
-      if (!planId) return;
-
-      if (!me || !me.user) {
-        alert('Нет данных о текущем пользователе');
-        return;
-      }
-
-      if (!confirm('Переключить тариф на план: ' + planId + '?')) {
-        return;
-      }
-
-      const u = me.user;
 
+    card.querySelector('#tfa-activate-btn').addEventListener('click', async () => {
       try {
-        btn.disabled = true;
-        btn.textContent = 'Переключаем...';
-
-        const body = {
-          name: u.name || '',
-          email: u.email || '',
-          role: u.role || 'user',
-          planId: planId,
-          planActive: true,
-        };
-
-        const res = await fetch(
-          buildApiUrl('/admin/users/' + encodeURIComponent(u.id)),
-          {
-            method: 'PUT',
-            headers: { 'Content-Type': 'application/json' },
-            body: JSON.stringify(body),
-          }
-        );
-
-        if (!res.ok) {
-          const txt = await res.text();
-          throw new Error(txt || 'HTTP ' + res.status);
-        }
-
-        // обновляем /me и шапку
-        const updatedMe = await fetchJSON('/me');
-        currentMe = updatedMe;
-        updateHeaderFromMe(updatedMe);
-
-        // перерисовываем биллинг
-        await renderBilling();
+        const res = await postJSON('/me/2fa/activate', {
+          code: card.querySelector('#tfa-activate-code').value.trim(),
+        });
+        st = { enabled: true, required: st.required, recoveryCodesLeft: res.recoveryCodes.length };
+        render();
+        showCodes(res.recoveryCodes);
       } catch (err) {
-        console.error(err);
-        alert('Не удалось сменить тариф: ' + (err.message || err));
+        alert('Неверный код');
       }
     });
-  });
-}
+  };
 
-// --- Admin users ---
+  const showCodes = (codes) => {
+    const pre = card.querySelector('#tfa-codes');
+    pre.textContent =
+      'Сохраните коды восстановления — каждый можно использовать один раз, больше они не будут показаны:\n\n' +
+      codes.join('\n');
+    pre.style.display = '';
+  };
 
-async function renderAdminUsers() {
+  render();
+  return card;
+}
+
+async function renderSettings() {
   contentEl.innerHTML = `
     <div class="card">
-      <div class="card-title">Загрузка пользователей...</div>
+      <div class="card-title">Загрузка настроек...</div>
     </div>
   `;
 
-  let usersData = [];
-  let plans = [];
-
   try {
-    const [usersRes, me] = await Promise.all([
-      fetch(buildApiUrl('/admin/users')),
-      fetchJSON('/me'),
-    ]);
+    const url = buildApiUrl('/admin/settings');
+    const res = await fetch(url, { method: 'GET' });
 
-    if (usersRes.status === 403) {
-      contentEl.innerHTML = `
-        <div class="card">
+    if (res.status === 403) {
+      const reason = await res.text();
+      contentEl.innerHTML = '';
+      contentEl.appendChild(await renderTwoFactorCard());
+      const denied = document.createElement('div');
+      denied.className = 'card';
+      denied.innerHTML = reason.indexOf('2fa') >= 0
+        ? `
+          <div class="card-title">Нужна двухфакторная аутентификация</div>
+          <p class="card-subtitle">Подключите 2FA выше, чтобы открыть разделы администратора.</p>
+        `
+        : `
           <div class="card-title">Нет доступа</div>
           <p class="card-subtitle">
-            Раздел "Пользователи" доступен только администратору.
-            Выберите "Администратор" в правом верхнем углу.
+            Технические настройки доступны только администратору.
+            Войдите под учётной записью администратора.
           </p>
-        </div>
-      `;
+        `;
+      contentEl.appendChild(denied);
       return;
     }
 
-    if (!usersRes.ok) {
-      contentEl.innerHTML = `
-        <div class="card">
-          <div class="card-title">Ошибка</div>
-          <p>Код ответа: ${usersRes.status}</p>
-        </div>
-      `;
-      return;
+    if (!res.ok) {
+      throw new Error('HTTP ' + res.status);
     }
 
-    usersData = await usersRes.json();
-    plans = me.plans || [];
-  } catch (err) {
-    console.error(err);
-    contentEl.innerHTML = `
-      <div class="card">
-        <div class="card-title">Ошибка</div>
-        <p>Не удалось загрузить данные.</p>
-      </div>
-    `;
-    return;
-  }
-
-  const root = document.createElement('div');
+    const data = await res.json();
 
-  const infoCard = document.createElement('div');
-  infoCard.className = 'card';
-  infoCard.innerHTML = `
-    <div class="card-title">Пользователи</div>
-    <div class="card-subtitle">
-      Администратор может управлять клиентами, их ролями и тарифами, а также сбрасывать пароли.
-    </div>
-  `;
-  root.appendChild(infoCard);
+    const root = document.createElement('div');
 
-  const tableCard = document.createElement('div');
-  tableCard.className = 'card';
-  tableCard.innerHTML = `
-    <div class="card-title">Список пользователей</div>
-    <div id="users-table-wrap"></div>
-  `;
-  root.appendChild(tableCard);
+    const card = document.createElement('div');
+    card.className = 'card';
+    card.innerHTML = `
+      <div class="card-title">Технические настройки</div>
+      <div class="card-subtitle">
+        Здесь администратор может указать базовые адреса сервисов маршрутизации на основе OpenStreetMap.
+      </div>
 
-  const editCard = document.createElement('div');
-  editCard.className = 'card';
-  editCard.id = 'user-edit-card';
-  editCard.style.display = 'none';
-  root.appendChild(editCard);
+      <div class="field">
+        <label class="field-label">OSRM base URL</label>
+        <input type="text" id="osrm-base-url-input" placeholder="https://router.project-osrm.org" />
+        <p class="small">
+          Сервис построения маршрутов. По умолчанию используется публичный инстанс OSRM.
+        </p>
+      </div>
 
-  contentEl.innerHTML = '';
-  contentEl.appendChild(root);
+      <div class="field">
+        <label class="field-label">Nominatim base URL</label>
+        <input type="text" id="nominatim-base-url-input" placeholder="https://nominatim.openstreetmap.org" />
+        <p class="small">
+          Сервис геокодирования (поиск координат по адресу). По умолчанию используется публичный Nominatim.
+        </p>
+      </div>
+      <div class="field">
+        <label class="field-label">Telegram bot token</label>
+       <input type="text" id="tg-bot-token-input" placeholder="123456:ABC-DEF..." />
 
-  let selectedUser = null;
+        <p class="small">
+          Токен вашего Telegram-бота из @BotFather. Используется для отправки уведомлений
+          с результатами расчётов.
+        </p>
+      </div>
 
-  function roleChip(u) {
-    const label = u.role === 'admin' ? 'Администратор' : 'Пользователь';
-    const extra = u.role === 'admin' ? ' chip--role-admin' : ' chip--role-user';
-    return `<span class="chip${extra}">${label}</span>`;
-  }
+      <hr class="divider" />
 
-  function planChip(u) {
-    if (!u.plan) return '<span class="chip chip--neutral">Нет тарифа</span>';
-    return `<span class="chip chip--plan">${u.plan.name} <span class="chip__tag">${u.plan.id}</span></span>`;
-  }
+      <div class="field">
+        <label class="field-label">SSO: OpenID Connect issuer</label>
+        <input type="text" id="oidc-issuer-input" placeholder="https://login.example.com" />
+        <p class="small">Пусто — вход через SSO выключен.</p>
+      </div>
+      <div class="field">
+        <label class="field-label">SSO: client ID</label>
+        <input type="text" id="oidc-client-id-input" />
+      </div>
+      <div class="field">
+        <label class="field-label">SSO: client secret</label>
+        <input type="password" id="oidc-client-secret-input" autocomplete="off" />
+        <p class="small" id="oidc-secret-hint"></p>
+      </div>
+      <p class="small">Redirect URI для провайдера: <code id="oidc-redirect-url"></code></p>
 
-  function planStatusChip(u) {
-    if (u.planActive) {
-      return '<span class="chip chip--ok">Активен</span>';
+      <div class="field">
+        <label class="field-label">
+          <input type="checkbox" id="require-admin-2fa-input" />
+          Обязательная двухфакторная аутентификация для администраторов
+        </label>
+      </div>
+
+      <div class="field" style="display:flex; gap:8px; align-items:center;">
+        <button class="btn primary" id="settings-save-btn" type="button">Сохранить</button>
+      </div>
+    `;
+
+    root.appendChild(await renderTwoFactorCard());
+    root.appendChild(card);
+    root.appendChild(renderTemplatesAdminCard());
+    contentEl.innerHTML = '';
+    contentEl.appendChild(root);
+
+    const osrmInput = document.getElementById('osrm-base-url-input');
+    const nominatimInput = document.getElementById('nominatim-base-url-input');
+    const tgTokenInput = document.getElementById('tg-bot-token-input');
+    const require2faInput = document.getElementById('require-admin-2fa-input');
+    const saveBtn = document.getElementById('settings-save-btn');
+
+    require2faInput.checked = !!(data && data.requireAdmin2fa);
+
+    const oidcIssuerInput = document.getElementById('oidc-issuer-input');
+    const oidcClientIdInput = document.getElementById('oidc-client-id-input');
+    const oidcSecretInput = document.getElementById('oidc-client-secret-input');
+    oidcIssuerInput.value = (data && data.oidcIssuer) || '';
+    oidcClientIdInput.value = (data && data.oidcClientId) || '';
+    document.getElementById('oidc-redirect-url').textContent = (data && data.oidcRedirectUrl) || '';
+    document.getElementById('oidc-secret-hint').textContent =
+      data && data.oidcClientSecretSet
+        ? 'Секрет задан. Оставьте поле пустым, чтобы не менять его.'
+        : 'Секрет не задан (для публичного клиента с PKCE он не обязателен).';
+
+    if (data && data.osrmBaseUrl) {
+      osrmInput.value = data.osrmBaseUrl;
+    }
+    if (data && data.nominatimBaseUrl) {
+      nominatimInput.value = data.nominatimBaseUrl;
     }
-    return '<span class="chip chip--warn">Не активен</span>';
+    if (data && data.telegramBotToken) {
+      tgTokenInput.value = data.telegramBotToken;
+    }
+
+    saveBtn.addEventListener('click', async () => {
+      const osrmBaseUrl = osrmInput.value.trim();
+      const nominatimBaseUrl = nominatimInput.value.trim();
+      const telegramBotToken = tgTokenInput.value.trim();
+      try {
+        saveBtn.disabled = true;
+        saveBtn.textContent = 'Сохранение...';
+
+        const res2 = await fetch(buildApiUrl('/admin/settings'), {
+          method: 'POST',
+          headers: { 'Content-Type': 'application/json' },
+          body: JSON.stringify({
+            osrmBaseUrl,
+            nominatimBaseUrl,
+            telegramBotToken,
+            requireAdmin2fa: require2faInput.checked,
+            oidcIssuer: oidcIssuerInput.value.trim(),
+            oidcClientId: oidcClientIdInput.value.trim(),
+            // секрет отправляем, только если его ввели заново
+            ...(oidcSecretInput.value ? { oidcClientSecret: oidcSecretInput.value } : {}),
+          }),
+        });
+
+        if (!res2.ok) {
+          throw new Error(await res2.text());
+        }
+
+        await res2.json();
+        alert('Настройки сохранены');
+      } catch (err) {
+        console.error(err);
+        alert('Не удалось сохранить настройки: ' + err.message);
+      } finally {
+        saveBtn.disabled = false;
+        saveBtn.textContent = 'Сохранить';
+      }
+    });
+  } catch (err) {
+    console.error(err);
+    contentEl.innerHTML = `
+      <div class="card">
+        <div class="card-title">Ошибка</div>
+        <p>Не удалось загрузить настройки.</p>
+      </div>
+    `;
   }
+}
 
-  function renderTable() {
-    const wrap = tableCard.querySelector('#users-table-wrap');
-    if (!wrap) return;
+// --- Шаблоны калькуляторов (админ) ---
+
+function renderTemplatesAdminCard() {
+  const card = document.createElement('div');
+  card.className = 'card';
+  card.innerHTML = `
+    <div class="card-title">Шаблоны калькуляторов</div>
+    <p class="card-subtitle">Из шаблонов пользователи создают калькуляторы с готовым конфигом.</p>
+    <div id="tpl-list"><p class="small">Загрузка...</p></div>
+    <div id="tpl-form" style="display:none;"></div>
+    <div class="field">
+      <button class="btn secondary" id="tpl-add-btn" type="button">Добавить шаблон</button>
+    </div>
+  `;
 
-    if (!usersData || usersData.length === 0) {
-      wrap.innerHTML = `<p class="small">Пользователей пока нет.</p>`;
-      return;
+  const listEl = card.querySelector('#tpl-list');
+  const formEl = card.querySelector('#tpl-form');
+  let items = [];
+
+  async function load() {
+    try {
+      const data = await fetchJSON('/admin/templates');
+      items = data.items || [];
+      renderItems();
+    } catch (err) {
+      console.error(err);
+      listEl.innerHTML = '<p class="small">Не удалось загрузить шаблоны.</p>';
     }
+  }
 
-    const rows = usersData
-      .slice()
-      .sort((a, b) => new Date(a.createdAt) - new Date(b.createdAt))
-      .map((u) => {
-        const created = u.createdAt ? new Date(u.createdAt).toLocaleString('ru-RU') : '—';
-        return `
-          <tr data-user-id="${u.id}">
-            <td>
-              <div class="user-cell">
-                <div class="user-avatar">${(u.name || u.email || '?').trim()[0].toUpperCase()}</div>
-                <div class="user-meta">
-                  <div class="user-name">${u.name || '—'}</div>
-                  <div class="user-email">${u.email}</div>
-                </div>
-              </div>
-            </td>
-            <td>${roleChip(u)}</td>
-            <td>${planChip(u)}</td>
-            <td>${planStatusChip(u)}</td>
-            <td>${created}</td>
-            <td class="user-actions">
-              <button class="icon-btn icon-btn-edit btn-edit-user" type="button" title="Редактировать">
-                ✏
-              </button>
-              <button class="icon-btn icon-btn-pass btn-pass-user" type="button" title="Сменить пароль">
-                🔑
-              </button>
-              <button class="icon-btn icon-btn-delete btn-delete-user" type="button" title="Удалить">
-                🗑
-              </button>
-            </td>
-          </tr>
-        `;
-      })
+  function renderItems() {
+    if (!items.length) {
+      listEl.innerHTML = '<p class="small">Шаблонов пока нет.</p>';
+      return;
+    }
+    listEl.innerHTML = items
+      .map(
+        (t) => `
+        <div class="field" style="display:flex; gap:8px; align-items:center;">
+          <div style="flex:1;">
+            <strong>${t.name}</strong>
+            <span class="small">· ${CALC_TYPE_LABELS[t.type] || t.type}${t.builtin ? ' · встроенный' : ''}</span>
+          </div>
+          <button class="btn secondary btn-sm" type="button" data-edit="${t.id}">Изменить</button>
+          ${t.builtin ? '' : `<button class="btn secondary btn-sm" type="button" data-delete="${t.id}">Удалить</button>`}
+        </div>
+      `
+      )
       .join('');
 
-    wrap.innerHTML = `
-      <table class="users-table">
-        <thead>
-          <tr>
-            <th>Пользователь</th>
-            <th>Роль</th>
-            <th>Тариф</th>
-            <th>Статус тарифа</th>
-            <th>Создан</th>
-            <th style="width:120px;">Действия</th>
-          </tr>
-        </thead>
-        <tbody>
-          ${rows}
-        </tbody>
-      </table>
-    `;
-
-    wrap.querySelectorAll('.btn-edit-user').forEach((btn) => {
+    listEl.querySelectorAll('[data-edit]').forEach((btn) => {
       btn.addEventListener('click', () => {
-        const tr = btn.closest('tr');
-        const id = tr?.dataset.userId;
-        if (!id) return;
-        const u = usersData.find((x) => x.id === id);
-        if (!u) return;
-        selectedUser = u;
-        renderEditForm();
+        openForm(items.find((t) => t.id === btn.dataset.edit));
       });
     });
-
-    wrap.querySelectorAll('.btn-delete-user').forEach((btn) => {
+    listEl.querySelectorAll('[data-delete]').forEach((btn) => {
       btn.addEventListener('click', async () => {
-        const tr = btn.closest('tr');
-        const id = tr?.dataset.userId;
-        if (!id) return;
-
-        if (!confirm('Удалить пользователя ' + id + '?')) return;
-
+        if (!confirm('Удалить шаблон?')) return;
         try {
-          const res = await fetch(buildApiUrl('/admin/users/' + encodeURIComponent(id)), {
+          const res = await fetch(buildApiUrl('/admin/templates/' + encodeURIComponent(btn.dataset.delete)), {
             method: 'DELETE',
           });
-          if (!res.ok && res.status !== 204) {
-            const text = await res.text();
-            alert('Не удалось удалить пользователя: ' + text);
-            return;
-          }
-          usersData = usersData.filter((u) => u.id !== id);
-          if (selectedUser && selectedUser.id === id) {
-            selectedUser = null;
-            editCard.style.display = 'none';
-          }
-          renderTable();
+          if (!res.ok) throw new Error(await res.text());
+          await load();
         } catch (err) {
           console.error(err);
-          alert('Ошибка удаления пользователя');
+          alert('Не удалось удалить шаблон: ' + err.message);
         }
       });
     });
-
-    wrap.querySelectorAll('.btn-pass-user').forEach((btn) => {
-      btn.addEventListener('click', () => {
-        const tr = btn.closest('tr');
-        const id = tr?.dataset.userId;
-        if (!id) return;
-        const u = usersData.find((x) => x.id === id);
-        if (!u) return;
-        selectedUser = u;
-        renderEditForm(true);
-      });
-    });
   }
 
-  function renderEditForm(focusPassword = false) {
-    if (!selectedUser) {
-      editCard.style.display = 'none';
-      editCard.innerHTML = '';
-      return;
-    }
-
-    const u = selectedUser;
-    const created = u.createdAt ? new Date(u.createdAt).toLocaleString('ru-RU') : '—';
-    const planId = u.plan ? u.plan.id : (u.planId || '');
-
-    const planOptions = plans
+  // tpl — существующий шаблон или null для нового
+  function openForm(tpl) {
+    formEl.style.display = '';
+    const typeOptions = Object.keys(CALC_TYPE_LABELS)
       .map(
-        (p) =>
-          `<option value="${p.id}" ${p.id === planId ? 'selected' : ''}>${p.name} (${p.id})</option>`
+        (type) =>
+          `<option value="${type}" ${tpl && tpl.type === type ? 'selected' : ''}>${CALC_TYPE_LABELS[type]}</option>`
       )
       .join('');
-    const roleOptions = `
-      <option value="user" ${u.role === 'user' ? 'selected' : ''}>Пользователь</option>
-      <option value="admin" ${u.role === 'admin' ? 'selected' : ''}>Администратор</option>
-    `;
-
-    editCard.style.display = '';
-    editCard.innerHTML = `
-      <div class="card-title">Редактирование пользователя</div>
-      <div class="card-subtitle small">
-        ID: <strong>${u.id}</strong>, создан: ${created}
-      </div>
-
+    formEl.innerHTML = `
       <div class="field">
-        <label class="field-label">Имя</label>
-        <input type="text" id="u-edit-name" value="${u.name || ''}" />
+        <label class="field-label">Название</label>
+        <input type="text" id="tpl-name" />
       </div>
       <div class="field">
-        <label class="field-label">E-mail</label>
-        <input type="email" id="u-edit-email" value="${u.email || ''}" />
+        <label class="field-label">Описание</label>
+        <input type="text" id="tpl-description" />
       </div>
       <div class="field">
-        <label class="field-label">Роль</label>
-        <select id="u-edit-role">
-          ${roleOptions}
-        </select>
+        <label class="field-label">Тип</label>
+        <select id="tpl-type">${typeOptions}</select>
       </div>
       <div class="field">
-        <label class="field-label">Тариф</label>
-        <select id="u-edit-plan">
-          <option value="">— без тарифа —</option>
-          ${planOptions}
-        </select>
+        <label class="field-label">Порядок</label>
+        <input type="number" id="tpl-sort" />
       </div>
       <div class="field">
-        <label class="field-label">
-          <input type="checkbox" id="u-edit-plan-active" ${u.planActive ? 'checked' : ''} />
-          Тариф активен
-        </label>
-      </div>
-
-      <hr class="divider" />
-
-      <div class="field">
-        <label class="field-label">Смена пароля</label>
-        <div class="password-row">
-          <input type="password" id="u-edit-password" placeholder="Новый пароль" />
-          <button class="btn secondary btn-sm" id="u-edit-password-btn" type="button">Сменить</button>
-        </div>
-        <p class="small">Пароль не отображается в списке, только устанавливается.</p>
+        <label class="field-label">Конфиг (JSON)</label>
+        <textarea id="tpl-config" rows="12" style="width:100%; font-family:monospace;"></textarea>
       </div>
-
-      <div class="field" style="display:flex; flex-wrap:wrap; gap:8px;">
-        <button class="btn primary btn-sm" id="u-edit-save" type="button">Сохранить изменения</button>
-        <button class="btn secondary btn-sm" id="u-edit-cancel" type="button">Закрыть</button>
+      <div class="field" style="display:flex; gap:8px;">
+        <button class="btn primary" id="tpl-save" type="button">Сохранить</button>
+        <button class="btn secondary" id="tpl-cancel" type="button">Отмена</button>
       </div>
     `;
 
-    const nameInput = editCard.querySelector('#u-edit-name');
-    const emailInput = editCard.querySelector('#u-edit-email');
-    const roleSelect = editCard.querySelector('#u-edit-role');
-    const planSelect = editCard.querySelector('#u-edit-plan');
-    const planActiveCheckbox = editCard.querySelector('#u-edit-plan-active');
-    const passInput = editCard.querySelector('#u-edit-password');
-
-    const saveBtn = editCard.querySelector('#u-edit-save');
-    const passBtn = editCard.querySelector('#u-edit-password-btn');
-    const cancelBtn = editCard.querySelector('#u-edit-cancel');
-
-    cancelBtn.addEventListener('click', () => {
-      selectedUser = null;
-      editCard.style.display = 'none';
-      editCard.innerHTML = '';
+    const nameInput = formEl.querySelector('#tpl-name');
+    const descInput = formEl.querySelector('#tpl-description');
+    const typeSelect = formEl.querySelector('#tpl-type');
+    const sortInput = formEl.querySelector('#tpl-sort');
+    const configInput = formEl.querySelector('#tpl-config');
+    nameInput.value = tpl ? tpl.name : '';
+    descInput.value = tpl ? tpl.description || '' : '';
+    sortInput.value = tpl ? tpl.sortOrder || 0 : 100;
+    configInput.value = JSON.stringify(tpl ? tpl.config : {}, null, 2);
+
+    formEl.querySelector('#tpl-cancel').addEventListener('click', () => {
+      formEl.style.display = 'none';
+      formEl.innerHTML = '';
     });
 
+    const saveBtn = formEl.querySelector('#tpl-save');
     saveBtn.addEventListener('click', async () => {
+      let config;
       try {
-        const body = {
-          name: nameInput.value.trim(),
-          email: emailInput.value.trim(),
-          role: roleSelect.value,
-          planId: planSelect.value || '',
-          planActive: planActiveCheckbox.checked,
-        };
-
-        const res = await fetch(
-          buildApiUrl('/admin/users/' + encodeURIComponent(u.id)),
-          {
-            method: 'PUT',
-            headers: { 'Content-Type': 'application/json' },
-            body: JSON.stringify(body),
-          }
-        );
-
-        if (!res.ok) {
-          const txt = await res.text();
-          alert('Ошибка сохранения: ' + txt);
-          return;
-        }
-
-        const updated = await res.json();
-        usersData = usersData.map((item) => (item.id === updated.id ? updated : item));
-        selectedUser = updated;
-        renderTable();
-        renderEditForm();
+        config = JSON.parse(configInput.value);
       } catch (err) {
-        console.error(err);
-        alert('Ошибка сохранения пользователя');
-      }
-    });
-
-    passBtn.addEventListener('click', async () => {
-      const newPass = passInput.value;
-      if (!newPass) {
-        alert('Введите новый пароль');
+        alert('Конфиг — некорректный JSON: ' + err.message);
         return;
       }
-      if (!confirm('Сменить пароль пользователю ' + u.id + '?')) return;
-
+      const body = {
+        name: nameInput.value.trim(),
+        description: descInput.value.trim(),
+        type: typeSelect.value,
+        sortOrder: parseInt(sortInput.value, 10) || 0,
+        config,
+      };
       try {
-        const res = await fetch(
-          buildApiUrl('/admin/users/' + encodeURIComponent(u.id) + '/password'),
-          {
-            method: 'POST',
-            headers: { 'Content-Type': 'application/json' },
-            body: JSON.stringify({ password: newPass }),
-          }
-        );
-
-        if (!res.ok) {
-          const txt = await res.text();
-          alert('Ошибка смены пароля: ' + txt);
-          return;
+        saveBtn.disabled = true;
+        if (tpl) {
+          await putJSON('/admin/templates/' + encodeURIComponent(tpl.id), body);
+        } else {
+          await postJSON('/admin/templates', body);
         }
-
-        passInput.value = '';
-        alert('Пароль обновлён');
+        formEl.style.display = 'none';
+        formEl.innerHTML = '';
+        await load();
       } catch (err) {
         console.error(err);
-        alert('Ошибка смены пароля');
+        alert('Не удалось сохранить шаблон: ' + err.message);
+      } finally {
+        saveBtn.disabled = false;
       }
     });
-
-    if (focusPassword && passInput) {
-      passInput.focus();
-    }
   }
 
-  renderTable();
+  card.querySelector('#tpl-add-btn').addEventListener('click', () => openForm(null));
+
+  load();
+  return card;
 }
 
-// --- Calculators list + create ---
+// --- Billing / plans ---
 
-async function renderCalculators() {
+async function renderBilling() {
   contentEl.innerHTML = `
     <div class="card">
-      <div class="card-title">Загрузка калькуляторов...</div>
+      <div class="card-title">Загрузка тарифа...</div>
     </div>
   `;
 
-  let data, me;
-  let planActive = true;
+  let me;
+  let plans;
 
   try {
-    [data, me] = await Promise.all([
-      fetchJSON('/calculators'),
+    [me, plans] = await Promise.all([
       fetchJSON('/me'),
+      fetchJSON('/plans'),
     ]);
   } catch (err) {
     console.error(err);
     contentEl.innerHTML = `
       <div class="card">
         <div class="card-title">Ошибка</div>
-        <p>Не удалось загрузить список калькуляторов или данные пользователя.</p>
+        <p>Не удалось загрузить данные о текущем пользователе или тарифах.</p>
       </div>
     `;
     return;
   }
 
-  const items = (data && data.items) || [];
+  const user = (me && me.user) || null;
+  const currentPlan = (me && me.plan) || null;
+  const leadsUsed = typeof me.leadsUsed === 'number' ? me.leadsUsed : 0;
+  const calcsUsed = typeof me.calcsUsed === 'number' ? me.calcsUsed : 0;
+  const userTelegramChatId =
+  user && (user.telegramChatId || user.tgChatId || '');
 
-  const meUser = me && me.user ? me.user : null;
-  planActive = !meUser || meUser.planActive !== false;
+  plans = Array.isArray(plans) ? plans : [];
 
   const root = document.createElement('div');
 
-  if (!planActive) {
-    const banner = document.createElement('div');
-    banner.className = 'card';
-    banner.innerHTML = `
-      <div class="card-title">Тариф не активен</div>
+  // --- карточка "Текущий тариф" ---
+
+  const currentCard = document.createElement('div');
+  currentCard.className = 'card';
+  if (!currentPlan) {
+    currentCard.innerHTML = `
+      <div class="card-title">Текущий тариф</div>
       <div class="card-subtitle">
-        Срок действия вашего тарифа завершился. 
-        Калькуляторы временно недоступны для редактирования.
-      </div>
-      <p class="small" style="margin-top:8px;">
-        Активируйте тариф, чтобы снова создавать и настраивать калькуляторы.
-      </p>
-      <div style="margin-top:12px;">
-        <button class="btn primary" id="goto-billing-from-calcs" type="button">
-          Активировать тариф
-        </button>
+        Тариф не выбран. Обратитесь к администратору или выберите тариф из списка ниже.
       </div>
     `;
-    root.appendChild(banner);
+  } else {
+    const leadsLimit =
+      typeof currentPlan.maxLeads === 'number' && currentPlan.maxLeads > 0
+        ? currentPlan.maxLeads
+        : null;
+    const calcsLimit =
+      typeof currentPlan.maxCalcs === 'number' && currentPlan.maxCalcs > 0
+        ? currentPlan.maxCalcs
+        : (leadsLimit ? leadsLimit * 2 : null);
 
-    const gotoBtn = banner.querySelector('#goto-billing-from-calcs');
-    gotoBtn.addEventListener('click', () => {
-      currentSection = 'billing';
-      setActiveNav('billing');
-      loadSection('billing');
-    });
-  }
+    const leadsLimitText = leadsLimit ? leadsLimit : '∞';
+    const calcsLimitText = calcsLimit ? calcsLimit : '∞';
 
-  const headerCard = document.createElement('div');
-  headerCard.className = 'card';
-  headerCard.innerHTML = `
-    <div style="display:flex; justify-content:space-between; align-items:center; gap:8px;">
-      <div>
-        <div class="card-title">Ваши калькуляторы</div>
-        <div class="card-subtitle">
-          Управляйте существующими калькуляторами и создавайте новые.
-          ${
-            !planActive
-              ? '<br><span class="small" style="color:#f97316;">Создание и редактирование недоступны до активации тарифа.</span>'
-              : ''
-          }
-        </div>
-      </div>
-      <div>
-        <button class="btn primary btn-large" id="btn-open-create-calc" type="button" ${
-          !planActive ? 'disabled' : ''
-        }>
-          + Создать калькулятор
-        </button>
+    const price =
+      typeof currentPlan.price === 'number'
+        ? currentPlan.price.toLocaleString('ru-RU') + ' ₽/мес'
+        : String(currentPlan.price || '');
+
+    const planActive = !user || user.planActive !== false;
+
+    currentCard.innerHTML = `
+      <div class="card-title">Ваш текущий тариф: ${currentPlan.name || currentPlan.id}</div>
+      <div class="card-subtitle">
+        ${currentPlan.description || 'Описание тарифа отсутствует.'}
       </div>
-    </div>
-  `;
-  root.appendChild(headerCard);
 
-  const listCard = document.createElement('div');
-  listCard.className = 'card';
-  listCard.innerHTML = `
-    <div class="card-title">Список калькуляторов</div>
-    <div id="calc-list" class="calc-list"></div>
-    <div id="calc-create-panel" class="calc-create-panel" style="display:none;"></div>
-  `;
-  root.appendChild(listCard);
+      <div class="field" style="margin-top:8px;">
+        <div class="small">
+          Стоимость: <strong>${price || 'по договорённости'}</strong>
+        </div>
+        <div class="small">
+          Калькуляторов: <strong>${currentPlan.maxCalculators}</strong>
+        </div>
+        <div class="small">
+          Лимит заявок: <strong>${leadsUsed}/${leadsLimitText}</strong>
+        </div>
+        <div class="small">
+          Лимит расчётов: <strong>${calcsUsed}/${calcsLimitText}</strong>
+        </div>
 
-  contentEl.innerHTML = '';
-  contentEl.appendChild(root);
+        <div class="field" style="margin-top:12px;">
+          <label class="field-label">Уведомления в Telegram</label>
+          <p class="small">
+            Укажите ваш Telegram ID, чтобы получать сюда копии расчётов по калькуляторам.
+          </p>
+          <div style="display:flex; gap:8px; align-items:center; flex-wrap:wrap;">
+            <input type="text" id="billing-tg-chat-id" placeholder="Например, 123456789" style="max-width:220px;" />
+            <button type="button" class="btn secondary" id="billing-tg-chat-save">
+              Сохранить
+            </button>
+          </div>
+          <p class="small" style="margin-top:4px;">
+            Узнать свой ID можно через бота <code>@userinfobot</code> (команда /start).
+          </p>
+        </div>
 
-  const listEl = listCard.querySelector('#calc-list');
-  const createPanelEl = listCard.querySelector('#calc-create-panel');
-  const openCreateBtn = headerCard.querySelector('#btn-open-create-calc');
+        ${
+          planActive
+            ? ''
+            : '<div class="small" style="color:#f97316;margin-top:4px;">Тариф не активен. Обратитесь к администратору или продлите подписку.</div>'
+        }
+      </div>
+    `;
+  }
 
-  function renderList() {
-    listEl.innerHTML = '';
+  root.appendChild(currentCard);
+     // --- Telegram ID в биллинге ---
+  const tgChatInput = document.getElementById('billing-tg-chat-id');
+  const tgChatSaveBtn = document.getElementById('billing-tg-chat-save');
+
+  if (tgChatInput && typeof userTelegramChatId === 'string') {
+    tgChatInput.value = userTelegramChatId;
+  }
+
+  if (tgChatInput && tgChatSaveBtn && user && user.id) {
+    tgChatSaveBtn.addEventListener('click', async () => {
+      const chatId = tgChatInput.value.trim();
+      if (!chatId) {
+        if (!confirm('Оставить Telegram ID пустым? Уведомления приходить не будут.')) {
+          return;
+        }
+      }
+
+      try {
+        tgChatSaveBtn.disabled = true;
+        tgChatSaveBtn.textContent = 'Сохраняем...';
+
+        const res = await fetch(buildApiUrl('/me/telegram'), {
+          method: 'POST',
+          headers: { 'Content-Type': 'application/json' },
+          body: JSON.stringify({ telegramChatId: chatId }),
+        });
+
+        if (!res.ok) {
+          const txt = await res.text();
+          throw new Error(txt || ('HTTP ' + res.status));
+        }
+
+        const updatedMe = await res.json();
+        currentMe = updatedMe;
+        updateHeaderFromMe(updatedMe);
+        alert('Telegram ID сохранён');
+      } catch (err) {
+        console.error(err);
+        alert('Не удалось сохранить Telegram ID: ' + (err.message || err));
+      } finally {
+        tgChatSaveBtn.disabled = false;
+        tgChatSaveBtn.textContent = 'Сохранить';
+      }
+    });
+  }
+
+  // --- список доступных тарифов ---
+
+  const plansCard = document.createElement('div');
+  plansCard.className = 'card';
+  plansCard.innerHTML = `
+    <div class="card-title">Доступные тарифы</div>
+    <div class="card-subtitle">
+      Тарифы загружаются из БД. Здесь можно сравнить ограничения и возможности.
+      Переключение тарифа пока демонстрационное — в реальной версии будет оплата и смена плана.
+    </div>
+    <div class="plans-grid" id="plans-grid" style="display:grid;grid-template-columns:repeat(auto-fit,minmax(220px,1fr));gap:12px;margin-top:12px;"></div>
+  `;
+
+  const grid = plansCard.querySelector('#plans-grid');
+
+  if (!plans.length) {
+    grid.innerHTML = `<p class="small">Тарифы пока не настроены. Добавьте записи в таблицу <code>plans</code> в БД.</p>`;
+  } else {
+    plans.forEach((p) => {
+      const isCurrent = currentPlan && currentPlan.id === p.id;
+
+      const price =
+        typeof p.price === 'number'
+          ? p.price.toLocaleString('ru-RU') + ' ₽/мес'
+          : String(p.price || '');
+
+      const leadsLimit =
+        typeof p.maxLeads === 'number' && p.maxLeads > 0 ? p.maxLeads : null;
+      const calcsLimit =
+        typeof p.maxCalcs === 'number' && p.maxCalcs > 0
+          ? p.maxCalcs
+          : (leadsLimit ? leadsLimit * 2 : null);
+
+      const card = document.createElement('div');
+      card.className = 'card';
+      card.style.border = isCurrent ? '1px solid #4f46e5' : '1px solid #e5e7eb';
+      card.style.boxShadow = isCurrent
+        ? '0 0 0 1px rgba(79,70,229,0.3)'
+        : 'none';
+
+      card.innerHTML = `
+        <div class="card-title" style="margin-bottom:4px;">
+          ${p.name || p.id}
+          ${
+            isCurrent
+              ? '<span class="badge" style="margin-left:6px;background:#eef2ff;color:#4f46e5;font-size:11px;padding:2px 6px;border-radius:999px;">Текущий</span>'
+              : ''
+          }
+        </div>
+        <div class="card-subtitle" style="min-height:40px;">
+          ${p.description || 'Без описания'}
+        </div>
+        <div class="field" style="margin-top:8px;">
+          <div class="small">Стоимость: <strong>${price || 'по договорённости'}</strong></div>
+          <div class="small">Калькуляторов: <strong>${p.maxCalculators}</strong></div>
+          <div class="small">Лимит заявок: <strong>${leadsLimit ? leadsLimit : '∞'}</strong></div>
+          <div class="small">Лимит расчётов: <strong>${calcsLimit ? calcsLimit : '∞'}</strong></div>
+        </div>
+        <div class="field" style="margin-top:8px;">
+          <button type="button" class="btn primary btn-choose-plan" data-plan-id="${p.id}" ${
+            isCurrent ? 'disabled' : ''
+          }>
+            ${isCurrent ? 'Текущий тариф' : 'Выбрать тариф'}
+          </button>
+        </div>
+      `;
+
+      grid.appendChild(card);
+    });
+  }
+
+  root.appendChild(plansCard);
+
+  contentEl.innerHTML = '';
+  contentEl.appendChild(root);
+
+  // обработка Telegram chat id
+  const tgIdInput = root.querySelector('#billing-tg-chat-id');
+  const tgSaveBtn = root.querySelector('#billing-tg-chat-save');
+  if (tgIdInput && tgSaveBtn && user) {
+    if (user.telegramChatId) {
+      tgIdInput.value = String(user.telegramChatId);
+    }
+
+    tgSaveBtn.addEventListener('click', async () => {
+      const val = tgIdInput.value.trim();
+      if (!val) {
+        if (!confirm('Очистить Telegram ID?')) return;
+      }
+
+      try {
+        tgSaveBtn.disabled = true;
+        tgSaveBtn.textContent = 'Сохранение...';
+
+        await fetch(buildApiUrl('/me/telegram'), {
+          method: 'POST',
+          headers: { 'Content-Type': 'application/json' },
+          body: JSON.stringify({ chatId: val }),
+        });
+
+        // локально обновим currentMe, если он есть
+        if (currentMe && currentMe.user) {
+          currentMe.user.telegramChatId = val || null;
+        }
+
+        alert('Telegram ID сохранён');
+      } catch (err) {
+        console.error(err);
+        alert('Не удалось сохранить Telegram ID');
+      } finally {
+        tgSaveBtn.disabled = false;
+        tgSaveBtn.textContent = 'Сохранить';
+      }
+    });
+  }
+
+  // пока делаем кнопки "демо" для смены тарифа
+  root.querySelectorAll('.btn-choose-plan').forEach((btn) => {
+    btn.addEventListener('click', async () => {
+      const planId = btn.getAttribute('data-plan-id');
+      if (!planId) return;
+
+      if (!me || !me.user) {
+        alert('Нет данных о текущем пользователе');
+        return;
+      }
+
+      if (!confirm('Переключить тариф на план: ' + planId + '?')) {
+        return;
+      }
+
+      const u = me.user;
+
+      try {
+        btn.disabled = true;
+        btn.textContent = 'Переключаем...';
+
+        const body = {
+          name: u.name || '',
+          email: u.email || '',
+          role: u.role || 'user',
+          planId: planId,
+          planActive: true,
+        };
+
+        const res = await fetch(
+          buildApiUrl('/admin/users/' + encodeURIComponent(u.id)),
+          {
+            method: 'PUT',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify(body),
+          }
+        );
+
+        if (!res.ok) {
+          const txt = await res.text();
+          throw new Error(txt || 'HTTP ' + res.status);
+        }
+
+        // обновляем /me и шапку
+        const updatedMe = await fetchJSON('/me');
+        currentMe = updatedMe;
+        updateHeaderFromMe(updatedMe);
+
+        // перерисовываем биллинг
+        await renderBilling();
+      } catch (err) {
+        console.error(err);
+        alert('Не удалось сменить тариф: ' + (err.message || err));
+      }
+    });
+  });
+}
+
+// --- Admin users ---
+
+async function renderAdminUsers() {
+  contentEl.innerHTML = `
+    <div class="card">
+      <div class="card-title">Загрузка пользователей...</div>
+    </div>
+  `;
+
+  let usersData = [];
+  let plans = [];
+
+  try {
+    const [usersRes, me] = await Promise.all([
+      fetch(buildApiUrl('/admin/users')),
+      fetchJSON('/me'),
+    ]);
+
+    if (usersRes.status === 403) {
+      contentEl.innerHTML = `
+        <div class="card">
+          <div class="card-title">Нет доступа</div>
+          <p class="card-subtitle">
+            Раздел "Пользователи" доступен только администратору.
+            Войдите под учётной записью администратора.
+          </p>
+        </div>
+      `;
+      return;
+    }
+
+    if (!usersRes.ok) {
+      contentEl.innerHTML = `
+        <div class="card">
+          <div class="card-title">Ошибка</div>
+          <p>Код ответа: ${usersRes.status}</p>
+        </div>
+      `;
+      return;
+    }
+
+    usersData = await usersRes.json();
+    plans = me.plans || [];
+  } catch (err) {
+    console.error(err);
+    contentEl.innerHTML = `
+      <div class="card">
+        <div class="card-title">Ошибка</div>
+        <p>Не удалось загрузить данные.</p>
+      </div>
+    `;
+    return;
+  }
+
+  const root = document.createElement('div');
+
+  const infoCard = document.createElement('div');
+  infoCard.className = 'card';
+  infoCard.innerHTML = `
+    <div class="card-title">Пользователи</div>
+    <div class="card-subtitle">
+      Администратор может управлять клиентами, их ролями и тарифами, а также сбрасывать пароли.
+    </div>
+  `;
+  root.appendChild(infoCard);
+
+  const planOptionsHtml = plans
+    .map((p) => `<option value="${p.id}">${p.name} (${p.id})</option>`)
+    .join('');
+
+  const createCard = document.createElement('div');
+  createCard.className = 'card';
+  createCard.innerHTML = `
+    <div class="card-title">Новый пользователь</div>
+    <div class="field">
+      <label class="field-label">Email</label>
+      <input type="email" id="u-new-email" />
+    </div>
+    <div class="field">
+      <label class="field-label">Имя</label>
+      <input type="text" id="u-new-name" />
+    </div>
+    <div class="field">
+      <label class="field-label">Роль</label>
+      <select id="u-new-role">
+        <option value="user">Пользователь</option>
+        <option value="admin">Администратор</option>
+      </select>
+    </div>
+    <div class="field">
+      <label class="field-label">Тариф</label>
+      <select id="u-new-plan">${planOptionsHtml}</select>
+    </div>
+    <div class="field">
+      <label class="field-label">
+        <input type="checkbox" id="u-new-plan-active" checked />
+        Тариф активен
+      </label>
+    </div>
+    <div class="field">
+      <label class="field-label">
+        <input type="checkbox" id="u-new-invite" checked />
+        Отправить приглашение на email (пользователь сам задаст пароль)
+      </label>
+    </div>
+    <div class="field" id="u-new-password-field" style="display:none;">
+      <label class="field-label">Пароль</label>
+      <input type="password" id="u-new-password" />
+    </div>
+    <button class="btn primary btn-sm" id="u-new-create" type="button">Создать</button>
+  `;
+  root.appendChild(createCard);
+
+  const inviteCheckbox = createCard.querySelector('#u-new-invite');
+  const newPasswordField = createCard.querySelector('#u-new-password-field');
+  inviteCheckbox.addEventListener('change', () => {
+    newPasswordField.style.display = inviteCheckbox.checked ? 'none' : '';
+  });
+
+  createCard.querySelector('#u-new-create').addEventListener('click', async () => {
+    const body = {
+      email: createCard.querySelector('#u-new-email').value.trim(),
+      name: createCard.querySelector('#u-new-name').value.trim(),
+      role: createCard.querySelector('#u-new-role').value,
+      planId: createCard.querySelector('#u-new-plan').value,
+      planActive: createCard.querySelector('#u-new-plan-active').checked,
+      sendInvite: inviteCheckbox.checked,
+      password: inviteCheckbox.checked ? '' : createCard.querySelector('#u-new-password').value,
+    };
+
+    try {
+      const res = await fetch(buildApiUrl('/admin/users'), {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify(body),
+      });
+      if (!res.ok) {
+        const txt = await res.text();
+        alert('Не удалось создать пользователя: ' + txt);
+        return;
+      }
+      const created = await res.json();
+      usersData.push(created);
+      renderTable();
+      createCard.querySelector('#u-new-email').value = '';
+      createCard.querySelector('#u-new-name').value = '';
+      createCard.querySelector('#u-new-password').value = '';
+      if (body.sendInvite) alert('Приглашение отправлено на ' + created.email);
+    } catch (err) {
+      console.error(err);
+      alert('Ошибка создания пользователя');
+    }
+  });
+
+  const tableCard = document.createElement('div');
+  tableCard.className = 'card';
+  tableCard.innerHTML = `
+    <div class="card-title">Список пользователей</div>
+    <div id="users-table-wrap"></div>
+  `;
+  root.appendChild(tableCard);
+
+  const editCard = document.createElement('div');
+  editCard.className = 'card';
+  editCard.id = 'user-edit-card';
+  editCard.style.display = 'none';
+  root.appendChild(editCard);
+
+  contentEl.innerHTML = '';
+  contentEl.appendChild(root);
+
+  let selectedUser = null;
+
+  function roleChip(u) {
+    const label = u.role === 'admin' ? 'Администратор' : 'Пользователь';
+    const extra = u.role === 'admin' ? ' chip--role-admin' : ' chip--role-user';
+    return `<span class="chip${extra}">${label}</span>`;
+  }
+
+  function planChip(u) {
+    if (!u.plan) return '<span class="chip chip--neutral">Нет тарифа</span>';
+    return `<span class="chip chip--plan">${u.plan.name} <span class="chip__tag">${u.plan.id}</span></span>`;
+  }
+
+  function planStatusChip(u) {
+    const locked = u.lockedUntil ? ' <span class="chip chip--warn">Вход заблокирован</span>' : '';
+    if (u.planActive) {
+      return '<span class="chip chip--ok">Активен</span>' + locked;
+    }
+    return '<span class="chip chip--warn">Не активен</span>' + locked;
+  }
+
+  function renderTable() {
+    const wrap = tableCard.querySelector('#users-table-wrap');
+    if (!wrap) return;
+
+    if (!usersData || usersData.length === 0) {
+      wrap.innerHTML = `<p class="small">Пользователей пока нет.</p>`;
+      return;
+    }
+
+    const rows = usersData
+      .slice()
+      .sort((a, b) => new Date(a.createdAt) - new Date(b.createdAt))
+      .map((u) => {
+        const created = u.createdAt ? new Date(u.createdAt).toLocaleString('ru-RU') : '—';
+        return `
+          <tr data-user-id="${u.id}">
+            <td>
+              <div class="user-cell">
+                <div class="user-avatar">${(u.name || u.email || '?').trim()[0].toUpperCase()}</div>
+                <div class="user-meta">
+                  <div class="user-name">${u.name || '—'}</div>
+                  <div class="user-email">${u.email}</div>
+                </div>
+              </div>
+            </td>
+            <td>${roleChip(u)}</td>
+            <td>${planChip(u)}</td>
+            <td>${planStatusChip(u)}</td>
+            <td>${created}</td>
+            <td class="user-actions">
+              <button class="icon-btn icon-btn-edit btn-edit-user" type="button" title="Редактировать">
+                ✏
+              </button>
+              <button class="icon-btn icon-btn-pass btn-pass-user" type="button" title="Сменить пароль">
+                🔑
+              </button>
+              ${u.lockedUntil ? '<button class="icon-btn btn-unlock-user" type="button" title="Снять блокировку входа">🔓</button>' : ''}
+              <button class="icon-btn icon-btn-delete btn-delete-user" type="button" title="Удалить">
+                🗑
+              </button>
+            </td>
+          </tr>
+        `;
+      })
+      .join('');
+
+    wrap.innerHTML = `
+      <table class="users-table">
+        <thead>
+          <tr>
+            <th>Пользователь</th>
+            <th>Роль</th>
+            <th>Тариф</th>
+            <th>Статус тарифа</th>
+            <th>Создан</th>
+            <th style="width:120px;">Действия</th>
+          </tr>
+        </thead>
+        <tbody>
+          ${rows}
+        </tbody>
+      </table>
+    `;
+
+    wrap.querySelectorAll('.btn-edit-user').forEach((btn) => {
+      btn.addEventListener('click', () => {
+        const tr = btn.closest('tr');
+        const id = tr?.dataset.userId;
+        if (!id) return;
+        const u = usersData.find((x) => x.id === id);
+        if (!u) return;
+        selectedUser = u;
+        renderEditForm();
+      });
+    });
+
+    wrap.querySelectorAll('.btn-unlock-user').forEach((btn) => {
+      btn.addEventListener('click', async () => {
+        const id = btn.closest('tr')?.dataset.userId;
+        if (!id) return;
+        try {
+          const res = await fetch(buildApiUrl('/admin/users/' + encodeURIComponent(id) + '/unlock'), {
+            method: 'POST',
+          });
+          if (!res.ok) {
+            alert('Не удалось снять блокировку: ' + (await res.text()));
+            return;
+          }
+          usersData = usersData.map((u) => (u.id === id ? { ...u, lockedUntil: null } : u));
+          renderTable();
+        } catch (err) {
+          console.error(err);
+          alert('Ошибка снятия блокировки');
+        }
+      });
+    });
+
+    wrap.querySelectorAll('.btn-delete-user').forEach((btn) => {
+      btn.addEventListener('click', async () => {
+        const tr = btn.closest('tr');
+        const id = tr?.dataset.userId;
+        if (!id) return;
+
+        if (!confirm('Удалить пользователя ' + id + '?')) return;
+
+        try {
+          const res = await fetch(buildApiUrl('/admin/users/' + encodeURIComponent(id)), {
+            method: 'DELETE',
+          });
+          if (!res.ok && res.status !== 204) {
+            const text = await res.text();
+            alert('Не удалось удалить пользователя: ' + text);
+            return;
+          }
+          usersData = usersData.filter((u) => u.id !== id);
+          if (selectedUser && selectedUser.id === id) {
+            selectedUser = null;
+            editCard.style.display = 'none';
+          }
+          renderTable();
+        } catch (err) {
+          console.error(err);
+          alert('Ошибка удаления пользователя');
+        }
+      });
+    });
+
+    wrap.querySelectorAll('.btn-pass-user').forEach((btn) => {
+      btn.addEventListener('click', () => {
+        const tr = btn.closest('tr');
+        const id = tr?.dataset.userId;
+        if (!id) return;
+        const u = usersData.find((x) => x.id === id);
+        if (!u) return;
+        selectedUser = u;
+        renderEditForm(true);
+      });
+    });
+  }
+
+  function renderEditForm(focusPassword = false) {
+    if (!selectedUser) {
+      editCard.style.display = 'none';
+      editCard.innerHTML = '';
+      return;
+    }
+
+    const u = selectedUser;
+    const created = u.createdAt ? new Date(u.createdAt).toLocaleString('ru-RU') : '—';
+    const planId = u.plan ? u.plan.id : (u.planId || '');
+
+    const planOptions = plans
+      .map(
+        (p) =>
+          `<option value="${p.id}" ${p.id === planId ? 'selected' : ''}>${p.name} (${p.id})</option>`
+      )
+      .join('');
+    const roleOptions = `
+      <option value="user" ${u.role === 'user' ? 'selected' : ''}>Пользователь</option>
+      <option value="admin" ${u.role === 'admin' ? 'selected' : ''}>Администратор</option>
+    `;
+
+    editCard.style.display = '';
+    editCard.innerHTML = `
+      <div class="card-title">Редактирование пользователя</div>
+      <div class="card-subtitle small">
+        ID: <strong>${u.id}</strong>, создан: ${created}
+      </div>
+
+      <div class="field">
+        <label class="field-label">Имя</label>
+        <input type="text" id="u-edit-name" value="${u.name || ''}" />
+      </div>
+      <div class="field">
+        <label class="field-label">E-mail</label>
+        <input type="email" id="u-edit-email" value="${u.email || ''}" />
+      </div>
+      <div class="field">
+        <label class="field-label">Роль</label>
+        <select id="u-edit-role">
+          ${roleOptions}
+        </select>
+      </div>
+      <div class="field">
+        <label class="field-label">Тариф</label>
+        <select id="u-edit-plan">
+          <option value="">— без тарифа —</option>
+          ${planOptions}
+        </select>
+      </div>
+      <div class="field">
+        <label class="field-label">
+          <input type="checkbox" id="u-edit-plan-active" ${u.planActive ? 'checked' : ''} />
+          Тариф активен
+        </label>
+      </div>
+
+      <hr class="divider" />
+
+      <div class="field">
+        <label class="field-label">Смена пароля</label>
+        <div class="password-row">
+          <input type="password" id="u-edit-password" placeholder="Новый пароль" />
+          <button class="btn secondary btn-sm" id="u-edit-password-btn" type="button">Сменить</button>
+        </div>
+        <p class="small">Пароль не отображается в списке, только устанавливается.</p>
+      </div>
+
+      <div class="field" style="display:flex; flex-wrap:wrap; gap:8px;">
+        <button class="btn primary btn-sm" id="u-edit-save" type="button">Сохранить изменения</button>
+        ${u.role !== 'admin' ? '<button class="btn secondary btn-sm" id="u-edit-impersonate" type="button">Войти под пользователем</button>' : ''}
+        <button class="btn secondary btn-sm" id="u-edit-cancel" type="button">Закрыть</button>
+      </div>
+    `;
+
+    const nameInput = editCard.querySelector('#u-edit-name');
+    const emailInput = editCard.querySelector('#u-edit-email');
+    const roleSelect = editCard.querySelector('#u-edit-role');
+    const planSelect = editCard.querySelector('#u-edit-plan');
+    const planActiveCheckbox = editCard.querySelector('#u-edit-plan-active');
+    const passInput = editCard.querySelector('#u-edit-password');
+
+    const saveBtn = editCard.querySelector('#u-edit-save');
+    const passBtn = editCard.querySelector('#u-edit-password-btn');
+    const cancelBtn = editCard.querySelector('#u-edit-cancel');
+    const impersonateBtn = editCard.querySelector('#u-edit-impersonate');
+
+    if (impersonateBtn) {
+      impersonateBtn.addEventListener('click', async () => {
+        if (!confirm('Войти под пользователем ' + (u.email || u.id) + '? Все изменения попадут в журнал аудита.')) return;
+        try {
+          const res = await fetch(
+            buildApiUrl('/admin/users/' + encodeURIComponent(u.id) + '/impersonate'),
+            { method: 'POST' }
+          );
+          if (!res.ok) {
+            const txt = await res.text();
+            alert('Не удалось войти под пользователем: ' + txt);
+            return;
+          }
+          window.location.reload();
+        } catch (err) {
+          console.error(err);
+          alert('Не удалось войти под пользователем');
+        }
+      });
+    }
+
+    cancelBtn.addEventListener('click', () => {
+      selectedUser = null;
+      editCard.style.display = 'none';
+      editCard.innerHTML = '';
+    });
+
+    saveBtn.addEventListener('click', async () => {
+      try {
+        const body = {
+          name: nameInput.value.trim(),
+          email: emailInput.value.trim(),
+          role: roleSelect.value,
+          planId: planSelect.value || '',
+          planActive: planActiveCheckbox.checked,
+        };
+
+        const res = await fetch(
+          buildApiUrl('/admin/users/' + encodeURIComponent(u.id)),
+          {
+            method: 'PUT',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify(body),
+          }
+        );
+
+        if (!res.ok) {
+          const txt = await res.text();
+          alert('Ошибка сохранения: ' + txt);
+          return;
+        }
+
+        const updated = await res.json();
+        usersData = usersData.map((item) => (item.id === updated.id ? updated : item));
+        selectedUser = updated;
+        renderTable();
+        renderEditForm();
+      } catch (err) {
+        console.error(err);
+        alert('Ошибка сохранения пользователя');
+      }
+    });
+
+    passBtn.addEventListener('click', async () => {
+      const newPass = passInput.value;
+      if (!newPass) {
+        alert('Введите новый пароль');
+        return;
+      }
+      if (!confirm('Сменить пароль пользователю ' + u.id + '?')) return;
+
+      try {
+        const res = await fetch(
+          buildApiUrl('/admin/users/' + encodeURIComponent(u.id) + '/password'),
+          {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ password: newPass }),
+          }
+        );
+
+        if (!res.ok) {
+          const txt = await res.text();
+          alert('Ошибка смены пароля: ' + txt);
+          return;
+        }
+
+        passInput.value = '';
+        alert('Пароль обновлён');
+      } catch (err) {
+        console.error(err);
+        alert('Ошибка смены пароля');
+      }
+    });
+
+    if (focusPassword && passInput) {
+      passInput.focus();
+    }
+  }
+
+  renderTable();
+}
+
+// --- Calculators list + create ---
+
+async function renderCalculators() {
+  contentEl.innerHTML = `
+    <div class="card">
+      <div class="card-title">Загрузка калькуляторов...</div>
+    </div>
+  `;
+
+  let data, me;
+  let planActive = true;
+
+  try {
+    [data, me] = await Promise.all([
+      fetchJSON('/calculators'),
+      fetchJSON('/me'),
+    ]);
+  } catch (err) {
+    console.error(err);
+    contentEl.innerHTML = `
+      <div class="card">
+        <div class="card-title">Ошибка</div>
+        <p>Не удалось загрузить список калькуляторов или данные пользователя.</p>
+      </div>
+    `;
+    return;
+  }
+
+  let items = (data && data.items) || [];
+  let total = (data && data.total) || items.length;
+  let nextCursor = (data && data.nextCursor) || '';
+  // папки организаций, в которых лежат показанные калькуляторы: orgId -> [{id, name}]
+  const foldersByOrg = {};
+
+  const meUser = me && me.user ? me.user : null;
+  planActive = !meUser || meUser.planActive !== false;
+
+  const root = document.createElement('div');
+
+  if (!planActive) {
+    const banner = document.createElement('div');
+    banner.className = 'card';
+    banner.innerHTML = `
+      <div class="card-title">Тариф не активен</div>
+      <div class="card-subtitle">
+        Срок действия вашего тарифа завершился. 
+        Калькуляторы временно недоступны для редактирования.
+      </div>
+      <p class="small" style="margin-top:8px;">
+        Активируйте тариф, чтобы снова создавать и настраивать калькуляторы.
+      </p>
+      <div style="margin-top:12px;">
+        <button class="btn primary" id="goto-billing-from-calcs" type="button">
+          Активировать тариф
+        </button>
+      </div>
+    `;
+    root.appendChild(banner);
+
+    const gotoBtn = banner.querySelector('#goto-billing-from-calcs');
+    gotoBtn.addEventListener('click', () => {
+      currentSection = 'billing';
+      setActiveNav('billing');
+      loadSection('billing');
+    });
+  }
+
+  // входящие передачи калькуляторов от других пользователей
+  const transfersCard = document.createElement('div');
+  transfersCard.className = 'card';
+  transfersCard.style.display = 'none';
+  root.appendChild(transfersCard);
+
+  const headerCard = document.createElement('div');
+  headerCard.className = 'card';
+  headerCard.innerHTML = `
+    <div style="display:flex; justify-content:space-between; align-items:center; gap:8px;">
+      <div>
+        <div class="card-title">Ваши калькуляторы</div>
+        <div class="card-subtitle">
+          Управляйте существующими калькуляторами и создавайте новые.
+          ${
+            !planActive
+              ? '<br><span class="small" style="color:#f97316;">Создание и редактирование недоступны до активации тарифа.</span>'
+              : ''
+          }
+        </div>
+      </div>
+      <div style="display:flex; gap:8px;">
+        <button class="btn secondary btn-large" id="btn-open-trash" type="button">
+          Корзина
+        </button>
+        <button class="btn secondary btn-large" id="btn-import-calc" type="button" ${
+          !planActive ? 'disabled' : ''
+        }>
+          Импорт
+        </button>
+        <input type="file" id="calc-import-file" accept=".zip,application/zip" style="display:none;" />
+        <button class="btn primary btn-large" id="btn-open-create-calc" type="button" ${
+          !planActive ? 'disabled' : ''
+        }>
+          + Создать калькулятор
+        </button>
+      </div>
+    </div>
+  `;
+  root.appendChild(headerCard);
+
+  const listCard = document.createElement('div');
+  listCard.className = 'card';
+  listCard.innerHTML = `
+    <div class="card-title">Список калькуляторов</div>
+    <div class="calc-filters" style="display:flex; flex-wrap:wrap; gap:8px; margin-bottom:12px;">
+      <input type="search" id="calc-filter-q" placeholder="Поиск по названию, ID или адресу" style="flex:1; min-width:200px;" />
+      <select id="calc-filter-type">
+        <option value="">Все типы</option>
+        ${Object.keys(CALC_TYPE_LABELS)
+          .map((t) => `<option value="${t}">${CALC_TYPE_LABELS[t]}</option>`)
+          .join('')}
+      </select>
+      <select id="calc-filter-status">
+        <option value="">Все статусы</option>
+        <option value="draft">Черновики</option>
+        <option value="published">Опубликованные</option>
+        <option value="archived">В архиве</option>
+      </select>
+      <input type="text" id="calc-filter-tag" placeholder="Метка" style="width:120px;" />
+      <select id="calc-filter-folder">
+        <option value="">Все папки</option>
+        <option value="none">Без папки</option>
+      </select>
+      <select id="calc-filter-sort">
+        <option value="created_desc">Сначала новые</option>
+        <option value="created_asc">Сначала старые</option>
+        <option value="name_asc">По названию</option>
+        <option value="calcs_desc">По числу расчётов</option>
+      </select>
+    </div>
+    <p class="small" id="calc-total"></p>
+    <div id="calc-list" class="calc-list"></div>
+    <div style="margin-top:8px;">
+      <button class="btn secondary" id="calc-more" type="button" style="display:none;">Показать ещё</button>
+    </div>
+    <div id="calc-create-panel" class="calc-create-panel" style="display:none;"></div>
+  `;
+  root.appendChild(listCard);
+
+  contentEl.innerHTML = '';
+  contentEl.appendChild(root);
+
+  const listEl = listCard.querySelector('#calc-list');
+  const createPanelEl = listCard.querySelector('#calc-create-panel');
+  const openCreateBtn = headerCard.querySelector('#btn-open-create-calc');
+  const totalEl = listCard.querySelector('#calc-total');
+  const moreBtn = listCard.querySelector('#calc-more');
+  const filterEls = {
+    q: listCard.querySelector('#calc-filter-q'),
+    type: listCard.querySelector('#calc-filter-type'),
+    status: listCard.querySelector('#calc-filter-status'),
+    tag: listCard.querySelector('#calc-filter-tag'),
+    folderId: listCard.querySelector('#calc-filter-folder'),
+    sort: listCard.querySelector('#calc-filter-sort'),
+  };
+
+  // фильтры, сортировка и страницы считаются на сервере: GET /api/calculators?...&cursor=
+  function calculatorsQuery(cursor) {
+    const params = new URLSearchParams();
+    Object.keys(filterEls).forEach((key) => {
+      const value = filterEls[key].value.trim();
+      if (value) params.set(key, value);
+    });
+    if (cursor) params.set('cursor', cursor);
+    return '/calculators?' + params.toString();
+  }
+
+  async function loadFolders() {
+    const orgIds = Array.from(new Set(items.map((c) => c.orgId).filter(Boolean)));
+    await Promise.all(
+      orgIds
+        .filter((orgId) => !foldersByOrg[orgId])
+        .map(async (orgId) => {
+          try {
+            const data = await fetchJSON('/orgs/' + encodeURIComponent(orgId) + '/folders');
+            foldersByOrg[orgId] = (data && data.items) || [];
+          } catch (err) {
+            console.error(err);
+            foldersByOrg[orgId] = [];
+          }
+        })
+    );
+    const selected = filterEls.folderId.value;
+    filterEls.folderId.innerHTML =
+      '<option value="">Все папки</option><option value="none">Без папки</option>' +
+      Object.keys(foldersByOrg)
+        .reduce((all, orgId) => all.concat(foldersByOrg[orgId]), [])
+        .map((f) => `<option value="${f.id}">${f.name}</option>`)
+        .join('');
+    filterEls.folderId.value = selected;
+  }
+
+  function folderName(c) {
+    const folder = (foldersByOrg[c.orgId] || []).find((f) => f.id === c.folderId);
+    return folder ? folder.name : '';
+  }
+
+  async function loadPage(more) {
+    try {
+      moreBtn.disabled = true;
+      const page = await fetchJSON(calculatorsQuery(more ? nextCursor : ''));
+      const pageItems = (page && page.items) || [];
+      items = more ? items.concat(pageItems) : pageItems;
+      total = (page && page.total) || 0;
+      nextCursor = (page && page.nextCursor) || '';
+      await loadFolders();
+      renderList();
+    } catch (err) {
+      console.error(err);
+      alert('Не удалось загрузить калькуляторы');
+    } finally {
+      moreBtn.disabled = false;
+    }
+  }
+
+  let filterTimer = null;
+  Object.keys(filterEls).forEach((key) => {
+    const el = filterEls[key];
+    el.addEventListener(el.tagName === 'SELECT' ? 'change' : 'input', () => {
+      clearTimeout(filterTimer);
+      filterTimer = setTimeout(() => loadPage(false), 300);
+    });
+  });
+  moreBtn.addEventListener('click', () => loadPage(true));
+
+  function renderList() {
+    listEl.innerHTML = '';
+    totalEl.textContent = items.length ? 'Показано ' + items.length + ' из ' + total : '';
+    moreBtn.style.display = nextCursor ? '' : 'none';
+    const filtered = Object.keys(filterEls).some((key) => key !== 'sort' && filterEls[key].value.trim());
+    if (!items.length && filtered) {
+      listEl.innerHTML = '<p class="small">Ничего не найдено.</p>';
+      return;
+    }
     if (!items.length) {
       listEl.innerHTML =
         '<p class="small">У вас пока нет калькуляторов. ' +
@@ -1287,725 +2622,2395 @@ async function renderCalculators() {
       return;
     }
 
-    items
-      .slice()
-      .sort((a, b) => new Date(b.createdAt) - new Date(a.createdAt))
-      .forEach((c) => {
-        const row = document.createElement('div');
-        row.className = 'calc-item';
+    items
+      .forEach((c) => {
+        const row = document.createElement('div');
+        row.className = 'calc-item';
+
+        const typeLabel = CALC_TYPE_LABELS[c.type] || c.type;
+        const statusLabel = calcStatusBadge(c.status);
+
+        const created = c.createdAt
+          ? new Date(c.createdAt).toLocaleString('ru-RU')
+          : '—';
+
+        const calcCount =
+          typeof c.calcCount === 'number' ? c.calcCount : 0;
+
+        const publicPath =
+          c.publicPath ||
+          (c.publicToken && c.ownerId
+            ? `/p/${c.ownerId}/${c.publicToken}`
+            : '');
+        const publicUrl = publicPath
+          ? window.location.origin + publicPath
+          : '';
+        const previewUrl = calcPreviewUrl(c);
+        const statusActions = (CALC_STATUS_ACTIONS[c.status] || []).slice();
+        if (c.status === 'published' && c.unpublishedChanges) {
+          statusActions.unshift({ publish: true, label: 'Опубликовать изменения' });
+        }
+
+        row.innerHTML = `
+          <div class="calc-item-main">
+            <div>
+              <span class="calc-type-badge">${typeLabel}</span>
+              <strong>${c.name}</strong>
+            </div>
+            <div class="calc-item-meta">
+              ${statusLabel}
+              <span style="margin-left:8px;">ID: ${c.id}</span>
+              <span style="margin-left:8px;">Создан: ${created}</span>
+              <span style="margin-left:8px;">Расчётов: ${calcCount}</span>
+              ${folderName(c) ? `<span style="margin-left:8px;">Папка: ${folderName(c)}</span>` : ''}
+            </div>
+            ${
+              (c.tags || []).length
+                ? `<div class="calc-item-tags" style="margin-top:4px;">${c.tags
+                    .map((t) => `<span class="chip">${t}</span>`)
+                    .join(' ')}</div>`
+                : ''
+            }
+            ${
+              publicUrl
+                ? `
+              <div class="calc-item-link" style="margin-top:6px;">
+                <span class="small" style="display:block;margin-bottom:4px;">Публичная ссылка:</span>
+                <div style="display:flex; gap:6px; align-items:center;">
+                  <input type="text" class="calc-link-input" value="${publicUrl}" readonly
+                         style="flex:1; font-size:12px; padding:4px 6px;" />
+                  <button type="button" class="btn secondary btn-copy-link" style="white-space:nowrap;">
+                    Копировать
+                  </button>
+                </div>
+                ${
+                  previewUrl
+                    ? `<a class="small" href="${previewUrl}" target="_blank" rel="noopener">Предпросмотр черновика</a>
+                       <span class="small" style="color:#6b7280;">${
+                         c.status !== 'published'
+                           ? '— посетители видят «калькулятор недоступен»'
+                           : c.unpublishedChanges
+                           ? '— есть неопубликованные правки'
+                           : ''
+                       }</span>`
+                    : ''
+                }
+              </div>
+            `
+                : ''
+            }
+          </div>
+          <div class="calc-item-actions" style="display:flex; flex-direction:column; gap:4px;">
+            <button class="btn secondary btn-open" type="button"${
+              !planActive ? ' disabled' : ''
+            }>Открыть</button>
+            ${statusActions
+              .map(
+                (a) =>
+                  a.publish
+                    ? `<button class="btn primary btn-publish" type="button">${a.label}</button>`
+                    : `<button class="btn secondary btn-status" type="button" data-status="${a.status}">${a.label}</button>`
+              )
+              .join('')}
+            <button class="btn secondary btn-rename" type="button">
+              Переименовать
+            </button>
+            <button class="btn secondary btn-tags" type="button">
+              Метки и папка
+            </button>
+            <button class="btn secondary btn-links" type="button">
+              Ссылки
+            </button>
+            <button class="btn secondary btn-transfer" type="button">
+              Передать
+            </button>
+            <button class="btn secondary btn-clone" type="button"${
+              !planActive ? ' disabled' : ''
+            }>Дублировать</button>
+            <a class="btn secondary" href="${buildApiUrl('/calculators/' + encodeURIComponent(c.id) + '/export')}" download>
+              Экспорт
+            </a>
+            <button class="btn secondary btn-delete" type="button">
+              Удалить
+            </button>
+          </div>
+        `;
+
+        if (!planActive) {
+          row.style.opacity = '0.5';
+        }
+
+        const openBtn = row.querySelector('.btn-open');
+        const deleteBtn = row.querySelector('.btn-delete');
+
+        openBtn.addEventListener('click', () => {
+          if (!planActive) {
+            currentSection = 'billing';
+            setActiveNav('billing');
+            loadSection('billing');
+            return;
+          }
+
+          if (c.type === 'layered') {
+            currentLayeredCalculator = c;
+            currentSection = 'layers';
+            setActiveNav('layers');
+            loadSection('layers');
+          } else if (c.type === 'distance') {
+            currentDistanceCalculator = c;
+            currentSection = 'distance';
+            setActiveNav('distance');
+            loadSection('distance');
+          } else if (c.type === 'on_site') {
+            currentOnSiteCalculator = c;
+            currentSection = 'onsite';
+            setActiveNav('onsite');
+            loadSection('onsite');
+          } else if (c.type === 'formula') {
+            currentFormulaCalculator = c;
+            currentSection = 'formula';
+            setActiveNav('formula');
+            loadSection('formula');
+          } else if (c.type === 'form') {
+            currentFormCalculator = c;
+            currentSection = 'form';
+            setActiveNav('form');
+            loadSection('form');
+          } else {
+            alert(
+              'Редактор для типа "' +
+                (CALC_TYPE_LABELS[c.type] || c.type) +
+                '" пока в разработке.'
+            );
+          }
+        });
+
+        // смена статуса и переименование: PUT /api/calculators/{id}
+        async function updateCalc(body) {
+          const updated = await putJSON('/calculators/' + encodeURIComponent(c.id), body);
+          const idx = items.findIndex((x) => x.id === c.id);
+          if (idx !== -1) {
+            items[idx] = updated;
+          }
+          renderList();
+        }
+
+        row.querySelectorAll('.btn-status').forEach((btn) => {
+          btn.addEventListener('click', async () => {
+            try {
+              btn.disabled = true;
+              await updateCalc({ status: btn.dataset.status });
+            } catch (err) {
+              console.error(err);
+              alert(
+                err.status === 403
+                  ? 'Опубликовать калькулятор можно после подтверждения email владельца'
+                  : 'Не удалось изменить статус: ' + err.message
+              );
+              btn.disabled = false;
+            }
+          });
+        });
+
+        const publishBtn = row.querySelector('.btn-publish');
+        if (publishBtn) {
+          publishBtn.addEventListener('click', async () => {
+            try {
+              publishBtn.disabled = true;
+              const updated = await postJSON('/calculators/' + encodeURIComponent(c.id) + '/publish', {});
+              const idx = items.findIndex((x) => x.id === c.id);
+              if (idx !== -1) items[idx] = updated;
+              renderList();
+            } catch (err) {
+              console.error(err);
+              alert('Не удалось опубликовать: ' + err.message);
+              publishBtn.disabled = false;
+            }
+          });
+        }
+
+        // метки через запятую и папка по имени (новая папка создаётся)
+        row.querySelector('.btn-tags').addEventListener('click', async () => {
+          const tagsInput = prompt('Метки через запятую', (c.tags || []).join(', '));
+          if (tagsInput === null) return;
+          const folderInput = prompt('Папка (пусто — без папки)', folderName(c));
+          if (folderInput === null) return;
+          try {
+            const body = {
+              tags: tagsInput.split(',').map((t) => t.trim()).filter(Boolean),
+              folderId: '',
+            };
+            const name = folderInput.trim();
+            if (name) {
+              const folders = foldersByOrg[c.orgId] || [];
+              let folder = folders.find((f) => f.name.toLowerCase() === name.toLowerCase());
+              if (!folder) {
+                folder = await postJSON('/orgs/' + encodeURIComponent(c.orgId) + '/folders', { name });
+                foldersByOrg[c.orgId] = folders.concat([folder]);
+              }
+              body.folderId = folder.id;
+            }
+            await updateCalc(body);
+            await loadFolders();
+            renderList();
+          } catch (err) {
+            console.error(err);
+            alert('Не удалось сохранить метки: ' + err.message);
+          }
+        });
+
+        row.querySelector('.btn-links').addEventListener('click', () => {
+          showPublicLinksModal(c, (updated) => {
+            const idx = items.findIndex((x) => x.id === c.id);
+            if (idx !== -1) {
+              items[idx] = updated;
+            }
+            renderList();
+          });
+        });
+
+        // передача другому пользователю: owner предлагает, получатель принимает; админ переносит сразу
+        row.querySelector('.btn-transfer').addEventListener('click', async () => {
+          const transferPath = '/calculators/' + encodeURIComponent(c.id) + '/transfer';
+          try {
+            const pending = await fetchJSON(transferPath).catch((err) => {
+              if (err.status === 404) return null;
+              throw err;
+            });
+            if (pending) {
+              if (!confirm('Калькулятор уже предложен ' + pending.toEmail + '. Отозвать предложение?')) return;
+              const res = await fetch(buildApiUrl(transferPath), { method: 'DELETE' });
+              if (!res.ok) throw new Error((await res.text()) || 'HTTP ' + res.status);
+              return;
+            }
+
+            const email = prompt('Email пользователя, которому передать калькулятор');
+            if (!email || !email.trim()) return;
+            const body = { email: email.trim() };
+            const isAdmin = meUser && meUser.role === 'admin';
+            if (isAdmin) {
+              const orgId = prompt('ID организации получателя (пусто — личная)', '');
+              if (orgId === null) return;
+              body.orgId = orgId.trim();
+            }
+
+            const result = await postJSON(transferPath, body);
+            if (isAdmin) {
+              const idx = items.findIndex((x) => x.id === c.id);
+              if (idx !== -1) items[idx] = result;
+              renderList();
+              alert('Калькулятор передан. Старая ссылка переадресует на новую: ' + result.publicPath);
+            } else {
+              alert('Предложение отправлено ' + result.toEmail + '. Калькулятор перейдёт, когда получатель его примет.');
+            }
+          } catch (err) {
+            console.error(err);
+            alert(
+              err.status === 403
+                ? 'Передать калькулятор может только владелец организации'
+                : 'Не удалось передать калькулятор: ' + err.message
+            );
+          }
+        });
+
+        row.querySelector('.btn-rename').addEventListener('click', async () => {
+          const name = prompt('Новое название калькулятора', c.name);
+          if (name === null || !name.trim() || name.trim() === c.name) return;
+          try {
+            await updateCalc({ name: name.trim() });
+          } catch (err) {
+            console.error(err);
+            alert('Не удалось переименовать калькулятор: ' + err.message);
+          }
+        });
+
+        // копия с тем же конфигом и картинками, новым публичным адресом и нулевым счётчиком
+        const cloneBtn = row.querySelector('.btn-clone');
+        cloneBtn.addEventListener('click', async () => {
+          try {
+            cloneBtn.disabled = true;
+            const created = await postJSON('/calculators/' + encodeURIComponent(c.id) + '/clone', {});
+            items.unshift(created);
+            renderList();
+          } catch (err) {
+            console.error(err);
+            if (String(err.message).toLowerCase().includes('лимит')) {
+              showPlanLimitPopup(err.message);
+            } else {
+              alert(err.message || 'Не удалось скопировать калькулятор');
+            }
+            cloneBtn.disabled = false;
+          }
+        });
+
+        deleteBtn.addEventListener('click', async () => {
+          if (!confirm(`Переместить калькулятор "${c.name}" в корзину?`)) return;
+
+          try {
+            deleteBtn.disabled = true;
+            deleteBtn.textContent = 'Удаление...';
+
+            const res = await fetch(
+              buildApiUrl('/calculators?id=' + encodeURIComponent(c.id)),
+              { method: 'DELETE' }
+            );
+
+            if (!res.ok) {
+              let msg = 'Не удалось удалить калькулятор';
+              try {
+                const txt = await res.text();
+                if (txt) msg += ': ' + txt;
+              } catch (_) {}
+              alert(msg);
+              return;
+            }
+
+            const idx = items.findIndex((x) => x.id === c.id);
+            if (idx !== -1) {
+              items.splice(idx, 1);
+            }
+            renderList();
+          } catch (err) {
+            console.error(err);
+            alert('Ошибка удаления калькулятора');
+          } finally {
+            deleteBtn.disabled = false;
+            deleteBtn.textContent = 'Удалить';
+          }
+        });
+
+        const copyBtn = row.querySelector('.btn-copy-link');
+        const linkInput = row.querySelector('.calc-link-input');
+        if (copyBtn && linkInput) {
+          copyBtn.addEventListener('click', () => {
+            linkInput.select();
+            try {
+              document.execCommand('copy');
+              copyBtn.textContent = 'Скопировано';
+              setTimeout(() => {
+                copyBtn.textContent = 'Копировать';
+              }, 1500);
+            } catch (e) {
+              console.error(e);
+            }
+          });
+        }
+
+        listEl.appendChild(row);
+      });
+  }
+
+  renderList();
+  // названия папок подгружаются после первого показа списка
+  loadFolders().then(renderList);
+
+  // принять калькулятор можно в организацию, где пользователь editor или owner
+  async function renderIncomingTransfers() {
+    let transfers = [];
+    let orgs = [];
+    try {
+      const [tData, oData] = await Promise.all([
+        fetchJSON('/calculators/transfers'),
+        fetchJSON('/orgs'),
+      ]);
+      transfers = (tData && tData.items) || [];
+      orgs = ((oData && oData.items) || []).filter((o) => o.role === 'owner' || o.role === 'editor');
+    } catch (err) {
+      console.error(err);
+    }
+
+    if (!transfers.length) {
+      transfersCard.style.display = 'none';
+      transfersCard.innerHTML = '';
+      return;
+    }
+
+    const orgOptions = orgs
+      .map((o) => `<option value="${o.id}">${o.personalFor ? 'Личная: ' : ''}${o.name}</option>`)
+      .join('');
+
+    transfersCard.style.display = '';
+    transfersCard.innerHTML = `
+      <div class="card-title">Вам передают калькуляторы</div>
+      <div class="card-subtitle">Калькулятор перейдёт к вам вместе с конфигом и заявками.</div>
+      ${transfers
+        .map(
+          (t) => `
+        <div class="calc-item" data-transfer="${t.id}" style="display:flex; justify-content:space-between; align-items:center; gap:8px;">
+          <div>
+            <div class="calc-item-title">${t.calculatorName}</div>
+            <div class="small">От ${t.fromEmail}, до ${new Date(t.expiresAt).toLocaleDateString()}</div>
+          </div>
+          <div style="display:flex; gap:8px; align-items:center;">
+            <select class="transfer-org">${orgOptions}</select>
+            <button class="btn primary btn-accept-transfer" type="button">Принять</button>
+            <button class="btn secondary btn-decline-transfer" type="button">Отклонить</button>
+          </div>
+        </div>`
+        )
+        .join('')}
+    `;
+
+    transfersCard.querySelectorAll('[data-transfer]').forEach((row) => {
+      const transferID = row.dataset.transfer;
+      const base = '/calculators/transfers/' + encodeURIComponent(transferID);
+
+      row.querySelector('.btn-accept-transfer').addEventListener('click', async () => {
+        try {
+          const orgSelect = row.querySelector('.transfer-org');
+          const calc = await postJSON(base + '/accept', { orgId: orgSelect.value });
+          items.unshift(calc);
+          renderList();
+          renderIncomingTransfers();
+        } catch (err) {
+          console.error(err);
+          alert('Не удалось принять калькулятор: ' + err.message);
+        }
+      });
+
+      row.querySelector('.btn-decline-transfer').addEventListener('click', async () => {
+        if (!confirm('Отказаться от калькулятора?')) return;
+        try {
+          const res = await fetch(buildApiUrl(base + '/decline'), { method: 'POST' });
+          if (!res.ok) throw new Error((await res.text()) || 'HTTP ' + res.status);
+          renderIncomingTransfers();
+        } catch (err) {
+          console.error(err);
+          alert('Не удалось отклонить передачу: ' + err.message);
+        }
+      });
+    });
+  }
+
+  renderIncomingTransfers();
+
+  let createPanelVisible = false;
+  let selectedType = 'layered';
+
+  function openCreatePanel() {
+    if (!planActive) {
+      currentSection = 'billing';
+      setActiveNav('billing');
+      loadSection('billing');
+      return;
+    }
+
+    createPanelVisible = true;
+    createPanelEl.style.display = '';
+    createPanelEl.innerHTML = `
+      <div class="field">
+        <label class="field-label">Название калькулятора</label>
+        <input type="text" id="calc-create-name" placeholder="Например, «Прицеп – послойный калькулятор»" />
+      </div>
+      <div class="field">
+        <label class="field-label">Тип калькулятора</label>
+        <div class="calc-type-buttons">
+          <button type="button" class="calc-type-btn" data-type="layered">Послойный</button>
+          <button type="button" class="calc-type-btn" data-type="distance">Расчёт доставки</button>
+          <button type="button" class="calc-type-btn" data-type="on_site">Выезд замерщика</button>
+          <button type="button" class="calc-type-btn" data-type="mortgage">Ипотека</button>
+          <button type="button" class="calc-type-btn" data-type="formula">По формулам</button>
+          <button type="button" class="calc-type-btn" data-type="form">Форма с ценами</button>
+        </div>
+        <p class="small">Тип влияет на логику и интерфейс конечного калькулятора.</p>
+      </div>
+      <div class="field">
+        <label class="field-label">Шаблон</label>
+        <select id="calc-create-template">
+          <option value="">Пустой калькулятор</option>
+        </select>
+        <p class="small" id="calc-create-template-desc"></p>
+      </div>
+      <div class="field">
+        <button class="btn primary" id="calc-create-submit" type="button">Создать</button>
+        <button class="btn secondary" id="calc-create-cancel" type="button">Отмена</button>
+      </div>
+    `;
+
+    const typeButtons = createPanelEl.querySelectorAll('.calc-type-btn');
+    function updateTypeButtons() {
+      typeButtons.forEach((btn) => {
+        btn.classList.toggle('active', btn.dataset.type === selectedType);
+      });
+    }
+    // шаблоны выбранного типа: конфиг с примерами сразу попадает в черновик
+    const templateSelect = createPanelEl.querySelector('#calc-create-template');
+    const templateDescEl = createPanelEl.querySelector('#calc-create-template-desc');
+    let templates = [];
+    function updateTemplateDesc() {
+      const tpl = templates.find((t) => t.id === templateSelect.value);
+      templateDescEl.textContent = tpl ? tpl.description || '' : '';
+    }
+    async function loadTemplates() {
+      templates = [];
+      templateSelect.innerHTML = '<option value="">Пустой калькулятор</option>';
+      updateTemplateDesc();
+      const type = selectedType;
+      try {
+        const data = await fetchJSON('/templates?type=' + encodeURIComponent(type));
+        if (type !== selectedType) return;
+        templates = data.items || [];
+      } catch (err) {
+        console.error(err);
+        return;
+      }
+      templates.forEach((t) => {
+        const opt = document.createElement('option');
+        opt.value = t.id;
+        opt.textContent = t.name;
+        templateSelect.appendChild(opt);
+      });
+    }
+    templateSelect.addEventListener('change', updateTemplateDesc);
+
+    typeButtons.forEach((btn) => {
+      btn.addEventListener('click', () => {
+        selectedType = btn.dataset.type;
+        updateTypeButtons();
+        loadTemplates();
+      });
+    });
+    updateTypeButtons();
+    loadTemplates();
+
+    const submitBtn = createPanelEl.querySelector('#calc-create-submit');
+    const cancelBtn = createPanelEl.querySelector('#calc-create-cancel');
+    const nameInput = createPanelEl.querySelector('#calc-create-name');
+
+    cancelBtn.addEventListener('click', () => {
+      createPanelVisible = false;
+      createPanelEl.style.display = 'none';
+    });
+
+    submitBtn.addEventListener('click', async () => {
+      const name = nameInput.value.trim();
+      const templateId = templateSelect.value;
+      // без названия калькулятор из шаблона получит имя шаблона
+      if (!name && !templateId) {
+        alert('Введите название калькулятора');
+        return;
+      }
+      try {
+        submitBtn.disabled = true;
+        submitBtn.textContent = 'Создание...';
+        const created = await postJSON('/calculators', {
+          name,
+          type: selectedType,
+          ...(templateId ? { templateId } : {}),
+        });
+        items.unshift(created);
+        renderList();
+        createPanelVisible = false;
+        createPanelEl.style.display = 'none';
+      } catch (err) {
+        console.error(err);
+        if (
+          err &&
+          (String(err.message).toLowerCase().includes('лимита') ||
+            String(err.message).toLowerCase().includes('лимит'))
+        ) {
+          showPlanLimitPopup(err.message);
+        } else {
+          alert(err.message || 'Не удалось создать калькулятор');
+        }
+      } finally {
+        submitBtn.disabled = false;
+        submitBtn.textContent = 'Создать';
+      }
+    });
+  }
+
+  // импорт архива из «Экспорт» (например, со стенда в прод): калькулятор создаётся черновиком
+  headerCard.querySelector('#btn-open-trash').addEventListener('click', () => {
+    showTrashModal((restored) => {
+      items.unshift(restored);
+      renderList();
+    });
+  });
+
+  const importBtn = headerCard.querySelector('#btn-import-calc');
+  const importInput = headerCard.querySelector('#calc-import-file');
+  importBtn.addEventListener('click', () => importInput.click());
+  importInput.addEventListener('change', async () => {
+    const file = importInput.files[0];
+    importInput.value = '';
+    if (!file) return;
+
+    const formData = new FormData();
+    formData.append('file', file);
+    try {
+      importBtn.disabled = true;
+      importBtn.textContent = 'Импорт...';
+      const res = await fetch(buildApiUrl('/calculators/import'), {
+        method: 'POST',
+        body: formData,
+      });
+      if (!res.ok) {
+        throw new Error(await res.text());
+      }
+      items.unshift(await res.json());
+      renderList();
+    } catch (err) {
+      console.error(err);
+      if (String(err.message).toLowerCase().includes('лимит')) {
+        showPlanLimitPopup(err.message);
+      } else {
+        alert('Не удалось импортировать калькулятор: ' + err.message);
+      }
+    } finally {
+      importBtn.disabled = false;
+      importBtn.textContent = 'Импорт';
+    }
+  });
+
+  openCreateBtn.addEventListener('click', () => {
+    if (!planActive) {
+      currentSection = 'billing';
+      setActiveNav('billing');
+      loadSection('billing');
+      return;
+    }
+
+    if (createPanelVisible) {
+      createPanelVisible = false;
+      createPanelEl.style.display = 'none';
+      return;
+    }
+    openCreatePanel();
+  });
+}
+
+// --- Distance builder ---
+
+function renderDistanceBuilder(cfg, calcMeta) {
+  contentEl.innerHTML = '';
+
+  // шапка, если открыто из списка калькуляторов
+  if (calcMeta) {
+    const infoCard = document.createElement('div');
+    infoCard.className = 'card';
+
+    const typeLabel = CALC_TYPE_LABELS[calcMeta.type] || calcMeta.type;
+    const statusLabel = calcStatusBadge(calcMeta.status);
+
+    const created = calcMeta.createdAt
+      ? new Date(calcMeta.createdAt).toLocaleString('ru-RU')
+      : '—';
+    const calcCount =
+      typeof calcMeta.calcCount === 'number' ? calcMeta.calcCount : 0;
+
+    const publicPath =
+      calcMeta.publicPath ||
+      (calcMeta.publicToken && calcMeta.ownerId
+        ? `/p/${calcMeta.ownerId}/${calcMeta.publicToken}`
+        : '');
+    const publicUrl = publicPath ? window.location.origin + publicPath : '';
+
+    infoCard.innerHTML = `
+      <div class="card-title">${calcMeta.name || 'Калькулятор доставки'}</div>
+      <div class="card-subtitle">
+        Тип: ${typeLabel}. ${statusLabel}
+      </div>
+      <p class="small" style="margin-top:4px;">
+        ID: ${calcMeta.id}, создан: ${created}, расчётов: ${calcCount}.
+      </p>
+      ${
+        publicUrl
+          ? `
+        <div class="field" style="margin-top:8px;">
+          <label class="field-label">Публичная ссылка</label>
+          <div style="display:flex; gap:6px; align-items:center;">
+            <input type="text" class="calc-link-input" value="${publicUrl}" readonly
+                   style="flex:1; font-size:12px; padding:4px 6px;" />
+            <button type="button" class="btn secondary" id="dist-copy-link-btn">
+              Копировать
+            </button>
+          </div>
+        </div>
+      `
+          : ''
+      }
+    `;
+
+    contentEl.appendChild(infoCard);
+
+    const copyBtn = infoCard.querySelector('#dist-copy-link-btn');
+    const linkInput = infoCard.querySelector('.calc-link-input');
+    if (copyBtn && linkInput) {
+      copyBtn.addEventListener('click', () => {
+        linkInput.select();
+        try {
+          document.execCommand('copy');
+          copyBtn.textContent = 'Скопировано';
+          setTimeout(() => {
+            copyBtn.textContent = 'Копировать';
+          }, 1500);
+        } catch (e) {
+          console.error(e);
+        }
+      });
+    }
+  }
+
+  const wrapper = document.createElement('div');
+  wrapper.className = 'grid grid-2';
+
+  const left = document.createElement('div');
+  const right = document.createElement('div');
+
+  wrapper.appendChild(left);
+  wrapper.appendChild(right);
+  contentEl.appendChild(wrapper);
+
+  const state = {
+    basePrice: (cfg && typeof cfg.basePrice === 'number') ? cfg.basePrice : 1500,
+    pricePerKm: (cfg && typeof cfg.pricePerKm === 'number') ? cfg.pricePerKm : 45,
+    loadingPrice: (cfg && typeof cfg.loadingPrice === 'number') ? cfg.loadingPrice : 0,
+    unloadingPrice: (cfg && typeof cfg.unloadingPrice === 'number') ? cfg.unloadingPrice : 0,
+    vehicleCoefs: Object.assign(
+      { small: 1.0, medium: 1.2, large: 1.5 },
+      (cfg && cfg.vehicleCoefs) || {}
+    ),
+  };
+
+  // --- левая колонка: настройки ---
+
+  left.innerHTML = `
+    <div class="card">
+      <div class="card-title">Калькулятор доставки по расстоянию</div>
+      <div class="card-subtitle">
+        Настройте базовую цену, стоимость километра и доп. услуги. Эти параметры используются
+        во всех калькуляторах типа «Расчёт доставки».
+      </div>
+
+      <div class="field">
+        <label class="field-label">Базовая стоимость, ₽</label>
+        <input type="number" id="dist-base-price" min="0" step="50" value="${state.basePrice}" />
+      </div>
+
+      <div class="field">
+        <label class="field-label">Тариф за километр, ₽</label>
+        <input type="number" id="dist-price-per-km" min="0" step="1" value="${state.pricePerKm}" />
+      </div>
+
+      <div class="inline" style="margin-bottom:10px;">
+        <div class="field">
+          <label class="field-label">Погрузка, ₽</label>
+          <input type="number" id="dist-loading-price" min="0" step="50" value="${state.loadingPrice}" />
+        </div>
+        <div class="field">
+          <label class="field-label">Разгрузка, ₽</label>
+          <input type="number" id="dist-unloading-price" min="0" step="50" value="${state.unloadingPrice}" />
+        </div>
+      </div>
+
+      <div class="field">
+        <label class="field-label">Коэффициенты по типу транспорта</label>
+        <div class="small" style="margin-bottom:4px;">Можно увеличить цену для более тяжёлых машин.</div>
+        <div class="inline" style="margin-bottom:6px;">
+          <div class="field">
+            <label class="field-label">До 1,5 т</label>
+            <input type="number" step="0.1" id="coef-small" value="${(state.vehicleCoefs.small || 1).toFixed(1)}" />
+          </div>
+          <div class="field">
+            <label class="field-label">До 3,5 т</label>
+            <input type="number" step="0.1" id="coef-medium" value="${(state.vehicleCoefs.medium || 1.2).toFixed(1)}" />
+          </div>
+          <div class="field">
+            <label class="field-label">5 т и выше</label>
+            <input type="number" step="0.1" id="coef-large" value="${(state.vehicleCoefs.large || 1.5).toFixed(1)}" />
+          </div>
+        </div>
+      </div>
+
+      <div class="card" style="margin-top:10px; padding-top:10px;">
+        <div class="card-title">Сохранить настройки</div>
+        <p class="small">
+          Каждое сохранение попадает в историю версий — к любой из них можно вернуться.
+        </p>
+        <button class="btn primary" id="dist-save-btn" type="button">Сохранить конфигурацию</button>
+        <button class="btn secondary" id="dist-history-btn" type="button">История версий</button>
+        ${publishControlsHTML(calcMeta)}
+      </div>
+    </div>
+  `;
+
+  // --- правая колонка: превью калькулятора + карта ---
+
+  right.innerHTML = `
+    <div class="card">
+      <div class="card-title">Превью калькулятора доставки</div>
+      <div class="card-subtitle">
+        Так клиент увидит калькулятор на вашем сайте. Расчёт маршрута выполняется на сервере через OpenStreetMap/OSRM.
+      </div>
+
+      <form id="dist-preview-form">
+        <div class="field">
+          <label class="field-label">Откуда</label>
+          <input type="text" id="dist-from" placeholder="Например, Москва, Варшавское шоссе 1" />
+        </div>
+        <div class="field">
+          <label class="field-label">Куда</label>
+          <input type="text" id="dist-to" placeholder="Например, Подольск, Ленина 10" />
+        </div>
+
+        <div class="field">
+          <label class="field-label">Тип транспорта</label>
+          <select id="dist-vehicle">
+            <option value="small">Малотоннажный до 1,5 т</option>
+            <option value="medium">Грузовик до 3,5 т</option>
+            <option value="large">Грузовик 5+ т</option>
+          </select>
+        </div>
+
+        <div class="field">
+          <label class="field-label">Используемые тарифы</label>
+          <div class="small">
+            База: <span id="dist-preview-base"></span>, км: <span id="dist-preview-km"></span>, погрузка/разгрузка: <span id="dist-preview-load"></span>
+          </div>
+        </div>
+
+        <div class="checkbox-row">
+          <input type="checkbox" id="dist-roundtrip" />
+          <label for="dist-roundtrip">В обе стороны (туда-обратно)</label>
+        </div>
+
+        <div style="display:flex; gap:8px; align-items:center; margin-top:8px;">
+          <button type="submit" class="btn primary">
+            <span class="icon">📍</span>
+            Рассчитать маршрут
+          </button>
+          <button type="button" id="dist-reset-btn" class="btn secondary">Сбросить</button>
+        </div>
+      </form>
+
+      <div id="dist-result-box" class="result-box" style="display:none; margin-top:10px;">
+        <div class="result-row">
+          <div class="result-label">Расстояние (одна сторона)</div>
+          <div class="result-value" id="dist-result-one">—</div>
+        </div>
+        <div class="result-row" id="dist-result-both-row" style="display:none;">
+          <div class="result-label">Расстояние (туда-обратно)</div>
+          <div class="result-value" id="dist-result-both">—</div>
+        </div>
+        <div class="result-row">
+          <div class="result-label">Базовая стоимость</div>
+          <div class="result-value" id="dist-result-base">—</div>
+        </div>
+        <div class="result-row">
+          <div class="result-label">Оплата за км</div>
+          <div class="result-value" id="dist-result-km">—</div>
+        </div>
+        <div class="result-row">
+          <div class="result-label">Погрузка / разгрузка</div>
+          <div class="result-value" id="dist-result-load">—</div>
+        </div>
+        <div class="result-total">
+          Итого ориентировочно: <strong id="dist-result-total">—</strong>
+        </div>
+      </div>
+
+      <div style="margin-top:10px;">
+        <div id="distance-map" style="width:100%;height:320px;border-radius:14px;overflow:hidden;"></div>
+        <div class="map-caption small" style="margin-top:4px;">
+          Карта использует тайлы OpenStreetMap через Leaflet.
+        </div>
+      </div>
+
+      <div id="dist-error" class="error" style="display:none;"></div>
+    </div>
+  `;
+
+  // --- бинды настроек ---
+
+  const basePriceInput = document.getElementById('dist-base-price');
+  const pricePerKmInput = document.getElementById('dist-price-per-km');
+  const loadingInput = document.getElementById('dist-loading-price');
+  const unloadingInput = document.getElementById('dist-unloading-price');
+  const coefSmallInput = document.getElementById('coef-small');
+  const coefMediumInput = document.getElementById('coef-medium');
+  const coefLargeInput = document.getElementById('coef-large');
+  const saveBtn = document.getElementById('dist-save-btn');
+
+  basePriceInput.addEventListener('input', () => {
+    state.basePrice = Number(basePriceInput.value) || 0;
+    updatePreviewTariffs();
+  });
+  pricePerKmInput.addEventListener('input', () => {
+    state.pricePerKm = Number(pricePerKmInput.value) || 0;
+    updatePreviewTariffs();
+  });
+  loadingInput.addEventListener('input', () => {
+    state.loadingPrice = Number(loadingInput.value) || 0;
+    updatePreviewTariffs();
+  });
+  unloadingInput.addEventListener('input', () => {
+    state.unloadingPrice = Number(unloadingInput.value) || 0;
+    updatePreviewTariffs();
+  });
+
+  coefSmallInput.addEventListener('input', () => {
+    state.vehicleCoefs.small = Number(coefSmallInput.value) || 1;
+  });
+  coefMediumInput.addEventListener('input', () => {
+    state.vehicleCoefs.medium = Number(coefMediumInput.value) || 1;
+  });
+  coefLargeInput.addEventListener('input', () => {
+    state.vehicleCoefs.large = Number(coefLargeInput.value) || 1;
+  });
+
+  bindPublishControls(calcMeta);
+
+  document.getElementById('dist-history-btn').addEventListener('click', () => {
+    showConfigHistoryModal(calcMeta, () => loadSection('distance'));
+  });
+
+  saveBtn.addEventListener('click', async () => {
+    try {
+      saveBtn.disabled = true;
+      saveBtn.textContent = 'Сохранение...';
+
+      const payload = {
+        basePrice: state.basePrice,
+        pricePerKm: state.pricePerKm,
+        loadingPrice: state.loadingPrice,
+        unloadingPrice: state.unloadingPrice,
+        vehicleCoefs: state.vehicleCoefs,
+      };
+
+      await postJSON('/distance/config?calculatorId=' + encodeURIComponent(calcMeta.id), payload);
+      alert('Настройки калькулятора доставки сохранены');
+    } catch (err) {
+      console.error(err);
+      alert('Ошибка сохранения настроек');
+    } finally {
+      saveBtn.disabled = false;
+      saveBtn.textContent = 'Сохранить конфигурацию';
+    }
+  });
+
+  // --- превью расчёта + карта ---
+
+  const previewBaseEl = document.getElementById('dist-preview-base');
+  const previewKmEl = document.getElementById('dist-preview-km');
+  const previewLoadEl = document.getElementById('dist-preview-load');
+
+  function formatMoney(num) {
+    return Math.round(num).toLocaleString('ru-RU') + ' ₽';
+  }
+  function formatKm(num) {
+    return (Math.round(num * 10) / 10).toLocaleString('ru-RU') + ' км';
+  }
+
+  function updatePreviewTariffs() {
+    previewBaseEl.textContent = formatMoney(state.basePrice || 0);
+    previewKmEl.textContent = (state.pricePerKm || 0).toLocaleString('ru-RU') + ' ₽/км';
+    const loadSum = (state.loadingPrice || 0) + (state.unloadingPrice || 0);
+    previewLoadEl.textContent = formatMoney(loadSum);
+  }
+
+  updatePreviewTariffs();
+
+  const previewForm = document.getElementById('dist-preview-form');
+  const fromInput = document.getElementById('dist-from');
+  const toInput = document.getElementById('dist-to');
+  const vehicleSelect = document.getElementById('dist-vehicle');
+  const roundtripInput = document.getElementById('dist-roundtrip');
+  const resetBtn = document.getElementById('dist-reset-btn');
+
+  const resultBox = document.getElementById('dist-result-box');
+  const resultOne = document.getElementById('dist-result-one');
+  const resultBothRow = document.getElementById('dist-result-both-row');
+  const resultBoth = document.getElementById('dist-result-both');
+  const resultBase = document.getElementById('dist-result-base');
+  const resultKm = document.getElementById('dist-result-km');
+  const resultLoad = document.getElementById('dist-result-load');
+  const resultTotal = document.getElementById('dist-result-total');
+  const errorBox = document.getElementById('dist-error');
+
+  function showError(msg) {
+    errorBox.textContent = msg;
+    errorBox.style.display = 'block';
+  }
+  function hideError() {
+    errorBox.textContent = '';
+    errorBox.style.display = 'none';
+  }
+  function hideResult() {
+    resultBox.style.display = 'none';
+  }
+
+  let distanceMap = null;
+  let routeLayer = null;
+
+  function initMapIfNeeded(route) {
+    if (typeof L === 'undefined') {
+      console.warn('Leaflet не загружен. Проверь подключение скрипта.');
+      return;
+    }
+    if (!distanceMap) {
+      distanceMap = L.map('distance-map').setView([55.751244, 37.618423], 9);
+      L.tileLayer('https://{s}.tile.openstreetmap.org/{z}/{x}/{y}.png', {
+        attribution: '&copy; OpenStreetMap contributors',
+      }).addTo(distanceMap);
+    }
+
+    if (!route || !route.length) {
+      return;
+    }
 
-        const typeLabel = CALC_TYPE_LABELS[c.type] || c.type;
-        const statusLabel =
-          c.status === 'published'
-            ? '<span class="calc-status-badge">Опубликован</span>'
-            : '<span class="calc-status-badge calc-status-badge--draft">Черновик</span>';
+    const latlngs = route.map((p) => [p.lat, p.lon]).filter((arr) => arr[0] && arr[1]);
+    if (!latlngs.length) {
+      return;
+    }
 
-        const created = c.createdAt
-          ? new Date(c.createdAt).toLocaleString('ru-RU')
-          : '—';
+    if (routeLayer) {
+      routeLayer.remove();
+      routeLayer = null;
+    }
 
-        const calcCount =
-          typeof c.calcCount === 'number' ? c.calcCount : 0;
+    routeLayer = L.polyline(latlngs, { weight: 4 }).addTo(distanceMap);
+    distanceMap.fitBounds(routeLayer.getBounds(), { padding: [20, 20] });
+  }
 
-        const publicPath =
-          c.publicPath ||
-          (c.publicToken && c.ownerId
-            ? `/p/${c.ownerId}/${c.publicToken}`
-            : '');
-        const publicUrl = publicPath
-          ? window.location.origin + publicPath
-          : '';
+  previewForm.addEventListener('submit', async (e) => {
+    e.preventDefault();
+    hideError();
 
-        row.innerHTML = `
-          <div class="calc-item-main">
-            <div>
-              <span class="calc-type-badge">${typeLabel}</span>
-              <strong>${c.name}</strong>
-            </div>
-            <div class="calc-item-meta">
-              ${statusLabel}
-              <span style="margin-left:8px;">ID: ${c.id}</span>
-              <span style="margin-left:8px;">Создан: ${created}</span>
-              <span style="margin-left:8px;">Расчётов: ${calcCount}</span>
-            </div>
-            ${
-              publicUrl
-                ? `
-              <div class="calc-item-link" style="margin-top:6px;">
-                <span class="small" style="display:block;margin-bottom:4px;">Публичная ссылка:</span>
-                <div style="display:flex; gap:6px; align-items:center;">
-                  <input type="text" class="calc-link-input" value="${publicUrl}" readonly
-                         style="flex:1; font-size:12px; padding:4px 6px;" />
-                  <button type="button" class="btn secondary btn-copy-link" style="white-space:nowrap;">
-                    Копировать
-                  </button>
-                </div>
-              </div>
-            `
-                : ''
-            }
-          </div>
-          <div class="calc-item-actions" style="display:flex; flex-direction:column; gap:4px;">
-            <button class="btn secondary btn-open" type="button"${
-              !planActive ? ' disabled' : ''
-            }>Открыть</button>
-            <button class="btn secondary btn-delete" type="button">
-              Удалить
-            </button>
-          </div>
-        `;
+    const from = fromInput.value.trim();
+    const to = toInput.value.trim();
 
-        if (!planActive) {
-          row.style.opacity = '0.5';
-        }
+    if (!from || !to) {
+      showError('Заполните поля «Откуда» и «Куда».');
+      return;
+    }
 
-        const openBtn = row.querySelector('.btn-open');
-        const deleteBtn = row.querySelector('.btn-delete');
+    try {
+      const body = {
+        from,
+        to,
+        vehicle: vehicleSelect.value,
+        roundTrip: roundtripInput.checked,
+        calculatorId: calcMeta && calcMeta.id ? calcMeta.id : '',
+      };
 
-        openBtn.addEventListener('click', () => {
-          if (!planActive) {
-            currentSection = 'billing';
-            setActiveNav('billing');
-            loadSection('billing');
-            return;
-          }
+      const res = await postJSON('/distance/calc', body);
 
-          if (c.type === 'layered') {
-            currentLayeredCalculator = c;
-            currentSection = 'layers';
-            setActiveNav('layers');
-            loadSection('layers');
-          } else if (c.type === 'distance') {
-            currentDistanceCalculator = c;
-            currentSection = 'distance';
-            setActiveNav('distance');
-            loadSection('distance');
-          } else {
-            alert(
-              'Редактор для типа "' +
-                (CALC_TYPE_LABELS[c.type] || c.type) +
-                '" пока в разработке.'
-            );
-          }
-        });
+      resultBox.style.display = 'block';
+      resultOne.textContent = formatKm(res.distanceOneWayKm || 0);
 
-        deleteBtn.addEventListener('click', async () => {
-          if (!confirm(`Удалить калькулятор "${c.name}"?`)) return;
+      if (roundtripInput.checked) {
+        resultBothRow.style.display = 'flex';
+        resultBoth.textContent = formatKm(res.distanceTotalKm || 0);
+      } else {
+        resultBothRow.style.display = 'none';
+      }
 
-          try {
-            deleteBtn.disabled = true;
-            deleteBtn.textContent = 'Удаление...';
+      resultBase.textContent = formatMoney(res.priceBase || 0);
+      resultKm.textContent = formatMoney(res.priceKm || 0);
+      resultLoad.textContent = formatMoney(res.priceLoad || 0);
+      resultTotal.textContent = formatMoney(res.priceTotal || 0);
 
-            const res = await fetch(
-              buildApiUrl('/calculators?id=' + encodeURIComponent(c.id)),
-              { method: 'DELETE' }
-            );
+      initMapIfNeeded(res.route || []);
 
-            if (!res.ok) {
-              let msg = 'Не удалось удалить калькулятор';
-              try {
-                const txt = await res.text();
-                if (txt) msg += ': ' + txt;
-              } catch (_) {}
-              alert(msg);
-              return;
-            }
+      refreshMeAndHeader();
+    } catch (err) {
+      console.error(err);
+      if (err && err.message) {
+        showError('Ошибка расчёта: ' + err.message);
+      } else {
+        showError('Не удалось рассчитать маршрут. Попробуйте ещё раз.');
+      }
+      hideResult();
+    }
+  });
 
-            const idx = items.findIndex((x) => x.id === c.id);
-            if (idx !== -1) {
-              items.splice(idx, 1);
-            }
-            renderList();
-          } catch (err) {
-            console.error(err);
-            alert('Ошибка удаления калькулятора');
-          } finally {
-            deleteBtn.disabled = false;
-            deleteBtn.textContent = 'Удалить';
-          }
-        });
+  resetBtn.addEventListener('click', () => {
+    fromInput.value = '';
+    toInput.value = '';
+    roundtripInput.checked = false;
+    hideError();
+    hideResult();
+    if (routeLayer && distanceMap) {
+      routeLayer.remove();
+      routeLayer = null;
+    }
+  });
+}
 
-        const copyBtn = row.querySelector('.btn-copy-link');
-        const linkInput = row.querySelector('.calc-link-input');
-        if (copyBtn && linkInput) {
-          copyBtn.addEventListener('click', () => {
-            linkInput.select();
-            try {
-              document.execCommand('copy');
-              copyBtn.textContent = 'Скопировано';
-              setTimeout(() => {
-                copyBtn.textContent = 'Копировать';
-              }, 1500);
-            } catch (e) {
-              console.error(e);
-            }
-          });
-        }
+// --- On-site builder ---
 
-        listEl.appendChild(row);
-      });
+// конструктор калькулятора выезда специалиста: база, бесплатный радиус, услуги и срочность
+function renderOnSiteBuilder(cfg, calcMeta) {
+  contentEl.innerHTML = '';
+
+  const state = {
+    baseAddress: (cfg && cfg.baseAddress) || '',
+    freeRadiusKm: (cfg && typeof cfg.freeRadiusKm === 'number') ? cfg.freeRadiusKm : 10,
+    pricePerKm: (cfg && typeof cfg.pricePerKm === 'number') ? cfg.pricePerKm : 30,
+    roundTrip: !!(cfg && cfg.roundTrip),
+    services: ((cfg && cfg.services) || []).map((s) => Object.assign({}, s)),
+    urgencies: ((cfg && cfg.urgencies) || []).map((u) => Object.assign({}, u)),
+  };
+
+  if (calcMeta) {
+    const infoCard = document.createElement('div');
+    infoCard.className = 'card';
+    const created = calcMeta.createdAt ? new Date(calcMeta.createdAt).toLocaleString('ru-RU') : '—';
+    infoCard.innerHTML = `
+      <div class="card-title">${calcMeta.name || 'Выезд специалиста'}</div>
+      <div class="card-subtitle">
+        Тип: ${CALC_TYPE_LABELS[calcMeta.type] || calcMeta.type}. ${calcStatusBadge(calcMeta.status)}
+      </div>
+      <p class="small" style="margin-top:4px;">
+        ID: ${calcMeta.id}, создан: ${created}, расчётов: ${calcMeta.calcCount || 0}.
+      </p>
+    `;
+    contentEl.appendChild(infoCard);
   }
 
-  renderList();
+  const wrapper = document.createElement('div');
+  wrapper.className = 'grid grid-2';
+  const left = document.createElement('div');
+  const right = document.createElement('div');
+  wrapper.appendChild(left);
+  wrapper.appendChild(right);
+  contentEl.appendChild(wrapper);
+
+  left.innerHTML = `
+    <div class="card">
+      <div class="card-title">Выезд специалиста</div>
+      <div class="card-subtitle">
+        Дорога в пределах бесплатного радиуса от базы не оплачивается, дальше — по цене за км.
+        Итог: стоимость услуги + дорога + надбавка за срочность.
+      </div>
+
+      <div class="field">
+        <label class="field-label">Адрес базы (откуда выезжает специалист)</label>
+        <input type="text" id="onsite-base-address" value="${state.baseAddress}" />
+      </div>
 
-  let createPanelVisible = false;
-  let selectedType = 'layered';
+      <div class="inline" style="margin-bottom:10px;">
+        <div class="field">
+          <label class="field-label">Бесплатный радиус, км</label>
+          <input type="number" id="onsite-free-radius" min="0" step="1" value="${state.freeRadiusKm}" />
+        </div>
+        <div class="field">
+          <label class="field-label">Цена за км дальше, ₽</label>
+          <input type="number" id="onsite-price-per-km" min="0" step="1" value="${state.pricePerKm}" />
+        </div>
+      </div>
 
-  function openCreatePanel() {
-    if (!planActive) {
-      currentSection = 'billing';
-      setActiveNav('billing');
-      loadSection('billing');
-      return;
-    }
+      <div class="checkbox-row" style="margin-bottom:10px;">
+        <input type="checkbox" id="onsite-roundtrip" ${state.roundTrip ? 'checked' : ''} />
+        <label for="onsite-roundtrip">Платные км считать туда и обратно</label>
+      </div>
 
-    createPanelVisible = true;
-    createPanelEl.style.display = '';
-    createPanelEl.innerHTML = `
       <div class="field">
-        <label class="field-label">Название калькулятора</label>
-        <input type="text" id="calc-create-name" placeholder="Например, «Прицеп – послойный калькулятор»" />
+        <label class="field-label">Услуги и стоимость выезда</label>
+        <div id="onsite-services"></div>
+        <button class="btn secondary" id="onsite-add-service" type="button">+ Услуга</button>
       </div>
+
       <div class="field">
-        <label class="field-label">Тип калькулятора</label>
-        <div class="calc-type-buttons">
-          <button type="button" class="calc-type-btn" data-type="layered">Послойный</button>
-          <button type="button" class="calc-type-btn" data-type="distance">Расчёт доставки</button>
-          <button type="button" class="calc-type-btn" data-type="on_site">Выезд замерщика</button>
-          <button type="button" class="calc-type-btn" data-type="mortgage">Ипотека</button>
-        </div>
-        <p class="small">Тип влияет на логику и интерфейс конечного калькулятора.</p>
+        <label class="field-label">Срочность: надбавка в % к выезду и дороге и/или фиксированная, ₽</label>
+        <div id="onsite-urgencies"></div>
+        <button class="btn secondary" id="onsite-add-urgency" type="button">+ Срочность</button>
       </div>
-      <div class="field">
-        <button class="btn primary" id="calc-create-submit" type="button">Создать</button>
-        <button class="btn secondary" id="calc-create-cancel" type="button">Отмена</button>
+
+      <div class="card" style="margin-top:10px; padding-top:10px;">
+        <div class="card-title">Сохранить настройки</div>
+        <p class="small">
+          Каждое сохранение попадает в историю версий — к любой из них можно вернуться.
+        </p>
+        <button class="btn primary" id="onsite-save-btn" type="button">Сохранить конфигурацию</button>
+        <button class="btn secondary" id="onsite-history-btn" type="button">История версий</button>
+        ${publishControlsHTML(calcMeta)}
       </div>
-    `;
+    </div>
+  `;
 
-    const typeButtons = createPanelEl.querySelectorAll('.calc-type-btn');
-    function updateTypeButtons() {
-      typeButtons.forEach((btn) => {
-        btn.classList.toggle('active', btn.dataset.type === selectedType);
+  right.innerHTML = `
+    <div class="card">
+      <div class="card-title">Превью расчёта</div>
+      <div class="card-subtitle">
+        Считается по сохранённому черновику. Маршрут строится на сервере через OpenStreetMap/OSRM.
+      </div>
+      <form id="onsite-preview-form">
+        <div class="field">
+          <label class="field-label">Адрес объекта</label>
+          <input type="text" id="onsite-preview-address" placeholder="Например, Химки, Ленинградская 5" />
+        </div>
+        <div class="inline">
+          <div class="field">
+            <label class="field-label">Услуга</label>
+            <select id="onsite-preview-service"></select>
+          </div>
+          <div class="field">
+            <label class="field-label">Срочность</label>
+            <select id="onsite-preview-urgency"></select>
+          </div>
+        </div>
+        <button type="submit" class="btn primary">Рассчитать выезд</button>
+      </form>
+      <div id="onsite-preview-result" class="result-box" style="display:none; margin-top:10px;"></div>
+      <div id="onsite-preview-error" class="error" style="display:none;"></div>
+    </div>
+  `;
+
+  function formatMoney(num) {
+    return Math.round(num).toLocaleString('ru-RU') + ' ₽';
+  }
+  function formatKm(num) {
+    return (Math.round(num * 10) / 10).toLocaleString('ru-RU') + ' км';
+  }
+
+  // id услуги/срочности нужен в запросе расчёта; новым строкам выдаём уникальный
+  function nextID(prefix, list) {
+    let n = list.length + 1;
+    while (list.some((x) => x.id === prefix + n)) n++;
+    return prefix + n;
+  }
+
+  const servicesEl = document.getElementById('onsite-services');
+  const urgenciesEl = document.getElementById('onsite-urgencies');
+  const previewService = document.getElementById('onsite-preview-service');
+  const previewUrgency = document.getElementById('onsite-preview-urgency');
+
+  function renderPreviewOptions() {
+    previewService.innerHTML = state.services
+      .map((s) => `<option value="${s.id}">${s.name}</option>`)
+      .join('');
+    previewUrgency.innerHTML = state.urgencies
+      .map((u) => `<option value="${u.id}">${u.name}</option>`)
+      .join('');
+  }
+
+  function renderRows() {
+    servicesEl.innerHTML = '';
+    state.services.forEach((s, idx) => {
+      const row = document.createElement('div');
+      row.className = 'inline';
+      row.style.marginBottom = '6px';
+      row.innerHTML = `
+        <input type="text" class="svc-name" value="${s.name}" placeholder="Название" />
+        <input type="number" class="svc-fee" min="0" step="100" value="${s.visitFee || 0}" title="Стоимость выезда, ₽" />
+        <button class="btn secondary svc-remove" type="button" title="Удалить">✕</button>
+      `;
+      row.querySelector('.svc-name').addEventListener('input', (e) => {
+        s.name = e.target.value;
+        renderPreviewOptions();
       });
-    }
-    typeButtons.forEach((btn) => {
-      btn.addEventListener('click', () => {
-        selectedType = btn.dataset.type;
-        updateTypeButtons();
+      row.querySelector('.svc-fee').addEventListener('input', (e) => {
+        s.visitFee = Number(e.target.value) || 0;
       });
+      row.querySelector('.svc-remove').addEventListener('click', () => {
+        state.services.splice(idx, 1);
+        renderRows();
+      });
+      servicesEl.appendChild(row);
     });
-    updateTypeButtons();
 
-    const submitBtn = createPanelEl.querySelector('#calc-create-submit');
-    const cancelBtn = createPanelEl.querySelector('#calc-create-cancel');
-    const nameInput = createPanelEl.querySelector('#calc-create-name');
-
-    cancelBtn.addEventListener('click', () => {
-      createPanelVisible = false;
-      createPanelEl.style.display = 'none';
+    urgenciesEl.innerHTML = '';
+    state.urgencies.forEach((u, idx) => {
+      const row = document.createElement('div');
+      row.className = 'inline';
+      row.style.marginBottom = '6px';
+      row.innerHTML = `
+        <input type="text" class="urg-name" value="${u.name}" placeholder="Название" />
+        <input type="number" class="urg-percent" min="0" step="5" value="${u.surchargePercent || 0}" title="Надбавка, %" />
+        <input type="number" class="urg-fixed" min="0" step="100" value="${u.surchargeFixed || 0}" title="Надбавка, ₽" />
+        <button class="btn secondary urg-remove" type="button" title="Удалить">✕</button>
+      `;
+      row.querySelector('.urg-name').addEventListener('input', (e) => {
+        u.name = e.target.value;
+        renderPreviewOptions();
+      });
+      row.querySelector('.urg-percent').addEventListener('input', (e) => {
+        u.surchargePercent = Number(e.target.value) || 0;
+      });
+      row.querySelector('.urg-fixed').addEventListener('input', (e) => {
+        u.surchargeFixed = Number(e.target.value) || 0;
+      });
+      row.querySelector('.urg-remove').addEventListener('click', () => {
+        state.urgencies.splice(idx, 1);
+        renderRows();
+      });
+      urgenciesEl.appendChild(row);
     });
 
-    submitBtn.addEventListener('click', async () => {
-      const name = nameInput.value.trim();
-      if (!name) {
-        alert('Введите название калькулятора');
-        return;
-      }
-      try {
-        submitBtn.disabled = true;
-        submitBtn.textContent = 'Создание...';
-        const created = await postJSON('/calculators', {
-          name,
-          type: selectedType,
-        });
-        items.push(created);
-        renderList();
-        createPanelVisible = false;
-        createPanelEl.style.display = 'none';
-      } catch (err) {
-        console.error(err);
-        if (
-          err &&
-          (String(err.message).toLowerCase().includes('лимита') ||
-            String(err.message).toLowerCase().includes('лимит'))
-        ) {
-          showPlanLimitPopup(err.message);
-        } else {
-          alert(err.message || 'Не удалось создать калькулятор');
-        }
-      } finally {
-        submitBtn.disabled = false;
-        submitBtn.textContent = 'Создать';
-      }
-    });
+    renderPreviewOptions();
   }
 
-  openCreateBtn.addEventListener('click', () => {
-    if (!planActive) {
-      currentSection = 'billing';
-      setActiveNav('billing');
-      loadSection('billing');
-      return;
+  renderRows();
+
+  document.getElementById('onsite-add-service').addEventListener('click', () => {
+    state.services.push({ id: nextID('service', state.services), name: 'Новая услуга', visitFee: 0 });
+    renderRows();
+  });
+  document.getElementById('onsite-add-urgency').addEventListener('click', () => {
+    state.urgencies.push({ id: nextID('urgency', state.urgencies), name: 'Срочно', surchargePercent: 0, surchargeFixed: 0 });
+    renderRows();
+  });
+
+  document.getElementById('onsite-base-address').addEventListener('input', (e) => {
+    state.baseAddress = e.target.value;
+  });
+  document.getElementById('onsite-free-radius').addEventListener('input', (e) => {
+    state.freeRadiusKm = Number(e.target.value) || 0;
+  });
+  document.getElementById('onsite-price-per-km').addEventListener('input', (e) => {
+    state.pricePerKm = Number(e.target.value) || 0;
+  });
+  document.getElementById('onsite-roundtrip').addEventListener('change', (e) => {
+    state.roundTrip = e.target.checked;
+  });
+
+  bindPublishControls(calcMeta);
+
+  document.getElementById('onsite-history-btn').addEventListener('click', () => {
+    showConfigHistoryModal(calcMeta, () => loadSection('onsite'));
+  });
+
+  const saveBtn = document.getElementById('onsite-save-btn');
+  saveBtn.addEventListener('click', async () => {
+    try {
+      saveBtn.disabled = true;
+      saveBtn.textContent = 'Сохранение...';
+      await postJSON('/onsite/config?calculatorId=' + encodeURIComponent(calcMeta.id), {
+        baseAddress: state.baseAddress,
+        freeRadiusKm: state.freeRadiusKm,
+        pricePerKm: state.pricePerKm,
+        roundTrip: state.roundTrip,
+        services: state.services,
+        urgencies: state.urgencies,
+      });
+      alert('Настройки калькулятора выезда сохранены');
+    } catch (err) {
+      console.error(err);
+      alert('Ошибка сохранения настроек: ' + err.message);
+    } finally {
+      saveBtn.disabled = false;
+      saveBtn.textContent = 'Сохранить конфигурацию';
     }
+  });
 
-    if (createPanelVisible) {
-      createPanelVisible = false;
-      createPanelEl.style.display = 'none';
+  const resultBox = document.getElementById('onsite-preview-result');
+  const errorBox = document.getElementById('onsite-preview-error');
+
+  document.getElementById('onsite-preview-form').addEventListener('submit', async (e) => {
+    e.preventDefault();
+    errorBox.style.display = 'none';
+    const address = document.getElementById('onsite-preview-address').value.trim();
+    if (!address) {
+      errorBox.textContent = 'Укажите адрес объекта.';
+      errorBox.style.display = 'block';
       return;
     }
-    openCreatePanel();
+    try {
+      const res = await postJSON('/onsite/calc', {
+        address,
+        service: previewService.value,
+        urgency: previewUrgency.value,
+        calculatorId: calcMeta && calcMeta.id ? calcMeta.id : '',
+        previewToken: calcMeta && calcMeta.previewToken ? calcMeta.previewToken : '',
+      });
+      resultBox.innerHTML = `
+        <div class="result-row"><div class="result-label">Расстояние</div><div class="result-value">${formatKm(res.distanceKm || 0)}</div></div>
+        <div class="result-row"><div class="result-label">${res.service}</div><div class="result-value">${formatMoney(res.visitFee || 0)}</div></div>
+        <div class="result-row"><div class="result-label">Дорога (${formatKm(res.paidKm || 0)})</div><div class="result-value">${formatMoney(res.priceKm || 0)}</div></div>
+        ${
+          res.surcharge
+            ? `<div class="result-row"><div class="result-label">Срочность: ${res.urgency}</div><div class="result-value">${formatMoney(res.surcharge)}</div></div>`
+            : ''
+        }
+        <div class="result-total">Итого ориентировочно: <strong>${formatMoney(res.priceTotal || 0)}</strong></div>
+      `;
+      resultBox.style.display = 'block';
+    } catch (err) {
+      console.error(err);
+      resultBox.style.display = 'none';
+      errorBox.textContent = 'Ошибка расчёта: ' + err.message;
+      errorBox.style.display = 'block';
+    }
   });
 }
 
-// --- Distance builder ---
+// --- Formula builder ---
 
-function renderDistanceBuilder(cfg, calcMeta) {
+// поля формы формульного калькулятора
+const FORMULA_INPUT_KINDS = {
+  number: 'Число',
+  select: 'Список',
+  checkbox: 'Галочка',
+};
+
+function renderFormulaBuilder(cfg, calcMeta) {
   contentEl.innerHTML = '';
 
-  // шапка, если открыто из списка калькуляторов
+  const state = {
+    description: (cfg && cfg.description) || '',
+    inputs: ((cfg && cfg.inputs) || []).map((i) =>
+      Object.assign({}, i, { options: (i.options || []).map((o) => Object.assign({}, o)) })
+    ),
+    outputs: ((cfg && cfg.outputs) || []).map((o) => Object.assign({}, o)),
+  };
+
   if (calcMeta) {
     const infoCard = document.createElement('div');
     infoCard.className = 'card';
-
-    const typeLabel = CALC_TYPE_LABELS[calcMeta.type] || calcMeta.type;
-    const statusLabel =
-      calcMeta.status === 'published'
-        ? '<span class="calc-status-badge">Опубликован</span>'
-        : '<span class="calc-status-badge calc-status-badge--draft">Черновик</span>';
-
-    const created = calcMeta.createdAt
-      ? new Date(calcMeta.createdAt).toLocaleString('ru-RU')
-      : '—';
-    const calcCount =
-      typeof calcMeta.calcCount === 'number' ? calcMeta.calcCount : 0;
-
-    const publicPath =
-      calcMeta.publicPath ||
-      (calcMeta.publicToken && calcMeta.ownerId
-        ? `/p/${calcMeta.ownerId}/${calcMeta.publicToken}`
-        : '');
-    const publicUrl = publicPath ? window.location.origin + publicPath : '';
-
+    const created = calcMeta.createdAt ? new Date(calcMeta.createdAt).toLocaleString('ru-RU') : '—';
     infoCard.innerHTML = `
-      <div class="card-title">${calcMeta.name || 'Калькулятор доставки'}</div>
+      <div class="card-title">${calcMeta.name || 'Калькулятор по формулам'}</div>
       <div class="card-subtitle">
-        Тип: ${typeLabel}. ${statusLabel}
+        Тип: ${CALC_TYPE_LABELS[calcMeta.type] || calcMeta.type}. ${calcStatusBadge(calcMeta.status)}
       </div>
       <p class="small" style="margin-top:4px;">
-        ID: ${calcMeta.id}, создан: ${created}, расчётов: ${calcCount}.
+        ID: ${calcMeta.id}, создан: ${created}, расчётов: ${calcMeta.calcCount || 0}.
       </p>
-      ${
-        publicUrl
-          ? `
-        <div class="field" style="margin-top:8px;">
-          <label class="field-label">Публичная ссылка</label>
-          <div style="display:flex; gap:6px; align-items:center;">
-            <input type="text" class="calc-link-input" value="${publicUrl}" readonly
-                   style="flex:1; font-size:12px; padding:4px 6px;" />
-            <button type="button" class="btn secondary" id="dist-copy-link-btn">
-              Копировать
-            </button>
-          </div>
-        </div>
-      `
-          : ''
-      }
     `;
-
     contentEl.appendChild(infoCard);
-
-    const copyBtn = infoCard.querySelector('#dist-copy-link-btn');
-    const linkInput = infoCard.querySelector('.calc-link-input');
-    if (copyBtn && linkInput) {
-      copyBtn.addEventListener('click', () => {
-        linkInput.select();
-        try {
-          document.execCommand('copy');
-          copyBtn.textContent = 'Скопировано';
-          setTimeout(() => {
-            copyBtn.textContent = 'Копировать';
-          }, 1500);
-        } catch (e) {
-          console.error(e);
-        }
-      });
-    }
   }
 
   const wrapper = document.createElement('div');
   wrapper.className = 'grid grid-2';
-
   const left = document.createElement('div');
   const right = document.createElement('div');
-
   wrapper.appendChild(left);
   wrapper.appendChild(right);
   contentEl.appendChild(wrapper);
 
-  const state = {
-    basePrice: (cfg && typeof cfg.basePrice === 'number') ? cfg.basePrice : 1500,
-    pricePerKm: (cfg && typeof cfg.pricePerKm === 'number') ? cfg.pricePerKm : 45,
-    loadingPrice: (cfg && typeof cfg.loadingPrice === 'number') ? cfg.loadingPrice : 0,
-    unloadingPrice: (cfg && typeof cfg.unloadingPrice === 'number') ? cfg.unloadingPrice : 0,
-    vehicleCoefs: Object.assign(
-      { small: 1.0, medium: 1.2, large: 1.5 },
-      (cfg && cfg.vehicleCoefs) || {}
-    ),
-  };
-
-  // --- левая колонка: настройки ---
-
   left.innerHTML = `
     <div class="card">
-      <div class="card-title">Калькулятор доставки по расстоянию</div>
+      <div class="card-title">Калькулятор по формулам</div>
       <div class="card-subtitle">
-        Настройте базовую цену, стоимость километра и доп. услуги. Эти параметры используются
-        во всех калькуляторах типа «Расчёт доставки».
+        Поля формы — это переменные, результаты считаются по формулам на сервере.
+        В формулах: + - * / %, ^, сравнения (== != &lt; &lt;= &gt; &gt;=), &amp;&amp; || !,
+        функции if(условие, да, нет), min, max, round(x, знаков), floor, ceil, abs.
+        Формула может использовать поля и результаты выше неё.
       </div>
 
       <div class="field">
-        <label class="field-label">Базовая стоимость, ₽</label>
-        <input type="number" id="dist-base-price" min="0" step="50" value="${state.basePrice}" />
+        <label class="field-label">Описание под заголовком</label>
+        <textarea id="formula-description" rows="2"></textarea>
       </div>
 
       <div class="field">
-        <label class="field-label">Тариф за километр, ₽</label>
-        <input type="number" id="dist-price-per-km" min="0" step="1" value="${state.pricePerKm}" />
-      </div>
-
-      <div class="inline" style="margin-bottom:10px;">
-        <div class="field">
-          <label class="field-label">Погрузка, ₽</label>
-          <input type="number" id="dist-loading-price" min="0" step="50" value="${state.loadingPrice}" />
-        </div>
-        <div class="field">
-          <label class="field-label">Разгрузка, ₽</label>
-          <input type="number" id="dist-unloading-price" min="0" step="50" value="${state.unloadingPrice}" />
-        </div>
+        <label class="field-label">Поля формы</label>
+        <div id="formula-inputs"></div>
+        <button class="btn secondary" id="formula-add-input" type="button">+ Поле</button>
       </div>
 
       <div class="field">
-        <label class="field-label">Коэффициенты по типу транспорта</label>
-        <div class="small" style="margin-bottom:4px;">Можно увеличить цену для более тяжёлых машин.</div>
-        <div class="inline" style="margin-bottom:6px;">
-          <div class="field">
-            <label class="field-label">До 1,5 т</label>
-            <input type="number" step="0.1" id="coef-small" value="${(state.vehicleCoefs.small || 1).toFixed(1)}" />
-          </div>
-          <div class="field">
-            <label class="field-label">До 3,5 т</label>
-            <input type="number" step="0.1" id="coef-medium" value="${(state.vehicleCoefs.medium || 1.2).toFixed(1)}" />
-          </div>
-          <div class="field">
-            <label class="field-label">5 т и выше</label>
-            <input type="number" step="0.1" id="coef-large" value="${(state.vehicleCoefs.large || 1.5).toFixed(1)}" />
-          </div>
-        </div>
+        <label class="field-label">Результаты</label>
+        <div id="formula-outputs"></div>
+        <button class="btn secondary" id="formula-add-output" type="button">+ Результат</button>
       </div>
 
+      <div id="formula-errors" class="error" style="display:none;"></div>
+
       <div class="card" style="margin-top:10px; padding-top:10px;">
         <div class="card-title">Сохранить настройки</div>
         <p class="small">
-          Конфигурация хранится на сервере (мок хранилища). В рабочей версии здесь будет ваша БД.
+          Формулы проверяются при сохранении. Каждое сохранение попадает в историю версий.
         </p>
-        <button class="btn primary" id="dist-save-btn" type="button">Сохранить конфигурацию</button>
+        <button class="btn primary" id="formula-save-btn" type="button">Сохранить конфигурацию</button>
+        <button class="btn secondary" id="formula-history-btn" type="button">История версий</button>
+        ${publishControlsHTML(calcMeta)}
       </div>
     </div>
   `;
 
-  // --- правая колонка: превью калькулятора + карта ---
-
   right.innerHTML = `
     <div class="card">
-      <div class="card-title">Превью калькулятора доставки</div>
+      <div class="card-title">Превью расчёта</div>
       <div class="card-subtitle">
-        Так клиент увидит калькулятор на вашем сайте. Расчёт маршрута выполняется на сервере через OpenStreetMap/OSRM.
+        Считается по сохранённому черновику — сначала сохраните изменения.
       </div>
+      <form id="formula-preview-form">
+        <div id="formula-preview-fields"></div>
+        <button type="submit" class="btn primary">Рассчитать</button>
+      </form>
+      <div id="formula-preview-result" class="result-box" style="display:none; margin-top:10px;"></div>
+      <div id="formula-preview-error" class="error" style="display:none;"></div>
+    </div>
+  `;
 
-      <form id="dist-preview-form">
-        <div class="field">
-          <label class="field-label">Откуда</label>
-          <input type="text" id="dist-from" placeholder="Например, Москва, Варшавское шоссе 1" />
-        </div>
-        <div class="field">
-          <label class="field-label">Куда</label>
-          <input type="text" id="dist-to" placeholder="Например, Подольск, Ленина 10" />
-        </div>
+  // новым полям и результатам выдаём уникальное имя
+  function nextName(prefix) {
+    const taken = state.inputs.concat(state.outputs).map((x) => x.name);
+    let n = 1;
+    while (taken.includes(prefix + n)) n++;
+    return prefix + n;
+  }
 
-        <div class="field">
-          <label class="field-label">Тип транспорта</label>
-          <select id="dist-vehicle">
-            <option value="small">Малотоннажный до 1,5 т</option>
-            <option value="medium">Грузовик до 3,5 т</option>
-            <option value="large">Грузовик 5+ т</option>
-          </select>
-        </div>
+  // варианты списка редактируются текстом: «Подпись = значение» по строке на вариант
+  function optionsToText(options) {
+    return (options || []).map((o) => o.label + ' = ' + o.value).join('\n');
+  }
+  function textToOptions(text) {
+    return text
+      .split('\n')
+      .map((line) => line.trim())
+      .filter(Boolean)
+      .map((line) => {
+        const eq = line.lastIndexOf('=');
+        if (eq < 0) return { label: line, value: 0 };
+        return { label: line.slice(0, eq).trim(), value: Number(line.slice(eq + 1).trim()) || 0 };
+      });
+  }
 
-        <div class="field">
-          <label class="field-label">Используемые тарифы</label>
-          <div class="small">
-            База: <span id="dist-preview-base"></span>, км: <span id="dist-preview-km"></span>, погрузка/разгрузка: <span id="dist-preview-load"></span>
-          </div>
-        </div>
+  const inputsEl = document.getElementById('formula-inputs');
+  const outputsEl = document.getElementById('formula-outputs');
+  const errorsEl = document.getElementById('formula-errors');
+  const previewFieldsEl = document.getElementById('formula-preview-fields');
 
-        <div class="checkbox-row">
-          <input type="checkbox" id="dist-roundtrip" />
-          <label for="dist-roundtrip">В обе стороны (туда-обратно)</label>
-        </div>
+  document.getElementById('formula-description').value = state.description;
+  document.getElementById('formula-description').addEventListener('input', (e) => {
+    state.description = e.target.value;
+  });
 
-        <div style="display:flex; gap:8px; align-items:center; margin-top:8px;">
-          <button type="submit" class="btn primary">
-            <span class="icon">📍</span>
-            Рассчитать маршрут
-          </button>
-          <button type="button" id="dist-reset-btn" class="btn secondary">Сбросить</button>
+  function renderInputs() {
+    inputsEl.innerHTML = '';
+    state.inputs.forEach((input, idx) => {
+      const row = document.createElement('div');
+      row.className = 'card';
+      row.style.marginBottom = '6px';
+      row.dataset.field = 'inputs[' + idx + ']';
+      row.innerHTML = `
+        <div class="inline" style="margin-bottom:6px;">
+          <input type="text" class="fi-name" placeholder="Имя в формуле" style="font-family:monospace;" />
+          <input type="text" class="fi-label" placeholder="Подпись" />
+          <select class="fi-kind">
+            ${Object.keys(FORMULA_INPUT_KINDS).map((k) => `<option value="${k}">${FORMULA_INPUT_KINDS[k]}</option>`).join('')}
+          </select>
+          <button class="btn secondary fi-remove" type="button" title="Удалить">✕</button>
         </div>
-      </form>
+        <div class="fi-kind-fields"></div>
+      `;
+      row.querySelector('.fi-name').value = input.name || '';
+      row.querySelector('.fi-label').value = input.label || '';
+      row.querySelector('.fi-kind').value = input.kind || 'number';
+
+      const kindEl = row.querySelector('.fi-kind-fields');
+      if (input.kind === 'select') {
+        kindEl.innerHTML = `
+          <textarea class="fi-options" rows="3" placeholder="Покраска = 500"></textarea>
+          <p class="small">По строке на вариант: «Подпись = значение», в формулу попадает значение.</p>
+        `;
+        const optionsEl = kindEl.querySelector('.fi-options');
+        optionsEl.value = optionsToText(input.options);
+        optionsEl.addEventListener('input', (e) => {
+          input.options = textToOptions(e.target.value);
+          if (!input.options.some((o) => o.value === input.default)) {
+            input.default = input.options.length ? input.options[0].value : 0;
+          }
+        });
+      } else if (input.kind === 'checkbox') {
+        kindEl.innerHTML = `
+          <div class="inline">
+            <input type="number" class="fi-value" step="any" title="Значение, если отмечено" />
+            <label class="small"><input type="checkbox" class="fi-default" /> отмечена по умолчанию</label>
+          </div>
+        `;
+        kindEl.querySelector('.fi-value').value = input.value || 0;
+        kindEl.querySelector('.fi-default').checked = !!input.default;
+        kindEl.querySelector('.fi-value').addEventListener('input', (e) => {
+          input.value = Number(e.target.value) || 0;
+        });
+        kindEl.querySelector('.fi-default').addEventListener('change', (e) => {
+          input.default = e.target.checked ? 1 : 0;
+        });
+      } else {
+        kindEl.innerHTML = `
+          <div class="inline">
+            <input type="number" class="fi-default" step="any" title="По умолчанию" placeholder="По умолчанию" />
+            <input type="number" class="fi-min" step="any" title="Минимум" placeholder="Мин." />
+            <input type="number" class="fi-max" step="any" title="Максимум" placeholder="Макс." />
+            <input type="number" class="fi-step" step="any" min="0" title="Шаг" placeholder="Шаг" />
+            <input type="text" class="fi-unit" title="Единицы" placeholder="м²" />
+          </div>
+        `;
+        kindEl.querySelector('.fi-default').value = input.default || 0;
+        kindEl.querySelector('.fi-min').value = input.min != null ? input.min : '';
+        kindEl.querySelector('.fi-max').value = input.max != null ? input.max : '';
+        kindEl.querySelector('.fi-step').value = input.step || '';
+        kindEl.querySelector('.fi-unit').value = input.unit || '';
+        kindEl.querySelector('.fi-default').addEventListener('input', (e) => {
+          input.default = Number(e.target.value) || 0;
+        });
+        kindEl.querySelector('.fi-min').addEventListener('input', (e) => {
+          input.min = e.target.value === '' ? null : Number(e.target.value);
+        });
+        kindEl.querySelector('.fi-max').addEventListener('input', (e) => {
+          input.max = e.target.value === '' ? null : Number(e.target.value);
+        });
+        kindEl.querySelector('.fi-step').addEventListener('input', (e) => {
+          input.step = Number(e.target.value) || 0;
+        });
+        kindEl.querySelector('.fi-unit').addEventListener('input', (e) => {
+          input.unit = e.target.value;
+        });
+      }
 
-      <div id="dist-result-box" class="result-box" style="display:none; margin-top:10px;">
-        <div class="result-row">
-          <div class="result-label">Расстояние (одна сторона)</div>
-          <div class="result-value" id="dist-result-one">—</div>
-        </div>
-        <div class="result-row" id="dist-result-both-row" style="display:none;">
-          <div class="result-label">Расстояние (туда-обратно)</div>
-          <div class="result-value" id="dist-result-both">—</div>
-        </div>
-        <div class="result-row">
-          <div class="result-label">Базовая стоимость</div>
-          <div class="result-value" id="dist-result-base">—</div>
-        </div>
-        <div class="result-row">
-          <div class="result-label">Оплата за км</div>
-          <div class="result-value" id="dist-result-km">—</div>
-        </div>
-        <div class="result-row">
-          <div class="result-label">Погрузка / разгрузка</div>
-          <div class="result-value" id="dist-result-load">—</div>
-        </div>
-        <div class="result-total">
-          Итого ориентировочно: <strong id="dist-result-total">—</strong>
-        </div>
-      </div>
+      row.querySelector('.fi-name').addEventListener('input', (e) => {
+        input.name = e.target.value.trim();
+      });
+      row.querySelector('.fi-label').addEventListener('input', (e) => {
+        input.label = e.target.value;
+      });
+      row.querySelector('.fi-kind').addEventListener('change', (e) => {
+        input.kind = e.target.value;
+        input.default = 0;
+        if (input.kind === 'select' && !input.options.length) {
+          input.options = [{ label: 'Вариант 1', value: 1 }];
+          input.default = 1;
+        }
+        if (input.kind === 'checkbox' && !input.value) input.value = 1;
+        renderInputs();
+      });
+      row.querySelector('.fi-remove').addEventListener('click', () => {
+        state.inputs.splice(idx, 1);
+        renderInputs();
+      });
+      inputsEl.appendChild(row);
+    });
+  }
 
-      <div style="margin-top:10px;">
-        <div id="distance-map" style="width:100%;height:320px;border-radius:14px;overflow:hidden;"></div>
-        <div class="map-caption small" style="margin-top:4px;">
-          Карта использует тайлы OpenStreetMap через Leaflet.
+  function renderOutputs() {
+    outputsEl.innerHTML = '';
+    state.outputs.forEach((out, idx) => {
+      const row = document.createElement('div');
+      row.className = 'card';
+      row.style.marginBottom = '6px';
+      row.dataset.field = 'outputs[' + idx + ']';
+      row.innerHTML = `
+        <div class="inline" style="margin-bottom:6px;">
+          <input type="text" class="fo-name" placeholder="Имя" style="font-family:monospace;" />
+          <input type="text" class="fo-label" placeholder="Подпись" />
+          <input type="text" class="fo-unit" placeholder="₽" title="Единицы" />
+          <input type="number" class="fo-decimals" min="0" max="10" step="1" title="Знаков после запятой" />
+          <button class="btn secondary fo-remove" type="button" title="Удалить">✕</button>
         </div>
-      </div>
+        <input type="text" class="fo-expr" placeholder="area * price" style="width:100%; font-family:monospace;" />
+        <label class="small"><input type="checkbox" class="fo-hidden" /> промежуточный, посетителю не показывать</label>
+      `;
+      row.querySelector('.fo-name').value = out.name || '';
+      row.querySelector('.fo-label').value = out.label || '';
+      row.querySelector('.fo-unit').value = out.unit || '';
+      row.querySelector('.fo-decimals').value = out.decimals || 0;
+      row.querySelector('.fo-expr').value = out.expr || '';
+      row.querySelector('.fo-hidden').checked = !!out.hidden;
+
+      row.querySelector('.fo-name').addEventListener('input', (e) => {
+        out.name = e.target.value.trim();
+      });
+      row.querySelector('.fo-label').addEventListener('input', (e) => {
+        out.label = e.target.value;
+      });
+      row.querySelector('.fo-unit').addEventListener('input', (e) => {
+        out.unit = e.target.value;
+      });
+      row.querySelector('.fo-decimals').addEventListener('input', (e) => {
+        out.decimals = Number(e.target.value) || 0;
+      });
+      row.querySelector('.fo-expr').addEventListener('input', (e) => {
+        out.expr = e.target.value;
+      });
+      row.querySelector('.fo-hidden').addEventListener('change', (e) => {
+        out.hidden = e.target.checked;
+      });
+      row.querySelector('.fo-remove').addEventListener('click', () => {
+        state.outputs.splice(idx, 1);
+        renderOutputs();
+      });
+      outputsEl.appendChild(row);
+    });
+  }
 
-      <div id="dist-error" class="error" style="display:none;"></div>
-    </div>
-  `;
+  // ошибки сохранения: список под формой и красная рамка у строки поля/результата
+  function showConfigErrors(errors) {
+    document.querySelectorAll('#formula-inputs [data-field], #formula-outputs [data-field]').forEach((el) => {
+      el.style.outline = '';
+    });
+    if (!errors || !errors.length) {
+      errorsEl.style.display = 'none';
+      errorsEl.innerHTML = '';
+      return;
+    }
+    errorsEl.innerHTML = '';
+    errors.forEach((e) => {
+      const line = document.createElement('div');
+      line.textContent = e.field + ': ' + e.message;
+      errorsEl.appendChild(line);
+      const rowKey = e.field.split('.')[0];
+      const row = document.querySelector('[data-field="' + rowKey + '"]');
+      if (row) row.style.outline = '2px solid #ef4444';
+    });
+    errorsEl.style.display = 'block';
+  }
 
-  // --- бинды настроек ---
+  // превью-форма строится по последнему сохранённому конфигу
+  const previewControls = {};
+  function renderPreviewFields(inputs) {
+    previewFieldsEl.innerHTML = '';
+    Object.keys(previewControls).forEach((k) => delete previewControls[k]);
+    inputs.forEach((input) => {
+      const field = document.createElement('div');
+      field.className = 'field';
+      const label = input.label || input.name;
+      if (input.kind === 'checkbox') {
+        field.innerHTML = `<label class="small"><input type="checkbox" /> <span></span></label>`;
+        field.querySelector('span').textContent = label;
+        const box = field.querySelector('input');
+        box.checked = !!input.default;
+        previewControls[input.name] = () => box.checked;
+      } else if (input.kind === 'select') {
+        field.innerHTML = `<label class="field-label"></label><select></select>`;
+        field.querySelector('label').textContent = label;
+        const select = field.querySelector('select');
+        (input.options || []).forEach((o, i) => {
+          const opt = document.createElement('option');
+          opt.value = String(i);
+          opt.textContent = o.label;
+          if (o.value === input.default) opt.selected = true;
+          select.appendChild(opt);
+        });
+        previewControls[input.name] = () => (input.options[Number(select.value)] || {}).value;
+      } else {
+        field.innerHTML = `<label class="field-label"></label><input type="number" step="any" />`;
+        field.querySelector('label').textContent = label + (input.unit ? ', ' + input.unit : '');
+        const num = field.querySelector('input');
+        num.value = input.default || 0;
+        previewControls[input.name] = () => Number(num.value);
+      }
+      previewFieldsEl.appendChild(field);
+    });
+  }
 
-  const basePriceInput = document.getElementById('dist-base-price');
-  const pricePerKmInput = document.getElementById('dist-price-per-km');
-  const loadingInput = document.getElementById('dist-loading-price');
-  const unloadingInput = document.getElementById('dist-unloading-price');
-  const coefSmallInput = document.getElementById('coef-small');
-  const coefMediumInput = document.getElementById('coef-medium');
-  const coefLargeInput = document.getElementById('coef-large');
-  const saveBtn = document.getElementById('dist-save-btn');
+  renderInputs();
+  renderOutputs();
+  renderPreviewFields(state.inputs);
 
-  basePriceInput.addEventListener('input', () => {
-    state.basePrice = Number(basePriceInput.value) || 0;
-    updatePreviewTariffs();
-  });
-  pricePerKmInput.addEventListener('input', () => {
-    state.pricePerKm = Number(pricePerKmInput.value) || 0;
-    updatePreviewTariffs();
-  });
-  loadingInput.addEventListener('input', () => {
-    state.loadingPrice = Number(loadingInput.value) || 0;
-    updatePreviewTariffs();
+  document.getElementById('formula-add-input').addEventListener('click', () => {
+    state.inputs.push({ name: nextName('x'), label: 'Новое поле', kind: 'number', default: 0, options: [] });
+    renderInputs();
   });
-  unloadingInput.addEventListener('input', () => {
-    state.unloadingPrice = Number(unloadingInput.value) || 0;
-    updatePreviewTariffs();
+  document.getElementById('formula-add-output').addEventListener('click', () => {
+    state.outputs.push({ name: nextName('result'), label: 'Результат', expr: '', unit: '₽', decimals: 0 });
+    renderOutputs();
   });
 
-  coefSmallInput.addEventListener('input', () => {
-    state.vehicleCoefs.small = Number(coefSmallInput.value) || 1;
+  bindPublishControls(calcMeta);
+
+  document.getElementById('formula-history-btn').addEventListener('click', () => {
+    showConfigHistoryModal(calcMeta, () => loadSection('formula'));
   });
-  coefMediumInput.addEventListener('input', () => {
-    state.vehicleCoefs.medium = Number(coefMediumInput.value) || 1;
+
+  const saveBtn = document.getElementById('formula-save-btn');
+  saveBtn.addEventListener('click', async () => {
+    try {
+      saveBtn.disabled = true;
+      saveBtn.textContent = 'Сохранение...';
+      const saved = await postJSON('/formula/config?calculatorId=' + encodeURIComponent(calcMeta.id), {
+        description: state.description,
+        inputs: state.inputs,
+        outputs: state.outputs,
+      });
+      showConfigErrors([]);
+      renderPreviewFields(saved.inputs || []);
+      alert('Настройки калькулятора сохранены');
+    } catch (err) {
+      console.error(err);
+      // 400 с разбором формул приходит JSON-ом со списком ошибок
+      let data = null;
+      try {
+        data = JSON.parse(err.message);
+      } catch (_) {}
+      if (data && data.errors) {
+        showConfigErrors(data.errors);
+      } else {
+        alert('Ошибка сохранения настроек: ' + err.message);
+      }
+    } finally {
+      saveBtn.disabled = false;
+      saveBtn.textContent = 'Сохранить конфигурацию';
+    }
   });
-  coefLargeInput.addEventListener('input', () => {
-    state.vehicleCoefs.large = Number(coefLargeInput.value) || 1;
+
+  const resultBox = document.getElementById('formula-preview-result');
+  const errorBox = document.getElementById('formula-preview-error');
+
+  document.getElementById('formula-preview-form').addEventListener('submit', async (e) => {
+    e.preventDefault();
+    errorBox.style.display = 'none';
+    const values = {};
+    Object.keys(previewControls).forEach((name) => {
+      values[name] = previewControls[name]();
+    });
+    try {
+      const res = await postJSON('/formula/calc', {
+        values,
+        calculatorId: calcMeta && calcMeta.id ? calcMeta.id : '',
+        previewToken: calcMeta && calcMeta.previewToken ? calcMeta.previewToken : '',
+      });
+      resultBox.innerHTML = '';
+      (res.results || []).forEach((r) => {
+        const row = document.createElement('div');
+        row.className = 'result-row';
+        row.innerHTML = `<div class="result-label"></div><div class="result-value"></div>`;
+        row.querySelector('.result-label').textContent = r.label || r.name;
+        row.querySelector('.result-value').textContent =
+          Number(r.value || 0).toLocaleString('ru-RU', {
+            minimumFractionDigits: r.decimals || 0,
+            maximumFractionDigits: r.decimals || 0,
+          }) + (r.unit ? ' ' + r.unit : '');
+        resultBox.appendChild(row);
+      });
+      resultBox.style.display = 'block';
+    } catch (err) {
+      console.error(err);
+      resultBox.style.display = 'none';
+      errorBox.textContent = 'Ошибка расчёта: ' + err.message;
+      errorBox.style.display = 'block';
+    }
   });
+}
+
+// --- Form builder ---
+
+const FORM_FIELD_KINDS = {
+  number: 'Число',
+  slider: 'Ползунок',
+  radio: 'Один из списка',
+  multiselect: 'Несколько из списка',
+  text: 'Текст',
+};
+
+const FORM_CONDITION_OPS = {
+  filled: 'заполнено',
+  empty: 'не заполнено',
+  eq: '=',
+  ne: '≠',
+  gt: '>',
+  gte: '≥',
+  lt: '<',
+  lte: '≤',
+  selected: 'выбран вариант',
+  not_selected: 'не выбран вариант',
+};
+
+function renderFormBuilder(cfg, calcMeta) {
+  contentEl.innerHTML = '';
+
+  const state = {
+    description: (cfg && cfg.description) || '',
+    basePrice: (cfg && typeof cfg.basePrice === 'number') ? cfg.basePrice : 0,
+    baseLabel: (cfg && cfg.baseLabel) || '',
+    minTotal: (cfg && typeof cfg.minTotal === 'number') ? cfg.minTotal : 0,
+    fields: ((cfg && cfg.fields) || []).map((f) =>
+      Object.assign({}, f, {
+        options: (f.options || []).map((o) => Object.assign({}, o)),
+        visibleIf: (f.visibleIf || []).map((c) => Object.assign({}, c)),
+      })
+    ),
+  };
+
+  if (calcMeta) {
+    const infoCard = document.createElement('div');
+    infoCard.className = 'card';
+    const created = calcMeta.createdAt ? new Date(calcMeta.createdAt).toLocaleString('ru-RU') : '—';
+    infoCard.innerHTML = `
+      <div class="card-title">${calcMeta.name || 'Калькулятор-форма'}</div>
+      <div class="card-subtitle">
+        Тип: ${CALC_TYPE_LABELS[calcMeta.type] || calcMeta.type}. ${calcStatusBadge(calcMeta.status)}
+      </div>
+      <p class="small" style="margin-top:4px;">
+        ID: ${calcMeta.id}, создан: ${created}, расчётов: ${calcMeta.calcCount || 0}.
+      </p>
+    `;
+    contentEl.appendChild(infoCard);
+  }
+
+  const wrapper = document.createElement('div');
+  wrapper.className = 'grid grid-2';
+  const left = document.createElement('div');
+  const right = document.createElement('div');
+  wrapper.appendChild(left);
+  wrapper.appendChild(right);
+  contentEl.appendChild(wrapper);
+
+  left.innerHTML = `
+    <div class="card">
+      <div class="card-title">Калькулятор-форма</div>
+      <div class="card-subtitle">
+        Итог = базовая цена + прибавки полей и вариантов, затем по очереди множители,
+        затем доплата до минимального заказа. Сумму считает сервер.
+      </div>
 
-  saveBtn.addEventListener('click', async () => {
-    try {
-      saveBtn.disabled = true;
-      saveBtn.textContent = 'Сохранение...';
+      <div class="field">
+        <label class="field-label">Описание под заголовком</label>
+        <textarea id="form-description" rows="2"></textarea>
+      </div>
 
-      const payload = {
-        basePrice: state.basePrice,
-        pricePerKm: state.pricePerKm,
-        loadingPrice: state.loadingPrice,
-        unloadingPrice: state.unloadingPrice,
-        vehicleCoefs: state.vehicleCoefs,
-      };
+      <div class="inline" style="margin-bottom:10px;">
+        <div class="field">
+          <label class="field-label">Базовая цена, ₽</label>
+          <input type="number" id="form-base-price" min="0" step="100" />
+        </div>
+        <div class="field">
+          <label class="field-label">Подпись базовой цены</label>
+          <input type="text" id="form-base-label" placeholder="Базовая стоимость" />
+        </div>
+        <div class="field">
+          <label class="field-label">Минимальный заказ, ₽</label>
+          <input type="number" id="form-min-total" min="0" step="100" />
+        </div>
+      </div>
 
-      await postJSON('/distance/config', payload);
-      alert('Настройки калькулятора доставки сохранены');
-    } catch (err) {
-      console.error(err);
-      alert('Ошибка сохранения настроек');
-    } finally {
-      saveBtn.disabled = false;
-      saveBtn.textContent = 'Сохранить конфигурацию';
-    }
-  });
+      <div class="field">
+        <label class="field-label">Поля формы (по порядку)</label>
+        <div id="form-fields"></div>
+        <button class="btn secondary" id="form-add-field" type="button">+ Поле</button>
+      </div>
 
-  // --- превью расчёта + карта ---
+      <div class="card" style="margin-top:10px; padding-top:10px;">
+        <div class="card-title">Сохранить настройки</div>
+        <p class="small">
+          Каждое сохранение попадает в историю версий — к любой из них можно вернуться.
+        </p>
+        <button class="btn primary" id="form-save-btn" type="button">Сохранить конфигурацию</button>
+        <button class="btn secondary" id="form-history-btn" type="button">История версий</button>
+        ${publishControlsHTML(calcMeta)}
+      </div>
+    </div>
+  `;
 
-  const previewBaseEl = document.getElementById('dist-preview-base');
-  const previewKmEl = document.getElementById('dist-preview-km');
-  const previewLoadEl = document.getElementById('dist-preview-load');
+  right.innerHTML = `
+    <div class="card">
+      <div class="card-title">Превью расчёта</div>
+      <div class="card-subtitle">
+        Считается по сохранённому черновику. Поля, скрытые условиями, сервер не учитывает.
+      </div>
+      <form id="form-preview-form">
+        <div id="form-preview-fields"></div>
+        <button type="submit" class="btn primary">Рассчитать</button>
+      </form>
+      <div id="form-preview-result" class="result-box" style="display:none; margin-top:10px;"></div>
+      <div id="form-preview-error" class="error" style="display:none;"></div>
+    </div>
+  `;
 
   function formatMoney(num) {
-    return Math.round(num).toLocaleString('ru-RU') + ' ₽';
-  }
-  function formatKm(num) {
-    return (Math.round(num * 10) / 10).toLocaleString('ru-RU') + ' км';
+    return Number(num || 0).toLocaleString('ru-RU', { maximumFractionDigits: 2 }) + ' ₽';
   }
 
-  function updatePreviewTariffs() {
-    previewBaseEl.textContent = formatMoney(state.basePrice || 0);
-    previewKmEl.textContent = (state.pricePerKm || 0).toLocaleString('ru-RU') + ' ₽/км';
-    const loadSum = (state.loadingPrice || 0) + (state.unloadingPrice || 0);
-    previewLoadEl.textContent = formatMoney(loadSum);
+  function nextID(prefix, list) {
+    let n = list.length + 1;
+    while (list.some((x) => x.id === prefix + n)) n++;
+    return prefix + n;
   }
 
-  updatePreviewTariffs();
-
-  const previewForm = document.getElementById('dist-preview-form');
-  const fromInput = document.getElementById('dist-from');
-  const toInput = document.getElementById('dist-to');
-  const vehicleSelect = document.getElementById('dist-vehicle');
-  const roundtripInput = document.getElementById('dist-roundtrip');
-  const resetBtn = document.getElementById('dist-reset-btn');
-
-  const resultBox = document.getElementById('dist-result-box');
-  const resultOne = document.getElementById('dist-result-one');
-  const resultBothRow = document.getElementById('dist-result-both-row');
-  const resultBoth = document.getElementById('dist-result-both');
-  const resultBase = document.getElementById('dist-result-base');
-  const resultKm = document.getElementById('dist-result-km');
-  const resultLoad = document.getElementById('dist-result-load');
-  const resultTotal = document.getElementById('dist-result-total');
-  const errorBox = document.getElementById('dist-error');
-
-  function showError(msg) {
-    errorBox.textContent = msg;
-    errorBox.style.display = 'block';
-  }
-  function hideError() {
-    errorBox.textContent = '';
-    errorBox.style.display = 'none';
-  }
-  function hideResult() {
-    resultBox.style.display = 'none';
+  function numOrNull(value) {
+    return value === '' ? null : Number(value);
   }
 
-  let distanceMap = null;
-  let routeLayer = null;
-
-  function initMapIfNeeded(route) {
-    if (typeof L === 'undefined') {
-      console.warn('Leaflet не загружен. Проверь подключение скрипта.');
-      return;
-    }
-    if (!distanceMap) {
-      distanceMap = L.map('distance-map').setView([55.751244, 37.618423], 9);
-      L.tileLayer('https://{s}.tile.openstreetmap.org/{z}/{x}/{y}.png', {
-        attribution: '&copy; OpenStreetMap contributors',
-      }).addTo(distanceMap);
-    }
+  const fieldsEl = document.getElementById('form-fields');
+  const previewFieldsEl = document.getElementById('form-preview-fields');
 
-    if (!route || !route.length) {
-      return;
-    }
+  const descriptionEl = document.getElementById('form-description');
+  descriptionEl.value = state.description;
+  descriptionEl.addEventListener('input', (e) => {
+    state.description = e.target.value;
+  });
+  const basePriceEl = document.getElementById('form-base-price');
+  basePriceEl.value = state.basePrice;
+  basePriceEl.addEventListener('input', (e) => {
+    state.basePrice = Number(e.target.value) || 0;
+  });
+  const baseLabelEl = document.getElementById('form-base-label');
+  baseLabelEl.value = state.baseLabel;
+  baseLabelEl.addEventListener('input', (e) => {
+    state.baseLabel = e.target.value;
+  });
+  const minTotalEl = document.getElementById('form-min-total');
+  minTotalEl.value = state.minTotal;
+  minTotalEl.addEventListener('input', (e) => {
+    state.minTotal = Number(e.target.value) || 0;
+  });
 
-    const latlngs = route.map((p) => [p.lat, p.lon]).filter((arr) => arr[0] && arr[1]);
-    if (!latlngs.length) {
-      return;
-    }
+  function renderOptions(field, container) {
+    container.innerHTML = '';
+    field.options.forEach((o, idx) => {
+      const row = document.createElement('div');
+      row.className = 'inline';
+      row.style.marginBottom = '4px';
+      row.innerHTML = `
+        <input type="text" class="fo-id" placeholder="id" title="id варианта" style="font-family:monospace;" />
+        <input type="text" class="fo-label" placeholder="Подпись" />
+        <input type="number" class="fo-price" min="0" step="100" title="Прибавка, ₽" />
+        <input type="number" class="fo-mult" min="0" step="0.05" title="Множитель суммы (пусто или 1 — нет)" />
+        <button class="btn secondary fo-remove" type="button" title="Удалить">✕</button>
+      `;
+      row.querySelector('.fo-id').value = o.id || '';
+      row.querySelector('.fo-label').value = o.label || '';
+      row.querySelector('.fo-price').value = o.price || 0;
+      row.querySelector('.fo-mult').value = o.multiplier || '';
+      row.querySelector('.fo-id').addEventListener('input', (e) => {
+        o.id = e.target.value.trim();
+      });
+      row.querySelector('.fo-label').addEventListener('input', (e) => {
+        o.label = e.target.value;
+      });
+      row.querySelector('.fo-price').addEventListener('input', (e) => {
+        o.price = Number(e.target.value) || 0;
+      });
+      row.querySelector('.fo-mult').addEventListener('input', (e) => {
+        o.multiplier = Number(e.target.value) || 0;
+      });
+      row.querySelector('.fo-remove').addEventListener('click', () => {
+        field.options.splice(idx, 1);
+        renderOptions(field, container);
+      });
+      container.appendChild(row);
+    });
+  }
 
-    if (routeLayer) {
-      routeLayer.remove();
-      routeLayer = null;
-    }
+  // условия видимости могут ссылаться только на поля выше
+  function renderConditions(field, fieldIdx, container) {
+    container.innerHTML = '';
+    const above = state.fields.slice(0, fieldIdx);
+    field.visibleIf.forEach((c, idx) => {
+      const target = above.find((f) => f.id === c.field);
+      const row = document.createElement('div');
+      row.className = 'inline';
+      row.style.marginBottom = '4px';
+      row.innerHTML = `
+        <select class="fc-field">
+          ${above.map((f) => `<option value="${f.id}">${f.label || f.id}</option>`).join('')}
+        </select>
+        <select class="fc-op">
+          ${Object.keys(FORM_CONDITION_OPS).map((op) => `<option value="${op}">${FORM_CONDITION_OPS[op]}</option>`).join('')}
+        </select>
+        <span class="fc-arg"></span>
+        <button class="btn secondary fc-remove" type="button" title="Удалить">✕</button>
+      `;
+      row.querySelector('.fc-field').value = c.field;
+      row.querySelector('.fc-op').value = c.op;
+
+      const arg = row.querySelector('.fc-arg');
+      if (c.op === 'selected' || c.op === 'not_selected') {
+        const select = document.createElement('select');
+        ((target && target.options) || []).forEach((o) => {
+          const opt = document.createElement('option');
+          opt.value = o.id;
+          opt.textContent = o.label || o.id;
+          select.appendChild(opt);
+        });
+        select.value = c.option || '';
+        select.addEventListener('change', (e) => {
+          c.option = e.target.value;
+        });
+        arg.appendChild(select);
+      } else if (c.op !== 'filled' && c.op !== 'empty') {
+        const input = document.createElement('input');
+        input.type = 'number';
+        input.step = 'any';
+        input.value = c.value || 0;
+        input.addEventListener('input', (e) => {
+          c.value = Number(e.target.value) || 0;
+        });
+        arg.appendChild(input);
+      }
 
-    routeLayer = L.polyline(latlngs, { weight: 4 }).addTo(distanceMap);
-    distanceMap.fitBounds(routeLayer.getBounds(), { padding: [20, 20] });
+      row.querySelector('.fc-field').addEventListener('change', (e) => {
+        c.field = e.target.value;
+        c.option = '';
+        renderConditions(field, fieldIdx, container);
+      });
+      row.querySelector('.fc-op').addEventListener('change', (e) => {
+        c.op = e.target.value;
+        renderConditions(field, fieldIdx, container);
+      });
+      row.querySelector('.fc-remove').addEventListener('click', () => {
+        field.visibleIf.splice(idx, 1);
+        renderConditions(field, fieldIdx, container);
+      });
+      container.appendChild(row);
+    });
   }
 
-  previewForm.addEventListener('submit', async (e) => {
-    e.preventDefault();
-    hideError();
+  function renderFields() {
+    fieldsEl.innerHTML = '';
+    state.fields.forEach((field, idx) => {
+      const numeric = field.kind === 'number' || field.kind === 'slider';
+      const withOptions = field.kind === 'radio' || field.kind === 'multiselect';
 
-    const from = fromInput.value.trim();
-    const to = toInput.value.trim();
+      const card = document.createElement('div');
+      card.className = 'card';
+      card.style.marginBottom = '6px';
+      card.innerHTML = `
+        <div class="inline" style="margin-bottom:6px;">
+          <input type="text" class="ff-id" placeholder="id" title="id поля" style="font-family:monospace;" />
+          <input type="text" class="ff-label" placeholder="Подпись" />
+          <select class="ff-kind">
+            ${Object.keys(FORM_FIELD_KINDS).map((k) => `<option value="${k}">${FORM_FIELD_KINDS[k]}</option>`).join('')}
+          </select>
+          <button class="btn secondary ff-up" type="button" title="Выше" ${idx === 0 ? 'disabled' : ''}>↑</button>
+          <button class="btn secondary ff-down" type="button" title="Ниже" ${idx === state.fields.length - 1 ? 'disabled' : ''}>↓</button>
+          <button class="btn secondary ff-remove" type="button" title="Удалить">✕</button>
+        </div>
+        <div class="checkbox-row" style="margin-bottom:6px;">
+          <input type="checkbox" class="ff-required" id="ff-required-${idx}" />
+          <label for="ff-required-${idx}">Обязательное</label>
+        </div>
+        <div class="inline" style="margin-bottom:6px;">
+          <input type="number" class="ff-price" min="0" step="100" title="Прибавка, если поле заполнено, ₽" placeholder="Прибавка, ₽" />
+          ${numeric ? '<input type="number" class="ff-unit-price" min="0" step="1" title="Цена за единицу, ₽" placeholder="За единицу, ₽" />' : ''}
+          <input type="number" class="ff-mult" min="0" step="0.05" title="Множитель суммы, если поле заполнено" placeholder="Множитель" />
+        </div>
+        ${
+          numeric
+            ? `<div class="inline" style="margin-bottom:6px;">
+                <input type="number" class="ff-default" step="any" title="По умолчанию" placeholder="По умолчанию" />
+                <input type="number" class="ff-min" step="any" title="Минимум" placeholder="Мин." />
+                <input type="number" class="ff-max" step="any" title="Максимум" placeholder="Макс." />
+                <input type="number" class="ff-step" step="any" min="0" title="Шаг" placeholder="Шаг" />
+                <input type="text" class="ff-unit" title="Единицы" placeholder="м²" />
+              </div>`
+            : ''
+        }
+        ${field.kind === 'text' ? '<input type="text" class="ff-placeholder" placeholder="Подсказка в поле" style="margin-bottom:6px;" />' : ''}
+        ${
+          withOptions
+            ? `<div class="small">Варианты: id, подпись, прибавка ₽, множитель</div>
+               <div class="ff-options"></div>
+               <button class="btn secondary ff-add-option" type="button">+ Вариант</button>`
+            : ''
+        }
+        <div class="small" style="margin-top:6px;">Показывать, только если (все условия):</div>
+        <div class="ff-conditions"></div>
+        ${idx > 0 ? '<button class="btn secondary ff-add-condition" type="button">+ Условие</button>' : ''}
+      `;
 
-    if (!from || !to) {
-      showError('Заполните поля «Откуда» и «Куда».');
-      return;
-    }
+      card.querySelector('.ff-id').value = field.id || '';
+      card.querySelector('.ff-label').value = field.label || '';
+      card.querySelector('.ff-kind').value = field.kind;
+      card.querySelector('.ff-required').checked = !!field.required;
+      card.querySelector('.ff-price').value = field.price || '';
+      card.querySelector('.ff-mult').value = field.multiplier || '';
 
-    try {
-      const body = {
-        from,
-        to,
-        vehicle: vehicleSelect.value,
-        roundTrip: roundtripInput.checked,
-        calculatorId: calcMeta && calcMeta.id ? calcMeta.id : '',
-      };
+      card.querySelector('.ff-id').addEventListener('input', (e) => {
+        field.id = e.target.value.trim();
+      });
+      card.querySelector('.ff-label').addEventListener('input', (e) => {
+        field.label = e.target.value;
+      });
+      card.querySelector('.ff-kind').addEventListener('change', (e) => {
+        field.kind = e.target.value;
+        if ((field.kind === 'radio' || field.kind === 'multiselect') && !field.options.length) {
+          field.options.push({ id: 'option1', label: 'Вариант 1', price: 0, multiplier: 0 });
+        }
+        if (field.kind === 'slider') {
+          if (field.min == null) field.min = 0;
+          if (field.max == null) field.max = 10;
+        }
+        renderFields();
+      });
+      card.querySelector('.ff-required').addEventListener('change', (e) => {
+        field.required = e.target.checked;
+      });
+      card.querySelector('.ff-price').addEventListener('input', (e) => {
+        field.price = Number(e.target.value) || 0;
+      });
+      card.querySelector('.ff-mult').addEventListener('input', (e) => {
+        field.multiplier = Number(e.target.value) || 0;
+      });
 
-      const res = await postJSON('/distance/calc', body);
+      if (numeric) {
+        const bind = (cls, value, apply) => {
+          const input = card.querySelector(cls);
+          input.value = value;
+          input.addEventListener('input', (e) => apply(e.target.value));
+        };
+        bind('.ff-unit-price', field.unitPrice || '', (v) => { field.unitPrice = Number(v) || 0; });
+        bind('.ff-default', field.default || 0, (v) => { field.default = Number(v) || 0; });
+        bind('.ff-min', field.min != null ? field.min : '', (v) => { field.min = numOrNull(v); });
+        bind('.ff-max', field.max != null ? field.max : '', (v) => { field.max = numOrNull(v); });
+        bind('.ff-step', field.step || '', (v) => { field.step = Number(v) || 0; });
+        bind('.ff-unit', field.unit || '', (v) => { field.unit = v; });
+      }
+      if (field.kind === 'text') {
+        const input = card.querySelector('.ff-placeholder');
+        input.value = field.placeholder || '';
+        input.addEventListener('input', (e) => {
+          field.placeholder = e.target.value;
+        });
+      }
+      if (withOptions) {
+        const optionsEl = card.querySelector('.ff-options');
+        renderOptions(field, optionsEl);
+        card.querySelector('.ff-add-option').addEventListener('click', () => {
+          field.options.push({ id: nextID('option', field.options), label: 'Вариант', price: 0, multiplier: 0 });
+          renderOptions(field, optionsEl);
+        });
+      }
 
-      resultBox.style.display = 'block';
-      resultOne.textContent = formatKm(res.distanceOneWayKm || 0);
+      const conditionsEl = card.querySelector('.ff-conditions');
+      renderConditions(field, idx, conditionsEl);
+      const addCondition = card.querySelector('.ff-add-condition');
+      if (addCondition) {
+        addCondition.addEventListener('click', () => {
+          field.visibleIf.push({ field: state.fields[idx - 1].id, op: 'filled' });
+          renderConditions(field, idx, conditionsEl);
+        });
+      }
 
-      if (roundtripInput.checked) {
-        resultBothRow.style.display = 'flex';
-        resultBoth.textContent = formatKm(res.distanceTotalKm || 0);
+      card.querySelector('.ff-up').addEventListener('click', () => {
+        state.fields.splice(idx - 1, 0, state.fields.splice(idx, 1)[0]);
+        renderFields();
+      });
+      card.querySelector('.ff-down').addEventListener('click', () => {
+        state.fields.splice(idx + 1, 0, state.fields.splice(idx, 1)[0]);
+        renderFields();
+      });
+      card.querySelector('.ff-remove').addEventListener('click', () => {
+        state.fields.splice(idx, 1);
+        renderFields();
+      });
+      fieldsEl.appendChild(card);
+    });
+  }
+
+  // превью-форма строится по последнему сохранённому конфигу
+  const previewControls = {};
+  function renderPreviewFields(fields) {
+    previewFieldsEl.innerHTML = '';
+    Object.keys(previewControls).forEach((k) => delete previewControls[k]);
+    fields.forEach((field) => {
+      const el = document.createElement('div');
+      el.className = 'field';
+      el.innerHTML = `<label class="field-label"></label>`;
+      el.querySelector('label').textContent = (field.label || field.id) + (field.required ? ' *' : '');
+
+      if (field.kind === 'radio' || field.kind === 'multiselect') {
+        const boxes = (field.options || []).map((o) => {
+          const wrap = document.createElement('label');
+          wrap.className = 'small';
+          wrap.style.display = 'block';
+          wrap.innerHTML = `<input type="${field.kind === 'radio' ? 'radio' : 'checkbox'}" /> <span></span>`;
+          const input = wrap.querySelector('input');
+          input.name = 'form-preview-' + field.id;
+          input.value = o.id;
+          wrap.querySelector('span').textContent = o.label || o.id;
+          el.appendChild(wrap);
+          return input;
+        });
+        previewControls[field.id] = () => {
+          const ids = boxes.filter((b) => b.checked).map((b) => b.value);
+          return field.kind === 'radio' ? ids[0] || '' : ids;
+        };
+      } else if (field.kind === 'text') {
+        const input = document.createElement('input');
+        input.type = 'text';
+        input.placeholder = field.placeholder || '';
+        el.appendChild(input);
+        previewControls[field.id] = () => input.value;
       } else {
-        resultBothRow.style.display = 'none';
+        const input = document.createElement('input');
+        input.type = 'number';
+        input.step = 'any';
+        input.value = field.default || 0;
+        el.appendChild(input);
+        previewControls[field.id] = () => Number(input.value);
       }
+      previewFieldsEl.appendChild(el);
+    });
+  }
 
-      resultBase.textContent = formatMoney(res.priceBase || 0);
-      resultKm.textContent = formatMoney(res.priceKm || 0);
-      resultLoad.textContent = formatMoney(res.priceLoad || 0);
-      resultTotal.textContent = formatMoney(res.priceTotal || 0);
+  renderFields();
+  renderPreviewFields(state.fields);
 
-      initMapIfNeeded(res.route || []);
+  document.getElementById('form-add-field').addEventListener('click', () => {
+    state.fields.push({
+      id: nextID('field', state.fields),
+      label: 'Новое поле',
+      kind: 'number',
+      default: 0,
+      price: 0,
+      unitPrice: 0,
+      multiplier: 0,
+      options: [],
+      visibleIf: [],
+    });
+    renderFields();
+  });
 
-      refreshMeAndHeader();
+  bindPublishControls(calcMeta);
+
+  document.getElementById('form-history-btn').addEventListener('click', () => {
+    showConfigHistoryModal(calcMeta, () => loadSection('form'));
+  });
+
+  const saveBtn = document.getElementById('form-save-btn');
+  saveBtn.addEventListener('click', async () => {
+    try {
+      saveBtn.disabled = true;
+      saveBtn.textContent = 'Сохранение...';
+      const saved = await postJSON('/form/config?calculatorId=' + encodeURIComponent(calcMeta.id), {
+        description: state.description,
+        basePrice: state.basePrice,
+        baseLabel: state.baseLabel,
+        minTotal: state.minTotal,
+        fields: state.fields,
+      });
+      renderPreviewFields(saved.fields || []);
+      alert('Настройки калькулятора сохранены');
     } catch (err) {
       console.error(err);
-      if (err && err.message) {
-        showError('Ошибка расчёта: ' + err.message);
-      } else {
-        showError('Не удалось рассчитать маршрут. Попробуйте ещё раз.');
-      }
-      hideResult();
+      alert('Ошибка сохранения настроек: ' + err.message);
+    } finally {
+      saveBtn.disabled = false;
+      saveBtn.textContent = 'Сохранить конфигурацию';
     }
   });
 
-  resetBtn.addEventListener('click', () => {
-    fromInput.value = '';
-    toInput.value = '';
-    roundtripInput.checked = false;
-    hideError();
-    hideResult();
-    if (routeLayer && distanceMap) {
-      routeLayer.remove();
-      routeLayer = null;
+  const resultBox = document.getElementById('form-preview-result');
+  const errorBox = document.getElementById('form-preview-error');
+
+  document.getElementById('form-preview-form').addEventListener('submit', async (e) => {
+    e.preventDefault();
+    errorBox.style.display = 'none';
+    const values = {};
+    Object.keys(previewControls).forEach((id) => {
+      values[id] = previewControls[id]();
+    });
+    try {
+      const res = await postJSON('/form/calc', {
+        values,
+        calculatorId: calcMeta && calcMeta.id ? calcMeta.id : '',
+        previewToken: calcMeta && calcMeta.previewToken ? calcMeta.previewToken : '',
+      });
+      resultBox.innerHTML = '';
+      (res.items || []).forEach((item) => {
+        const row = document.createElement('div');
+        row.className = 'result-row';
+        row.innerHTML = `<div class="result-label"></div><div class="result-value"></div>`;
+        row.querySelector('.result-label').textContent = item.label + (item.detail ? ': ' + item.detail : '');
+        row.querySelector('.result-value').textContent = formatMoney(item.amount);
+        resultBox.appendChild(row);
+      });
+      const total = document.createElement('div');
+      total.className = 'result-total';
+      total.innerHTML = 'Итого: <strong></strong>';
+      total.querySelector('strong').textContent = formatMoney(res.total);
+      resultBox.appendChild(total);
+      resultBox.style.display = 'block';
+    } catch (err) {
+      console.error(err);
+      resultBox.style.display = 'none';
+      errorBox.textContent = 'Ошибка расчёта: ' + err.message;
+      errorBox.style.display = 'block';
     }
   });
 }
@@ -2020,10 +5025,7 @@ function renderLayersBuilder(cfg, calcMeta) {
     infoCard.className = 'card';
 
     const typeLabel = CALC_TYPE_LABELS[calcMeta.type] || calcMeta.type;
-    const statusLabel =
-      calcMeta.status === 'published'
-        ? '<span class="calc-status-badge">Опубликован</span>'
-        : '<span class="calc-status-badge calc-status-badge--draft">Черновик</span>';
+    const statusLabel = calcStatusBadge(calcMeta.status);
 
     const created = calcMeta.createdAt
       ? new Date(calcMeta.createdAt).toLocaleString('ru-RU')
@@ -2129,7 +5131,9 @@ function renderLayersBuilder(cfg, calcMeta) {
     <div class="card">
       <div class="card-title">Сохранить конфигурацию</div>
       <button class="btn primary" id="save-config-btn" type="button">Сохранить</button>
-      <p class="small">Сейчас конфиг хранится в памяти сервера (мок). В бою здесь будет БД.</p>
+      <button class="btn secondary" id="layers-history-btn" type="button">История версий</button>
+      <p class="small">Каждое сохранение попадает в историю версий — к любой из них можно вернуться.</p>
+      ${publishControlsHTML(calcMeta)}
     </div>
   `;
 
@@ -2572,6 +5576,12 @@ function renderLayersBuilder(cfg, calcMeta) {
     renderPreview();
   });
 
+  bindPublishControls(calcMeta);
+
+  document.getElementById('layers-history-btn').addEventListener('click', () => {
+    showConfigHistoryModal(calcMeta, () => loadSection('layers'));
+  });
+
   document.getElementById('save-config-btn').addEventListener('click', async () => {
     try {
       const payload = {
@@ -2581,7 +5591,7 @@ function renderLayersBuilder(cfg, calcMeta) {
         baseDescription: state.baseDescription,
         showRear: state.showRear,
       };
-      await postJSON('/layers/config', payload);
+      await postJSON('/layers/config?calculatorId=' + encodeURIComponent(calcMeta.id), payload);
       alert('Конфигурация сохранена');
     } catch (err) {
       console.error(err);
@@ -2592,6 +5602,8 @@ function renderLayersBuilder(cfg, calcMeta) {
 
 // --- start ---
 
-initCurrentUser();
-setActiveNav('calculators');
-loadSection('calculators');
+initCurrentUser().then((loggedIn) => {
+  if (!loggedIn) return;
+  setActiveNav('calculators');
+  loadSection('calculators');
+});